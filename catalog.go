@@ -0,0 +1,455 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog maps table names to datasets. `FROM <table>` in queries is sent to S3 Select of the dataset as `FROM S3Object`.
+//
+//	tables:
+//	  - name: access_logs
+//	    location: s3://example-com/logs/
+//	    format: alb
+//	  - name: users
+//	    location: s3://example-com/users/
+//	    format: csv
+//	    columns:
+//	      - name: id
+//	        type: bigint
+type Catalog struct {
+	Tables []*CatalogTable `json:"tables" yaml:"tables"`
+}
+
+// CatalogTable is a dataset of the catalog.
+type CatalogTable struct {
+	// Name is the table name in queries, case-insensitive.
+	Name string `json:"name" yaml:"name"`
+	// Location is the s3 url of the dataset as DSN without query params, multiple locations are separated by comma.
+	Location string `json:"location" yaml:"location"`
+	// Format and CompressionType are the same as `format` and `compression_type` of DSN.
+	Format          S3SelectFormat          `json:"format,omitempty" yaml:"format,omitempty"`
+	CompressionType S3SelectCompressionType `json:"compression_type,omitempty" yaml:"compression_type,omitempty"`
+	// KeyTemplate is the partitioning of the dataset, the same as `key_template` of DSN.
+	KeyTemplate string `json:"key_template,omitempty" yaml:"key_template,omitempty"`
+	// Params are other query params of DSN, such as `header`, `delimiter` or `input_serialization`.
+	// client options such as `region` and credentials are of the DSN of sql.Open, not of tables.
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+	// Columns are the schema of the table, types are used for column types, DESCRIBE and converting values.
+	Columns []*CatalogColumn `json:"columns,omitempty" yaml:"columns,omitempty"`
+
+	// glue is the table of Glue which the table is resolved from.
+	glue *GlueTable
+}
+
+// CatalogColumn is a column of the table, Type is one of string, bigint, double, boolean and timestamp,
+// or nested types such as `array<bigint>`, `map<string,bigint>` and `struct<id:bigint,name:string>`.
+type CatalogColumn struct {
+	Name    string `json:"name" yaml:"name"`
+	Type    string `json:"type" yaml:"type"`
+	NotNull bool   `json:"not_null,omitempty" yaml:"not_null,omitempty"`
+}
+
+var catalogTableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// LoadCatalog reads the catalog file of YAML or JSON.
+func LoadCatalog(path string) (*Catalog, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read catalog: %w", err)
+	}
+	return ParseCatalog(bs)
+}
+
+// ParseCatalog parses the catalog of YAML or JSON, and validates tables.
+func ParseCatalog(bs []byte) (*Catalog, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(bs))
+	dec.KnownFields(true)
+	var catalog Catalog
+	if err := dec.Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("parse catalog: %w", err)
+	}
+	if err := catalog.Validate(); err != nil {
+		return nil, err
+	}
+	return &catalog, nil
+}
+
+// Validate checks names and locations of tables.
+func (catalog *Catalog) Validate() error {
+	seen := make(map[string]bool, len(catalog.Tables))
+	for i, table := range catalog.Tables {
+		if table == nil {
+			return fmt.Errorf("catalog table #%d is empty", i+1)
+		}
+		if !catalogTableNameRegexp.MatchString(table.Name) {
+			return fmt.Errorf("catalog table #%d: invalid name %q", i+1, table.Name)
+		}
+		name := strings.ToLower(table.Name)
+		if name == "s3object" {
+			return fmt.Errorf("catalog table %s: S3Object is reserved", table.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("catalog table %s: duplicated", table.Name)
+		}
+		seen[name] = true
+		if _, err := table.config(); err != nil {
+			return fmt.Errorf("catalog table %s: %w", table.Name, err)
+		}
+	}
+	return nil
+}
+
+// Table returns the table of the name, nil if not found.
+func (catalog *Catalog) Table(name string) *CatalogTable {
+	if catalog == nil {
+		return nil
+	}
+	for _, table := range catalog.Tables {
+		if strings.EqualFold(table.Name, name) {
+			return table
+		}
+	}
+	return nil
+}
+
+// catalogScalarType accepts scalar types of Schema as is.
+func catalogScalarType(name string) (string, bool) {
+	switch name {
+	case SchemaTypeString, SchemaTypeBigint, SchemaTypeDouble, SchemaTypeBoolean, SchemaTypeTimestamp:
+		return name, true
+	}
+	return "", false
+}
+
+// config returns the config of the dataset of the table.
+func (table *CatalogTable) config() (*S3SelectConfig, error) {
+	if table.Location == "" {
+		return nil, errors.New("location is empty")
+	}
+	params := url.Values{}
+	for key, value := range table.Params {
+		params.Set(key, value)
+	}
+	if table.Format != "" {
+		params.Set("format", string(table.Format))
+	}
+	if table.CompressionType != "" {
+		params.Set("compression_type", string(table.CompressionType))
+	}
+	if table.KeyTemplate != "" {
+		params.Set("key_template", table.KeyTemplate)
+	}
+	if params.Has("catalog") {
+		return nil, errors.New("catalog can not be nested")
+	}
+	cfg, err := ParseDSN(table.Location + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.S3OptFns) > 0 {
+		return nil, errors.New("client options such as region and endpoint are not supported in tables")
+	}
+	if len(table.Columns) > 0 {
+		schema := &Schema{Columns: make([]*SchemaColumn, 0, len(table.Columns))}
+		for _, column := range table.Columns {
+			schemaColumn, err := parseTypeName(column.Type, catalogScalarType)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			schemaColumn.Name = column.Name
+			schemaColumn.Nullable = !column.NotNull
+			schema.Columns = append(schema.Columns, schemaColumn)
+		}
+		cfg.Schema = schema
+	}
+	return cfg, nil
+}
+
+// WithCatalog sets the catalog of tables.
+func (cfg *S3SelectConfig) WithCatalog(catalog *Catalog) *S3SelectConfig {
+	cfg.Catalog = catalog
+	return cfg
+}
+
+func (cfg *S3SelectConfig) setCatalogParams(params url.Values) error {
+	if !params.Has("catalog") {
+		return nil
+	}
+	cfg.CatalogLocation = params.Get("catalog")
+	cfg.Params.Del("catalog")
+	if cfg.CatalogLocation == "" {
+		return errors.New("catalog is empty")
+	}
+	if strings.HasPrefix(cfg.CatalogLocation, "s3://") {
+		src, err := parseSource(cfg.CatalogLocation)
+		if err != nil {
+			return fmt.Errorf("parse catalog: %w", err)
+		}
+		if src.ObjectKey == "" {
+			return errors.New("catalog must be an object, not prefix")
+		}
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setCatalogToURLValues(params url.Values) {
+	if cfg.CatalogLocation != "" {
+		params.Set("catalog", cfg.CatalogLocation)
+	} else {
+		params.Del("catalog")
+	}
+}
+
+// hasCatalog reports whether tables other than S3Object are available.
+func (cfg *S3SelectConfig) hasCatalog() bool {
+	return cfg.Catalog != nil || cfg.CatalogLocation != "" || cfg.Glue
+}
+
+// hasDefaultTable reports whether S3Object is a table. a DSN only for the catalog, such as `s3://example-com/?catalog=catalog.yaml`, has no S3Object.
+func (cfg *S3SelectConfig) hasDefaultTable() bool {
+	if !cfg.hasCatalog() {
+		return true
+	}
+	return cfg.Format != "" || cfg.InputSerialization != nil || cfg.Manifest != "" || len(cfg.Sources) > 0 || cfg.ObjectKey != ""
+}
+
+// loadCatalog returns the catalog, the catalog file is read once per connection.
+func (conn *s3SelectConn) loadCatalog(ctx context.Context) (*Catalog, error) {
+	if conn.cfg.Catalog != nil {
+		return conn.cfg.Catalog, nil
+	}
+	if conn.cfg.CatalogLocation == "" || conn.catalog != nil {
+		return conn.catalog, nil
+	}
+	location := conn.cfg.CatalogLocation
+	if !strings.HasPrefix(location, "s3://") {
+		catalog, err := LoadCatalog(location)
+		if err != nil {
+			return nil, err
+		}
+		conn.catalog = catalog
+		return catalog, nil
+	}
+	src, err := parseSource(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse catalog: %w", err)
+	}
+	bs, err := conn.readObject(ctx, src.BucketName, src.ObjectKey)
+	if err != nil {
+		return nil, fmt.Errorf("read catalog %s: %w", location, err)
+	}
+	catalog, err := ParseCatalog(bs)
+	if err != nil {
+		return nil, err
+	}
+	conn.catalog = catalog
+	return catalog, nil
+}
+
+// hasTables reports whether FROM of queries may be a table other than S3Object.
+func (conn *s3SelectConn) hasTables() bool {
+	return conn.cfg.hasCatalog() || conn.glue != nil || len(conn.registry.all()) > 0
+}
+
+// lookupTable returns the table of the name in created tables, the catalog or Glue.
+func (conn *s3SelectConn) lookupTable(ctx context.Context, name string) (*CatalogTable, error) {
+	if table := conn.registry.table(name); table != nil {
+		return table, nil
+	}
+	catalog, err := conn.loadCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if table := catalog.Table(name); table != nil {
+		return table, nil
+	}
+	if database, table, ok := strings.Cut(name, "."); ok && (conn.cfg.Glue || conn.glue != nil) {
+		return conn.lookupGlueTable(ctx, database, table)
+	}
+	return nil, fmt.Errorf("unknown table %s", name)
+}
+
+// tables returns all tables of the catalog and created tables.
+func (conn *s3SelectConn) tables(ctx context.Context) ([]*CatalogTable, error) {
+	catalog, err := conn.loadCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var tables []*CatalogTable
+	if catalog != nil {
+		tables = append(tables, catalog.Tables...)
+	}
+	return append(tables, conn.registry.all()...), nil
+}
+
+// tableConnFor looks up the table and returns the connection for it.
+func (conn *s3SelectConn) tableConnFor(ctx context.Context, name string) (*s3SelectConn, error) {
+	table, err := conn.lookupTable(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return conn.tableConn(table)
+}
+
+// tableConn returns the connection for the dataset of the table, it shares the client and the lifetime of conn.
+func (conn *s3SelectConn) tableConn(table *CatalogTable) (*s3SelectConn, error) {
+	cfg, err := table.config()
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", table.Name, err)
+	}
+	cfg.inheritParams(conn.cfg)
+	return &s3SelectConn{
+		client:      conn.client,
+		cfg:         cfg,
+		aliveCh:     conn.aliveCh,
+		errLogger:   conn.errLogger,
+		debugLogger: conn.debugLogger,
+		hooks:       conn.hooks,
+		glue:        conn.glue,
+		glueTable:   table.glue,
+		resultCache: conn.resultCache,
+		listCache:   conn.listCache,
+		indexes:     conn.indexes,
+	}, nil
+}
+
+// inheritParams sets params which are not of the dataset, such as expected_bucket_owner, request_payer, SSE-C key and parse_time,
+// from the config of DSN. params of the table override them.
+func (cfg *S3SelectConfig) inheritParams(parent *S3SelectConfig) {
+	if cfg.ExpectedBucketOwner == "" {
+		cfg.ExpectedBucketOwner = parent.ExpectedBucketOwner
+	}
+	if cfg.RequestPayer == "" {
+		cfg.RequestPayer = parent.RequestPayer
+	}
+	if cfg.SSECustomerKey == nil && cfg.SSECustomerKeyFile == "" && cfg.SSECustomerKeyEnv == "" {
+		cfg.SSECustomerAlgorithm = parent.SSECustomerAlgorithm
+		cfg.SSECustomerKey = parent.SSECustomerKey
+		cfg.SSECustomerKeyFile = parent.SSECustomerKeyFile
+		cfg.SSECustomerKeyEnv = parent.SSECustomerKeyEnv
+	}
+	if cfg.ParseTime == nil {
+		cfg.ParseTime = parent.ParseTime
+	}
+}
+
+// fromTable returns the index of the table token after the top level FROM of the query, -1 if not found.
+func fromTable(tokens []lexer.Token) int {
+	var depth int
+	for i, token := range tokens {
+		switch {
+		case token.Kind == lexer.KindSymbol && token.Value == "(":
+			depth++
+		case token.Kind == lexer.KindSymbol && token.Value == ")":
+			depth--
+		case depth == 0 && token.Kind == lexer.KindIdentifier && strings.EqualFold(token.Value, "FROM"):
+			for j := i + 1; j < len(tokens); j++ {
+				switch tokens[j].Kind {
+				case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+					continue
+				case lexer.KindIdentifier:
+					return j
+				case lexer.KindString:
+					if strings.HasPrefix(tokens[j].Value, `"`) {
+						return j
+					}
+				}
+				return -1
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// tableOfQuery returns the table name in FROM of the query, empty for S3Object and information_schema.
+func tableOfQuery(query string) string {
+	tokens, err := lexer.NewLexer(query).Lex()
+	if err != nil {
+		return ""
+	}
+	i := fromTable(tokens)
+	if i < 0 {
+		return ""
+	}
+	name := unquoteIdentifier(tokens[i].Value)
+	lower := strings.ToLower(name)
+	if lower == "s3object" || strings.HasPrefix(lower, "s3object[") || strings.HasPrefix(lower, "s3object.") || strings.HasPrefix(lower, informationSchemaPrefix) {
+		return ""
+	}
+	return name
+}
+
+// rewriteFromTable rewrites `FROM <table> [alias]` to `FROM S3Object alias`. the table name is the alias if no alias is given,
+// the last part of `database.table`.
+func rewriteFromTable(query string) (string, error) {
+	tokens, err := lexer.NewLexer(query).Lex()
+	if err != nil {
+		return "", err
+	}
+	i := fromTable(tokens)
+	if i < 0 {
+		return "", errors.New("table is not found in FROM")
+	}
+	table := tokens[i].Value
+	if j := strings.LastIndex(table, "."); j >= 0 {
+		// the alias of `database.table` is the table name
+		table = table[j+1:]
+	}
+	var next lexer.Token
+next:
+	for _, token := range tokens[i+1:] {
+		switch token.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+		default:
+			next = token
+			break next
+		}
+	}
+	hasAlias := next.Kind == lexer.KindIdentifier &&
+		(strings.EqualFold(next.Value, "AS") || !localReservedWords[strings.ToUpper(next.Value)])
+	var builder strings.Builder
+	for j, token := range tokens {
+		if j != i {
+			builder.WriteString(token.Value)
+			continue
+		}
+		builder.WriteString("S3Object")
+		if !hasAlias {
+			builder.WriteString(" " + table)
+		}
+	}
+	return builder.String(), nil
+}
+
+// convertSchemaColumns converts values of columns declared in Schema, such as numbers in CSV.
+// values which can not be converted are left as is.
+func (cfg *S3SelectConfig) convertSchemaColumns(columns []string, rows [][]interface{}) {
+	if cfg.Schema == nil {
+		return
+	}
+	for i, name := range columns {
+		column := cfg.Schema.Column(name)
+		if column == nil || column.Type == SchemaTypeString {
+			continue
+		}
+		for _, row := range rows {
+			if i >= len(row) {
+				continue
+			}
+			if v, err := column.ConvertValue(row[i]); err == nil {
+				row[i] = v
+			}
+		}
+	}
+}
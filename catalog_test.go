@@ -0,0 +1,229 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCatalog(t *testing.T) {
+	catalog, err := ParseCatalog([]byte(`
+tables:
+  - name: access_logs
+    location: s3://example-com/logs/
+    format: alb
+  - name: users
+    location: s3://example-com/users/
+    format: csv
+    params:
+      delimiter: ";"
+    columns:
+      - name: id
+        type: bigint
+        not_null: true
+      - name: name
+        type: string
+`))
+	require.NoError(t, err)
+	require.Len(t, catalog.Tables, 2)
+	users := catalog.Table("USERS")
+	require.NotNil(t, users)
+	cfg, err := users.config()
+	require.NoError(t, err)
+	require.Equal(t, "users/", cfg.ObjectKeyPrefix)
+	require.Equal(t, ";", cfg.Delimiter)
+	require.Equal(t, SchemaTypeBigint, cfg.Schema.Column("id").Type)
+	require.False(t, cfg.Schema.Column("id").Nullable)
+	require.True(t, cfg.Schema.Column("name").Nullable)
+	require.Nil(t, catalog.Table("unknown"))
+
+	catalog, err = ParseCatalog([]byte(`{"tables":[{"name":"events","location":"s3://example-com/events/","format":"json_lines"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, S3SelectFormatJSONL, catalog.Tables[0].Format)
+}
+
+func TestParseCatalog__Invalid(t *testing.T) {
+	cases := map[string]string{
+		"tables:\n  - name: a-b\n    location: s3://example-com/a/\n    format: csv\n":                                                                `catalog table #1: invalid name "a-b"`,
+		"tables:\n  - name: s3object\n    location: s3://example-com/a/\n    format: csv\n":                                                           "catalog table s3object: S3Object is reserved",
+		"tables:\n  - name: a\n    location: s3://example-com/a/\n    format: csv\n  - name: A\n    location: s3://example-com/b/\n    format: csv\n": "catalog table A: duplicated",
+		"tables:\n  - name: a\n    format: csv\n":                                                                                                     "catalog table a: location is empty",
+		"tables:\n  - name: a\n    location: s3://example-com/a/\n":                                                                                   "catalog table a: dsn is invalid: set query params: format is not set",
+		"tables:\n  - name: a\n    location: s3://example-com/a/\n    format: csv\n    params:\n      region: us-east-1\n":                            "catalog table a: client options such as region and endpoint are not supported in tables",
+		"tables:\n  - name: a\n    location: s3://example-com/a/\n    format: csv\n    columns:\n      - name: x\n        type: int\n":                `catalog table a: column x: unsupported type "int"`,
+	}
+	for body, expected := range cases {
+		_, err := ParseCatalog([]byte(body))
+		require.EqualError(t, err, expected, body)
+	}
+	_, err := ParseCatalog([]byte("tables:\n  - name: a\n    path: s3://example-com/a/\n"))
+	require.Error(t, err, "unknown fields are errors")
+}
+
+func TestTableConn__InheritParams(t *testing.T) {
+	parentKey, tableKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	t.Setenv("SSE_KEY", base64.StdEncoding.EncodeToString(parentKey))
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, tableKey, 0600))
+	cfg, err := ParseDSN("s3://example-com/?format=json&expected_bucket_owner=111111111111&request_payer=requester&sse_customer_key_env=SSE_KEY&parse_time=true")
+	require.NoError(t, err)
+	conn := newConn(nil, cfg)
+
+	tc, err := conn.tableConn(&CatalogTable{Name: "logs", Location: "s3://logs-com/logs/", Format: S3SelectFormatJSON})
+	require.NoError(t, err)
+	require.Equal(t, "111111111111", tc.cfg.ExpectedBucketOwner)
+	require.Equal(t, cfg.RequestPayer, tc.cfg.RequestPayer)
+	require.Equal(t, parentKey, tc.cfg.SSECustomerKey)
+	require.True(t, *tc.cfg.ParseTime)
+
+	tc, err = conn.tableConn(&CatalogTable{
+		Name:     "other",
+		Location: "s3://other-com/logs/",
+		Format:   S3SelectFormatJSON,
+		Params:   map[string]string{"expected_bucket_owner": "222222222222", "sse_customer_key_file": keyFile, "parse_time": "false"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "222222222222", tc.cfg.ExpectedBucketOwner, "params of the table override")
+	require.Equal(t, tableKey, tc.cfg.SSECustomerKey)
+	require.Empty(t, tc.cfg.SSECustomerKeyEnv)
+	require.False(t, *tc.cfg.ParseTime)
+}
+
+func TestRewriteFromTable(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM users":                                    "SELECT * FROM S3Object users",
+		"SELECT u.id FROM users u WHERE u.id = 1":                "SELECT u.id FROM S3Object u WHERE u.id = 1",
+		"SELECT users.id FROM users WHERE users.id = 1 LIMIT 10": "SELECT users.id FROM S3Object users WHERE users.id = 1 LIMIT 10",
+		"SELECT COUNT(*) FROM access_logs AS a":                  "SELECT COUNT(*) FROM S3Object AS a",
+		"SELECT (SELECT 1 FROM x) FROM users u":                  "SELECT (SELECT 1 FROM x) FROM S3Object u",
+	}
+	for query, expected := range cases {
+		actual, err := rewriteFromTable(query)
+		require.NoError(t, err, query)
+		require.Equal(t, expected, actual, query)
+	}
+	require.Equal(t, "users", tableOfQuery("SELECT * FROM users u"))
+	require.Equal(t, "", tableOfQuery("SELECT * FROM S3Object s"))
+	require.Equal(t, "", tableOfQuery("SELECT * FROM S3Object[*].Records[*] r"))
+	require.Equal(t, "", tableOfQuery("SELECT * FROM information_schema.tables"))
+}
+
+func TestMock__Catalog(t *testing.T) {
+	var expressions []string
+	var getObjectCalls int
+	mockClients["catalog"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String(*params.Prefix + "1.csv")},
+				},
+			}, nil
+		},
+		GetObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			getObjectCalls++
+			require.Equal(t, "catalog.json", *params.Key)
+			require.Nil(t, params.ExpectedBucketOwner, "expected_bucket_owner is not applied to catalogs")
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader([]byte(`{"tables":[{"name":"events","location":"s3://example-com/events/","format":"json_lines"}]}`))),
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			expressions = append(expressions, *params.Key+": "+*params.Expression)
+			switch *params.Key {
+			case "users/1.csv":
+				require.Equal(t, ";", *params.InputSerialization.CSV.FieldDelimiter)
+				_, err := io.WriteString(w, `{"id":"1","name":"alice"}`+"\n")
+				return err
+			case "events/1.csv":
+				_, err := io.WriteString(w, `{"type":"login"}`+"\n")
+				return err
+			}
+			t.Fatalf("unexpected key %s", *params.Key)
+			return nil
+		},
+	}
+	path := filepath.Join(t.TempDir(), "catalog.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+tables:
+  - name: users
+    location: s3://example-com/users/
+    format: csv
+    params:
+      delimiter: ";"
+    columns:
+      - name: id
+        type: bigint
+        not_null: true
+      - name: name
+        type: string
+`), 0644))
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/?catalog="+path+"&mock=catalog")
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.Background(), `SELECT u.id, u.name FROM users u WHERE u.id = ?`, 1)
+	require.NoError(t, err)
+	columnTypes, err := rows.ColumnTypes()
+	require.NoError(t, err)
+	require.Equal(t, "BIGINT", columnTypes[0].DatabaseTypeName())
+	require.True(t, rows.Next())
+	var id interface{}
+	var name string
+	require.NoError(t, rows.Scan(&id, &name))
+	require.Equal(t, int64(1), id, "declared types convert values of CSV")
+	require.Equal(t, "alice", name)
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"users/1.csv: SELECT u.id, u.name FROM S3Object u WHERE u.id = 1"}, expressions)
+
+	rows, err = db.QueryContext(context.Background(), `DESCRIBE users`)
+	require.NoError(t, err)
+	var described []string
+	for rows.Next() {
+		var name, typ, nullable string
+		require.NoError(t, rows.Scan(&name, &typ, &nullable))
+		described = append(described, name+" "+typ+" "+nullable)
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"id bigint NO", "name string YES"}, described)
+
+	var tables []string
+	rows, err = db.QueryContext(context.Background(), `SELECT table_name, location FROM information_schema.tables`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var name, location string
+		require.NoError(t, rows.Scan(&name, &location))
+		tables = append(tables, name+" "+location)
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"users s3://example-com/users/"}, tables, "the DSN only for the catalog has no S3Object")
+
+	_, err = db.QueryContext(context.Background(), `SELECT * FROM orders o`)
+	require.EqualError(t, err, "unknown table orders")
+
+	// catalog on S3 is read once per connection
+	expressions = nil
+	db2, err := sql.Open("s3-select", "s3://example-com/data.csv?catalog=s3://example-com/catalog.json&expected_bucket_owner=123456789012&mock=catalog")
+	require.NoError(t, err)
+	defer db2.Close()
+	db2.SetMaxOpenConns(1)
+	for i := 0; i < 2; i++ {
+		rows, err = db2.QueryContext(context.Background(), `SELECT * FROM events`)
+		require.NoError(t, err)
+		require.NoError(t, rows.Close())
+	}
+	require.Equal(t, 1, getObjectCalls)
+	require.Equal(t, []string{"events/1.csv: SELECT * FROM S3Object events", "events/1.csv: SELECT * FROM S3Object events"}, expressions)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"io"
 
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -14,6 +13,39 @@ type S3SelectClient interface {
 	s3.ListObjectsV2APIClient
 }
 
+// S3GetObjectClient is implemented by S3SelectClient that can read objects, such as S3SelectClientWithWriter.
+// it is required for reading manifests.
+type S3GetObjectClient interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// S3HeadObjectClient is implemented by S3SelectClient that can read object metadata, such as S3SelectClientWithWriter.
+// it is used for detecting formats with format=auto.
+type S3HeadObjectClient interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// S3PutObjectClient is implemented by S3SelectClient that can write objects, such as S3SelectClientWithWriter.
+// it is required for building the sidecar index.
+type S3PutObjectClient interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// S3MultipartUploadClient is implemented by S3SelectClient that can upload objects in parts, such as S3SelectClientWithWriter.
+// it is required for UNLOAD and CREATE TABLE AS SELECT with S3PutObjectClient.
+type S3MultipartUploadClient interface {
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// S3DeleteObjectClient is implemented by S3SelectClient that can delete objects, such as S3SelectClientWithWriter.
+// it is used for removing objects written by UNLOAD and CREATE TABLE AS SELECT that failed.
+type S3DeleteObjectClient interface {
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
 type S3SelectClientWithWriter struct {
 	*s3.Client
 }
@@ -52,7 +84,7 @@ func newS3SelectClient(ctx context.Context, cfg *S3SelectConfig) (S3SelectClient
 }
 
 func DefaultS3SelectClientConstructor(ctx context.Context, cfg *S3SelectConfig) (S3SelectClient, error) {
-	awsCfg, err := config.LoadDefaultConfig(ctx)
+	awsCfg, err := loadAWSConfig(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
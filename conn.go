@@ -22,20 +22,56 @@ import (
 )
 
 type s3SelectConn struct {
-	client   S3SelectClient
-	cfg      *S3SelectConfig
-	aliveCh  chan struct{}
-	isClosed bool
+	client S3SelectClient
+	cfg    *S3SelectConfig
+	// dsnCfg is the config of the connector, cfg is restored to it by ResetSession after SET.
+	dsnCfg      *S3SelectConfig
+	aliveCh     chan struct{}
+	isClosed    bool
+	errLogger   Logger
+	debugLogger Logger
+	hooks       *Hooks
+
+	// catalog is loaded from CatalogLocation on the first query.
+	catalog *Catalog
+	// glue is shared by connections of the connector, and glueTable is the table of Glue the connection queries.
+	glue      *glueCatalog
+	glueTable *GlueTable
+	// registry is shared by connections of the connector.
+	registry *tableRegistry
+	// resultCache is shared by connections of the connector, nil without cache.
+	resultCache *resultCache
+	// listCache is shared by connections of the connector, nil without list_cache_ttl.
+	listCache *listingCache
+	// indexes caches index files by ETag, it is shared by connections of the connector.
+	indexes *lruCache[objectVersion, *objectIndex]
 }
 
 func newConn(client S3SelectClient, cfg *S3SelectConfig) *s3SelectConn {
 	return &s3SelectConn{
 		client:  client,
 		cfg:     cfg,
+		dsnCfg:  cfg,
 		aliveCh: make(chan struct{}),
 	}
 }
 
+func (conn *s3SelectConn) debugf(format string, v ...any) {
+	if conn.debugLogger != nil {
+		conn.debugLogger.Printf(format, v...)
+		return
+	}
+	debugLogger.Printf(format, v...)
+}
+
+func (conn *s3SelectConn) errorf(format string, v ...any) {
+	if conn.errLogger != nil {
+		conn.errLogger.Printf(format, v...)
+		return
+	}
+	errLogger.Printf(format, v...)
+}
+
 func (conn *s3SelectConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
 	return nil, fmt.Errorf("prepared statment %w", ErrNotSupported)
 }
@@ -53,6 +89,18 @@ func (conn *s3SelectConn) Close() error {
 	return nil
 }
 
+// ResetSession discards parameters of SET before the pooled connection is reused.
+func (conn *s3SelectConn) ResetSession(ctx context.Context) error {
+	if conn.isClosed {
+		return driver.ErrBadConn
+	}
+	if conn.cfg != conn.dsnCfg {
+		conn.cfg = conn.dsnCfg
+		conn.catalog = nil
+	}
+	return nil
+}
+
 func (conn *s3SelectConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
 	return nil, fmt.Errorf("transaction %w", ErrNotSupported)
 }
@@ -64,40 +112,180 @@ func (conn *s3SelectConn) Begin() (driver.Tx, error) {
 type contentInfo struct {
 	BucketName string
 	ObjectKey  string
+	ETag       string
+	Source     *S3SelectSource
+	// Partition is the partition derived from key_template.
+	Partition *partition
+	// LastModified, Size and StorageClass are set for listed objects.
+	LastModified time.Time
+	Size         int64
+	StorageClass string
+	// SourceIndex is the index of the source or the partition.
+	SourceIndex int
+	// Skip is the number of records already emitted from the object, for resumed queries.
+	Skip int
+	// ListingKey is the key of the listing cache the content is read from, the object may be modified or deleted after listing.
+	ListingKey string
 }
 
-func (conn *s3SelectConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
-	debugLogger.Printf("query: %s", query)
+func (conn *s3SelectConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (_ driver.Rows, err error) {
+	conn.hooks.beforeQuery(ctx, query, args)
+	defer func() {
+		conn.hooks.afterQuery(ctx, query, args, err)
+	}()
+	conn.debugf("query: %s", query)
 	if conn.isClosed {
 		return nil, sql.ErrConnDone
 	}
+	return conn.query(ctx, query, args)
+}
+
+// query executes the query for S3Object of the DSN, or for the table of the catalog in FROM.
+func (conn *s3SelectConn) query(ctx context.Context, query string, args []driver.NamedValue) (_ driver.Rows, err error) {
+	if table, ok := describedTable(query); ok {
+		if strings.EqualFold(table, "S3Object") {
+			return conn.describe(ctx)
+		}
+		tableConn, err := conn.tableConnFor(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		return tableConn.describe(ctx)
+	}
 	var limitValue *int
 	if len(args) > 0 || strings.Contains(strings.ToUpper(query), "LIMIT") {
-		var err error
 		query, limitValue, err = conn.rewriteQuery(query, args)
 		if err != nil {
 			return nil, err
 		}
-		debugLogger.Printf("rewrited query: %s", query)
+		conn.debugf("rewrited query: %s", query)
+	}
+	if isInformationSchemaQuery(query) {
+		return conn.queryInformationSchema(ctx, query)
+	}
+	if table := tableOfQuery(query); table != "" && conn.hasTables() {
+		tableConn, err := conn.tableConnFor(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		query, err = rewriteFromTable(query)
+		if err != nil {
+			return nil, err
+		}
+		conn.debugf("table %s: %s", table, query)
+		return tableConn.query(ctx, query, nil)
+	}
+	if preset := conn.cfg.logPreset(); preset != nil && preset.fromRoot != "" && conn.cfg.InputSerialization == nil {
+		query, err = rewriteFromRoot(query, preset.fromRoot)
+		if err != nil {
+			return nil, err
+		}
+		conn.debugf("rewrited query: %s", query)
+	}
+	columnNames := conn.cfg.columnNames()
+	if len(columnNames) > 0 {
+		query, err = rewriteColumnNames(query, columnNames)
+		if err != nil {
+			return nil, err
+		}
+		conn.debugf("rewrited query: %s", query)
+	}
+	var tmpl *keyTemplate
+	var partitions []partition
+	if conn.cfg.KeyTemplate != "" {
+		tmpl, err = parseKeyTemplate(conn.cfg.KeyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		partitions, err = tmpl.planPartitions(conn.cfg.ObjectKeyPrefix, query, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		conn.debugf("key_template: partitions=%d", len(partitions))
+	} else if conn.glueTable.partitioned() {
+		tmpl, partitions, err = conn.planGluePartitions(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		conn.debugf("glue: partitions=%d", len(partitions))
+	}
+	continuation := continuationFromContext(ctx)
+	if conn.cfg.Follow {
+		if continuation != nil {
+			return nil, errors.New("continuation can not be used with follow")
+		}
+		if conn.cfg.Header == S3SelectHeaderFirstObject {
+			return nil, errors.New("header=first_object can not be used with follow")
+		}
+		return conn.follow(ctx, query, args, limitValue, columnNames)
+	}
+	fingerprint := queryFingerprint(conn.cfg, query)
+	resume, err := resumePointFor(continuation, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if resume != nil {
+		conn.debugf("resume from s3://%s/%s offset=%d", resume.Bucket, resume.Key, resume.Offset)
+	}
+	prune := conn.indexPruner(ctx, query)
+	var headerContent *contentInfo
+	if conn.cfg.Header == S3SelectHeaderFirstObject && (resume != nil || prune != nil) {
+		// the first object of the dataset may be skipped by the continuation or the index
+		headerContent, err = conn.firstContent(ctx, partitions)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	eg, egctx := errgroup.WithContext(ctx)
 	contentCh := make(chan contentInfo, 100)
 	limitExceededCh := make(chan struct{})
 	pr, pw := io.Pipe()
+	var footerSchema *Schema
 
 	eg.Go(func() error {
 		defer pw.Close()
-		if err := conn.s3SelectWorker(egctx, query, args, contentCh, pw, limitExceededCh); err != nil {
+		if err := conn.s3SelectWorker(egctx, query, args, contentCh, pw, limitExceededCh, headerContent, &columnNames, &footerSchema); err != nil {
 			return err
 		}
 		return nil
 	})
+	var parseTime bool
+	if conn.cfg.ParseTime != nil {
+		parseTime = *conn.cfg.ParseTime
+	}
+	// newResult converts rows decoded for columns, columns are copied because decoding continues with batches.
+	newResult := func(columns []string, rows [][]interface{}) *s3SelectRows {
+		columns = append([]string{}, columns...)
+		renameColumns(columns, columnNames)
+		conn.cfg.convertPresetColumns(columns, rows)
+		conn.cfg.convertSchemaColumns(columns, rows)
+		if tmpl != nil {
+			tmpl.convertProjectedColumns(columns, rows)
+		}
+		ret := newRows(columns, rows, parseTime)
+		ret.knownSchema = footerSchema
+		if ret.knownSchema == nil {
+			ret.knownSchema = conn.cfg.Schema
+		}
+		return ret
+	}
+	// with the row batch callback, rows are passed by batches instead of the result.
+	emit := rowBatchFromContext(ctx)
 	columns := make([]string, 0)
 	rows := make([][]interface{}, 0)
+	var numRows int
+	// origins are recorded only for continuation tokens
+	var origins []rowOrigin
+	// lastOrigin is the origin after the last decoded record, rows before it are complete.
+	var lastOrigin rowOrigin
 	eg.Go(func() error {
 		defer pr.Close()
 		dec := json.NewDecoder(pr)
+		var origin rowOrigin
+		defer func() {
+			lastOrigin = origin
+		}()
 		for {
 			select {
 			case <-conn.aliveCh:
@@ -109,11 +297,15 @@ func (conn *s3SelectConn) QueryContext(ctx context.Context, query string, args [
 			o := orderedmap.New()
 			if err := dec.Decode(o); err != nil {
 				if err == io.EOF {
-					return nil
+					break
 				}
 				return err
 			}
 			keys := o.Keys()
+			if marker, ok := parseObjectMarker(keys, o.Get); ok {
+				origin = marker
+				continue
+			}
 			for _, key := range keys {
 				// containes key in columns check and if not contains then append
 				if lo.Contains(columns, key) {
@@ -128,83 +320,90 @@ func (conn *s3SelectConn) QueryContext(ctx context.Context, query string, args [
 					row = append(row, nil)
 					continue
 				}
-				switch v := v.(type) {
-				case *orderedmap.OrderedMap:
-					b, err := json.Marshal(v)
-					if err != nil {
-						return err
-					}
-					var nv interface{}
-					if err := json.Unmarshal(b, &nv); err != nil {
-						return err
-					}
-					row = append(row, nv)
-				default:
-					row = append(row, v)
+				value, err := recordValue(v)
+				if err != nil {
+					return err
 				}
+				row = append(row, value)
 			}
 			rows = append(rows, row)
-			if limitValue != nil && len(rows) >= *limitValue {
+			numRows++
+			origin.offset++
+			if continuation != nil && emit == nil {
+				origins = append(origins, origin)
+			}
+			if emit != nil && len(rows) >= rowBatchSize {
+				if err := emit(newResult(columns, rows)); err != nil {
+					return err
+				}
+				rows = make([][]interface{}, 0)
+			}
+			if limitValue != nil && numRows >= *limitValue {
 				close(limitExceededCh)
 				break
 			}
 		}
+		if emit != nil && len(rows) > 0 {
+			if err := emit(newResult(columns, rows)); err != nil {
+				return err
+			}
+			rows = make([][]interface{}, 0)
+		}
 		// vacume io.Reader
 		io.Copy(io.Discard, pr)
 		return nil
 	})
 
-	if conn.cfg.ObjectKey != "" {
-		eg.Go(func() error {
-			defer close(contentCh)
-			contentCh <- contentInfo{
-				BucketName: conn.cfg.BucketName,
-				ObjectKey:  conn.cfg.ObjectKey,
-			}
-			return nil
-		})
-	} else {
-		eg.Go(func() error {
-			defer close(contentCh)
-			p := s3.NewListObjectsV2Paginator(conn.client, &s3.ListObjectsV2Input{
-				Bucket:    aws.String(conn.cfg.BucketName),
-				Prefix:    aws.String(conn.cfg.ObjectKeyPrefix),
-				Delimiter: aws.String("/"),
-			})
-			for p.HasMorePages() {
-				select {
-				case <-conn.aliveCh:
-					return sql.ErrConnDone
-				case <-egctx.Done():
-					return nil
-				default:
-				}
-				output, err := p.NextPage(ctx)
-				if err != nil {
-					return err
-				}
-				for _, content := range output.Contents {
-					contentCh <- contentInfo{
-						BucketName: *output.Name,
-						ObjectKey:  *content.Key,
-					}
-				}
-			}
-			return nil
-		})
+	eg.Go(func() error {
+		defer close(contentCh)
+		return conn.enumerateContents(egctx, partitions, resume, prune, contentCh, limitExceededCh)
+	})
+	err = eg.Wait()
+	if err != nil && (continuation == nil || emit != nil) {
+		return nil, err
+	}
+	ret := newResult(columns, rows)
+	if continuation != nil && emit == nil {
+		ret.continuation = continuation
+		ret.fingerprint = fingerprint
+		ret.origins = origins
+	}
+	if err != nil {
+		// with a continuation, decoded rows are returned and the error is returned after them,
+		// so the query can be resumed from the object that failed.
+		conn.debugf("s3 select failed after rows=%d: %v", numRows, err)
+		ret.err = err
+		ret.errOrigin = lastOrigin
+		return ret, nil
+	}
+	conn.debugf("complete s3 select: rows=%d", numRows)
+	return ret, nil
+}
+
+// recordValue converts nested objects of the record to map[string]interface{}.
+func recordValue(v interface{}) (interface{}, error) {
+	o, ok := v.(*orderedmap.OrderedMap)
+	if !ok {
+		return v, nil
 	}
-	if err := eg.Wait(); err != nil {
+	b, err := json.Marshal(o)
+	if err != nil {
 		return nil, err
 	}
-	debugLogger.Printf("complete s3 select: rows=%d", len(rows))
-	var parseTime bool
-	if conn.cfg.ParseTime != nil {
-		parseTime = *conn.cfg.ParseTime
+	var nv interface{}
+	if err := json.Unmarshal(b, &nv); err != nil {
+		return nil, err
 	}
-	return newRows(columns, rows, parseTime), nil
+	return nv, nil
 }
 
-func (conn *s3SelectConn) s3SelectWorker(ctx context.Context, query string, args []driver.NamedValue, contentCh <-chan contentInfo, w io.Writer, limitExceededCh <-chan struct{}) error {
+// s3SelectWorker executes S3 Select for each content.
+// with header=first_object, columnNames is set by the header of headerContent, or the first object if headerContent is nil.
+// footerSchema is set by the footer of the first parquet object if not nil,
+// and parquet objects whose statistics prove that WHERE does not match are skipped.
+func (conn *s3SelectConn) s3SelectWorker(ctx context.Context, query string, args []driver.NamedValue, contentCh <-chan contentInfo, w io.Writer, limitExceededCh <-chan struct{}, headerContent *contentInfo, columnNames *[]string, footerSchema **Schema) error {
+	headerRead := false
+	hasWhere := hasWhereClause(query)
 	for content := range contentCh {
 		select {
 		case <-conn.aliveCh:
@@ -215,30 +414,105 @@ func (conn *s3SelectConn) s3SelectWorker(ctx context.Context, query string, args
 			return nil
 		default:
 		}
-		inputSerialization, err := conn.cfg.newInputSeliarization()
+		inputSerialization, err := conn.inputSerializationFor(ctx, content)
 		if err != nil {
+			if errors.Is(err, ErrUnknownFormat) && conn.cfg.UnknownFormatPolicy == UnknownFormatPolicySkip {
+				conn.debugf("skip object: %v", err)
+				continue
+			}
+			if conn.deletedAfterListing(content, err) {
+				continue
+			}
+			return err
+		}
+		if inputSerialization.Parquet != nil && (hasWhere || (footerSchema != nil && *footerSchema == nil)) {
+			if conn.skipByParquetFooter(ctx, content, query, hasWhere, footerSchema) {
+				continue
+			}
+		}
+		if conn.cfg.Header == S3SelectHeaderFirstObject && inputSerialization.CSV != nil {
+			if !headerRead {
+				if headerContent == nil {
+					first := content
+					headerContent = &first
+				}
+				names, err := conn.readObjectHeader(ctx, *headerContent)
+				if err != nil {
+					return err
+				}
+				headerRead = true
+				*columnNames = names
+				query, err = rewriteColumnNames(query, names)
+				if err != nil {
+					return err
+				}
+				conn.debugf("rewrited query by header of s3://%s/%s: %s", headerContent.BucketName, headerContent.ObjectKey, query)
+			}
+			isHeader := content.BucketName == headerContent.BucketName && content.ObjectKey == headerContent.ObjectKey
+			inputSerialization = withFirstObjectHeader(inputSerialization, isHeader)
+		}
+		expression, objectWriter := query, w
+		if err := writeObjectMarker(w, content); err != nil {
 			return err
 		}
+		if content.Skip > 0 {
+			expression, err = rewriteLimit(expression, content.Skip)
+			if err != nil {
+				return err
+			}
+			objectWriter = &skipWriter{w: w, skip: content.Skip}
+		}
+		var pw *projectionWriter
+		if content.Partition != nil {
+			var star bool
+			expression, star, err = rewriteProjectedColumns(expression, content.Partition.values)
+			if err != nil {
+				return err
+			}
+			if star {
+				pw, err = newProjectionWriter(objectWriter, content.Partition.values)
+				if err != nil {
+					return err
+				}
+				objectWriter = pw
+			}
+		}
 		input := &s3.SelectObjectContentInput{
 			Bucket:         aws.String(content.BucketName),
 			Key:            aws.String(content.ObjectKey),
-			Expression:     aws.String(query),
+			Expression:     aws.String(expression),
 			ExpressionType: types.ExpressionTypeSql,
 			OutputSerialization: &types.OutputSerialization{
 				JSON: &types.JSONOutput{},
 			},
 			InputSerialization: inputSerialization,
 		}
-		debugLogger.Printf("s3 select key=%s", content.ObjectKey)
-		if err := conn.client.SelectObjectContentWithWriter(ctx, w, input); err != nil {
+		conn.cfg.applySelectObjectContentInput(input)
+		conn.debugf("s3 select key=%s", content.ObjectKey)
+		err = conn.selectObjectContent(ctx, objectWriter, input, content)
+		if err != nil && conn.deletedAfterListing(content, err) {
+			continue
+		}
+		if err == nil && pw != nil {
+			err = pw.Flush()
+		}
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (conn *s3SelectConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
-	return nil, fmt.Errorf("exec %w", ErrNotSupported)
+func (conn *s3SelectConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (_ driver.Result, err error) {
+	conn.hooks.beforeQuery(ctx, query, args)
+	defer func() {
+		conn.hooks.afterQuery(ctx, query, args, err)
+	}()
+	conn.debugf("exec: %s", query)
+	if conn.isClosed {
+		return nil, sql.ErrConnDone
+	}
+	return conn.exec(ctx, query, args)
 }
 
 func (conn *s3SelectConn) rewriteQuery(query string, args []driver.NamedValue) (string, *int, error) {
@@ -265,7 +539,7 @@ func (conn *s3SelectConn) rewriteQuery(query string, args []driver.NamedValue) (
 	var i int
 	var limitFound bool
 	var limitValue *int
-	for _, token := range tokens {
+	for idx, token := range tokens {
 		switch token.Kind {
 		case lexer.KindNamedPlaceholder:
 			if !isNamedArgs {
@@ -275,9 +549,9 @@ func (conn *s3SelectConn) rewriteQuery(query string, args []driver.NamedValue) (
 			if !ok {
 				return "", nil, fmt.Errorf("missing named parameter: %s", strings.TrimPrefix(token.Value, ":"))
 			}
-			s, err := conn.convertNamedArgToString(arg)
+			s, err := conn.convertPlaceholderArgToString(arg, isWrappedByParens(tokens, idx))
 			if err != nil {
-				return "", nil, err
+				return "", nil, fmt.Errorf("failed to convert parameter %s: %w", arg.Name, err)
 			}
 			usedByName[token.Value] = true
 			builder.WriteString(s)
@@ -290,7 +564,7 @@ func (conn *s3SelectConn) rewriteQuery(query string, args []driver.NamedValue) (
 			}
 			arg := args[i]
 			i++
-			s, err := conn.convertNamedArgToString(arg)
+			s, err := conn.convertPlaceholderArgToString(arg, isWrappedByParens(tokens, idx))
 			if err != nil {
 				return "", nil, fmt.Errorf("failed to convert parameter %d: %w", i, err)
 			}
@@ -329,23 +603,51 @@ func (conn *s3SelectConn) rewriteQuery(query string, args []driver.NamedValue) (
 	return "", nil, fmt.Errorf("unexpected EOF query: %s", query)
 }
 
-func (conn *s3SelectConn) convertNamedArgToString(arg driver.NamedValue) (string, error) {
-	switch v := arg.Value.(type) {
-	case string:
-		return `'` + strings.ReplaceAll(v, "'", "''") + `'`, nil
-	case []byte:
-		return `'` + strings.ReplaceAll(string(v), "'", "''") + `'`, nil
-	case int64:
-		return strconv.FormatInt(v, 10), nil
-	case float64:
-		return strconv.FormatFloat(v, 'f', -1, 64), nil
-	case bool:
-		return strconv.FormatBool(v), nil
-	case time.Time:
-		return `'` + v.Format(time.RFC3339) + `'`, nil
-	case nil:
-		return "NULL", nil
-	default:
-		return "", fmt.Errorf("unsupported parameter type: %T", v)
+// convertPlaceholderArgToString renders a placeholder argument.
+// A slice argument is expanded into a literal list; if the placeholder is already wrapped by parentheses like `IN (?)`, the list is written without its own parentheses.
+func (conn *s3SelectConn) convertPlaceholderArgToString(arg driver.NamedValue, wrapped bool) (string, error) {
+	elems, isList, err := listLiteralElements(arg.Value)
+	if err != nil {
+		return "", err
+	}
+	if !isList {
+		return conn.convertNamedArgToString(arg)
+	}
+	if wrapped {
+		return strings.Join(elems, ", "), nil
 	}
+	return "(" + strings.Join(elems, ", ") + ")", nil
+}
+
+// isWrappedByParens reports whether tokens[idx] is the only token (except spaces) between a pair of parentheses.
+func isWrappedByParens(tokens lexer.Tokens, idx int) bool {
+	isParen := func(t lexer.Token, v string) bool {
+		return t.Kind == lexer.KindSymbol && t.Value == v
+	}
+	var before, after bool
+	for j := idx - 1; j >= 0; j-- {
+		if tokens[j].Kind == lexer.KindSpace || tokens[j].Kind == lexer.KindNewline {
+			continue
+		}
+		before = isParen(tokens[j], "(")
+		break
+	}
+	for j := idx + 1; j < len(tokens); j++ {
+		if tokens[j].Kind == lexer.KindSpace || tokens[j].Kind == lexer.KindNewline {
+			continue
+		}
+		after = isParen(tokens[j], ")")
+		break
+	}
+	return before && after
+}
+
+func (conn *s3SelectConn) convertNamedArgToString(arg driver.NamedValue) (string, error) {
+	return encodeLiteral(arg.Value)
+}
+
+// CheckNamedValue implements driver.NamedValueChecker.
+// It accepts every value that can be rendered as an S3 Select literal, see encodeLiteral.
+func (conn *s3SelectConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return checkLiteralValue(nv)
 }
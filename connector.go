@@ -3,19 +3,256 @@ package s3selectsqldriver
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// ConnectorOption configures the connector created by NewConnector.
+type ConnectorOption func(*s3SelectConnector)
+
+// WithAWSConfig sets aws.Config used to create the S3 client instead of config.LoadDefaultConfig.
+func WithAWSConfig(awsCfg aws.Config) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.awsCfg = &awsCfg
+	}
+}
+
+// WithS3SelectClient sets a ready S3SelectClient. it is shared by all connections of the connector.
+func WithS3SelectClient(client S3SelectClient) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.client = client
+	}
+}
+
+// WithS3OptFns appends option functions applied to the S3 client.
+func WithS3OptFns(optFns ...func(*s3.Options)) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.s3OptFns = append(c.s3OptFns, optFns...)
+	}
+}
+
+// WithCredentialsProvider sets a function that returns credentials provider of the S3 client.
+// the returned provider is wrapped by aws.CredentialsCache.
+func WithCredentialsProvider(fn CredentialsProviderFunc) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.credentialsProvider = fn
+	}
+}
+
+// WithLogger sets the error logger of the connector instead of the package logger set by SetLogger.
+func WithLogger(l Logger) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.errLogger = l
+	}
+}
+
+// WithDebugLogger sets the debug logger of the connector instead of the package logger set by SetDebugLogger.
+func WithDebugLogger(l Logger) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.debugLogger = l
+	}
+}
+
+// WithHooks sets callbacks invoked while executing queries.
+func WithHooks(hooks *Hooks) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.hooks = hooks
+	}
+}
+
+// WithGlueClient sets the client of AWS Glue Data Catalog, `FROM database.table` is resolved by it.
+// tables and partitions are cached by the connector for GlueCacheTTL.
+func WithGlueClient(client GlueClient) ConnectorOption {
+	return func(c *s3SelectConnector) {
+		c.glueClient = client
+	}
+}
+
 type s3SelectConnector struct {
-	d   *s3SelectDriver
-	cfg *S3SelectConfig
+	d                   *s3SelectDriver
+	cfg                 *S3SelectConfig
+	awsCfg              *aws.Config
+	s3OptFns            []func(*s3.Options)
+	credentialsProvider CredentialsProviderFunc
+	errLogger           Logger
+	debugLogger         Logger
+	hooks               *Hooks
+	glueClient          GlueClient
+
+	mu     sync.Mutex
+	client S3SelectClient
+	glue   *glueCatalog
+	// registry holds tables created by CREATE EXTERNAL TABLE on any connection.
+	registry    *tableRegistry
+	resultCache *resultCache
+	listCache   *listingCache
+	indexes     *lruCache[objectVersion, *objectIndex]
+}
+
+// NewConnector returns a driver.Connector for sql.OpenDB.
+//
+//	db := sql.OpenDB(s3selectsqldriver.NewConnector(cfg, s3selectsqldriver.WithAWSConfig(awsCfg)))
+//
+// The S3 client is created on the first connection and shared by all pooled connections.
+func NewConnector(cfg *S3SelectConfig, opts ...ConnectorOption) driver.Connector {
+	return newConnector(&s3SelectDriver{}, cfg, opts...)
+}
+
+func newConnector(d *s3SelectDriver, cfg *S3SelectConfig, opts ...ConnectorOption) *s3SelectConnector {
+	c := &s3SelectConnector{
+		d:        d,
+		cfg:      cfg,
+		registry: &tableRegistry{},
+		indexes:  newLRUCache[objectVersion, *objectIndex](indexCacheSize),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 func (c *s3SelectConnector) Connect(ctx context.Context) (driver.Conn, error) {
-	client, err := newS3SelectClient(ctx, c.cfg)
+	if c.cfg == nil {
+		return nil, errors.New("s3 select config is nil")
+	}
+	client, err := c.getClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return newConn(client, c.cfg), nil
+	conn := newConn(client, c.cfg)
+	conn.errLogger = c.errLogger
+	conn.debugLogger = c.debugLogger
+	conn.hooks = c.hooks
+	conn.glue, err = c.getGlueCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn.registry = c.registry
+	conn.resultCache = c.getResultCache()
+	conn.listCache = c.getListingCache()
+	conn.indexes = c.indexes
+	return conn, nil
+}
+
+func (c *s3SelectConnector) getListingCache() *listingCache {
+	if c.cfg.ListCacheTTL == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listCache == nil {
+		c.listCache = newListingCache(c.cfg)
+	}
+	return c.listCache
+}
+
+func (c *s3SelectConnector) getResultCache() *resultCache {
+	if c.cfg.Cache == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resultCache == nil {
+		c.resultCache = newResultCache(c.cfg)
+	}
+	return c.resultCache
+}
+
+// getGlueCatalog returns the catalog by the client of WithGlueClient, or by the Glue client of the AWS config with glue=true.
+func (c *s3SelectConnector) getGlueCatalog(ctx context.Context) (*glueCatalog, error) {
+	if c.glueClient == nil && !c.cfg.Glue {
+		return nil, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.glue != nil {
+		return c.glue, nil
+	}
+	client := c.glueClient
+	if client == nil {
+		var err error
+		client, err = c.newGlueClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("create glue client: %w", err)
+		}
+	}
+	c.glue = newGlueCatalog(client, c.cfg.GlueCacheTTL)
+	return c.glue, nil
+}
+
+func (c *s3SelectConnector) newGlueClient(ctx context.Context) (GlueClient, error) {
+	if GlueClientConstructor != nil {
+		return GlueClientConstructor(ctx, c.cfg)
+	}
+	awsCfg, err := c.loadAWSConfig(ctx, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewGlueClient(glue.NewFromConfig(awsCfg)), nil
+}
+
+func (c *s3SelectConnector) getClient(ctx context.Context) (S3SelectClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	cfg := c.cfg
+	if len(c.s3OptFns) > 0 {
+		copied := *c.cfg
+		copied.S3OptFns = append(append([]func(*s3.Options){}, c.cfg.S3OptFns...), c.s3OptFns...)
+		cfg = &copied
+	}
+	if c.awsCfg == nil && c.credentialsProvider == nil {
+		client, err := newS3SelectClient(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+		return client, nil
+	}
+	awsCfg, err := c.loadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := S3SelectClientWithWriter{
+		Client: s3.NewFromConfig(awsCfg, cfg.S3OptFns...),
+	}
+	c.client = client
+	return client, nil
+}
+
+func (c *s3SelectConnector) loadAWSConfig(ctx context.Context, cfg *S3SelectConfig) (aws.Config, error) {
+	var awsCfg aws.Config
+	if c.awsCfg != nil {
+		awsCfg = c.awsCfg.Copy()
+		// the role is assumed by the credentials of the aws.Config, so the credentials are not shared with other connectors
+		if err := applyCredentials(cfg, &awsCfg, nil); err != nil {
+			return aws.Config{}, err
+		}
+	} else {
+		var err error
+		awsCfg, err = loadAWSConfig(ctx, cfg)
+		if err != nil {
+			return aws.Config{}, err
+		}
+	}
+	if c.credentialsProvider != nil {
+		provider, err := c.credentialsProvider(ctx, cfg, awsCfg)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		if _, ok := provider.(*aws.CredentialsCache); !ok && provider != nil {
+			provider = aws.NewCredentialsCache(provider)
+		}
+		awsCfg.Credentials = provider
+	}
+	return awsCfg, nil
 }
 
 func (c *s3SelectConnector) Driver() driver.Driver {
@@ -0,0 +1,121 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConnector__WithS3SelectClient(t *testing.T) {
+	newClient := func(name string) S3SelectClient {
+		return &mockS3SelectClient{
+			SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+				fmt.Fprintf(w, `{"client":"%s","key":"%s"}`+"\n", name, *params.Key)
+				return nil
+			},
+		}
+	}
+	db1 := sql.OpenDB(NewConnector(&S3SelectConfig{
+		BucketName:      "example-com",
+		ObjectKey:       "csv/data.csv",
+		Format:          S3SelectFormatCSV,
+		CompressionType: S3SelectCompressionTypeNone,
+	}, WithS3SelectClient(newClient("first"))))
+	defer db1.Close()
+	db2 := sql.OpenDB(NewConnector(&S3SelectConfig{
+		BucketName:      "example-com",
+		ObjectKey:       "json/data.json",
+		Format:          S3SelectFormatJSON,
+		CompressionType: S3SelectCompressionTypeNone,
+	}, WithS3SelectClient(newClient("second"))))
+	defer db2.Close()
+
+	var client, key string
+	require.NoError(t, db1.QueryRowContext(context.Background(), `SELECT * FROM s3object`).Scan(&client, &key))
+	require.Equal(t, "first", client)
+	require.Equal(t, "csv/data.csv", key)
+	require.NoError(t, db2.QueryRowContext(context.Background(), `SELECT * FROM s3object`).Scan(&client, &key))
+	require.Equal(t, "second", client)
+	require.Equal(t, "json/data.json", key)
+}
+
+func TestNewConnector__SharedClient(t *testing.T) {
+	var applied int
+	connector := NewConnector(&S3SelectConfig{
+		BucketName:      "example-com",
+		ObjectKey:       "csv/data.csv",
+		Format:          S3SelectFormatCSV,
+		CompressionType: S3SelectCompressionTypeNone,
+	},
+		WithAWSConfig(aws.Config{Region: "ap-northeast-1"}),
+		WithS3OptFns(func(o *s3.Options) {
+			require.Equal(t, "ap-northeast-1", o.Region)
+			applied++
+		}),
+	)
+	require.Equal(t, 0, applied)
+	conn1, err := connector.Connect(context.Background())
+	require.NoError(t, err)
+	defer conn1.Close()
+	conn2, err := connector.Connect(context.Background())
+	require.NoError(t, err)
+	defer conn2.Close()
+	require.Same(t, conn1.(*s3SelectConn).client.(S3SelectClientWithWriter).Client, conn2.(*s3SelectConn).client.(S3SelectClientWithWriter).Client)
+	require.Equal(t, 1, applied)
+}
+
+func TestNewConnector__LoggerAndHooks(t *testing.T) {
+	var debugBuilder strings.Builder
+	var events []string
+	connector := NewConnector(&S3SelectConfig{
+		BucketName:      "example-com",
+		ObjectKey:       "csv/data.csv",
+		Format:          S3SelectFormatCSV,
+		CompressionType: S3SelectCompressionTypeNone,
+	},
+		WithS3SelectClient(&mockS3SelectClient{
+			SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+				require.Equal(t, "hooked", *params.Bucket)
+				fmt.Fprintf(w, `{"id":1}`+"\n")
+				return nil
+			},
+		}),
+		WithDebugLogger(log.New(&debugBuilder, "", 0)),
+		WithHooks(&Hooks{
+			BeforeQuery: func(ctx context.Context, query string, args []driver.NamedValue) {
+				events = append(events, "before_query:"+query)
+			},
+			AfterQuery: func(ctx context.Context, query string, args []driver.NamedValue, err error) {
+				events = append(events, fmt.Sprintf("after_query:%v", err))
+			},
+			BeforeSelectObjectContent: func(ctx context.Context, input *s3.SelectObjectContentInput) {
+				events = append(events, "before_select:"+*input.Key)
+				input.Bucket = aws.String("hooked")
+			},
+			AfterSelectObjectContent: func(ctx context.Context, input *s3.SelectObjectContentInput, err error) {
+				events = append(events, fmt.Sprintf("after_select:%v", err))
+			},
+		}),
+	)
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	var id int64
+	require.NoError(t, db.QueryRowContext(context.Background(), `SELECT * FROM s3object`).Scan(&id))
+	require.EqualValues(t, 1, id)
+	require.Equal(t, []string{
+		"before_query:SELECT * FROM s3object",
+		"before_select:csv/data.csv",
+		"after_select:<nil>",
+		"after_query:<nil>",
+	}, events)
+	require.Contains(t, debugBuilder.String(), "query: SELECT * FROM s3object")
+}
@@ -0,0 +1,210 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+// Continuation holds the continuation token of a query, the token is updated as rows are read.
+// a query with the token of an interrupted query skips already processed objects and already emitted records.
+//
+//	c := s3selectsqldriver.NewContinuation(savedToken)
+//	rows, err := db.QueryContext(s3selectsqldriver.WithContinuation(ctx, c), `SELECT * FROM S3Object s`)
+//	for rows.Next() {
+//		// ...
+//		savedToken = c.Token()
+//	}
+type Continuation struct {
+	mu    sync.Mutex
+	token string
+}
+
+// NewContinuation returns Continuation which resumes from the token. an empty token starts from the beginning.
+func NewContinuation(token string) *Continuation {
+	return &Continuation{token: token}
+}
+
+// Token returns the opaque continuation token after the last read row.
+func (c *Continuation) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *Continuation) setToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+type continuationKey struct{}
+
+// WithContinuation returns the context for QueryContext, which resumes from and records the continuation token.
+func WithContinuation(ctx context.Context, c *Continuation) context.Context {
+	return context.WithValue(ctx, continuationKey{}, c)
+}
+
+func continuationFromContext(ctx context.Context) *Continuation {
+	c, _ := ctx.Value(continuationKey{}).(*Continuation)
+	return c
+}
+
+// resumePoint is the content of the continuation token.
+type resumePoint struct {
+	// Fingerprint is the hash of the DSN and the query, the token can not be used for other queries.
+	Fingerprint string `json:"f"`
+	// Source is the index of the source or the partition of key_template.
+	Source int    `json:"s"`
+	Bucket string `json:"b"`
+	Key    string `json:"k"`
+	// Offset is the number of records emitted from the object.
+	Offset int `json:"o"`
+}
+
+func (p resumePoint) encode() string {
+	bs, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeResumePoint(token string) (*resumePoint, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid continuation token: %w", err)
+	}
+	var p resumePoint
+	if err := json.Unmarshal(bs, &p); err != nil {
+		return nil, fmt.Errorf("invalid continuation token: %w", err)
+	}
+	if p.Key == "" || p.Offset < 0 {
+		return nil, errors.New("invalid continuation token")
+	}
+	return &p, nil
+}
+
+func queryFingerprint(cfg *S3SelectConfig, query string) string {
+	h := sha256.Sum256([]byte(cfg.String() + "\n" + query))
+	return hex.EncodeToString(h[:8])
+}
+
+// resumePointFor returns the resume point of the continuation token for the query, nil if the token is empty.
+func resumePointFor(c *Continuation, fingerprint string) (*resumePoint, error) {
+	if c == nil || c.Token() == "" {
+		return nil, nil
+	}
+	p, err := decodeResumePoint(c.Token())
+	if err != nil {
+		return nil, err
+	}
+	if p.Fingerprint != fingerprint {
+		return nil, errors.New("continuation token is for another query")
+	}
+	return p, nil
+}
+
+// objectMarkerKey is the key of the marker record written before records of each object,
+// the decoder knows which object the following records come from.
+const objectMarkerKey = "\x00object"
+
+func writeObjectMarker(w io.Writer, content contentInfo) error {
+	bs, err := json.Marshal(map[string]interface{}{
+		objectMarkerKey: []interface{}{content.SourceIndex, content.BucketName, content.ObjectKey, content.Skip},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
+
+// rowOrigin is the object and the offset of the record.
+type rowOrigin struct {
+	source int
+	bucket string
+	key    string
+	offset int
+}
+
+// parseObjectMarker returns the origin of the following records if the record is the object marker.
+func parseObjectMarker(keys []string, get func(string) (interface{}, bool)) (rowOrigin, bool) {
+	if len(keys) != 1 || keys[0] != objectMarkerKey {
+		return rowOrigin{}, false
+	}
+	v, _ := get(objectMarkerKey)
+	values, ok := v.([]interface{})
+	if !ok || len(values) != 4 {
+		return rowOrigin{}, false
+	}
+	source, _ := values[0].(float64)
+	bucket, _ := values[1].(string)
+	key, _ := values[2].(string)
+	skip, _ := values[3].(float64)
+	return rowOrigin{source: int(source), bucket: bucket, key: key, offset: int(skip)}, true
+}
+
+// skipWriter discards the first records of newline delimited JSON.
+type skipWriter struct {
+	w    io.Writer
+	skip int
+}
+
+func (sw *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for sw.skip > 0 && len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			return n, nil
+		}
+		p = p[i+1:]
+		sw.skip--
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	if _, err := sw.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// rewriteLimit adds delta to the value of LIMIT, for the resumed object whose records are skipped.
+func rewriteLimit(query string, delta int) (string, error) {
+	l := lexer.NewLexer(query)
+	tokens, err := l.Lex()
+	if err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	var limitFound bool
+	for _, token := range tokens {
+		switch token.Kind {
+		case lexer.KindIdentifier:
+			if strings.EqualFold(token.Value, "LIMIT") {
+				limitFound = true
+			}
+		case lexer.KindNumber:
+			if limitFound {
+				v, err := strconv.Atoi(token.Value)
+				if err != nil {
+					return "", fmt.Errorf("failed to parse limit value: %w", err)
+				}
+				builder.WriteString(strconv.Itoa(v + delta))
+				limitFound = false
+				continue
+			}
+		}
+		builder.WriteString(token.Value)
+	}
+	return builder.String(), nil
+}
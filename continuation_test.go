@@ -0,0 +1,206 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMock__Continuation(t *testing.T) {
+	keys := []string{"data/1.json", "data/2.json", "data/3.json"}
+	var startAfters []string
+	mockClients["continuation"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			startAfters = append(startAfters, aws.ToString(params.StartAfter))
+			output := &s3.ListObjectsV2Output{Name: params.Bucket}
+			for _, key := range keys {
+				if key > aws.ToString(params.StartAfter) {
+					output.Contents = append(output.Contents, types.Object{Key: aws.String(key)})
+				}
+			}
+			return output, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			var limit int
+			_, err := fmt.Sscanf((*params.Expression)[strings.Index(*params.Expression, "LIMIT"):], "LIMIT %d", &limit)
+			require.NoError(t, err)
+			for i := 1; i <= 2 && i <= limit; i++ {
+				fmt.Fprintf(w, `{"id":"%s#%d"}`+"\n", *params.Key, i)
+			}
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json&mock=continuation")
+	require.NoError(t, err)
+	defer db.Close()
+
+	query := func(token string) ([]string, string) {
+		t.Helper()
+		c := NewContinuation(token)
+		rows, err := db.QueryContext(WithContinuation(context.Background(), c), `SELECT * FROM S3Object s LIMIT 3`)
+		require.NoError(t, err)
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		require.NoError(t, rows.Err())
+		return ids, c.Token()
+	}
+	ids, token := query("")
+	require.Equal(t, []string{"data/1.json#1", "data/1.json#2", "data/2.json#1"}, ids)
+	require.NotEmpty(t, token)
+
+	ids, token = query(token)
+	require.Equal(t, []string{"data/2.json#2", "data/3.json#1", "data/3.json#2"}, ids)
+
+	ids, last := query(token)
+	require.Empty(t, ids)
+	require.Equal(t, token, last)
+	require.Equal(t, []string{"", "data/2.json", "data/3.json"}, startAfters)
+
+	_, err = db.QueryContext(WithContinuation(context.Background(), NewContinuation(token)), `SELECT s.id FROM S3Object s LIMIT 3`)
+	require.EqualError(t, err, "continuation token is for another query")
+	_, err = db.QueryContext(WithContinuation(context.Background(), NewContinuation("invalid")), `SELECT * FROM S3Object s LIMIT 3`)
+	require.ErrorContains(t, err, "invalid continuation token")
+}
+
+func TestMock__Continuation__Error(t *testing.T) {
+	keys := []string{"data/1.json", "data/2.json", "data/3.json"}
+	fail := true
+	var selected []string
+	mockClients["continuation_error"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			output := &s3.ListObjectsV2Output{Name: params.Bucket}
+			for _, key := range keys {
+				if key > aws.ToString(params.StartAfter) {
+					output.Contents = append(output.Contents, types.Object{Key: aws.String(key)})
+				}
+			}
+			return output, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			selected = append(selected, *params.Key)
+			if fail && *params.Key == "data/3.json" {
+				return errors.New("boom")
+			}
+			for i := 1; i <= 2; i++ {
+				fmt.Fprintf(w, `{"id":"%s#%d"}`+"\n", *params.Key, i)
+			}
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json&mock=continuation_error")
+	require.NoError(t, err)
+	defer db.Close()
+
+	query := func(token string) ([]string, string, error) {
+		t.Helper()
+		selected = nil
+		c := NewContinuation(token)
+		rows, err := db.QueryContext(WithContinuation(context.Background(), c), `SELECT * FROM S3Object s`)
+		require.NoError(t, err)
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		return ids, c.Token(), rows.Err()
+	}
+	ids, token, err := query("")
+	require.EqualError(t, err, "boom")
+	require.Equal(t, []string{"data/1.json#1", "data/1.json#2", "data/2.json#1", "data/2.json#2"}, ids, "rows before the error are returned")
+	require.NotEmpty(t, token)
+
+	fail = false
+	ids, _, err = query(token)
+	require.NoError(t, err)
+	require.Equal(t, []string{"data/3.json#1", "data/3.json#2"}, ids)
+	require.Equal(t, []string{"data/3.json"}, selected, "the query is resumed at the failed object")
+
+	fail = true
+	_, err = db.QueryContext(context.Background(), `SELECT * FROM S3Object s`)
+	require.EqualError(t, err, "boom", "without continuation, the query fails")
+}
+
+func TestMock__Continuation__KeyTemplate(t *testing.T) {
+	var expressions []string
+	mockClients["continuation_key_template"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			output := &s3.ListObjectsV2Output{Name: params.Bucket}
+			if key := *params.Prefix + "1.json"; key > aws.ToString(params.StartAfter) {
+				output.Contents = append(output.Contents, types.Object{Key: aws.String(key)})
+			}
+			return output, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			expressions = append(expressions, *params.Expression)
+			var limit int
+			_, err := fmt.Sscanf((*params.Expression)[strings.Index(*params.Expression, "LIMIT"):], "LIMIT %d", &limit)
+			require.NoError(t, err)
+			part := strings.TrimPrefix(path.Dir(*params.Key), "data/part=")
+			for i := 1; i <= 5 && i <= limit; i++ {
+				fmt.Fprintf(w, `{"id":"%s#%d","part":"%s"}`+"\n", *params.Key, i, part)
+			}
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json&key_template=part={part:enum:a|b}/&mock=continuation_key_template")
+	require.NoError(t, err)
+	defer db.Close()
+
+	query := func(token string) ([]string, string) {
+		t.Helper()
+		expressions = nil
+		c := NewContinuation(token)
+		rows, err := db.QueryContext(WithContinuation(context.Background(), c), `SELECT s.id, s.part FROM S3Object s LIMIT 2`)
+		require.NoError(t, err)
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id, part string
+			require.NoError(t, rows.Scan(&id, &part))
+			ids = append(ids, id+":"+part)
+		}
+		require.NoError(t, rows.Err())
+		return ids, c.Token()
+	}
+	ids, token := query("")
+	require.Equal(t, []string{"data/part=a/1.json#1:a", "data/part=a/1.json#2:a"}, ids)
+	require.Equal(t, "SELECT s.id, 'a' AS part FROM S3Object s LIMIT 2", expressions[0])
+
+	ids, _ = query(token)
+	require.Equal(t, []string{"data/part=a/1.json#3:a", "data/part=a/1.json#4:a"}, ids)
+	require.Equal(t, "SELECT s.id, 'a' AS part FROM S3Object s LIMIT 4", expressions[0], "the resumed object skips 2 records by LIMIT")
+}
+
+func TestSkipWriter(t *testing.T) {
+	var buf strings.Builder
+	sw := &skipWriter{w: &buf, skip: 2}
+	for _, chunk := range []string{`{"id":1}`, "\n{\"id\"", ":2}\n{\"id\":3}\n", `{"id":4}` + "\n"} {
+		n, err := sw.Write([]byte(chunk))
+		require.NoError(t, err)
+		require.Equal(t, len(chunk), n)
+	}
+	require.Equal(t, `{"id":3}`+"\n"+`{"id":4}`+"\n", buf.String())
+}
@@ -0,0 +1,108 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CredentialsProviderFunc returns aws.CredentialsProvider for the config.
+// awsCfg is the loaded base config, its Credentials can be used as source credentials.
+type CredentialsProviderFunc func(ctx context.Context, cfg *S3SelectConfig, awsCfg aws.Config) (aws.CredentialsProvider, error)
+
+// sharedCredentialsCacheSize is the max number of identities of sharedCredentialsCaches.
+const sharedCredentialsCacheSize = 100
+
+// sharedCredentialsCaches shares credentials of assumed roles by connectors with the default credential chain.
+// connectors with their own aws.Config have their own caches, because the source identity may be different.
+var sharedCredentialsCaches = newLRUCache[string, *aws.CredentialsCache](sharedCredentialsCacheSize)
+
+// loadAWSConfig loads aws.Config with the profile and region of the config, and applies the credentials of the config.
+func loadAWSConfig(ctx context.Context, cfg *S3SelectConfig) (aws.Config, error) {
+	var optFns []func(*config.LoadOptions) error
+	if cfg.Profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(cfg.Profile))
+	}
+	if region := cfg.Params.Get("region"); region != "" {
+		optFns = append(optFns, config.WithRegion(region))
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if err := applyCredentials(cfg, &awsCfg, sharedCredentialsCaches); err != nil {
+		return aws.Config{}, err
+	}
+	return awsCfg, nil
+}
+
+// applyCredentials replaces awsCfg.Credentials, if the config requires anonymous access or assuming a role.
+// credentials of the role are shared per identity by caches, if caches is not nil.
+// caches must be used only for the same source credentials.
+func applyCredentials(cfg *S3SelectConfig, awsCfg *aws.Config, caches *lruCache[string, *aws.CredentialsCache]) error {
+	if cfg.Anonymous {
+		if cfg.RoleARN != "" || cfg.WebIdentityTokenFile != "" {
+			return errors.New("anonymous and role_arn are exclusive")
+		}
+		awsCfg.Credentials = aws.AnonymousCredentials{}
+		return nil
+	}
+	if cfg.RoleARN == "" {
+		if cfg.WebIdentityTokenFile != "" {
+			return errors.New("web_identity_token_file requires role_arn")
+		}
+		return nil
+	}
+	key := cfg.identityKey()
+	if caches != nil {
+		if cache, ok := caches.get(key); ok {
+			awsCfg.Credentials = cache
+			return nil
+		}
+	}
+	client := sts.NewFromConfig(*awsCfg)
+	var provider aws.CredentialsProvider
+	if cfg.WebIdentityTokenFile != "" {
+		provider = stscreds.NewWebIdentityRoleProvider(
+			client,
+			cfg.RoleARN,
+			stscreds.IdentityTokenFile(cfg.WebIdentityTokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = cfg.RoleSessionName
+				o.Duration = cfg.RoleDuration
+			},
+		)
+	} else {
+		provider = stscreds.NewAssumeRoleProvider(client, cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+			o.RoleSessionName = cfg.RoleSessionName
+			o.Duration = cfg.RoleDuration
+		})
+	}
+	cache := aws.NewCredentialsCache(provider)
+	if caches != nil {
+		caches.add(key, cache)
+	}
+	awsCfg.Credentials = cache
+	return nil
+}
+
+// identityKey returns the key of credentials, the same key means the same identity.
+func (cfg *S3SelectConfig) identityKey() string {
+	return strings.Join([]string{
+		cfg.Profile,
+		cfg.Params.Get("region"),
+		cfg.RoleARN,
+		cfg.ExternalID,
+		cfg.RoleSessionName,
+		cfg.RoleDuration.String(),
+		cfg.WebIdentityTokenFile,
+	}, "\x00")
+}
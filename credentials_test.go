@@ -0,0 +1,107 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyCredentials(t *testing.T) {
+	base := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+
+	t.Run("default", func(t *testing.T) {
+		awsCfg := base.Copy()
+		require.NoError(t, applyCredentials(&S3SelectConfig{}, &awsCfg, nil))
+		require.Equal(t, base.Credentials, awsCfg.Credentials)
+	})
+	t.Run("anonymous", func(t *testing.T) {
+		awsCfg := base.Copy()
+		require.NoError(t, applyCredentials(&S3SelectConfig{Anonymous: true}, &awsCfg, nil))
+		require.Equal(t, aws.AnonymousCredentials{}, awsCfg.Credentials)
+	})
+	t.Run("web identity without role", func(t *testing.T) {
+		awsCfg := base.Copy()
+		require.EqualError(t, applyCredentials(&S3SelectConfig{WebIdentityTokenFile: "/tmp/token"}, &awsCfg, nil), "web_identity_token_file requires role_arn")
+	})
+	t.Run("assume role is shared per identity", func(t *testing.T) {
+		cfg := &S3SelectConfig{
+			RoleARN:    "arn:aws:iam::123456789012:role/shared",
+			ExternalID: "external",
+		}
+		caches := newLRUCache[string, *aws.CredentialsCache](1)
+		awsCfg1 := base.Copy()
+		require.NoError(t, applyCredentials(cfg, &awsCfg1, caches))
+		require.IsType(t, &aws.CredentialsCache{}, awsCfg1.Credentials)
+		awsCfg2 := base.Copy()
+		require.NoError(t, applyCredentials(&S3SelectConfig{
+			RoleARN:    "arn:aws:iam::123456789012:role/shared",
+			ExternalID: "external",
+		}, &awsCfg2, caches))
+		require.Same(t, awsCfg1.Credentials, awsCfg2.Credentials)
+		awsCfg3 := base.Copy()
+		require.NoError(t, applyCredentials(&S3SelectConfig{
+			RoleARN:    "arn:aws:iam::123456789012:role/shared",
+			ExternalID: "other",
+		}, &awsCfg3, caches))
+		require.NotSame(t, awsCfg1.Credentials, awsCfg3.Credentials)
+		_, ok := caches.get(cfg.identityKey())
+		require.False(t, ok, "the least recently used identity is evicted")
+	})
+	t.Run("assume role is not shared without caches", func(t *testing.T) {
+		cfg := &S3SelectConfig{RoleARN: "arn:aws:iam::123456789012:role/shared"}
+		awsCfg1 := base.Copy()
+		require.NoError(t, applyCredentials(cfg, &awsCfg1, nil))
+		awsCfg2 := base.Copy()
+		require.NoError(t, applyCredentials(cfg, &awsCfg2, nil))
+		require.NotSame(t, awsCfg1.Credentials, awsCfg2.Credentials)
+	})
+}
+
+func TestNewConnector__WithCredentialsProvider(t *testing.T) {
+	server := newFakeS3Server(t)
+	server.SelectFunc = func(r *http.Request, bucket, key, expression string) (string, error) {
+		return `{"id":1}` + "\n", nil
+	}
+	cfg, err := ParseDSN("s3://example-com/data.csv?use_path_style=true&endpoint=" + url.QueryEscape(server.URL))
+	require.NoError(t, err)
+	var called int
+	db := sql.OpenDB(NewConnector(cfg, WithCredentialsProvider(func(ctx context.Context, cfg *S3SelectConfig, awsCfg aws.Config) (aws.CredentialsProvider, error) {
+		called++
+		return credentials.NewStaticCredentialsProvider("AKIAHOOKED", "SECRET", ""), nil
+	})))
+	defer db.Close()
+	var id int64
+	require.NoError(t, db.QueryRowContext(context.Background(), `SELECT * FROM s3object`).Scan(&id))
+	require.NoError(t, db.QueryRowContext(context.Background(), `SELECT * FROM s3object`).Scan(&id))
+	require.Equal(t, 1, called)
+	requests := server.Requests()
+	require.Len(t, requests, 2)
+	for _, r := range requests {
+		require.True(t, strings.Contains(r.Header.Get("Authorization"), "Credential=AKIAHOOKED/"), r.Header.Get("Authorization"))
+	}
+}
+
+func TestFakeS3__Anonymous(t *testing.T) {
+	server := newFakeS3Server(t)
+	server.SelectFunc = func(r *http.Request, bucket, key, expression string) (string, error) {
+		return `{"id":1}` + "\n", nil
+	}
+	dsn := "s3://example-com/data.csv?anonymous=true&use_path_style=true&endpoint=" + url.QueryEscape(server.URL)
+	runTestsWithDB(t, dsn, func(t *testing.T, db *sql.DB) {
+		var id int64
+		require.NoError(t, db.QueryRowContext(context.Background(), `SELECT * FROM s3object`).Scan(&id))
+	})
+	requests := server.Requests()
+	require.Len(t, requests, 1)
+	require.Empty(t, requests[0].Header.Get("Authorization"))
+}
@@ -0,0 +1,373 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+// S3SelectHeader is how the first line of CSV objects is handled.
+type S3SelectHeader string
+
+const (
+	// S3SelectHeaderUse uses the first line as column names.
+	S3SelectHeaderUse S3SelectHeader = "use"
+	// S3SelectHeaderIgnore skips the first line, columns are _1.._N or ColumnNames.
+	S3SelectHeaderIgnore S3SelectHeader = "ignore"
+	// S3SelectHeaderNone treats the first line as a record, columns are _1.._N or ColumnNames.
+	S3SelectHeaderNone S3SelectHeader = "none"
+	// S3SelectHeaderFirstObject uses the first line of the first object as column names, other objects have no header.
+	S3SelectHeaderFirstObject S3SelectHeader = "first_object"
+)
+
+// csvDialectParams are DSN parameters of CSV dialect, they are exclusive with input_serialization.
+var csvDialectParams = []string{
+	"header",
+	"delimiter",
+	"record_delimiter",
+	"quote",
+	"quote_escape",
+	"comments",
+	"allow_quoted_record_delimiter",
+	"column_names",
+}
+
+func (cfg *S3SelectConfig) setCSVDialectParams(params url.Values) error {
+	if params.Has("header") {
+		switch header := S3SelectHeader(strings.ToLower(params.Get("header"))); header {
+		case S3SelectHeaderUse, S3SelectHeaderIgnore, S3SelectHeaderNone, S3SelectHeaderFirstObject:
+			cfg.Header = header
+		default:
+			return fmt.Errorf("unknown header: %s", params.Get("header"))
+		}
+		cfg.Params.Del("header")
+	}
+	stringParams := []struct {
+		name string
+		dest *string
+	}{
+		{name: "delimiter", dest: &cfg.Delimiter},
+		{name: "record_delimiter", dest: &cfg.RecordDelimiter},
+		{name: "quote", dest: &cfg.Quote},
+		{name: "quote_escape", dest: &cfg.QuoteEscape},
+		{name: "comments", dest: &cfg.Comments},
+	}
+	for _, p := range stringParams {
+		if !params.Has(p.name) {
+			continue
+		}
+		*p.dest = unescapeDialectString(params.Get(p.name))
+		cfg.Params.Del(p.name)
+	}
+	if params.Has("allow_quoted_record_delimiter") {
+		b, err := strconv.ParseBool(params.Get("allow_quoted_record_delimiter"))
+		if err != nil {
+			return fmt.Errorf("parse allow_quoted_record_delimiter: %w", err)
+		}
+		cfg.AllowQuotedRecordDelimiter = b
+		cfg.Params.Del("allow_quoted_record_delimiter")
+	}
+	if params.Has("column_names") {
+		for _, name := range strings.Split(params.Get("column_names"), ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return errors.New("column_names has empty name")
+			}
+			cfg.ColumnNames = append(cfg.ColumnNames, name)
+		}
+		cfg.Params.Del("column_names")
+	}
+	return cfg.validateCSVDialect()
+}
+
+func (cfg *S3SelectConfig) validateCSVDialect() error {
+	switch cfg.Format {
+	case S3SelectFormatJSON, S3SelectFormatJSONL, S3SelectFormatParquet, S3SelectFormatCloudTrail, S3SelectFormatWAF:
+		if cfg.hasCSVDialect() {
+			return fmt.Errorf("csv dialect parameters can not be used with format=%s", cfg.Format)
+		}
+	}
+	if len(cfg.ColumnNames) > 0 {
+		switch cfg.Header {
+		case S3SelectHeaderUse:
+			return errors.New("column_names can not be used with header=use")
+		case S3SelectHeaderFirstObject:
+			return errors.New("column_names can not be used with header=first_object")
+		}
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) hasCSVDialect() bool {
+	return cfg.Header != "" || cfg.Delimiter != "" || cfg.RecordDelimiter != "" || cfg.Quote != "" ||
+		cfg.QuoteEscape != "" || cfg.Comments != "" || cfg.AllowQuotedRecordDelimiter || len(cfg.ColumnNames) > 0
+}
+
+// unescapeDialectString unescapes backslash escapes such as `\t`, the value is used as is if it is not valid escapes.
+func unescapeDialectString(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(s, `"`, `\"`) + `"`)
+	if err != nil {
+		return s
+	}
+	return unquoted
+}
+
+func (cfg *S3SelectConfig) setCSVDialectToURLValues(params url.Values) {
+	for _, name := range csvDialectParams {
+		params.Del(name)
+	}
+	stringParams := []struct {
+		name  string
+		value string
+	}{
+		{name: "header", value: string(cfg.Header)},
+		{name: "delimiter", value: cfg.Delimiter},
+		{name: "record_delimiter", value: cfg.RecordDelimiter},
+		{name: "quote", value: cfg.Quote},
+		{name: "quote_escape", value: cfg.QuoteEscape},
+		{name: "comments", value: cfg.Comments},
+		{name: "column_names", value: strings.Join(cfg.ColumnNames, ",")},
+	}
+	for _, p := range stringParams {
+		if p.value != "" {
+			params.Set(p.name, p.value)
+		}
+	}
+	if cfg.AllowQuotedRecordDelimiter {
+		params.Set("allow_quoted_record_delimiter", "true")
+	}
+}
+
+// applyCSVDialect overwrites CSVInput by the dialect of the config.
+func (cfg *S3SelectConfig) applyCSVDialect(input *types.CSVInput) {
+	switch cfg.Header {
+	case S3SelectHeaderUse:
+		input.FileHeaderInfo = types.FileHeaderInfoUse
+	case S3SelectHeaderIgnore:
+		input.FileHeaderInfo = types.FileHeaderInfoIgnore
+	case S3SelectHeaderNone:
+		input.FileHeaderInfo = types.FileHeaderInfoNone
+	case "":
+		if len(cfg.ColumnNames) > 0 && input.FileHeaderInfo == types.FileHeaderInfoUse {
+			input.FileHeaderInfo = types.FileHeaderInfoNone
+		}
+	}
+	if cfg.Delimiter != "" {
+		input.FieldDelimiter = aws.String(cfg.Delimiter)
+	}
+	if cfg.RecordDelimiter != "" {
+		input.RecordDelimiter = aws.String(cfg.RecordDelimiter)
+	}
+	if cfg.Quote != "" {
+		input.QuoteCharacter = aws.String(cfg.Quote)
+	}
+	if cfg.QuoteEscape != "" {
+		input.QuoteEscapeCharacter = aws.String(cfg.QuoteEscape)
+	}
+	if cfg.Comments != "" {
+		input.Comments = aws.String(cfg.Comments)
+	}
+	if cfg.AllowQuotedRecordDelimiter {
+		input.AllowQuotedRecordDelimiter = true
+	}
+}
+
+// withFirstObjectHeader returns a copy of InputSerialization for header=first_object.
+// the first object skips the header line, and other objects have no header.
+func withFirstObjectHeader(inputSerialization *types.InputSerialization, isFirst bool) *types.InputSerialization {
+	if inputSerialization.CSV == nil {
+		return inputSerialization
+	}
+	copied := *inputSerialization
+	csvInput := *inputSerialization.CSV
+	if isFirst {
+		csvInput.FileHeaderInfo = types.FileHeaderInfoIgnore
+	} else {
+		csvInput.FileHeaderInfo = types.FileHeaderInfoNone
+	}
+	copied.CSV = &csvInput
+	return &copied
+}
+
+// readObjectHeader reads the first line of the object as column names, header=first_object requires csv for the object.
+func (conn *s3SelectConn) readObjectHeader(ctx context.Context, content contentInfo) ([]string, error) {
+	inputSerialization, err := conn.inputSerializationFor(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	if inputSerialization.CSV == nil {
+		return nil, fmt.Errorf("header=first_object: s3://%s/%s is not csv", content.BucketName, content.ObjectKey)
+	}
+	return conn.readHeader(ctx, content, inputSerialization)
+}
+
+// readHeader reads the first line of the object as column names by S3 Select.
+func (conn *s3SelectConn) readHeader(ctx context.Context, content contentInfo, inputSerialization *types.InputSerialization) ([]string, error) {
+	copied := *inputSerialization
+	csvInput := *inputSerialization.CSV
+	csvInput.FileHeaderInfo = types.FileHeaderInfoNone
+	copied.CSV = &csvInput
+	input := &s3.SelectObjectContentInput{
+		Bucket:             aws.String(content.BucketName),
+		Key:                aws.String(content.ObjectKey),
+		Expression:         aws.String("SELECT * FROM S3Object s LIMIT 1"),
+		ExpressionType:     types.ExpressionTypeSql,
+		InputSerialization: &copied,
+		OutputSerialization: &types.OutputSerialization{
+			JSON: &types.JSONOutput{},
+		},
+	}
+	conn.cfg.applySelectObjectContentInput(input)
+	var buf bytes.Buffer
+	if err := conn.client.SelectObjectContentWithWriter(ctx, &buf, input, conn.cfg.selectObjectContentOptFns()...); err != nil {
+		return nil, fmt.Errorf("read header of s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+	}
+	var record map[string]interface{}
+	if err := json.NewDecoder(&buf).Decode(&record); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("read header of s3://%s/%s: object is empty", content.BucketName, content.ObjectKey)
+		}
+		return nil, fmt.Errorf("read header of s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+	}
+	names := make([]string, len(record))
+	for i := range names {
+		v, ok := record["_"+strconv.Itoa(i+1)]
+		if !ok {
+			return nil, fmt.Errorf("read header of s3://%s/%s: column _%d not found", content.BucketName, content.ObjectKey, i+1)
+		}
+		names[i] = fmt.Sprint(v)
+	}
+	return names, nil
+}
+
+// rewriteColumnNames rewrites column names in the query to positional names _1.._N.
+func rewriteColumnNames(query string, names []string) (string, error) {
+	if len(names) == 0 {
+		return query, nil
+	}
+	positions := make(map[string]string, len(names))
+	for i, name := range names {
+		positions[strings.ToLower(name)] = "_" + strconv.Itoa(i+1)
+	}
+	l := lexer.NewLexer(query)
+	tokens, err := l.Lex()
+	if err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	var prev lexer.Token
+	for idx, token := range tokens {
+		switch token.Kind {
+		case lexer.KindIdentifier:
+			if isAliasOrFunction(prev, tokens, idx) {
+				builder.WriteString(token.Value)
+				break
+			}
+			prefix, name := "", token.Value
+			if i := strings.LastIndex(token.Value, "."); i >= 0 {
+				prefix, name = token.Value[:i+1], token.Value[i+1:]
+			}
+			// a bare reserved word is a keyword, such as END of CASE, the column is written as alias.name or "name"
+			if position, ok := positions[strings.ToLower(name)]; ok && (prefix != "" || !isReservedWord(name)) {
+				builder.WriteString(prefix + position)
+				break
+			}
+			builder.WriteString(token.Value)
+		case lexer.KindString:
+			if position, ok := positions[strings.ToLower(strings.Trim(token.Value, `"`))]; ok && strings.HasPrefix(token.Value, `"`) {
+				builder.WriteString(position)
+				break
+			}
+			builder.WriteString(token.Value)
+		default:
+			builder.WriteString(token.Value)
+		}
+		switch token.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+		default:
+			prev = token
+		}
+	}
+	return builder.String(), nil
+}
+
+// reservedWords are reserved words of S3 Select SQL.
+// see https://docs.aws.amazon.com/AmazonS3/latest/userguide/s3-select-sql-reference-keyword-list.html
+var reservedWords = func() map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.Fields(`
+		absolute action add all allocate alter and any are as asc assertion at authorization avg
+		bag begin between bit bit_length blob bool boolean both by
+		cascade cascaded case cast catalog char char_length character character_length check clob close coalesce
+		collate collation column commit connect connection constraint constraints continue convert corresponding
+		count create cross current current_date current_time current_timestamp current_user cursor
+		date day deallocate dec decimal declare default deferrable deferred delete desc describe descriptor
+		diagnostics disconnect distinct domain double drop
+		else end end-exec escape except exception exec execute exists external extract
+		false fetch first float for foreign found from full get global go goto grant group having hour
+		identity immediate in indicator initially inner input insensitive insert int integer intersect interval
+		into is isolation join key language last leading left level like limit list local lower
+		match max min minute missing module month names national natural nchar next no not null nullif numeric
+		octet_length of on only open option or order outer output overlaps
+		pad partial pivot position precision prepare preserve primary prior privileges procedure public
+		read real references relative restrict revoke right rollback rows
+		schema scroll second section select session session_user set sexp size smallint some space sql
+		sqlcode sqlerror sqlstate string struct substring sum symbol system_user
+		table temporary then time timestamp timezone_hour timezone_minute to trailing transaction translate
+		translation trim true tuple union unique unknown unpivot update upper usage user using
+		value values varchar varying view when whenever where with work write year zone
+	`) {
+		words[word] = true
+	}
+	return words
+}()
+
+func isReservedWord(s string) bool {
+	return reservedWords[strings.ToLower(s)]
+}
+
+// isAliasOrFunction reports whether the identifier at idx is a table alias, a column alias or a function name, they are not column names.
+func isAliasOrFunction(prev lexer.Token, tokens []lexer.Token, idx int) bool {
+	if prev.Kind == lexer.KindIdentifier {
+		switch strings.ToUpper(prev.Value) {
+		case "S3OBJECT", "AS":
+			return true
+		}
+	}
+	for _, next := range tokens[idx+1:] {
+		switch next.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+			continue
+		}
+		return next.Kind == lexer.KindSymbol && next.Value == "("
+	}
+	return false
+}
+
+// renameColumns renames positional columns _1.._N of the result to names.
+func renameColumns(columns []string, names []string) {
+	for i, column := range columns {
+		if !strings.HasPrefix(column, "_") {
+			continue
+		}
+		n, err := strconv.Atoi(column[1:])
+		if err != nil || n < 1 || n > len(names) {
+			continue
+		}
+		columns[i] = names[n-1]
+	}
+}
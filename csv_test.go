@@ -0,0 +1,217 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteColumnNames(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    `SELECT id, name FROM S3Object`,
+			expected: `SELECT _1, _2 FROM S3Object`,
+		},
+		{
+			query:    `SELECT s.id, s."Name" AS name FROM S3Object s WHERE s.score > 10`,
+			expected: `SELECT s._1, s._2 AS name FROM S3Object s WHERE s._3 > 10`,
+		},
+		{
+			query:    `SELECT COUNT(*) FROM S3Object WHERE name = 'name'`,
+			expected: `SELECT COUNT(*) FROM S3Object WHERE _2 = 'name'`,
+		},
+		{
+			query:    `SELECT * FROM S3Object id LIMIT 1`,
+			expected: `SELECT * FROM S3Object id LIMIT 1`,
+		},
+		{
+			query:    `SELECT CASE WHEN s.action = 'ACCEPT' THEN s."end" ELSE 0 END AS e FROM S3Object s WHERE end > 0`,
+			expected: `SELECT CASE WHEN s._5 = 'ACCEPT' THEN s._6 ELSE 0 END AS e FROM S3Object s WHERE end > 0`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			actual, err := rewriteColumnNames(c.query, []string{"id", "name", "score", "count", "action", "end"})
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestS3SelectConfig__CSVDialect(t *testing.T) {
+	cfg, err := ParseDSN(`s3://example-com/data.csv?header=none&delimiter=%3B&record_delimiter=\r\n&quote='&quote_escape=\\&comments=%23&allow_quoted_record_delimiter=true&column_names=id,name`)
+	require.NoError(t, err)
+	inputSerialization, err := cfg.newInputSeliarization()
+	require.NoError(t, err)
+	require.EqualValues(t, &types.CSVInput{
+		FileHeaderInfo:             types.FileHeaderInfoNone,
+		FieldDelimiter:             aws.String(";"),
+		RecordDelimiter:            aws.String("\r\n"),
+		QuoteCharacter:             aws.String("'"),
+		QuoteEscapeCharacter:       aws.String(`\`),
+		Comments:                   aws.String("#"),
+		AllowQuotedRecordDelimiter: true,
+	}, inputSerialization.CSV)
+	require.Equal(t, "s3://example-com/data.csv?allow_quoted_record_delimiter=true&column_names=id%2Cname&comments=%23&compression_type=none&delimiter=%3B&format=csv&header=none&quote=%27&quote_escape=%5C&record_delimiter=%0D%0A", cfg.String())
+
+	_, err = ParseDSN("s3://example-com/data.csv?header=use&column_names=id,name")
+	require.EqualError(t, err, "dsn is invalid: set query params: column_names can not be used with header=use")
+	_, err = ParseDSN("s3://example-com/data/?format=json&delimiter=%3B")
+	require.EqualError(t, err, "dsn is invalid: set query params: csv dialect parameters can not be used with format=json")
+}
+
+func TestMock__ColumnNames(t *testing.T) {
+	mockClients["column_names"] = &mockS3SelectClient{
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.Equal(t, "SELECT _2, _1 FROM S3Object s WHERE s._1 > 1", *params.Expression)
+			require.Equal(t, types.FileHeaderInfoNone, params.InputSerialization.CSV.FileHeaderInfo)
+			require.Equal(t, ";", *params.InputSerialization.CSV.FieldDelimiter)
+			fmt.Fprintln(w, `{"_2":"fuga","_1":"2"}`)
+			return nil
+		},
+	}
+	db, err := sql.Open("s3-select", "s3://example-com/data.csv?delimiter=%3B&column_names=id,name&mock=column_names")
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.QueryContext(context.Background(), `SELECT name, id FROM S3Object s WHERE s.id > 1`)
+	require.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"name", "id"}, columns)
+}
+
+func TestMock__HeaderFirstObject(t *testing.T) {
+	objects := map[string][]string{
+		"data/part-00000.csv": {"id,name", "1,hoge", "2,fuga"},
+		"data/part-00001.csv": {"3,piyo"},
+	}
+	mockClients["header_first_object"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("data/part-00000.csv")},
+					{Key: aws.String("data/part-00001.csv")},
+				},
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			lines := objects[*params.Key]
+			switch *params.Expression {
+			case "SELECT * FROM S3Object s LIMIT 1":
+				require.Equal(t, types.FileHeaderInfoNone, params.InputSerialization.CSV.FileHeaderInfo)
+				fmt.Fprintln(w, `{"_1":"id","_2":"name"}`)
+				return nil
+			case "SELECT s._2 FROM S3Object s":
+			default:
+				return fmt.Errorf("unexpected expression: %s", *params.Expression)
+			}
+			switch params.InputSerialization.CSV.FileHeaderInfo {
+			case types.FileHeaderInfoIgnore:
+				lines = lines[1:]
+			case types.FileHeaderInfoNone:
+			default:
+				return fmt.Errorf("unexpected file header info: %s", params.InputSerialization.CSV.FileHeaderInfo)
+			}
+			for _, line := range lines {
+				var id, name string
+				fmt.Sscanf(line, "%1s,%s", &id, &name)
+				fmt.Fprintf(w, `{"_2":"%s"}`+"\n", name)
+			}
+			return nil
+		},
+	}
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=csv&header=first_object&mock=header_first_object")
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.QueryContext(context.Background(), `SELECT s.name FROM S3Object s`)
+	require.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"name"}, columns)
+	actual := make([]string, 0, 3)
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		actual = append(actual, name)
+	}
+	require.Equal(t, []string{"hoge", "fuga", "piyo"}, actual)
+}
+
+func TestMock__HeaderFirstObject__Pruned(t *testing.T) {
+	objects := map[string][]string{
+		"data/part-00000.csv": {"id,name", "1,hoge", "2,fuga"},
+		"data/part-00001.csv": {"3,piyo"},
+	}
+	indexBody := `{"version":1,"objects":[` +
+		`{"bucket":"example-com","key":"data/part-00000.csv","etag":"\"etag-0\"","records":2,"columns":{"_1":{"type":"string","min":"1","max":"2","nulls":0}}},` +
+		`{"bucket":"example-com","key":"data/part-00001.csv","etag":"\"etag-1\"","records":1,"columns":{"_1":{"type":"string","min":"3","max":"3","nulls":0}}}]}`
+	var selected []string
+	mockClients["header_first_object_pruned"] = &mockS3SelectClient{
+		HeadObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			require.Equal(t, "data/_s3select_index.json", *params.Key)
+			return &s3.HeadObjectOutput{ETag: aws.String(`"index"`)}, nil
+		},
+		GetObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			require.Equal(t, "data/_s3select_index.json", *params.Key)
+			return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(indexBody))}, nil
+		},
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("data/_s3select_index.json"), ETag: aws.String(`"index"`)},
+					{Key: aws.String("data/part-00000.csv"), ETag: aws.String(`"etag-0"`)},
+					{Key: aws.String("data/part-00001.csv"), ETag: aws.String(`"etag-1"`)},
+				},
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			if *params.Expression == "SELECT * FROM S3Object s LIMIT 1" {
+				require.Equal(t, "data/part-00000.csv", *params.Key, "the header is read from the first object of the dataset")
+				fmt.Fprintln(w, `{"_1":"id","_2":"name"}`)
+				return nil
+			}
+			require.Equal(t, "SELECT s._2 FROM S3Object s WHERE s._1 = '3'", *params.Expression)
+			require.Equal(t, types.FileHeaderInfoNone, params.InputSerialization.CSV.FileHeaderInfo)
+			selected = append(selected, *params.Key)
+			for _, line := range objects[*params.Key] {
+				fmt.Fprintf(w, `{"_2":"%s"}`+"\n", strings.Split(line, ",")[1])
+			}
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=csv&header=first_object&index=true&mock=header_first_object_pruned")
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.QueryContext(context.Background(), `SELECT s.name FROM S3Object s WHERE s._1 = '3'`)
+	require.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"name"}, columns)
+	var actual []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		actual = append(actual, name)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"piyo"}, actual)
+	require.Equal(t, []string{"data/part-00001.csv"}, selected, "the first object is pruned by the index")
+}
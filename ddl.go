@@ -0,0 +1,590 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+// tableRegistry holds tables created by CREATE EXTERNAL TABLE, it is shared by connections of the connector.
+type tableRegistry struct {
+	mu     sync.RWMutex
+	tables []*CatalogTable
+}
+
+func (r *tableRegistry) table(name string) *CatalogTable {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, table := range r.tables {
+		if strings.EqualFold(table.Name, name) {
+			return table
+		}
+	}
+	return nil
+}
+
+func (r *tableRegistry) all() []*CatalogTable {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*CatalogTable{}, r.tables...)
+}
+
+func (r *tableRegistry) add(table *CatalogTable) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tables {
+		if strings.EqualFold(t.Name, table.Name) {
+			return false
+		}
+	}
+	r.tables = append(r.tables, table)
+	return true
+}
+
+func (r *tableRegistry) remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range r.tables {
+		if strings.EqualFold(t.Name, name) {
+			r.tables = append(r.tables[:i], r.tables[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sessionUnsupportedParams are client options and credentials, they can not be changed by SET because the client is shared by connections.
+var sessionUnsupportedParams = map[string]bool{
+	"region":                  true,
+	"endpoint":                true,
+	"use_path_style":          true,
+	"disable_ssl":             true,
+	"use_accelerate":          true,
+	"use_dualstack":           true,
+	"use_fips":                true,
+	"profile":                 true,
+	"role_arn":                true,
+	"external_id":             true,
+	"session_name":            true,
+	"duration":                true,
+	"web_identity_token_file": true,
+	"anonymous":               true,
+	"sse_customer_key":        true,
+	"sse_customer_key_file":   true,
+	"sse_customer_key_env":    true,
+	"sse_customer_algorithm":  true,
+	"cache":                   true,
+	"cache_ttl":               true,
+	"cache_max_size":          true,
+	"list_cache_ttl":          true,
+	"list_cache_rescan":       true,
+	"mock":                    true,
+}
+
+// exec executes DDL statements, CREATE EXTERNAL TABLE, CREATE TABLE AS SELECT, DROP TABLE, SET and UNLOAD.
+func (conn *s3SelectConn) exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		var err error
+		if query, _, err = conn.rewriteQuery(query, args); err != nil {
+			return nil, err
+		}
+	}
+	p, err := newDDLParser(query)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("CREATE"):
+		return conn.createTable(ctx, p)
+	case p.acceptKeyword("DROP"):
+		return conn.dropTable(ctx, p)
+	case p.acceptKeyword("SET"):
+		return conn.setSessionParam(p)
+	case p.acceptKeyword("UNLOAD"):
+		return conn.unloadStatement(ctx, p)
+	}
+	return nil, fmt.Errorf("exec %w", ErrNotSupported)
+}
+
+// createTable registers the table of `CREATE [EXTERNAL] TABLE [IF NOT EXISTS] name [(columns)] ... LOCATION 's3://...'`.
+// Hive clauses ROW FORMAT, STORED AS and TBLPROPERTIES are converted as tables of Glue,
+// and STORED AS accepts formats of the driver such as csv and alb. other TBLPROPERTIES are query parameters of DSN.
+func (conn *s3SelectConn) createTable(ctx context.Context, p *ddlParser) (driver.Result, error) {
+	p.acceptKeyword("EXTERNAL")
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	ifNotExists := p.acceptKeyword("IF")
+	if ifNotExists {
+		if err := p.expectKeyword("NOT"); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+	}
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	if !catalogTableNameRegexp.MatchString(name) || strings.EqualFold(name, "S3Object") {
+		return nil, fmt.Errorf("invalid table name %q", name)
+	}
+	if p.isKeyword("WITH") || p.isKeyword("AS") {
+		return conn.createTableAsSelect(ctx, p, name, ifNotExists)
+	}
+	glueTable := &GlueTable{Name: name, SerDeParameters: map[string]string{}, Parameters: map[string]string{}}
+	var storedAs string
+	var hive bool
+	properties := map[string]string{}
+	if p.acceptSymbol("(") {
+		if glueTable.Columns, err = p.columns(); err != nil {
+			return nil, err
+		}
+	}
+	for !p.done() {
+		switch {
+		case p.acceptKeyword("COMMENT"):
+			if _, err := p.stringLiteral(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("PARTITIONED"):
+			return nil, errors.New("PARTITIONED BY is not supported, use key_template in TBLPROPERTIES")
+		case p.acceptKeyword("ROW"):
+			hive = true
+			if err := p.expectKeyword("FORMAT"); err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("SERDE") {
+				if glueTable.SerializationLibrary, err = p.stringLiteral(); err != nil {
+					return nil, err
+				}
+				if p.acceptKeyword("WITH") {
+					if err := p.expectKeyword("SERDEPROPERTIES"); err != nil {
+						return nil, err
+					}
+					if glueTable.SerDeParameters, err = p.properties(); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if err := p.expectKeyword("DELIMITED"); err != nil {
+				return nil, err
+			}
+			glueTable.SerializationLibrary = "org.apache.hadoop.hive.serde2.lazy.LazySimpleSerDe"
+			for {
+				var clause string
+				for _, c := range []string{"FIELDS", "LINES", "ESCAPED"} {
+					if p.acceptKeyword(c) {
+						clause = c
+						break
+					}
+				}
+				if clause == "" {
+					break
+				}
+				if clause != "ESCAPED" {
+					if err := p.expectKeyword("TERMINATED"); err != nil {
+						return nil, err
+					}
+				}
+				if err := p.expectKeyword("BY"); err != nil {
+					return nil, err
+				}
+				v, err := p.stringLiteral()
+				if err != nil {
+					return nil, err
+				}
+				switch clause {
+				case "FIELDS":
+					glueTable.SerDeParameters["field.delim"] = v
+				case "LINES":
+					glueTable.SerDeParameters["line.delim"] = v
+				case "ESCAPED":
+					glueTable.SerDeParameters["escape.delim"] = v
+				}
+			}
+		case p.acceptKeyword("STORED"):
+			if err := p.expectKeyword("AS"); err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("INPUTFORMAT") {
+				hive = true
+				if glueTable.InputFormat, err = p.stringLiteral(); err != nil {
+					return nil, err
+				}
+				if err := p.expectKeyword("OUTPUTFORMAT"); err != nil {
+					return nil, err
+				}
+				if _, err := p.stringLiteral(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if storedAs, err = p.identifier(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("LOCATION"):
+			if glueTable.Location, err = p.stringLiteral(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("TBLPROPERTIES"):
+			if properties, err = p.properties(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected("")
+		}
+	}
+	if glueTable.Location == "" {
+		return nil, errors.New("LOCATION is required")
+	}
+	switch strings.ToUpper(storedAs) {
+	case "PARQUET":
+		hive = true
+		glueTable.InputFormat = "org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat"
+	case "JSONFILE":
+		hive = true
+		glueTable.SerializationLibrary = "org.openx.data.jsonserde.JsonSerDe"
+	case "TEXTFILE", "":
+		// TEXTFILE is the default of Hive
+		hive = true
+		if glueTable.SerializationLibrary == "" {
+			glueTable.SerializationLibrary = "org.apache.hadoop.hive.serde2.lazy.LazySimpleSerDe"
+		}
+	default:
+		if hive {
+			return nil, fmt.Errorf("STORED AS %s can not be used with ROW FORMAT or INPUTFORMAT", storedAs)
+		}
+	}
+	for _, key := range []string{"compressionType", "skip.header.line.count"} {
+		if v, ok := properties[key]; ok {
+			glueTable.Parameters[key] = v
+			delete(properties, key)
+		}
+	}
+	var table *CatalogTable
+	if hive {
+		if table, err = glueTable.catalogTable(); err != nil {
+			return nil, fmt.Errorf("table %s: %w", name, err)
+		}
+		table.Name, table.glue = name, nil
+	} else {
+		format, err := parseFormat(storedAs)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", name, err)
+		}
+		table = &CatalogTable{Name: name, Location: glueTable.Location, Format: format, Params: map[string]string{}}
+		if v := glueTable.Parameters["compressionType"]; v != "" {
+			if table.CompressionType, err = parseCompressionType(v); err != nil {
+				return nil, fmt.Errorf("table %s: %w", name, err)
+			}
+		}
+		for _, column := range glueTable.Columns {
+			schemaColumn, err := parseTypeName(column.Type, glueScalarType)
+			if err != nil {
+				return nil, fmt.Errorf("table %s: column %s: %w", name, column.Name, err)
+			}
+			table.Columns = append(table.Columns, &CatalogColumn{Name: column.Name, Type: schemaColumn.TypeName()})
+		}
+	}
+	for key, value := range properties {
+		table.Params[key] = value
+	}
+	if _, err := table.config(); err != nil {
+		return nil, fmt.Errorf("table %s: %w", name, err)
+	}
+	catalog, err := conn.loadCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if catalog.Table(name) != nil || !conn.registry.add(table) {
+		if ifNotExists {
+			return driver.ResultNoRows, nil
+		}
+		return nil, fmt.Errorf("table %s already exists", name)
+	}
+	conn.debugf("create table %s: %s", name, table.Location)
+	return driver.ResultNoRows, nil
+}
+
+// dropTable removes the table created by CREATE EXTERNAL TABLE, `DROP TABLE [IF EXISTS] name`.
+func (conn *s3SelectConn) dropTable(ctx context.Context, p *ddlParser) (driver.Result, error) {
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+	ifExists := p.acceptKeyword("IF")
+	if ifExists {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+	}
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.unexpected("end of query")
+	}
+	if !conn.registry.remove(name) && !ifExists {
+		return nil, fmt.Errorf("unknown table %s", name)
+	}
+	return driver.ResultNoRows, nil
+}
+
+// setSessionParam sets the query parameter of DSN for the connection, `SET name = value` or `SET name TO value`.
+func (conn *s3SelectConn) setSessionParam(p *ddlParser) (driver.Result, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.ToLower(name)
+	if !p.acceptSymbol("=") && !p.acceptKeyword("TO") {
+		return nil, p.unexpected("= or TO")
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.unexpected("end of query")
+	}
+	cfg, err := conn.cfg.withSessionParam(name, value)
+	if err != nil {
+		return nil, err
+	}
+	conn.cfg = cfg
+	if name == "catalog" {
+		conn.catalog = nil
+	}
+	conn.debugf("set %s: %s", name, value)
+	return driver.ResultNoRows, nil
+}
+
+// withSessionParam returns a copy of the config with the query parameter.
+func (cfg *S3SelectConfig) withSessionParam(name, value string) (*S3SelectConfig, error) {
+	if sessionUnsupportedParams[name] {
+		return nil, fmt.Errorf("%s can not be set in the session, set it in the DSN", name)
+	}
+	u, err := url.Parse(cfg.String())
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	// the redacted key is copied from the config
+	redacted := params.Get("sse_customer_key") == redactedValue
+	params.Del("sse_customer_key")
+	if value == "" {
+		params.Del(name)
+	} else {
+		params.Set(name, value)
+	}
+	u.RawQuery = params.Encode()
+	copied, err := ParseDSN(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("set %s: %w", name, err)
+	}
+	if copied.Params.Has(name) {
+		return nil, fmt.Errorf("unknown parameter %s", name)
+	}
+	if redacted {
+		copied.SSECustomerKey = cfg.SSECustomerKey
+	}
+	copied.S3OptFns = cfg.S3OptFns
+	copied.Catalog = cfg.Catalog
+	copied.Schema = cfg.Schema
+	return copied, nil
+}
+
+// ddlParser reads DDL statements, the query in the statement is read as is.
+type ddlParser struct {
+	tokenParser
+}
+
+func newDDLParser(query string) (*ddlParser, error) {
+	base, err := newTokenParser(query)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(base.tokens); n > 0 && base.tokens[n-1].Kind == lexer.KindSymbol && base.tokens[n-1].Value == ";" {
+		base.truncate(n - 1)
+	}
+	return &ddlParser{tokenParser: base}, nil
+}
+
+// identifier reads a name, quoted by `"` or “ ` “ or not.
+func (p *ddlParser) identifier() (string, error) {
+	token := p.peek()
+	switch {
+	case token.Kind == lexer.KindIdentifier:
+	case token.Kind == lexer.KindString && !strings.HasPrefix(token.Value, "'"):
+	default:
+		return "", p.unexpected("name")
+	}
+	p.pos++
+	return unquoteIdentifier(token.Value), nil
+}
+
+func (p *ddlParser) stringLiteral() (string, error) {
+	token := p.peek()
+	if token.Kind != lexer.KindString || !strings.HasPrefix(token.Value, "'") {
+		return "", p.unexpected("string literal")
+	}
+	p.pos++
+	return unquoteSQLString(token.Value), nil
+}
+
+// value reads a string literal, a number or an identifier, such as values of SET and options of UNLOAD.
+func (p *ddlParser) value() (string, error) {
+	token := p.peek()
+	switch {
+	case token.Kind == lexer.KindString && strings.HasPrefix(token.Value, "'"):
+		return p.stringLiteral()
+	case token.Kind == lexer.KindNumber, token.Kind == lexer.KindIdentifier:
+		p.pos++
+		return token.Value, nil
+	}
+	return "", p.unexpected("value")
+}
+
+// rest returns the rest of the statement as is.
+func (p *ddlParser) rest() string {
+	rest := p.source(p.pos, len(p.tokens))
+	p.pos = len(p.tokens)
+	return rest
+}
+
+// parenthesized reads `( ... )` and returns the content as is, such as the query of UNLOAD.
+func (p *ddlParser) parenthesized() (string, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return "", err
+	}
+	start := p.pos
+	var depth int
+	for ; !p.done(); p.pos++ {
+		switch {
+		case p.isSymbol("("):
+			depth++
+		case p.isSymbol(")"):
+			if depth == 0 {
+				p.pos++
+				return p.source(start, p.pos-1), nil
+			}
+			depth--
+		}
+	}
+	return "", p.unexpected(")")
+}
+
+// columns reads `name type [COMMENT '...'], ...)`, types are read without spaces until `,` or `)` of the top level.
+func (p *ddlParser) columns() ([]GlueColumn, error) {
+	var columns []GlueColumn
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		var typ strings.Builder
+		var depth int
+	typeLoop:
+		for ; !p.done(); p.pos++ {
+			token := p.peek()
+			switch {
+			case token.Value == "(" || token.Value == "<":
+				depth++
+			case token.Value == ")" || token.Value == ">":
+				if depth == 0 {
+					break typeLoop
+				}
+				depth--
+			case depth == 0 && (token.Value == "," || (token.Kind == lexer.KindIdentifier && strings.EqualFold(token.Value, "COMMENT"))):
+				break typeLoop
+			}
+			typ.WriteString(token.Value)
+		}
+		if typ.Len() == 0 {
+			return nil, fmt.Errorf("column %s: type is empty", name)
+		}
+		columns = append(columns, GlueColumn{Name: name, Type: typ.String()})
+		if p.acceptKeyword("COMMENT") {
+			if _, err := p.stringLiteral(); err != nil {
+				return nil, err
+			}
+		}
+		if p.acceptSymbol(")") {
+			return columns, nil
+		}
+		if !p.acceptSymbol(",") {
+			return nil, p.unexpected(", or )")
+		}
+	}
+}
+
+// properties reads `('key' = 'value', ...)`.
+func (p *ddlParser) properties() (map[string]string, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	properties := make(map[string]string)
+	for {
+		key, err := p.stringLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		value, err := p.stringLiteral()
+		if err != nil {
+			return nil, err
+		}
+		properties[key] = value
+		if p.acceptSymbol(")") {
+			return properties, nil
+		}
+		if !p.acceptSymbol(",") {
+			return nil, p.unexpected(", or )")
+		}
+	}
+}
+
+// options reads `(name = value, ...)`, names are in lower case.
+func (p *ddlParser) options() (map[string]string, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	options := make(map[string]string)
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		options[strings.ToLower(name)] = value
+		if p.acceptSymbol(")") {
+			return options, nil
+		}
+		if !p.acceptSymbol(",") {
+			return nil, p.unexpected(", or )")
+		}
+	}
+}
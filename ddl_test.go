@@ -0,0 +1,208 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTable(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected *CatalogTable
+	}{
+		{
+			query: `CREATE EXTERNAL TABLE IF NOT EXISTS users (id bigint, name string COMMENT 'user name')
+				ROW FORMAT DELIMITED FIELDS TERMINATED BY ';'
+				STORED AS TEXTFILE
+				LOCATION 's3://example-com/users'
+				TBLPROPERTIES ('skip.header.line.count'='1', 'compressionType'='gzip');`,
+			expected: &CatalogTable{
+				Name:            "users",
+				Location:        "s3://example-com/users/",
+				Format:          S3SelectFormatCSV,
+				CompressionType: S3SelectCompressionTypeGzip,
+				Params:          map[string]string{"delimiter": ";", "header": "ignore", "column_names": "id,name"},
+				Columns:         []*CatalogColumn{{Name: "id", Type: "bigint"}, {Name: "name", Type: "string"}},
+			},
+		},
+		{
+			query: "CREATE EXTERNAL TABLE `events` (`id` int, payload struct<type:string,tags:array<string>>, price decimal(10,2)) STORED AS PARQUET LOCATION 's3://example-com/events/'",
+			expected: &CatalogTable{
+				Name:     "events",
+				Location: "s3://example-com/events/",
+				Format:   S3SelectFormatParquet,
+				Params:   map[string]string{},
+				Columns: []*CatalogColumn{
+					{Name: "id", Type: "bigint"},
+					{Name: "payload", Type: "struct<type:string,tags:array<string>>"},
+					{Name: "price", Type: "double"},
+				},
+			},
+		},
+		{
+			query: `CREATE EXTERNAL TABLE quoted (line string)
+				ROW FORMAT SERDE 'org.apache.hadoop.hive.serde2.OpenCSVSerde' WITH SERDEPROPERTIES ('separatorChar' = '\t', 'quoteChar' = '|')
+				LOCATION 's3://example-com/quoted/'`,
+			expected: &CatalogTable{
+				Name:     "quoted",
+				Location: "s3://example-com/quoted/",
+				Format:   S3SelectFormatCSV,
+				Params:   map[string]string{"delimiter": `\t`, "quote": "|", "header": "none", "column_names": "line"},
+				Columns:  []*CatalogColumn{{Name: "line", Type: "string"}},
+			},
+		},
+		{
+			query: `CREATE TABLE access_logs STORED AS alb LOCATION 's3://example-com/AWSLogs/' TBLPROPERTIES ('key_template' = '{dt:date:yyyy/MM/dd}/', 'compressionType' = 'gzip')`,
+			expected: &CatalogTable{
+				Name:            "access_logs",
+				Location:        "s3://example-com/AWSLogs/",
+				Format:          S3SelectFormatALB,
+				CompressionType: S3SelectCompressionTypeGzip,
+				Params:          map[string]string{"key_template": "{dt:date:yyyy/MM/dd}/"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expected.Name, func(t *testing.T) {
+			cfg, err := ParseDSN("s3://example-com/data/?format=csv")
+			require.NoError(t, err)
+			conn := newConn(&mockS3SelectClient{}, cfg)
+			conn.registry = &tableRegistry{}
+			result, err := conn.exec(context.Background(), c.query, nil)
+			require.NoError(t, err)
+			require.Equal(t, driver.ResultNoRows, result)
+			require.Equal(t, []*CatalogTable{c.expected}, conn.registry.all())
+		})
+	}
+}
+
+func TestCreateTable__Invalid(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{query: `CREATE EXTERNAL TABLE users (id bigint) STORED AS TEXTFILE`, expected: "LOCATION is required"},
+		{query: `CREATE EXTERNAL TABLE users (id bigint) PARTITIONED BY (dt string) LOCATION 's3://example-com/users/'`, expected: "PARTITIONED BY is not supported, use key_template in TBLPROPERTIES"},
+		{query: `CREATE EXTERNAL TABLE s3object (id bigint) LOCATION 's3://example-com/users/'`, expected: `invalid table name "s3object"`},
+		{query: `CREATE EXTERNAL TABLE users (id uniontype<int,string>) LOCATION 's3://example-com/users/'`, expected: `table users: column id: unsupported type "uniontype"`},
+		{query: `CREATE EXTERNAL TABLE users (id bigint) STORED AS avro LOCATION 's3://example-com/users/'`, expected: `table users: unknown format: avro`},
+		{query: `CREATE EXTERNAL TABLE users (id bigint) ROW FORMAT DELIMITED STORED AS csv LOCATION 's3://example-com/users/'`, expected: "STORED AS csv can not be used with ROW FORMAT or INPUTFORMAT"},
+		{query: `CREATE EXTERNAL TABLE users (id bigint LOCATION 's3://example-com/users/'`, expected: "unexpected end of query, expected , or )"},
+		{query: `CREATE EXTERNAL TABLE users LOCATION 's3://example-com/users/' CLUSTERED BY (id)`, expected: `unexpected "CLUSTERED"`},
+		{query: `DROP TABLE users`, expected: "unknown table users"},
+		{query: `SET region = 'us-east-1'`, expected: "region can not be set in the session, set it in the DSN"},
+		{query: `SET unknown = 1`, expected: "unknown parameter unknown"},
+		{query: `SET format = avro`, expected: "set format: dsn is invalid: set query params: unknown format: avro"},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			cfg, err := ParseDSN("s3://example-com/data/?format=csv")
+			require.NoError(t, err)
+			conn := newConn(&mockS3SelectClient{}, cfg)
+			conn.registry = &tableRegistry{}
+			_, err = conn.exec(context.Background(), c.query, nil)
+			require.EqualError(t, err, c.expected)
+		})
+	}
+
+	conn := newConn(&mockS3SelectClient{}, &S3SelectConfig{BucketName: "example-com"})
+	_, err := conn.exec(context.Background(), `INSERT INTO users VALUES (1)`, nil)
+	require.True(t, errors.Is(err, ErrNotSupported))
+}
+
+func TestMock__DDL(t *testing.T) {
+	var expressions []string
+	mockClients["ddl"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name:     params.Bucket,
+				Contents: []types.Object{{Key: aws.String(*params.Prefix + "1.json")}},
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			expressions = append(expressions, *params.Key+": "+*params.Expression)
+			if *params.Key == "users/1.json" {
+				require.Equal(t, types.JSONTypeLines, params.InputSerialization.JSON.Type)
+			}
+			_, err := io.WriteString(w, `{"id":"1"}`+"\n")
+			return err
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=csv&mock=ddl")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.ExecContext(context.Background(), `CREATE EXTERNAL TABLE users (id bigint) STORED AS JSONFILE LOCATION ?`, "s3://example-com/users/")
+	require.NoError(t, err)
+	_, err = db.ExecContext(context.Background(), `CREATE EXTERNAL TABLE users (id bigint) STORED AS JSONFILE LOCATION 's3://example-com/users/'`)
+	require.EqualError(t, err, "table users already exists")
+
+	var id int64
+	require.NoError(t, db.QueryRowContext(context.Background(), `SELECT u.id FROM users u`).Scan(&id))
+	require.Equal(t, int64(1), id, "declared types convert values")
+	require.Equal(t, []string{"users/1.json: SELECT u.id FROM S3Object u"}, expressions)
+
+	var tables []string
+	rows, err := db.QueryContext(context.Background(), `SELECT table_name FROM information_schema.tables`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		tables = append(tables, name)
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"S3Object", "users"}, tables)
+
+	_, err = db.ExecContext(context.Background(), `DROP TABLE users`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(context.Background(), `DROP TABLE IF EXISTS users`)
+	require.NoError(t, err)
+	expressions = nil
+	rows, err = db.QueryContext(context.Background(), `SELECT * FROM users`)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"data/1.json: SELECT * FROM users"}, expressions, "without tables, FROM is S3Object of the DSN")
+
+	// SET is of the connection
+	db.SetMaxOpenConns(1)
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	_, err = conn.ExecContext(context.Background(), `SET format TO 'json_lines'`)
+	require.NoError(t, err)
+	err = conn.Raw(func(driverConn interface{}) error {
+		require.Equal(t, S3SelectFormatJSONL, driverConn.(*s3SelectConn).cfg.Format)
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = conn.ExecContext(context.Background(), `SET format = csv`)
+	require.NoError(t, err)
+	err = conn.Raw(func(driverConn interface{}) error {
+		require.Equal(t, S3SelectFormatCSV, driverConn.(*s3SelectConn).cfg.Format)
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = conn.ExecContext(context.Background(), `SET format = json_lines`)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	// the pooled connection is reset
+	conn, err = db.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	err = conn.Raw(func(driverConn interface{}) error {
+		require.Equal(t, S3SelectFormatCSV, driverConn.(*s3SelectConn).cfg.Format)
+		return nil
+	})
+	require.NoError(t, err)
+}
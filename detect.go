@@ -0,0 +1,183 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// inputSerializationFor returns InputSerialization of the content.
+// with format=auto, the format is detected from the extension of the key, and HeadObject if the extension is unknown.
+func (conn *s3SelectConn) inputSerializationFor(ctx context.Context, content contentInfo) (*types.InputSerialization, error) {
+	cfg := conn.cfg
+	format := cfg.Format
+	compressionType := cfg.CompressionType
+	if src := content.Source; src != nil {
+		if src.Format != "" {
+			format = src.Format
+		}
+		if src.CompressionType != "" {
+			compressionType = src.CompressionType
+		}
+	}
+	if cfg.InputSerialization == nil && format == S3SelectFormatSniff {
+		return conn.sniffInputSerialization(ctx, content, compressionType)
+	}
+	if cfg.InputSerialization != nil || format != S3SelectFormatAuto {
+		return cfg.inputSerializationFor(content.Source, content.ObjectKey)
+	}
+	detectedFormat, detectedCompressionType, detected := detectFormatFromKey(content.ObjectKey)
+	if !detected {
+		headFormat, headCompressionType, headDetected, err := conn.detectFormatFromHeadObject(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		detectedFormat, detected = headFormat, headDetected
+		if headCompressionType != "" {
+			detectedCompressionType = headCompressionType
+		}
+	}
+	if !detected {
+		return nil, fmt.Errorf("%w: s3://%s/%s", ErrUnknownFormat, content.BucketName, content.ObjectKey)
+	}
+	copied := *cfg
+	copied.Format = detectedFormat
+	switch {
+	case compressionType != "":
+		copied.CompressionType = compressionType
+	case detectedCompressionType != "":
+		copied.CompressionType = detectedCompressionType
+	default:
+		copied.CompressionType = S3SelectCompressionTypeNone
+	}
+	conn.debugf("detected s3://%s/%s format=%s compression_type=%s", content.BucketName, content.ObjectKey, copied.Format, copied.CompressionType)
+	return copied.newInputSeliarization()
+}
+
+// detectFormatFromHeadObject detects the format from x-amz-meta-format and x-amz-meta-compression-type metadata,
+// or Content-Type and Content-Encoding of the object.
+func (conn *s3SelectConn) detectFormatFromHeadObject(ctx context.Context, content contentInfo) (format S3SelectFormat, compressionType S3SelectCompressionType, detected bool, err error) {
+	client, ok := conn.client.(S3HeadObjectClient)
+	if !ok {
+		conn.debugf("s3 select client does not support HeadObject, skip detection of s3://%s/%s", content.BucketName, content.ObjectKey)
+		return "", "", false, nil
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(content.BucketName),
+		Key:    aws.String(content.ObjectKey),
+	}
+	conn.cfg.applyHeadObjectInput(input)
+	output, err := client.HeadObject(ctx, input)
+	if err != nil {
+		return "", "", false, fmt.Errorf("head object s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+	}
+	if v, ok := lookupMetadata(output.Metadata, "format"); ok {
+		format, err = parseFormat(v)
+		if err != nil || format == S3SelectFormatAuto {
+			return "", "", false, fmt.Errorf("%w: x-amz-meta-format of s3://%s/%s is %s", ErrUnknownFormat, content.BucketName, content.ObjectKey, v)
+		}
+		detected = true
+	}
+	if v, ok := lookupMetadata(output.Metadata, "compression-type", "compression_type"); ok {
+		compressionType, err = parseCompressionType(v)
+		if err != nil {
+			return "", "", false, fmt.Errorf("x-amz-meta-compression-type of s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+		}
+	}
+	if output.ContentType != nil {
+		contentFormat, contentCompressionType := formatFromContentType(*output.ContentType)
+		if !detected && contentFormat != "" {
+			format = contentFormat
+			detected = true
+		}
+		if compressionType == "" {
+			compressionType = contentCompressionType
+		}
+	}
+	if output.ContentEncoding != nil && compressionType == "" {
+		compressionType = compressionTypeFromContentEncoding(*output.ContentEncoding)
+	}
+	return format, compressionType, detected, nil
+}
+
+func lookupMetadata(metadata map[string]string, names ...string) (string, bool) {
+	for key, value := range metadata {
+		for _, name := range names {
+			if strings.EqualFold(key, name) {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+func formatFromContentType(contentType string) (S3SelectFormat, S3SelectCompressionType) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", ""
+	}
+	switch mediaType {
+	case "text/csv":
+		return S3SelectFormatCSV, ""
+	case "text/tab-separated-values":
+		return S3SelectFormatTSV, ""
+	case "application/json":
+		return S3SelectFormatJSON, ""
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines", "application/jsonlines":
+		return S3SelectFormatJSONL, ""
+	case "application/vnd.apache.parquet", "application/parquet", "application/x-parquet":
+		return S3SelectFormatParquet, ""
+	case "application/gzip", "application/x-gzip":
+		return "", S3SelectCompressionTypeGzip
+	case "application/x-bzip2":
+		return "", S3SelectCompressionTypeBzip2
+	}
+	return "", ""
+}
+
+func compressionTypeFromContentEncoding(contentEncoding string) S3SelectCompressionType {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		return S3SelectCompressionTypeGzip
+	case "bzip2", "x-bzip2":
+		return S3SelectCompressionTypeBzip2
+	}
+	return ""
+}
+
+// objectETag returns the ETag of the object, by HeadObject if the object is not listed.
+// it returns an empty string if the ETag is unknown, then the object is not cached.
+func (conn *s3SelectConn) objectETag(ctx context.Context, content contentInfo) string {
+	if content.ETag != "" {
+		return content.ETag
+	}
+	etag, err := conn.headObjectETag(ctx, content)
+	if err != nil {
+		conn.debugf("head object s3://%s/%s: %v", content.BucketName, content.ObjectKey, err)
+		return ""
+	}
+	return etag
+}
+
+// headObjectETag returns the current ETag of the object by HeadObject, empty if the client does not implement S3HeadObjectClient.
+func (conn *s3SelectConn) headObjectETag(ctx context.Context, content contentInfo) (string, error) {
+	client, ok := conn.client.(S3HeadObjectClient)
+	if !ok {
+		return "", nil
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(content.BucketName),
+		Key:    aws.String(content.ObjectKey),
+	}
+	conn.cfg.applyHeadObjectInput(input)
+	output, err := client.HeadObject(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.ETag), nil
+}
@@ -0,0 +1,133 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func newFormatAutoMockClient() *mockS3SelectClient {
+	return &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("data/1.csv.gz")},
+					{Key: aws.String("data/2.jsonl")},
+					{Key: aws.String("data/3.parquet")},
+					{Key: aws.String("data/4")},
+					{Key: aws.String("data/5.gz")},
+					{Key: aws.String("data/6")},
+					{Key: aws.String("data/7.bin")},
+				},
+			}, nil
+		},
+		HeadObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			switch *params.Key {
+			case "data/4":
+				return &s3.HeadObjectOutput{
+					ContentType:     aws.String("text/csv; charset=utf-8"),
+					ContentEncoding: aws.String("gzip"),
+				}, nil
+			case "data/5.gz":
+				return &s3.HeadObjectOutput{
+					ContentType: aws.String("application/octet-stream"),
+					Metadata:    map[string]string{"Format": "json_lines"},
+				}, nil
+			case "data/6":
+				return &s3.HeadObjectOutput{
+					ContentType: aws.String("application/x-ndjson"),
+					Metadata:    map[string]string{"compression-type": "bzip2"},
+				}, nil
+			default:
+				return &s3.HeadObjectOutput{
+					ContentType: aws.String("application/octet-stream"),
+				}, nil
+			}
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			var format string
+			switch {
+			case params.InputSerialization.CSV != nil:
+				format = "csv"
+			case params.InputSerialization.JSON != nil:
+				format = string(params.InputSerialization.JSON.Type)
+			case params.InputSerialization.Parquet != nil:
+				format = "parquet"
+			}
+			fmt.Fprintf(w, `{"key":"%s","format":"%s","compression_type":"%s"}`+"\n", *params.Key, format, params.InputSerialization.CompressionType)
+			return nil
+		},
+	}
+}
+
+func TestMock__FormatAuto(t *testing.T) {
+	cases := []struct {
+		name     string
+		dsn      string
+		expected []string
+		errorMsg string
+	}{
+		{
+			name: "skip",
+			dsn:  "s3://example-com/data/?format=auto&unknown_format=skip",
+			expected: []string{
+				"data/1.csv.gz:csv:GZIP",
+				"data/2.jsonl:LINES:NONE",
+				"data/3.parquet:parquet:NONE",
+				"data/4:csv:GZIP",
+				"data/5.gz:LINES:GZIP",
+				"data/6:LINES:BZIP2",
+			},
+		},
+		{
+			name:     "error",
+			dsn:      "s3://example-com/data/?format=auto",
+			errorMsg: "unknown format: s3://example-com/data/7.bin",
+		},
+		{
+			name: "compression_type",
+			dsn:  "s3://example-com/data/?format=auto&compression_type=none&unknown_format=skip",
+			expected: []string{
+				"data/1.csv.gz:csv:NONE",
+				"data/2.jsonl:LINES:NONE",
+				"data/3.parquet:parquet:NONE",
+				"data/4:csv:NONE",
+				"data/5.gz:LINES:NONE",
+				"data/6:LINES:NONE",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockName := "format_auto_" + c.name
+			mockClients[mockName] = newFormatAutoMockClient()
+			restore := requireNoErrorLog(t)
+			defer restore()
+			db, err := sql.Open("s3-select", c.dsn+"&mock="+mockName)
+			require.NoError(t, err)
+			defer db.Close()
+			rows, err := db.QueryContext(context.Background(), `SELECT * FROM S3Object`)
+			if c.errorMsg != "" {
+				require.EqualError(t, err, c.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			defer rows.Close()
+			actual := make([]string, 0, len(c.expected))
+			for rows.Next() {
+				var key, format, compressionType string
+				require.NoError(t, rows.Scan(&key, &format, &compressionType))
+				actual = append(actual, key+":"+format+":"+compressionType)
+			}
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
@@ -25,8 +25,5 @@ func (d *s3SelectDriver) OpenConnector(dsn string) (driver.Connector, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &s3SelectConnector{
-		d:   d,
-		cfg: cfg,
-	}, nil
+	return newConnector(d, cfg), nil
 }
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -502,7 +504,7 @@ func TestMock__SuccessWithPlaceholder(t *testing.T) {
 	mockClients["success_with_placeholder"] = &mockS3SelectClient{
 		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
 			require.EqualValues(t,
-				`SELECT * FROM S3Object as s WHERE s."time" = '2020-01-01T00:00:00Z' AND s."user" = 'hoge'`,
+				`SELECT * FROM S3Object as s WHERE s."time" = CAST('2020-01-01T00:00:00Z' AS TIMESTAMP) AND s."user" = 'hoge'`,
 				string(*params.Expression),
 			)
 			fmt.Fprintf(w, `{"_1":"2020-01-01T00:00:00Z","_2":"hoge"}`+"\n")
@@ -609,7 +611,7 @@ func TestMock__SuccessWithNamedPlaceholder(t *testing.T) {
 	mockClients["success_with_named_placeholder"] = &mockS3SelectClient{
 		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
 			require.EqualValues(t,
-				`SELECT * FROM S3Object as s WHERE s."time" = '2020-01-01T00:00:00Z' AND s."user" = 'hoge'`,
+				`SELECT * FROM S3Object as s WHERE s."time" = CAST('2020-01-01T00:00:00Z' AS TIMESTAMP) AND s."user" = 'hoge'`,
 				string(*params.Expression),
 			)
 			fmt.Fprintf(w, `{"_1":"2020-01-01T00:00:00Z","_2":"hoge"}`+"\n")
@@ -954,3 +956,191 @@ func TestMock__SuccessGlobalLimit(t *testing.T) {
 		}, actual)
 	})
 }
+
+func TestMock__SuccessWithPlaceholder__RichTypes(t *testing.T) {
+	query := `SELECT * FROM S3Object as s WHERE s."id" = ? AND s."size" < ? AND s."ratio" > ? AND s."time" >= ? AND s."name" = ?`
+	mockClients["success_with_placeholder_rich_types"] = &mockS3SelectClient{
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.EqualValues(t,
+				`SELECT * FROM S3Object as s WHERE s."id" = 8 AND s."size" < 18446744073709551615 AND s."ratio" > 0.25 AND s."time" >= CAST('2020-01-01T00:00:00.5Z' AS TIMESTAMP) AND s."name" = NULL`,
+				string(*params.Expression),
+			)
+			return nil
+		},
+	}
+	mockDSN := (&S3SelectConfig{
+		BucketName: "example-com",
+		ObjectKey:  "csv/data.csv",
+		Format:     S3SelectFormatCSV,
+		Params:     url.Values{"mock": []string{"success_with_placeholder_rich_types"}},
+	}).String()
+	runTestsWithDB(t, mockDSN, func(t *testing.T, db *sql.DB) {
+		restore := requireNoErrorLog(t)
+		defer restore()
+		_, err := db.QueryContext(context.Background(), query,
+			int8(8), uint64(18446744073709551615), float32(0.25),
+			time.Date(2020, 1, 1, 0, 0, 0, 500000000, time.UTC), sql.NullString{},
+		)
+		require.NoError(t, err)
+	})
+}
+
+func TestMock__SuccessWithPlaceholder__SliceExpansion(t *testing.T) {
+	query := `SELECT * FROM S3Object as s WHERE s."status" IN (?) AND s."code" IN ? AND s."user" = ?`
+	mockClients["success_with_placeholder_slice_expansion"] = &mockS3SelectClient{
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.EqualValues(t,
+				`SELECT * FROM S3Object as s WHERE s."status" IN ('a', 'b''', 'c') AND s."code" IN (200, 404) AND s."user" = 'hoge'`,
+				string(*params.Expression),
+			)
+			return nil
+		},
+	}
+	mockDSN := (&S3SelectConfig{
+		BucketName: "example-com",
+		ObjectKey:  "csv/data.csv",
+		Format:     S3SelectFormatCSV,
+		Params:     url.Values{"mock": []string{"success_with_placeholder_slice_expansion"}},
+	}).String()
+	runTestsWithDB(t, mockDSN, func(t *testing.T, db *sql.DB) {
+		restore := requireNoErrorLog(t)
+		defer restore()
+		_, err := db.QueryContext(context.Background(), query, []string{"a", "b'", "c"}, []int{200, 404}, "hoge")
+		require.NoError(t, err)
+	})
+}
+
+func TestMock__SuccessWithNamedPlaceholder__SliceExpansion(t *testing.T) {
+	query := `SELECT * FROM S3Object as s WHERE s."status" IN ( :status ) AND s."user" = :user`
+	mockClients["success_with_named_placeholder_slice_expansion"] = &mockS3SelectClient{
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.EqualValues(t,
+				`SELECT * FROM S3Object as s WHERE s."status" IN ( 'a', 'b' ) AND s."user" = 'hoge'`,
+				string(*params.Expression),
+			)
+			return nil
+		},
+	}
+	mockDSN := (&S3SelectConfig{
+		BucketName: "example-com",
+		ObjectKey:  "csv/data.csv",
+		Format:     S3SelectFormatCSV,
+		Params:     url.Values{"mock": []string{"success_with_named_placeholder_slice_expansion"}},
+	}).String()
+	runTestsWithDB(t, mockDSN, func(t *testing.T, db *sql.DB) {
+		restore := requireNoErrorLog(t)
+		defer restore()
+		_, err := db.QueryContext(context.Background(), query, sql.Named("status", []string{"a", "b"}), sql.Named("user", "hoge"))
+		require.NoError(t, err)
+	})
+}
+
+func TestMock__FailedWithPlaceholder__EmptySlice(t *testing.T) {
+	query := `SELECT * FROM S3Object as s WHERE s."status" IN (?) AND s."user" = ?`
+	mockClients["failed_with_placeholder_empty_slice"] = &mockS3SelectClient{}
+	mockDSN := (&S3SelectConfig{
+		BucketName: "example-com",
+		ObjectKey:  "csv/data.csv",
+		Format:     S3SelectFormatCSV,
+		Params:     url.Values{"mock": []string{"failed_with_placeholder_empty_slice"}},
+	}).String()
+	runTestsWithDB(t, mockDSN, func(t *testing.T, db *sql.DB) {
+		restore := requireNoErrorLog(t)
+		defer restore()
+		_, err := db.QueryContext(context.Background(), query, []string{}, "hoge")
+		require.EqualError(t, err, "sql: converting argument $1 type: empty slice cannot be expanded into a list")
+	})
+}
+
+func TestFakeS3__CustomEndpoint(t *testing.T) {
+	server := newFakeS3Server(t)
+	server.PutObject("example-com/csv/1.csv", []byte("id,name\n1,hoge\n"))
+	server.PutObject("example-com/csv/2.csv", []byte("id,name\n2,fuga\n"))
+	server.SelectFunc = func(r *http.Request, bucket, key, expression string) (string, error) {
+		require.Equal(t, "example-com", bucket)
+		require.Equal(t, "SELECT * FROM s3object s", expression)
+		switch key {
+		case "csv/1.csv":
+			return `{"id":"1","name":"hoge"}` + "\n", nil
+		case "csv/2.csv":
+			return `{"id":"2","name":"fuga"}` + "\n", nil
+		}
+		return "", errors.New("NoSuchKey")
+	}
+	dsn := "s3://example-com/csv/?format=csv&use_path_style=true&endpoint=" + url.QueryEscape(server.URL)
+	runTestsWithDB(t, dsn, func(t *testing.T, db *sql.DB) {
+		restore := requireNoErrorLog(t)
+		defer restore()
+		rows, err := db.QueryContext(context.Background(), `SELECT * FROM s3object s`)
+		require.NoError(t, err)
+		defer rows.Close()
+		actual := make([][]string, 0, 2)
+		for rows.Next() {
+			var id, name string
+			require.NoError(t, rows.Scan(&id, &name))
+			actual = append(actual, []string{id, name})
+		}
+		require.Equal(t, [][]string{{"1", "hoge"}, {"2", "fuga"}}, actual)
+	})
+	for _, r := range server.Requests() {
+		require.True(t, strings.HasPrefix(r.URL.Path, "/example-com"), "path-style request: %s", r.URL.Path)
+	}
+}
+
+func TestMock__SuccessMultipleSources(t *testing.T) {
+	query := `SELECT * FROM S3Object`
+	selected := make([]string, 0)
+	mockClients["success_multiple_sources"] = &mockS3SelectClient{
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			location := *params.Bucket + "/" + *params.Key
+			selected = append(selected, location)
+			switch {
+			case params.InputSerialization.CSV != nil:
+				fmt.Fprintf(w, `{"location":"%s","format":"csv"}`+"\n", location)
+			case params.InputSerialization.JSON != nil:
+				fmt.Fprintf(w, `{"location":"%s","format":"json"}`+"\n", location)
+			}
+			return nil
+		},
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			require.Equal(t, "example-com", *params.Bucket)
+			require.Equal(t, "b/", *params.Prefix)
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("b/1.csv")},
+					{Key: aws.String("b/2.csv")},
+				},
+			}, nil
+		},
+	}
+	mockDSN := "s3://example-com/a.csv,s3://example-com/b/,s3://other-com/c.json?format=csv&mock=success_multiple_sources"
+	cfg, err := ParseDSN(mockDSN)
+	require.NoError(t, err)
+	cfg.Sources[2].Format = S3SelectFormatJSON
+	db := sql.OpenDB(NewConnector(cfg, WithS3SelectClient(mockClients["success_multiple_sources"])))
+	defer db.Close()
+	restore := requireNoErrorLog(t)
+	defer restore()
+	rows, err := db.QueryContext(context.Background(), query)
+	require.NoError(t, err)
+	defer rows.Close()
+	actual := make([]string, 0, 4)
+	for rows.Next() {
+		var location, format string
+		require.NoError(t, rows.Scan(&location, &format))
+		actual = append(actual, location+":"+format)
+	}
+	require.Equal(t, []string{
+		"example-com/a.csv:csv",
+		"example-com/b/1.csv:csv",
+		"example-com/b/2.csv:csv",
+		"other-com/c.json:json",
+	}, actual)
+	require.Equal(t, []string{
+		"example-com/a.csv",
+		"example-com/b/1.csv",
+		"example-com/b/2.csv",
+		"other-com/c.json",
+	}, selected)
+}
@@ -6,9 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -23,6 +23,18 @@ const (
 	S3SelectFormatJSON    S3SelectFormat = "json"
 	S3SelectFormatParquet S3SelectFormat = "parquet"
 	S3SelectFormatJSONL   S3SelectFormat = "json_lines"
+	// S3SelectFormatAuto detects the format and the compression type of each object.
+	S3SelectFormatAuto S3SelectFormat = "auto"
+	// S3SelectFormatSniff detects the format and the compression type of each object from the head of the content.
+	S3SelectFormatSniff S3SelectFormat = "sniff"
+)
+
+// UnknownFormatPolicy is the behavior for objects whose format can not be detected.
+type UnknownFormatPolicy string
+
+const (
+	UnknownFormatPolicyError UnknownFormatPolicy = "error"
+	UnknownFormatPolicySkip  UnknownFormatPolicy = "skip"
 )
 
 type S3SelectCompressionType string
@@ -43,9 +55,116 @@ type S3SelectConfig struct {
 	ParseTime          *bool
 	Params             url.Values
 	S3OptFns           []func(*s3.Options)
+
+	// Profile is the shared config profile name.
+	Profile string
+	// RoleARN is the IAM role assumed for accessing objects.
+	RoleARN string
+	// ExternalID is the external id for assuming RoleARN.
+	ExternalID string
+	// RoleSessionName is the session name for assuming RoleARN.
+	RoleSessionName string
+	// RoleDuration is the expiry duration of the assumed role credentials.
+	RoleDuration time.Duration
+	// WebIdentityTokenFile is the path of OIDC token file, RoleARN is assumed with web identity if set.
+	WebIdentityTokenFile string
+	// Anonymous is true, requests are not signed. for public buckets.
+	Anonymous bool
+
+	// SSECustomerAlgorithm is the algorithm of server-side encryption with customer key (SSE-C). default is AES256.
+	SSECustomerAlgorithm string
+	// SSECustomerKey is the raw 256-bit key of SSE-C. it is redacted in String().
+	SSECustomerKey []byte
+	// SSECustomerKeyFile is the path of the file that contains SSECustomerKey.
+	SSECustomerKeyFile string
+	// SSECustomerKeyEnv is the environment variable name that contains base64 encoded SSECustomerKey.
+	SSECustomerKeyEnv string
+	// ExpectedBucketOwner is the account id of the expected bucket owner.
+	ExpectedBucketOwner string
+	// RequestPayer is set "requester" for requester pays buckets.
+	RequestPayer types.RequestPayer
+
+	// Sources are the locations of objects queried as one logical table, in order.
+	// if empty, BucketName, ObjectKey and ObjectKeyPrefix are used.
+	Sources []S3SelectSource
+	// Manifest is the s3 url of the manifest that names objects, instead of listing sources.
+	Manifest string
+	// UnknownFormatPolicy is the behavior for objects whose format can not be detected. default is error.
+	UnknownFormatPolicy UnknownFormatPolicy
+
+	// Header is how the first line of CSV objects is handled. default is use.
+	Header S3SelectHeader
+	// Delimiter is the field delimiter of CSV objects. default is "," for csv and "\t" for tsv.
+	Delimiter string
+	// RecordDelimiter is the record delimiter of CSV objects. default is "\n".
+	RecordDelimiter string
+	// Quote is the quote character of CSV objects.
+	Quote string
+	// QuoteEscape is the escape character of quote in CSV objects.
+	QuoteEscape string
+	// Comments is the prefix of comment lines in CSV objects.
+	Comments string
+	// AllowQuotedRecordDelimiter allows record delimiters in quoted fields.
+	AllowQuotedRecordDelimiter bool
+	// ColumnNames names the columns _1.._N of headerless CSV objects.
+	ColumnNames []string
+
+	// KeyTemplate is the template of keys under the prefix with typed placeholders, such as `{dt:date:yyyy/MM/dd/HH}/`.
+	// prefixes to scan are derived from WHERE predicates on the placeholders, without listing the prefix.
+	KeyTemplate string
+
+	// Follow is true, queries follow the prefix like `tail -f`, rows are streamed for newly arriving objects until the context is cancelled.
+	Follow bool
+	// FollowInterval is the polling interval of the prefix in follow mode. default is 5s.
+	FollowInterval time.Duration
+	// FollowRescanInterval is the interval of rescanning the whole prefix in follow mode, for keys which do not sort lexicographically. default is 1m.
+	FollowRescanInterval time.Duration
+
+	// SampleRecords is the number of records sampled from each object for schema inference. default is 100.
+	SampleRecords int
+	// SampleObjects is the number of objects sampled for schema inference. default is 3.
+	SampleObjects int
+
+	// Index is the s3 url of the sidecar index file built by BuildIndex, or "true" for `<prefix>_s3select_index.json`.
+	// objects whose statistics prove that no record matches WHERE are not queried.
+	Index string
+	// IndexBloomColumns are columns which have bloom filters in the index.
+	IndexBloomColumns []string
+
+	// Catalog maps table names in FROM to datasets, set by WithCatalog.
+	Catalog *Catalog
+	// CatalogLocation is the path or the s3 url of the catalog file, it is read on the first query of each connection.
+	CatalogLocation string
+	// Glue resolves `FROM database.table` by AWS Glue Data Catalog with the client set by WithGlueClient.
+	Glue bool
+	// GlueCacheTTL is the duration tables and partitions of Glue are cached. default is 5m.
+	GlueCacheTTL time.Duration
+	// Cache is `memory` or a local directory of the result cache, which caches the output of S3 Select per object and ETag.
+	Cache string
+	// CacheTTL is the duration results are cached. default is 1h.
+	CacheTTL time.Duration
+	// CacheMaxSize is the max total bytes of cached results. default is 256MB.
+	CacheMaxSize int64
+	// ListConcurrency is the number of sub-prefixes listed at once. recursive listings are split by common prefixes. default is 1.
+	ListConcurrency int
+	// ListShards are sub-prefixes which all keys under the prefix start with, such as `0`..`f` of `hex`, for listing in parallel.
+	ListShards []string
+	// ListCacheTTL is the duration listings of prefixes are cached, older listings are refreshed by keys after the last key.
+	ListCacheTTL time.Duration
+	// ListCacheRescanInterval is the interval of listing the whole prefix again with ListCacheTTL. default is 10m.
+	ListCacheRescanInterval time.Duration
+	// Schema is the declared schema of the dataset, such as columns of the catalog table.
+	Schema *Schema
 }
 
 func (cfg *S3SelectConfig) String() string {
+	if len(cfg.Sources) > 0 {
+		locations := make([]string, 0, len(cfg.Sources))
+		for _, src := range cfg.Sources {
+			locations = append(locations, src.String())
+		}
+		return strings.Join(locations, ",") + "?" + cfg.encodeParams()
+	}
 	var path string
 	if cfg.ObjectKey != "" {
 		path = "/" + cfg.ObjectKey
@@ -58,6 +177,11 @@ func (cfg *S3SelectConfig) String() string {
 		Host:   cfg.BucketName,
 		Path:   path,
 	}
+	u.RawQuery = cfg.encodeParams()
+	return u.String()
+}
+
+func (cfg *S3SelectConfig) encodeParams() string {
 	params := url.Values{}
 	for key, value := range cfg.Params {
 		params[key] = append([]string{}, value...)
@@ -82,8 +206,58 @@ func (cfg *S3SelectConfig) String() string {
 	} else {
 		params.Del("input_serialization")
 	}
-	u.RawQuery = params.Encode()
-	return u.String()
+	stringParams := []struct {
+		name  string
+		value string
+	}{
+		{name: "profile", value: cfg.Profile},
+		{name: "role_arn", value: cfg.RoleARN},
+		{name: "external_id", value: cfg.ExternalID},
+		{name: "session_name", value: cfg.RoleSessionName},
+		{name: "web_identity_token_file", value: cfg.WebIdentityTokenFile},
+	}
+	for _, p := range stringParams {
+		if p.value != "" {
+			params.Set(p.name, p.value)
+		} else {
+			params.Del(p.name)
+		}
+	}
+	if cfg.RoleDuration != 0 {
+		params.Set("duration", cfg.RoleDuration.String())
+	} else {
+		params.Del("duration")
+	}
+	if cfg.Anonymous {
+		params.Set("anonymous", "true")
+	} else {
+		params.Del("anonymous")
+	}
+	cfg.setRequestParamsToURLValues(params)
+	if cfg.Manifest != "" {
+		params.Set("manifest", cfg.Manifest)
+	} else {
+		params.Del("manifest")
+	}
+	if cfg.UnknownFormatPolicy != "" {
+		params.Set("unknown_format", string(cfg.UnknownFormatPolicy))
+	} else {
+		params.Del("unknown_format")
+	}
+	cfg.setCSVDialectToURLValues(params)
+	if cfg.KeyTemplate != "" {
+		params.Set("key_template", cfg.KeyTemplate)
+	} else {
+		params.Del("key_template")
+	}
+	cfg.setFollowToURLValues(params)
+	cfg.setSampleToURLValues(params)
+	cfg.setIndexToURLValues(params)
+	cfg.setCatalogToURLValues(params)
+	cfg.setGlueToURLValues(params)
+	cfg.setResultCacheToURLValues(params)
+	cfg.setListToURLValues(params)
+	return params.Encode()
 }
 
 func SetInputSerializationToURLValues(params url.Values, inputSerialization *types.InputSerialization) error {
@@ -100,39 +274,63 @@ func (cfg *S3SelectConfig) setParams(params url.Values) error {
 	if params.Has("region") {
 		cfg = cfg.WithRegion(params.Get("region"))
 	}
+	boolParams := []struct {
+		name string
+		fn   func(bool) *S3SelectConfig
+	}{
+		// disable_ssl must be applied before endpoint, because an endpoint without scheme depends on it
+		{name: "disable_ssl", fn: cfg.WithDisableSSL},
+		{name: "use_path_style", fn: cfg.WithPathStyle},
+		{name: "use_accelerate", fn: cfg.WithAccelerate},
+		{name: "use_dualstack", fn: cfg.WithDualStack},
+		{name: "use_fips", fn: cfg.WithFIPS},
+	}
+	for _, p := range boolParams {
+		if !params.Has(p.name) {
+			continue
+		}
+		b, err := strconv.ParseBool(params.Get(p.name))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", p.name, err)
+		}
+		p.fn(b)
+	}
+	if params.Has("endpoint") {
+		endpoint := params.Get("endpoint")
+		if endpoint == "" {
+			return errors.New("endpoint is empty")
+		}
+		if strings.Contains(endpoint, "://") {
+			if _, err := url.Parse(endpoint); err != nil {
+				return fmt.Errorf("parse endpoint: %w", err)
+			}
+		}
+		cfg = cfg.WithEndpoint(endpoint)
+	}
 	var formatSet bool
 	if params.Has("format") {
-		switch strings.ToLower(params.Get("format")) {
-		case "csv":
-			cfg.Format = S3SelectFormatCSV
-		case "tsv":
-			cfg.Format = S3SelectFormatTSV
-		case "json":
-			cfg.Format = S3SelectFormatJSON
-		case "parquet":
-			cfg.Format = S3SelectFormatParquet
-		case "jsonl", "jsonlines", "json_lines":
-			cfg.Format = S3SelectFormatJSONL
-		default:
-			return fmt.Errorf("unknown format: %s", params.Get("format"))
+		format, err := parseFormat(params.Get("format"))
+		if err != nil {
+			return err
 		}
+		cfg.Format = format
 		cfg.Params.Del("format")
 		formatSet = true
 	}
 	var comporessionTypeSet bool
 	if params.Has("compression_type") {
-		switch strings.ToLower(params.Get("compression_type")) {
-		case "none":
-			cfg.CompressionType = S3SelectCompressionTypeNone
-		case "gzip":
-			cfg.CompressionType = S3SelectCompressionTypeGzip
-		case "bzip2":
-			cfg.CompressionType = S3SelectCompressionTypeBzip2
-		default:
-			return fmt.Errorf("unknown compression_type: %s", params.Get("compression_type"))
+		compressionType, err := parseCompressionType(params.Get("compression_type"))
+		if err != nil {
+			return err
 		}
+		cfg.CompressionType = compressionType
 		cfg.Params.Del("compression_type")
 		comporessionTypeSet = true
+	} else if cfg.Format == S3SelectFormatAuto || cfg.Format == S3SelectFormatSniff {
+		// compression type is detected for each object
+		cfg.CompressionType = ""
+	} else if preset := cfg.logPreset(); preset != nil {
+		cfg.CompressionType = preset.compressionType
 	} else {
 		cfg.CompressionType = S3SelectCompressionTypeNone
 	}
@@ -144,11 +342,62 @@ func (cfg *S3SelectConfig) setParams(params url.Values) error {
 		cfg.ParseTime = &parseTime
 		cfg.Params.Del("parse_time")
 	}
+	if err := cfg.setCredentialsParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setRequestParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setManifestParams(params); err != nil {
+		return err
+	}
+	if params.Has("unknown_format") {
+		switch policy := UnknownFormatPolicy(strings.ToLower(params.Get("unknown_format"))); policy {
+		case UnknownFormatPolicyError, UnknownFormatPolicySkip:
+			cfg.UnknownFormatPolicy = policy
+		default:
+			return fmt.Errorf("unknown unknown_format policy: %s", params.Get("unknown_format"))
+		}
+		cfg.Params.Del("unknown_format")
+	}
+	if err := cfg.setCSVDialectParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setLogLocationParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setKeyTemplateParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setFollowParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setSampleParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setIndexParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setCatalogParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setGlueParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setResultCacheParams(params); err != nil {
+		return err
+	}
+	if err := cfg.setListParams(params); err != nil {
+		return err
+	}
 	var inputSerializationSet bool
 	if params.Has("input_serialization") {
 		if formatSet {
 			return errors.New("format and input_serialization are exclusive")
 		}
+		if cfg.hasCSVDialect() {
+			return errors.New("csv dialect parameters and input_serialization are exclusive")
+		}
 		var inputSerialization types.InputSerialization
 		bs, err := base64.URLEncoding.DecodeString(params.Get("input_serialization"))
 		if err != nil {
@@ -167,69 +416,155 @@ func (cfg *S3SelectConfig) setParams(params url.Values) error {
 		cfg.Params = nil
 	}
 	if !formatSet && !inputSerializationSet {
-		var detected bool
-		remain := cfg.ObjectKey
-		for ext := filepath.Ext(remain); ext != ""; ext = filepath.Ext(remain) {
-			remain = strings.TrimSuffix(remain, ext)
-			switch ext {
-			case ".csv":
-				cfg.Format = S3SelectFormatCSV
-				detected = true
-			case ".tsv":
-				cfg.Format = S3SelectFormatTSV
-				detected = true
-			case ".json":
-				cfg.Format = S3SelectFormatJSON
-				detected = true
-			case ".parquet":
-				cfg.Format = S3SelectFormatParquet
-				detected = true
-			case ".jsonl", ".jsonlines":
-				cfg.Format = S3SelectFormatJSONL
-				detected = true
-			case ".gz":
-				if !comporessionTypeSet {
-					cfg.CompressionType = S3SelectCompressionTypeGzip
-				}
-			case ".bz2":
-				if !comporessionTypeSet {
-					cfg.CompressionType = S3SelectCompressionTypeBzip2
-				}
+		if cfg.Manifest != "" {
+			// format is detected from each object key in the manifest
+			if !comporessionTypeSet {
+				cfg.CompressionType = ""
 			}
+			return nil
+		}
+		if len(cfg.Sources) > 0 {
+			return cfg.detectSourcesFormat(comporessionTypeSet)
+		}
+		format, compressionType, detected := detectFormatFromKey(cfg.ObjectKey)
+		if !detected && cfg.hasCatalog() && cfg.ObjectKey == "" {
+			// the DSN is only for tables of the catalog
+			return nil
 		}
 		if !detected {
 			return errors.New("format is not set")
 		}
+		cfg.Format = format
+		if compressionType != "" && !comporessionTypeSet {
+			cfg.CompressionType = compressionType
+		}
 	}
 	return nil
 }
 
-func ParseDSN(dsn string) (*S3SelectConfig, error) {
-	if dsn == "" {
-		return nil, ErrDSNEmpty
+func parseFormat(s string) (S3SelectFormat, error) {
+	switch strings.ToLower(s) {
+	case "csv":
+		return S3SelectFormatCSV, nil
+	case "tsv":
+		return S3SelectFormatTSV, nil
+	case "json":
+		return S3SelectFormatJSON, nil
+	case "parquet":
+		return S3SelectFormatParquet, nil
+	case "jsonl", "jsonlines", "json_lines":
+		return S3SelectFormatJSONL, nil
+	case "auto":
+		return S3SelectFormatAuto, nil
+	case "sniff":
+		return S3SelectFormatSniff, nil
+	case "cloudtrail":
+		return S3SelectFormatCloudTrail, nil
+	case "alb":
+		return S3SelectFormatALB, nil
+	case "cloudfront":
+		return S3SelectFormatCloudFront, nil
+	case "vpcflow":
+		return S3SelectFormatVPCFlow, nil
+	case "s3access":
+		return S3SelectFormatS3Access, nil
+	case "waf":
+		return S3SelectFormatWAF, nil
+	default:
+		return "", fmt.Errorf("unknown format: %s", s)
 	}
-	u, err := url.Parse(dsn)
-	if err != nil {
-		return nil, err
+}
+
+func parseCompressionType(s string) (S3SelectCompressionType, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return S3SelectCompressionTypeNone, nil
+	case "gzip":
+		return S3SelectCompressionTypeGzip, nil
+	case "bzip2":
+		return S3SelectCompressionTypeBzip2, nil
+	default:
+		return "", fmt.Errorf("unknown compression_type: %s", s)
+	}
+}
+
+func (cfg *S3SelectConfig) setCredentialsParams(params url.Values) error {
+	stringParams := []struct {
+		name string
+		dest *string
+	}{
+		{name: "profile", dest: &cfg.Profile},
+		{name: "role_arn", dest: &cfg.RoleARN},
+		{name: "external_id", dest: &cfg.ExternalID},
+		{name: "session_name", dest: &cfg.RoleSessionName},
+		{name: "web_identity_token_file", dest: &cfg.WebIdentityTokenFile},
+	}
+	for _, p := range stringParams {
+		if !params.Has(p.name) {
+			continue
+		}
+		*p.dest = params.Get(p.name)
+		cfg.Params.Del(p.name)
+	}
+	if params.Has("duration") {
+		d, err := parseDurationOrSeconds(params.Get("duration"))
+		if err != nil {
+			return fmt.Errorf("parse duration: %w", err)
+		}
+		cfg.RoleDuration = d
+		cfg.Params.Del("duration")
+	}
+	if params.Has("anonymous") {
+		anonymous, err := strconv.ParseBool(params.Get("anonymous"))
+		if err != nil {
+			return fmt.Errorf("parse anonymous: %w", err)
+		}
+		cfg.Anonymous = anonymous
+		cfg.Params.Del("anonymous")
+	}
+	if cfg.Anonymous && cfg.RoleARN != "" {
+		return errors.New("anonymous and role_arn are exclusive")
 	}
-	if u.Scheme != "s3" {
-		return nil, errors.New("dsn scheme not s3")
+	if cfg.RoleARN == "" && (cfg.ExternalID != "" || cfg.RoleSessionName != "" || cfg.RoleDuration != 0 || cfg.WebIdentityTokenFile != "") {
+		return errors.New("external_id, session_name, duration and web_identity_token_file require role_arn")
+	}
+	return nil
+}
+
+// parseDurationOrSeconds parses Go duration string such as "1h", or integer seconds.
+func parseDurationOrSeconds(s string) (time.Duration, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(sec) * time.Second, nil
 	}
-	if u.Host == "" {
-		return nil, errors.New("dsn bucket name is empty")
+	return time.ParseDuration(s)
+}
+
+func ParseDSN(dsn string) (*S3SelectConfig, error) {
+	if dsn == "" {
+		return nil, ErrDSNEmpty
 	}
-	if u.Path == "" {
-		return nil, errors.New("dsn object key is empty")
+	body, rawQuery, _ := strings.Cut(dsn, "?")
+	sources := make([]S3SelectSource, 0, 1)
+	for _, s := range splitSources(body) {
+		src, err := parseSource(s)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
 	}
 	cfg := &S3SelectConfig{
-		BucketName: u.Host,
+		BucketName:      sources[0].BucketName,
+		ObjectKey:       sources[0].ObjectKey,
+		ObjectKeyPrefix: sources[0].ObjectKeyPrefix,
 	}
-	if strings.HasSuffix(u.Path, filepath.Base(u.Path)) {
-		cfg.ObjectKey = strings.TrimPrefix(u.Path, "/")
-	} else {
-		cfg.ObjectKeyPrefix = strings.TrimPrefix(u.Path, "/")
+	if len(sources) > 1 {
+		cfg.Sources = sources
+	}
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("dsn is invalid: parse query params: %w", err)
 	}
-	if err := cfg.setParams(u.Query()); err != nil {
+	if err := cfg.setParams(params); err != nil {
 		return nil, fmt.Errorf("dsn is invalid: set query params: %w", err)
 	}
 	return cfg, nil
@@ -246,12 +581,95 @@ func (cfg *S3SelectConfig) WithRegion(region string) *S3SelectConfig {
 	return cfg
 }
 
+// WithEndpoint sets the custom endpoint URL, for S3 compatible storages such as MinIO and LocalStack.
+// If endpoint has no scheme, https is used unless WithDisableSSL(true) is called before.
+func (cfg *S3SelectConfig) WithEndpoint(endpoint string) *S3SelectConfig {
+	if cfg.Params == nil {
+		cfg.Params = url.Values{}
+	}
+	cfg.Params.Set("endpoint", endpoint)
+	cfg.S3OptFns = append(cfg.S3OptFns, func(o *s3.Options) {
+		u := endpoint
+		if !strings.Contains(u, "://") {
+			if o.EndpointOptions.DisableHTTPS {
+				u = "http://" + u
+			} else {
+				u = "https://" + u
+			}
+		}
+		o.BaseEndpoint = aws.String(u)
+	})
+	return cfg
+}
+
+// WithPathStyle sets path-style addressing (https://endpoint/BUCKET/KEY) instead of virtual hosted addressing.
+func (cfg *S3SelectConfig) WithPathStyle(usePathStyle bool) *S3SelectConfig {
+	cfg.setBoolParam("use_path_style", usePathStyle)
+	cfg.S3OptFns = append(cfg.S3OptFns, func(o *s3.Options) {
+		o.UsePathStyle = usePathStyle
+	})
+	return cfg
+}
+
+// WithDisableSSL sets whether to use http instead of https.
+func (cfg *S3SelectConfig) WithDisableSSL(disableSSL bool) *S3SelectConfig {
+	cfg.setBoolParam("disable_ssl", disableSSL)
+	cfg.S3OptFns = append(cfg.S3OptFns, func(o *s3.Options) {
+		o.EndpointOptions.DisableHTTPS = disableSSL
+	})
+	return cfg
+}
+
+// WithAccelerate sets whether to use S3 Transfer Acceleration endpoint.
+func (cfg *S3SelectConfig) WithAccelerate(useAccelerate bool) *S3SelectConfig {
+	cfg.setBoolParam("use_accelerate", useAccelerate)
+	cfg.S3OptFns = append(cfg.S3OptFns, func(o *s3.Options) {
+		o.UseAccelerate = useAccelerate
+	})
+	return cfg
+}
+
+// WithDualStack sets whether to use dual-stack (IPv6) endpoint.
+func (cfg *S3SelectConfig) WithDualStack(useDualStack bool) *S3SelectConfig {
+	cfg.setBoolParam("use_dualstack", useDualStack)
+	cfg.S3OptFns = append(cfg.S3OptFns, func(o *s3.Options) {
+		if useDualStack {
+			o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateEnabled
+		} else {
+			o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateDisabled
+		}
+	})
+	return cfg
+}
+
+// WithFIPS sets whether to use FIPS endpoint.
+func (cfg *S3SelectConfig) WithFIPS(useFIPS bool) *S3SelectConfig {
+	cfg.setBoolParam("use_fips", useFIPS)
+	cfg.S3OptFns = append(cfg.S3OptFns, func(o *s3.Options) {
+		if useFIPS {
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		} else {
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateDisabled
+		}
+	})
+	return cfg
+}
+
+func (cfg *S3SelectConfig) setBoolParam(name string, value bool) {
+	if cfg.Params == nil {
+		cfg.Params = url.Values{}
+	}
+	cfg.Params.Set(name, strconv.FormatBool(value))
+}
+
 func (cfg *S3SelectConfig) newInputSeliarization() (*types.InputSerialization, error) {
 	if cfg.InputSerialization != nil {
 		return cfg.InputSerialization, nil
 	}
 	var ret *types.InputSerialization
 	switch cfg.Format {
+	case S3SelectFormatCloudTrail, S3SelectFormatALB, S3SelectFormatCloudFront, S3SelectFormatVPCFlow, S3SelectFormatS3Access, S3SelectFormatWAF:
+		ret = cfg.logPreset().newInputSerialization()
 	case S3SelectFormatCSV:
 		ret = &types.InputSerialization{
 			CSV: &types.CSVInput{
@@ -287,6 +705,9 @@ func (cfg *S3SelectConfig) newInputSeliarization() (*types.InputSerialization, e
 	default:
 		return nil, errors.New("unknown format")
 	}
+	if ret.CSV != nil {
+		cfg.applyCSVDialect(ret.CSV)
+	}
 	switch cfg.CompressionType {
 	case S3SelectCompressionTypeNone:
 		ret.CompressionType = types.CompressionTypeNone
@@ -3,6 +3,7 @@ package s3selectsqldriver
 import (
 	"encoding/base64"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
@@ -42,6 +43,70 @@ func TestS3SelectConfig__String(t *testing.T) {
 			}).WithRegion("us-east-1"),
 			expected: "s3://example-com/csv/?region=us-east-1",
 		},
+		{
+			dsn: (&S3SelectConfig{
+				BucketName: "example-com",
+				ObjectKey:  "csv/data.csv",
+			}).WithRegion("us-east-1").WithDisableSSL(true).WithEndpoint("localhost:9000").WithPathStyle(true),
+			expected: "s3://example-com/csv/data.csv?disable_ssl=true&endpoint=localhost%3A9000&region=us-east-1&use_path_style=true",
+		},
+		{
+			dsn: (&S3SelectConfig{
+				BucketName: "example-com",
+				ObjectKey:  "csv/data.csv",
+			}).WithAccelerate(true).WithDualStack(true).WithFIPS(false),
+			expected: "s3://example-com/csv/data.csv?use_accelerate=true&use_dualstack=true&use_fips=false",
+		},
+		{
+			dsn: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKey:       "csv/data.csv",
+				Profile:         "dev",
+				RoleARN:         "arn:aws:iam::123456789012:role/reader",
+				ExternalID:      "external",
+				RoleSessionName: "session",
+				RoleDuration:    time.Hour,
+			},
+			expected: "s3://example-com/csv/data.csv?duration=1h0m0s&external_id=external&profile=dev&role_arn=arn%3Aaws%3Aiam%3A%3A123456789012%3Arole%2Freader&session_name=session",
+		},
+		{
+			dsn: &S3SelectConfig{
+				BucketName: "example-com",
+				ObjectKey:  "csv/data.csv",
+				Anonymous:  true,
+			},
+			expected: "s3://example-com/csv/data.csv?anonymous=true",
+		},
+		{
+			dsn: &S3SelectConfig{
+				BucketName: "example-com",
+				ObjectKey:  "a.csv",
+				Format:     S3SelectFormatCSV,
+				Sources: []S3SelectSource{
+					{BucketName: "example-com", ObjectKey: "a.csv"},
+					{BucketName: "example-com", ObjectKeyPrefix: "b/"},
+					{BucketName: "other-com", ObjectKey: "c.csv"},
+				},
+			},
+			expected: "s3://example-com/a.csv,s3://example-com/b/,s3://other-com/c.csv?format=csv",
+		},
+		{
+			dsn: &S3SelectConfig{
+				BucketName:        "example-com",
+				ObjectKeyPrefix:   "logs/",
+				Format:            S3SelectFormatJSONL,
+				Index:             "s3://index-com/logs.json",
+				IndexBloomColumns: []string{"request_id"},
+			},
+			expected: "s3://example-com/logs/?format=json_lines&index=s3%3A%2F%2Findex-com%2Flogs.json&index_bloom=request_id",
+		},
+		{
+			dsn: &S3SelectConfig{
+				BucketName:      "example-com",
+				CatalogLocation: "catalog.yaml",
+			},
+			expected: "s3://example-com?catalog=catalog.yaml",
+		},
 	}
 
 	for _, c := range cases {
@@ -121,6 +186,162 @@ func TestS3SelectConfig__ParseDSN(t *testing.T) {
 				Format:          S3SelectFormatJSONL,
 			}).WithRegion("us-east-1"),
 		},
+		{
+			dsn: "s3://example-com/csv/?format=csv&endpoint=localhost:9000&use_path_style=true&disable_ssl=1",
+			expected: (&S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKeyPrefix: "csv/",
+				CompressionType: S3SelectCompressionTypeNone,
+				Format:          S3SelectFormatCSV,
+			}).WithDisableSSL(true).WithPathStyle(true).WithEndpoint("localhost:9000"),
+		},
+		{
+			dsn: "s3://example-com/csv/data.csv?use_accelerate=true&use_dualstack=true&use_fips=true",
+			expected: (&S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKey:       "csv/data.csv",
+				CompressionType: S3SelectCompressionTypeNone,
+				Format:          S3SelectFormatCSV,
+			}).WithAccelerate(true).WithDualStack(true).WithFIPS(true),
+		},
+		{
+			dsn: "s3://example-com/csv/data.csv?role_arn=arn:aws:iam::123456789012:role/reader&web_identity_token_file=/var/run/token&session_name=session&duration=900",
+			expected: &S3SelectConfig{
+				BucketName:           "example-com",
+				ObjectKey:            "csv/data.csv",
+				CompressionType:      S3SelectCompressionTypeNone,
+				Format:               S3SelectFormatCSV,
+				RoleARN:              "arn:aws:iam::123456789012:role/reader",
+				WebIdentityTokenFile: "/var/run/token",
+				RoleSessionName:      "session",
+				RoleDuration:         15 * time.Minute,
+			},
+		},
+		{
+			dsn: "s3://example-com/csv/data.csv?profile=public&anonymous=true",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKey:       "csv/data.csv",
+				CompressionType: S3SelectCompressionTypeNone,
+				Format:          S3SelectFormatCSV,
+				Profile:         "public",
+				Anonymous:       true,
+			},
+		},
+		{
+			dsn: "s3://example-com/a.csv,s3://example-com/b.json.gz,s3://other-com/c.parquet?region=us-east-1",
+			expected: (&S3SelectConfig{
+				BucketName: "example-com",
+				ObjectKey:  "a.csv",
+				Sources: []S3SelectSource{
+					{BucketName: "example-com", ObjectKey: "a.csv", Format: S3SelectFormatCSV},
+					{BucketName: "example-com", ObjectKey: "b.json.gz", Format: S3SelectFormatJSON, CompressionType: S3SelectCompressionTypeGzip},
+					{BucketName: "other-com", ObjectKey: "c.parquet", Format: S3SelectFormatParquet},
+				},
+			}).WithRegion("us-east-1"),
+		},
+		{
+			dsn: "s3://example-com/a.csv,s3://example-com/b/,s3://other-com/c.csv?format=csv",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKey:       "a.csv",
+				CompressionType: S3SelectCompressionTypeNone,
+				Format:          S3SelectFormatCSV,
+				Sources: []S3SelectSource{
+					{BucketName: "example-com", ObjectKey: "a.csv"},
+					{BucketName: "example-com", ObjectKeyPrefix: "b/"},
+					{BucketName: "other-com", ObjectKey: "c.csv"},
+				},
+			},
+		},
+		{
+			dsn: "s3://example-com/data/?manifest=s3://manifest-com/manifest.json",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKeyPrefix: "data/",
+				Manifest:        "s3://manifest-com/manifest.json",
+			},
+		},
+		{
+			dsn: "s3://example-com/data/?format=auto&unknown_format=skip",
+			expected: &S3SelectConfig{
+				BucketName:          "example-com",
+				ObjectKeyPrefix:     "data/",
+				Format:              S3SelectFormatAuto,
+				UnknownFormatPolicy: UnknownFormatPolicySkip,
+			},
+		},
+		{
+			dsn: "s3://example-com/logs/?format=alb&account_id=123456789012&log_region=ap-northeast-1&log_date=2024-05-01",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKeyPrefix: "logs/AWSLogs/123456789012/elasticloadbalancing/ap-northeast-1/2024/05/01/",
+				Format:          S3SelectFormatALB,
+				CompressionType: S3SelectCompressionTypeGzip,
+			},
+		},
+		{
+			dsn: "s3://example-com/logs/?format=json_lines&key_template={dt:date:yyyy/MM/dd/HH}/",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKeyPrefix: "logs/",
+				Format:          S3SelectFormatJSONL,
+				CompressionType: S3SelectCompressionTypeNone,
+				KeyTemplate:     "{dt:date:yyyy/MM/dd/HH}/",
+			},
+		},
+		{
+			dsn: "s3://example-com/logs/?format=json_lines&index=true&index_bloom=request_id,user_id",
+			expected: &S3SelectConfig{
+				BucketName:        "example-com",
+				ObjectKeyPrefix:   "logs/",
+				Format:            S3SelectFormatJSONL,
+				CompressionType:   S3SelectCompressionTypeNone,
+				Index:             "true",
+				IndexBloomColumns: []string{"request_id", "user_id"},
+			},
+		},
+		{
+			dsn: "s3://example-com/?catalog=s3://example-com/catalog.yaml",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				CompressionType: S3SelectCompressionTypeNone,
+				CatalogLocation: "s3://example-com/catalog.yaml",
+			},
+		},
+		{
+			dsn: "s3://example-com/?glue=true&glue_cache_ttl=1m",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				CompressionType: S3SelectCompressionTypeNone,
+				Glue:            true,
+				GlueCacheTTL:    time.Minute,
+			},
+		},
+		{
+			dsn: "s3://example-com/data/?format=json&cache=memory&cache_ttl=10m&cache_max_size=64MB",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKeyPrefix: "data/",
+				Format:          S3SelectFormatJSON,
+				CompressionType: S3SelectCompressionTypeNone,
+				Cache:           ResultCacheMemory,
+				CacheTTL:        10 * time.Minute,
+				CacheMaxSize:    64 << 20,
+			},
+		},
+		{
+			dsn: "s3://example-com/data/?format=json&list_concurrency=8&list_shards=2023,2024&list_cache_ttl=30s",
+			expected: &S3SelectConfig{
+				BucketName:      "example-com",
+				ObjectKeyPrefix: "data/",
+				Format:          S3SelectFormatJSON,
+				CompressionType: S3SelectCompressionTypeNone,
+				ListConcurrency: 8,
+				ListShards:      []string{"2023", "2024"},
+				ListCacheTTL:    30 * time.Second,
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -134,3 +355,8 @@ func TestS3SelectConfig__ParseDSN(t *testing.T) {
 	}
 
 }
+
+func TestS3SelectConfig__ParseDSN__PrefixSourceWithoutFormat(t *testing.T) {
+	_, err := ParseDSN("s3://example-com/a.csv,s3://example-com/b/")
+	require.EqualError(t, err, "dsn is invalid: set query params: format is not set: s3://example-com/b/")
+}
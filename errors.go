@@ -5,4 +5,6 @@ import "errors"
 var (
 	ErrNotSupported = errors.New("not supported")
 	ErrDSNEmpty     = errors.New("dsn is empty")
+	// ErrUnknownFormat is returned when the format of an object can not be detected with format=auto.
+	ErrUnknownFormat = errors.New("unknown format")
 )
@@ -0,0 +1,324 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/iancoleman/orderedmap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultFollowInterval       = 5 * time.Second
+	defaultFollowRescanInterval = time.Minute
+)
+
+func (cfg *S3SelectConfig) setFollowParams(params url.Values) error {
+	if params.Has("follow") {
+		follow, err := strconv.ParseBool(params.Get("follow"))
+		if err != nil {
+			return fmt.Errorf("parse follow: %w", err)
+		}
+		cfg.Follow = follow
+		cfg.Params.Del("follow")
+	}
+	durationParams := []struct {
+		name string
+		dest *time.Duration
+	}{
+		{name: "follow_interval", dest: &cfg.FollowInterval},
+		{name: "follow_rescan", dest: &cfg.FollowRescanInterval},
+	}
+	for _, p := range durationParams {
+		if !params.Has(p.name) {
+			continue
+		}
+		d, err := parseDurationOrSeconds(params.Get(p.name))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", p.name, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", p.name)
+		}
+		*p.dest = d
+		cfg.Params.Del(p.name)
+	}
+	if !cfg.Follow {
+		return nil
+	}
+	if cfg.ObjectKey != "" || len(cfg.Sources) > 0 {
+		return errors.New("follow requires a single prefix")
+	}
+	if cfg.Manifest != "" {
+		return errors.New("follow and manifest are exclusive")
+	}
+	if cfg.KeyTemplate != "" {
+		return errors.New("follow and key_template are exclusive")
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setFollowToURLValues(params url.Values) {
+	if cfg.Follow {
+		params.Set("follow", "true")
+	} else {
+		params.Del("follow")
+	}
+	if cfg.FollowInterval != 0 {
+		params.Set("follow_interval", cfg.FollowInterval.String())
+	} else {
+		params.Del("follow_interval")
+	}
+	if cfg.FollowRescanInterval != 0 {
+		params.Set("follow_rescan", cfg.FollowRescanInterval.String())
+	} else {
+		params.Del("follow_rescan")
+	}
+}
+
+func (cfg *S3SelectConfig) followInterval() time.Duration {
+	if cfg.FollowInterval > 0 {
+		return cfg.FollowInterval
+	}
+	return defaultFollowInterval
+}
+
+func (cfg *S3SelectConfig) followRescanInterval() time.Duration {
+	if cfg.FollowRescanInterval > 0 {
+		return cfg.FollowRescanInterval
+	}
+	return defaultFollowRescanInterval
+}
+
+// followRows is driver.Rows of follow mode, rows are streamed until the context is cancelled.
+type followRows struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	parseTime    bool
+	columns      []string
+	columnsReady chan struct{}
+	rowCh        chan []interface{}
+	done         chan struct{}
+	err          error
+}
+
+// Columns blocks until the first record arrives, because columns are the keys of the first record.
+func (rows *followRows) Columns() []string {
+	select {
+	case <-rows.columnsReady:
+		return rows.columns
+	case <-rows.done:
+		select {
+		case <-rows.columnsReady:
+			return rows.columns
+		default:
+			return []string{}
+		}
+	}
+}
+
+// Next blocks until a new record arrives.
+func (rows *followRows) Next(dest []driver.Value) error {
+	row, ok := <-rows.rowCh
+	if !ok {
+		<-rows.done
+		if rows.err != nil {
+			return rows.err
+		}
+		if err := rows.ctx.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	scanRow(dest, row, rows.parseTime)
+	return nil
+}
+
+func (rows *followRows) Close() error {
+	rows.cancel()
+	<-rows.done
+	return nil
+}
+
+// follow executes the query for objects newly arriving under the prefix, and returns rows streamed until the context is cancelled.
+func (conn *s3SelectConn) follow(ctx context.Context, query string, args []driver.NamedValue, limitValue *int, columnNames []string) (driver.Rows, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	rows := &followRows{
+		ctx:          ctx,
+		cancel:       cancel,
+		columnsReady: make(chan struct{}),
+		rowCh:        make(chan []interface{}),
+		done:         make(chan struct{}),
+	}
+	if conn.cfg.ParseTime != nil {
+		rows.parseTime = *conn.cfg.ParseTime
+	}
+	eg, egctx := errgroup.WithContext(ctx)
+	contentCh := make(chan contentInfo)
+	limitExceededCh := make(chan struct{})
+	pr, pw := io.Pipe()
+	eg.Go(func() error {
+		defer pw.Close()
+		return conn.s3SelectWorker(egctx, query, args, contentCh, pw, limitExceededCh, nil, &columnNames, nil)
+	})
+	eg.Go(func() error {
+		defer pr.Close()
+		defer close(rows.rowCh)
+		dec := json.NewDecoder(pr)
+		var keys []string
+		var count int
+		for {
+			o := orderedmap.New()
+			if err := dec.Decode(o); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			if _, ok := parseObjectMarker(o.Keys(), o.Get); ok {
+				continue
+			}
+			if keys == nil {
+				// columns are fixed by the first record, keys of later records which are not in it are dropped
+				keys = o.Keys()
+				rows.columns = append([]string{}, keys...)
+				renameColumns(rows.columns, columnNames)
+				close(rows.columnsReady)
+			}
+			row := make([]interface{}, len(keys))
+			for i, key := range keys {
+				v, ok := o.Get(key)
+				if !ok {
+					continue
+				}
+				value, err := recordValue(v)
+				if err != nil {
+					return err
+				}
+				row[i] = value
+			}
+			conn.cfg.convertPresetColumns(rows.columns, [][]interface{}{row})
+			select {
+			case rows.rowCh <- row:
+			case <-egctx.Done():
+				return nil
+			}
+			count++
+			if limitValue != nil && count >= *limitValue {
+				close(limitExceededCh)
+				break
+			}
+		}
+		// vacume io.Reader
+		io.Copy(io.Discard, pr)
+		return nil
+	})
+	eg.Go(func() error {
+		defer close(contentCh)
+		return conn.enumerateFollow(egctx, contentCh, limitExceededCh)
+	})
+	go func() {
+		rows.err = eg.Wait()
+		close(rows.done)
+	}()
+	return rows, nil
+}
+
+// enumerateFollow sends objects newly arriving under the prefix until the context is cancelled.
+// objects existing at the start are skipped. the prefix is polled with StartAfter the last seen key,
+// and rescanned for objects modified in the last two rescan intervals, because some keys do not sort after the last seen key.
+func (conn *s3SelectConn) enumerateFollow(ctx context.Context, contentCh chan<- contentInfo, doneCh <-chan struct{}) error {
+	startedAt := time.Now()
+	seen := make(map[string]time.Time)
+	var lastKey string
+	ok, err := conn.listContents(ctx, conn.newListObjectsV2Input(conn.cfg.BucketName, conn.cfg.ObjectKeyPrefix), doneCh, func(content contentInfo) bool {
+		if content.ObjectKey > lastKey {
+			lastKey = content.ObjectKey
+		}
+		if !content.LastModified.Before(startedAt) {
+			seen[content.ObjectKey] = content.LastModified
+		}
+		return true
+	})
+	if err != nil || !ok {
+		return err
+	}
+	conn.debugf("follow s3://%s/%s start after %s", conn.cfg.BucketName, conn.cfg.ObjectKeyPrefix, lastKey)
+	send := func(content contentInfo) bool {
+		if _, ok := seen[content.ObjectKey]; ok || conn.cfg.isIndexObject(content) {
+			return true
+		}
+		seen[content.ObjectKey] = content.LastModified
+		if content.ObjectKey > lastKey {
+			lastKey = content.ObjectKey
+		}
+		conn.debugf("follow new object s3://%s/%s", content.BucketName, content.ObjectKey)
+		select {
+		case contentCh <- content:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-doneCh:
+			return false
+		}
+	}
+	ticker := time.NewTicker(conn.cfg.followInterval())
+	defer ticker.Stop()
+	lastRescanAt := startedAt
+	for {
+		select {
+		case <-conn.aliveCh:
+			return sql.ErrConnDone
+		case <-ctx.Done():
+			return nil
+		case <-doneCh:
+			return nil
+		case <-ticker.C:
+		}
+		input := conn.newListObjectsV2Input(conn.cfg.BucketName, conn.cfg.ObjectKeyPrefix)
+		if lastKey != "" {
+			input.StartAfter = aws.String(lastKey)
+		}
+		ok, err := conn.listContents(ctx, input, doneCh, send)
+		if err != nil || !ok {
+			return err
+		}
+		if time.Since(lastRescanAt) < conn.cfg.followRescanInterval() {
+			continue
+		}
+		since := lastRescanAt.Add(-conn.cfg.followRescanInterval())
+		if since.Before(startedAt) {
+			since = startedAt
+		}
+		lastRescanAt = time.Now()
+		ok, err = conn.listContents(ctx, conn.newListObjectsV2Input(conn.cfg.BucketName, conn.cfg.ObjectKeyPrefix), doneCh, func(content contentInfo) bool {
+			if content.LastModified.Before(since) {
+				return true
+			}
+			return send(content)
+		})
+		if err != nil || !ok {
+			return err
+		}
+		// the next rescan skips objects modified before this one's window
+		pruneFollowSeen(seen, lastKey, lastRescanAt.Add(-conn.cfg.followRescanInterval()))
+	}
+}
+
+// pruneFollowSeen removes keys which are neither listed by polling after startAfter nor by rescans since before.
+func pruneFollowSeen(seen map[string]time.Time, startAfter string, before time.Time) {
+	for key, lastModified := range seen {
+		if key <= startAfter && lastModified.Before(before) {
+			delete(seen, key)
+		}
+	}
+}
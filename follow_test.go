@@ -0,0 +1,115 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMock__Follow(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	var mu sync.Mutex
+	var fullListings int
+	mockClients["follow"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			output := &s3.ListObjectsV2Output{Name: params.Bucket}
+			switch {
+			case params.StartAfter == nil:
+				fullListings++
+				output.Contents = append(output.Contents, types.Object{Key: aws.String("logs/1.json"), LastModified: aws.Time(old)})
+				if fullListings > 1 {
+					// a key which does not sort after the last seen key, found by rescan
+					output.Contents = append(output.Contents,
+						types.Object{Key: aws.String("logs/0.json"), LastModified: aws.Time(time.Now())},
+						types.Object{Key: aws.String("logs/2.json"), LastModified: aws.Time(time.Now())},
+					)
+				}
+			case *params.StartAfter == "logs/1.json":
+				output.Contents = append(output.Contents, types.Object{Key: aws.String("logs/2.json"), LastModified: aws.Time(time.Now())})
+			}
+			return output, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			fmt.Fprintf(w, `{"key":"%s"}`+"\n", *params.Key)
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/logs/?format=json_lines&follow=true&follow_interval=10ms&follow_rescan=30ms&mock=follow")
+	require.NoError(t, err)
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `SELECT * FROM S3Object s`)
+	require.NoError(t, err)
+	defer rows.Close()
+	var actual []string
+	for rows.Next() {
+		var key string
+		require.NoError(t, rows.Scan(&key))
+		actual = append(actual, key)
+		if len(actual) == 2 {
+			cancel()
+		}
+	}
+	require.ErrorIs(t, rows.Err(), context.Canceled)
+	require.Equal(t, []string{"logs/2.json", "logs/0.json"}, actual)
+}
+
+func TestPruneFollowSeen(t *testing.T) {
+	now := time.Now()
+	seen := map[string]time.Time{
+		"logs/0.json": now.Add(-time.Hour),
+		"logs/1.json": now,
+		"logs/2.json": now.Add(-time.Hour),
+		"logs/3.json": now.Add(-time.Hour),
+	}
+	pruneFollowSeen(seen, "logs/2.json", now.Add(-time.Minute))
+	require.Equal(t, map[string]time.Time{
+		"logs/1.json": now,
+		"logs/3.json": now.Add(-time.Hour),
+	}, seen, "keys after StartAfter or modified in the rescan window are kept")
+}
+
+func TestMock__Follow__HeaderFirstObject(t *testing.T) {
+	mockClients["follow_header_first_object"] = &mockS3SelectClient{}
+	db, err := sql.Open("s3-select", "s3://example-com/logs/?format=csv&header=first_object&follow=true&mock=follow_header_first_object")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.QueryContext(context.Background(), `SELECT * FROM S3Object s`)
+	require.EqualError(t, err, "header=first_object can not be used with follow")
+}
+
+func TestS3SelectConfig__ParseDSN__FollowError(t *testing.T) {
+	cases := []struct {
+		dsn      string
+		errorMsg string
+	}{
+		{
+			dsn:      "s3://example-com/logs/data.json?follow=true",
+			errorMsg: "dsn is invalid: set query params: follow requires a single prefix",
+		},
+		{
+			dsn:      "s3://example-com/logs/?format=json&follow=true&follow_interval=0",
+			errorMsg: "dsn is invalid: set query params: follow_interval must be positive",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.dsn, func(t *testing.T) {
+			_, err := ParseDSN(c.dsn)
+			require.EqualError(t, err, c.errorMsg)
+		})
+	}
+}
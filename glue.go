@@ -0,0 +1,398 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGlueCacheTTL = 5 * time.Minute
+	// gluePartitionsCacheSize is the max number of cached results of glue:GetPartitions.
+	gluePartitionsCacheSize = 256
+)
+
+// GlueClient resolves tables of AWS Glue Data Catalog, it is set by WithGlueClient.
+// GlueClientWithSDK implements it by *glue.Client of aws-sdk-go-v2, and it is used for glue=true without WithGlueClient.
+type GlueClient interface {
+	// GetTable returns the table, it corresponds to glue:GetTable.
+	GetTable(ctx context.Context, database string, name string) (*GlueTable, error)
+	// GetPartitions returns partitions of the table, it corresponds to glue:GetPartitions with all pages.
+	// expression is the partition filter such as `dt >= '2024-05-01' AND region IN ('us-east-1')`, empty for all partitions.
+	// partitions which do not match the expression may be returned, they are filtered by the driver.
+	GetPartitions(ctx context.Context, database string, name string, expression string) ([]*GluePartition, error)
+}
+
+// GlueTable is the table of Glue, fields are of the StorageDescriptor of the table.
+type GlueTable struct {
+	DatabaseName string
+	Name         string
+	// Location is the s3 url of the table, such as `s3://example-com/logs/`.
+	Location string
+	// InputFormat and SerializationLibrary decide the format, such as
+	// `org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat` and `org.openx.data.jsonserde.JsonSerDe`.
+	InputFormat          string
+	SerializationLibrary string
+	// SerDeParameters are parameters of the SerDe, such as `field.delim` and `separatorChar`.
+	SerDeParameters map[string]string
+	// Parameters are parameters of the table, such as `compressionType` and `skip.header.line.count`.
+	Parameters    map[string]string
+	Columns       []GlueColumn
+	PartitionKeys []GlueColumn
+}
+
+// GlueColumn is a column of the table, Type is the Hive type such as `int`, `varchar(10)` or `array<string>`.
+type GlueColumn struct {
+	Name string
+	Type string
+}
+
+// GluePartition is a partition of the table, Values are in the order of PartitionKeys.
+// Location is the s3 url of the partition, `key=value/` under the location of the table if empty.
+type GluePartition struct {
+	Values   []string
+	Location string
+}
+
+func (cfg *S3SelectConfig) setGlueParams(params url.Values) error {
+	if params.Has("glue") {
+		glue, err := strconv.ParseBool(params.Get("glue"))
+		if err != nil {
+			return fmt.Errorf("parse glue: %w", err)
+		}
+		cfg.Glue = glue
+		cfg.Params.Del("glue")
+	}
+	if params.Has("glue_cache_ttl") {
+		d, err := parseDurationOrSeconds(params.Get("glue_cache_ttl"))
+		if err != nil {
+			return fmt.Errorf("parse glue_cache_ttl: %w", err)
+		}
+		if d <= 0 {
+			return errors.New("glue_cache_ttl must be positive")
+		}
+		cfg.GlueCacheTTL = d
+		cfg.Params.Del("glue_cache_ttl")
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setGlueToURLValues(params url.Values) {
+	if cfg.Glue {
+		params.Set("glue", "true")
+	} else {
+		params.Del("glue")
+	}
+	if cfg.GlueCacheTTL != 0 {
+		params.Set("glue_cache_ttl", cfg.GlueCacheTTL.String())
+	} else {
+		params.Del("glue_cache_ttl")
+	}
+}
+
+// glueCatalog caches tables and partitions of Glue, it is shared by connections of the connector.
+type glueCatalog struct {
+	client GlueClient
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	tables map[string]glueTableCacheEntry
+	// partitions are cached per expression, the least recently used results are evicted.
+	partitions *lruCache[string, gluePartitionsCacheEntry]
+}
+
+type glueTableCacheEntry struct {
+	table   *GlueTable
+	expires time.Time
+}
+
+type gluePartitionsCacheEntry struct {
+	partitions []*GluePartition
+	expires    time.Time
+}
+
+func newGlueCatalog(client GlueClient, ttl time.Duration) *glueCatalog {
+	if ttl <= 0 {
+		ttl = defaultGlueCacheTTL
+	}
+	return &glueCatalog{
+		client:     client,
+		ttl:        ttl,
+		now:        time.Now,
+		tables:     make(map[string]glueTableCacheEntry),
+		partitions: newLRUCache[string, gluePartitionsCacheEntry](gluePartitionsCacheSize),
+	}
+}
+
+// table returns the table by glue:GetTable, cached for the TTL. errors are not cached.
+func (c *glueCatalog) table(ctx context.Context, database, name string) (*GlueTable, error) {
+	key := strings.ToLower(database + "." + name)
+	c.mu.Lock()
+	entry, ok := c.tables[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.table, nil
+	}
+	table, err := c.client.GetTable(ctx, database, name)
+	if err != nil {
+		return nil, fmt.Errorf("glue get table %s.%s: %w", database, name, err)
+	}
+	if table == nil {
+		return nil, fmt.Errorf("unknown table %s.%s", database, name)
+	}
+	c.mu.Lock()
+	c.tables[key] = glueTableCacheEntry{table: table, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return table, nil
+}
+
+// partitionsOf returns partitions by glue:GetPartitions, cached per the expression for the TTL.
+// expired results are removed, and the least recently used results are evicted over gluePartitionsCacheSize.
+func (c *glueCatalog) partitionsOf(ctx context.Context, table *GlueTable, expression string) ([]*GluePartition, error) {
+	key := strings.ToLower(table.DatabaseName+"."+table.Name) + "\x00" + expression
+	if entry, ok := c.partitions.get(key); ok {
+		if c.now().Before(entry.expires) {
+			return entry.partitions, nil
+		}
+		c.partitions.remove(key)
+	}
+	partitions, err := c.client.GetPartitions(ctx, table.DatabaseName, table.Name, expression)
+	if err != nil {
+		return nil, fmt.Errorf("glue get partitions %s.%s: %w", table.DatabaseName, table.Name, err)
+	}
+	c.partitions.add(key, gluePartitionsCacheEntry{partitions: partitions, expires: c.now().Add(c.ttl)})
+	return partitions, nil
+}
+
+// lookupGlueTable resolves `database.table` by Glue.
+func (conn *s3SelectConn) lookupGlueTable(ctx context.Context, database, name string) (*CatalogTable, error) {
+	if conn.glue == nil {
+		return nil, errors.New("glue client is not set, use WithGlueClient")
+	}
+	table, err := conn.glue.table(ctx, database, name)
+	if err != nil {
+		return nil, err
+	}
+	catalogTable, err := table.catalogTable()
+	if err != nil {
+		return nil, fmt.Errorf("glue table %s.%s: %w", database, name, err)
+	}
+	return catalogTable, nil
+}
+
+// catalogTable converts the table of Glue to the table of the catalog.
+// partition keys are columns of the schema, and the partitions are planned for each query by planGluePartitions.
+func (table *GlueTable) catalogTable() (*CatalogTable, error) {
+	if table.Location == "" {
+		return nil, errors.New("location is empty")
+	}
+	t := &CatalogTable{
+		Name:     table.DatabaseName + "." + table.Name,
+		Location: glueLocation(table.Location),
+		Params:   make(map[string]string),
+		glue:     table,
+	}
+	serde := table.SerializationLibrary
+	switch {
+	case strings.Contains(serde, "ParquetHiveSerDe") || strings.Contains(table.InputFormat, "Parquet"):
+		t.Format = S3SelectFormatParquet
+	case strings.Contains(serde, "JsonSerDe"):
+		t.Format = S3SelectFormatJSONL
+	case strings.Contains(serde, "OpenCSVSerde"):
+		t.Format = S3SelectFormatCSV
+		for name, param := range map[string]string{"delimiter": "separatorChar", "quote": "quoteChar", "quote_escape": "escapeChar"} {
+			if v := table.SerDeParameters[param]; v != "" {
+				t.Params[name] = v
+			}
+		}
+	case strings.Contains(serde, "LazySimpleSerDe"):
+		t.Format = S3SelectFormatCSV
+		// the default delimiter of Hive is \x01
+		t.Params["delimiter"] = "\x01"
+		if v := table.SerDeParameters["field.delim"]; v != "" {
+			t.Params["delimiter"] = v
+		}
+	default:
+		return nil, fmt.Errorf("unsupported serde %q", serde)
+	}
+	if t.Format == S3SelectFormatCSV {
+		names := make([]string, 0, len(table.Columns))
+		for _, column := range table.Columns {
+			names = append(names, column.Name)
+		}
+		t.Params["header"] = string(S3SelectHeaderNone)
+		switch {
+		case len(names) > 0:
+			t.Params["column_names"] = strings.Join(names, ",")
+			if table.Parameters["skip.header.line.count"] == "1" {
+				t.Params["header"] = string(S3SelectHeaderIgnore)
+			}
+		case table.Parameters["skip.header.line.count"] == "1":
+			// without columns, names are of the header line
+			t.Params["header"] = string(S3SelectHeaderUse)
+		}
+	}
+	if v := table.Parameters["compressionType"]; v != "" {
+		compressionType, err := parseCompressionType(v)
+		if err != nil {
+			return nil, err
+		}
+		t.CompressionType = compressionType
+	}
+	for _, column := range append(append([]GlueColumn{}, table.Columns...), table.PartitionKeys...) {
+		schemaColumn, err := parseTypeName(column.Type, glueScalarType)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		t.Columns = append(t.Columns, &CatalogColumn{Name: column.Name, Type: schemaColumn.TypeName()})
+	}
+	return t, nil
+}
+
+// glueScalarType maps Hive types to types of Schema.
+func glueScalarType(name string) (string, bool) {
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	switch name {
+	case "string", "varchar", "char":
+		return SchemaTypeString, true
+	case "tinyint", "smallint", "int", "integer", "bigint":
+		return SchemaTypeBigint, true
+	case "float", "double", "decimal":
+		return SchemaTypeDouble, true
+	case "boolean":
+		return SchemaTypeBoolean, true
+	case "timestamp", "date":
+		return SchemaTypeTimestamp, true
+	}
+	return "", false
+}
+
+// glueLocation normalizes the location to the s3 url of the prefix, such as `s3a://example-com/logs` to `s3://example-com/logs/`.
+func glueLocation(location string) string {
+	for _, scheme := range []string{"s3a://", "s3n://"} {
+		if strings.HasPrefix(location, scheme) {
+			location = "s3://" + strings.TrimPrefix(location, scheme)
+		}
+	}
+	if !strings.HasSuffix(location, "/") {
+		location += "/"
+	}
+	return location
+}
+
+func (table *GlueTable) partitioned() bool {
+	return table != nil && len(table.PartitionKeys) > 0
+}
+
+// hiveDefaultPartition is the value of the partition whose key is NULL.
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// planGluePartitions returns partitions of the table which may match WHERE of the query.
+// top level AND predicates on partition keys are sent to glue:GetPartitions as the expression,
+// and partition keys in the query are replaced with the values of each partition as key_template.
+func (conn *s3SelectConn) planGluePartitions(ctx context.Context, query string) (*keyTemplate, []partition, error) {
+	table := conn.glueTable
+	tmpl := &keyTemplate{}
+	for _, key := range table.PartitionKeys {
+		column := &projectedColumn{name: key.Name, typ: projectionTypeString}
+		if typ, _ := glueScalarType(strings.ToLower(key.Type)); typ == SchemaTypeBigint {
+			column.typ = projectionTypeInt
+		}
+		tmpl.columns = append(tmpl.columns, column)
+	}
+	constraints, err := tmpl.extractConstraints(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	expression := gluePartitionExpression(tmpl.columns, constraints)
+	conn.debugf("glue: expression=%s", expression)
+	gluePartitions, err := conn.glue.partitionsOf(ctx, table, expression)
+	if err != nil {
+		return nil, nil, err
+	}
+	partitions := make([]partition, 0, len(gluePartitions))
+	for _, gp := range gluePartitions {
+		if len(gp.Values) != len(tmpl.columns) {
+			return nil, nil, fmt.Errorf("glue table %s.%s: partition %v does not match partition keys", table.DatabaseName, table.Name, gp.Values)
+		}
+		p := partition{values: make([]projectedValue, len(tmpl.columns))}
+		matched := true
+		for i, column := range tmpl.columns {
+			if gp.Values[i] == hiveDefaultPartition {
+				// NULL does not match any constraint
+				if len(constraints[column]) > 0 {
+					matched = false
+				}
+				p.values[i] = projectedValue{column: column}
+				continue
+			}
+			v, ok := column.parseLiteral(gp.Values[i])
+			if !ok {
+				return nil, nil, fmt.Errorf("glue table %s.%s: invalid value %q of %s", table.DatabaseName, table.Name, gp.Values[i], column.name)
+			}
+			for _, c := range constraints[column] {
+				if !c.match(v) {
+					matched = false
+				}
+			}
+			p.values[i] = projectedValue{column: column, value: v}
+		}
+		if !matched {
+			continue
+		}
+		location := gp.Location
+		if location == "" {
+			location = table.Location
+			for i, column := range tmpl.columns {
+				location = glueLocation(location) + column.name + "=" + gp.Values[i]
+			}
+		}
+		src, err := parseSource(glueLocation(location))
+		if err != nil {
+			return nil, nil, fmt.Errorf("glue table %s.%s: parse location of partition: %w", table.DatabaseName, table.Name, err)
+		}
+		p.bucket, p.prefix = src.BucketName, src.ObjectKeyPrefix
+		partitions = append(partitions, p)
+	}
+	// the order of partitions is stable for continuation tokens
+	sort.SliceStable(partitions, func(i, j int) bool {
+		if partitions[i].bucket != partitions[j].bucket {
+			return partitions[i].bucket < partitions[j].bucket
+		}
+		return partitions[i].prefix < partitions[j].prefix
+	})
+	return tmpl, partitions, nil
+}
+
+// gluePartitionExpression returns the expression of glue:GetPartitions from constraints on partition keys.
+func gluePartitionExpression(columns []*projectedColumn, constraints map[*projectedColumn][]projectionConstraint) string {
+	var exprs []string
+	for _, column := range columns {
+		for _, c := range constraints[column] {
+			literals := make([]string, 0, len(c.values))
+			for _, v := range c.values {
+				literals = append(literals, column.literal(v))
+			}
+			switch c.op {
+			case "IN":
+				exprs = append(exprs, column.name+" IN ("+strings.Join(literals, ", ")+")")
+			case "BETWEEN":
+				exprs = append(exprs, column.name+" BETWEEN "+literals[0]+" AND "+literals[1])
+			case "!=":
+				exprs = append(exprs, column.name+" <> "+literals[0])
+			default:
+				exprs = append(exprs, column.name+" "+c.op+" "+literals[0])
+			}
+		}
+	}
+	return strings.Join(exprs, " AND ")
+}
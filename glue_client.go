@@ -0,0 +1,99 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	gluetypes "github.com/aws/aws-sdk-go-v2/service/glue/types"
+)
+
+// GlueAPIClient is the part of *glue.Client used by GlueClientWithSDK.
+type GlueAPIClient interface {
+	GetTable(ctx context.Context, params *glue.GetTableInput, optFns ...func(*glue.Options)) (*glue.GetTableOutput, error)
+	glue.GetPartitionsAPIClient
+}
+
+// GlueClientWithSDK implements GlueClient by *glue.Client of aws-sdk-go-v2.
+type GlueClientWithSDK struct {
+	Client GlueAPIClient
+}
+
+// NewGlueClient returns GlueClient by *glue.Client, such as glue.NewFromConfig(awsCfg).
+func NewGlueClient(client *glue.Client) *GlueClientWithSDK {
+	return &GlueClientWithSDK{Client: client}
+}
+
+// GetTable implements GlueClient by glue:GetTable.
+func (c *GlueClientWithSDK) GetTable(ctx context.Context, database string, name string) (*GlueTable, error) {
+	output, err := c.Client.GetTable(ctx, &glue.GetTableInput{
+		DatabaseName: aws.String(database),
+		Name:         aws.String(name),
+	})
+	if err != nil {
+		var notFound *gluetypes.EntityNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if output.Table == nil {
+		return nil, nil
+	}
+	t := output.Table
+	table := &GlueTable{
+		DatabaseName:  database,
+		Name:          aws.ToString(t.Name),
+		Parameters:    t.Parameters,
+		PartitionKeys: glueColumns(t.PartitionKeys),
+	}
+	if sd := t.StorageDescriptor; sd != nil {
+		table.Location = aws.ToString(sd.Location)
+		table.InputFormat = aws.ToString(sd.InputFormat)
+		table.Columns = glueColumns(sd.Columns)
+		if sd.SerdeInfo != nil {
+			table.SerializationLibrary = aws.ToString(sd.SerdeInfo.SerializationLibrary)
+			table.SerDeParameters = sd.SerdeInfo.Parameters
+		}
+	}
+	return table, nil
+}
+
+// GetPartitions implements GlueClient by glue:GetPartitions with all pages.
+func (c *GlueClientWithSDK) GetPartitions(ctx context.Context, database string, name string, expression string) ([]*GluePartition, error) {
+	input := &glue.GetPartitionsInput{
+		DatabaseName: aws.String(database),
+		TableName:    aws.String(name),
+	}
+	if expression != "" {
+		input.Expression = aws.String(expression)
+	}
+	var partitions []*GluePartition
+	p := glue.NewGetPartitionsPaginator(c.Client, input)
+	for p.HasMorePages() {
+		output, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, gp := range output.Partitions {
+			partition := &GluePartition{Values: gp.Values}
+			if gp.StorageDescriptor != nil {
+				partition.Location = aws.ToString(gp.StorageDescriptor.Location)
+			}
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions, nil
+}
+
+func glueColumns(columns []gluetypes.Column) []GlueColumn {
+	ret := make([]GlueColumn, 0, len(columns))
+	for _, column := range columns {
+		ret = append(ret, GlueColumn{Name: aws.ToString(column.Name), Type: aws.ToString(column.Type)})
+	}
+	return ret
+}
+
+// GlueClientConstructor creates GlueClient for glue=true without WithGlueClient, it is for testing.
+var GlueClientConstructor func(ctx context.Context, cfg *S3SelectConfig) (GlueClient, error)
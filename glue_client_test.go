@@ -0,0 +1,96 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	gluetypes "github.com/aws/aws-sdk-go-v2/service/glue/types"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeGlueAPIClient struct {
+	tables     map[string]*gluetypes.Table
+	partitions []gluetypes.Partition
+	requests   []string
+}
+
+func (c *fakeGlueAPIClient) GetTable(ctx context.Context, params *glue.GetTableInput, optFns ...func(*glue.Options)) (*glue.GetTableOutput, error) {
+	table, ok := c.tables[*params.DatabaseName+"."+*params.Name]
+	if !ok {
+		return nil, &gluetypes.EntityNotFoundException{Message: aws.String("table not found")}
+	}
+	return &glue.GetTableOutput{Table: table}, nil
+}
+
+func (c *fakeGlueAPIClient) GetPartitions(ctx context.Context, params *glue.GetPartitionsInput, optFns ...func(*glue.Options)) (*glue.GetPartitionsOutput, error) {
+	c.requests = append(c.requests, aws.ToString(params.Expression)+" "+aws.ToString(params.NextToken))
+	var start int
+	if params.NextToken != nil {
+		start, _ = strconv.Atoi(*params.NextToken)
+	}
+	output := &glue.GetPartitionsOutput{}
+	end := start + 1
+	if end < len(c.partitions) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	} else {
+		end = len(c.partitions)
+	}
+	output.Partitions = c.partitions[start:end]
+	return output, nil
+}
+
+func TestGlueClientWithSDK(t *testing.T) {
+	api := &fakeGlueAPIClient{
+		tables: map[string]*gluetypes.Table{
+			"analytics.access_logs": {
+				Name: aws.String("access_logs"),
+				StorageDescriptor: &gluetypes.StorageDescriptor{
+					Location:    aws.String("s3://example-com/logs"),
+					InputFormat: aws.String("org.apache.hadoop.mapred.TextInputFormat"),
+					Columns:     []gluetypes.Column{{Name: aws.String("id"), Type: aws.String("int")}},
+					SerdeInfo: &gluetypes.SerDeInfo{
+						SerializationLibrary: aws.String("org.apache.hadoop.hive.serde2.lazy.LazySimpleSerDe"),
+						Parameters:           map[string]string{"field.delim": ","},
+					},
+				},
+				Parameters:    map[string]string{"skip.header.line.count": "1"},
+				PartitionKeys: []gluetypes.Column{{Name: aws.String("dt"), Type: aws.String("string")}},
+			},
+		},
+		partitions: []gluetypes.Partition{
+			{Values: []string{"2024-05-01"}, StorageDescriptor: &gluetypes.StorageDescriptor{Location: aws.String("s3://example-com/logs/dt=2024-05-01")}},
+			{Values: []string{"2024-05-02"}},
+		},
+	}
+	client := &GlueClientWithSDK{Client: api}
+	ctx := context.Background()
+
+	table, err := client.GetTable(ctx, "analytics", "access_logs")
+	require.NoError(t, err)
+	require.Equal(t, &GlueTable{
+		DatabaseName:         "analytics",
+		Name:                 "access_logs",
+		Location:             "s3://example-com/logs",
+		InputFormat:          "org.apache.hadoop.mapred.TextInputFormat",
+		SerializationLibrary: "org.apache.hadoop.hive.serde2.lazy.LazySimpleSerDe",
+		SerDeParameters:      map[string]string{"field.delim": ","},
+		Parameters:           map[string]string{"skip.header.line.count": "1"},
+		Columns:              []GlueColumn{{Name: "id", Type: "int"}},
+		PartitionKeys:        []GlueColumn{{Name: "dt", Type: "string"}},
+	}, table)
+
+	table, err = client.GetTable(ctx, "analytics", "missing")
+	require.NoError(t, err)
+	require.Nil(t, table, "unknown tables are nil")
+
+	partitions, err := client.GetPartitions(ctx, "analytics", "access_logs", "dt >= '2024-05-01'")
+	require.NoError(t, err)
+	require.Equal(t, []*GluePartition{
+		{Values: []string{"2024-05-01"}, Location: "s3://example-com/logs/dt=2024-05-01"},
+		{Values: []string{"2024-05-02"}},
+	}, partitions)
+	require.Equal(t, []string{"dt >= '2024-05-01' ", "dt >= '2024-05-01' 1"}, api.requests, "all pages are read")
+}
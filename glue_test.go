@@ -0,0 +1,272 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeGlueClient struct {
+	tables      map[string]*GlueTable
+	partitions  map[string][]*GluePartition
+	getTables   int
+	expressions []string
+}
+
+func (c *fakeGlueClient) GetTable(ctx context.Context, database string, name string) (*GlueTable, error) {
+	c.getTables++
+	return c.tables[database+"."+name], nil
+}
+
+func (c *fakeGlueClient) GetPartitions(ctx context.Context, database string, name string, expression string) ([]*GluePartition, error) {
+	c.expressions = append(c.expressions, expression)
+	return c.partitions[database+"."+name], nil
+}
+
+func TestGlueTable__CatalogTable(t *testing.T) {
+	cases := []struct {
+		table    *GlueTable
+		expected *CatalogTable
+	}{
+		{
+			table: &GlueTable{
+				DatabaseName:         "analytics",
+				Name:                 "events",
+				Location:             "s3a://example-com/events",
+				InputFormat:          "org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat",
+				SerializationLibrary: "org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe",
+				Columns: []GlueColumn{
+					{Name: "id", Type: "int"},
+					{Name: "price", Type: "decimal(10,2)"},
+					{Name: "tags", Type: "array<struct<key:varchar(10),value:int>>"},
+					{Name: "attrs", Type: "map<string,boolean>"},
+				},
+				PartitionKeys: []GlueColumn{{Name: "dt", Type: "date"}},
+			},
+			expected: &CatalogTable{
+				Name:     "analytics.events",
+				Location: "s3://example-com/events/",
+				Format:   S3SelectFormatParquet,
+				Params:   map[string]string{},
+				Columns: []*CatalogColumn{
+					{Name: "id", Type: "bigint"},
+					{Name: "price", Type: "double"},
+					{Name: "tags", Type: "array<struct<key:string,value:bigint>>"},
+					{Name: "attrs", Type: "map<string,boolean>"},
+					{Name: "dt", Type: "timestamp"},
+				},
+			},
+		},
+		{
+			table: &GlueTable{
+				DatabaseName:         "analytics",
+				Name:                 "users",
+				Location:             "s3://example-com/users/",
+				SerializationLibrary: "org.apache.hadoop.hive.serde2.OpenCSVSerde",
+				SerDeParameters:      map[string]string{"separatorChar": ";", "quoteChar": "'"},
+				Parameters:           map[string]string{"skip.header.line.count": "1", "compressionType": "gzip"},
+				Columns:              []GlueColumn{{Name: "id", Type: "bigint"}, {Name: "name", Type: "string"}},
+			},
+			expected: &CatalogTable{
+				Name:            "analytics.users",
+				Location:        "s3://example-com/users/",
+				Format:          S3SelectFormatCSV,
+				CompressionType: S3SelectCompressionTypeGzip,
+				Params:          map[string]string{"delimiter": ";", "quote": "'", "header": "ignore", "column_names": "id,name"},
+				Columns:         []*CatalogColumn{{Name: "id", Type: "bigint"}, {Name: "name", Type: "string"}},
+			},
+		},
+		{
+			table: &GlueTable{
+				DatabaseName:         "analytics",
+				Name:                 "raw",
+				Location:             "s3://example-com/raw/",
+				SerializationLibrary: "org.apache.hadoop.hive.serde2.lazy.LazySimpleSerDe",
+				Columns:              []GlueColumn{{Name: "line", Type: "string"}},
+			},
+			expected: &CatalogTable{
+				Name:     "analytics.raw",
+				Location: "s3://example-com/raw/",
+				Format:   S3SelectFormatCSV,
+				Params:   map[string]string{"delimiter": "\x01", "header": "none", "column_names": "line"},
+				Columns:  []*CatalogColumn{{Name: "line", Type: "string"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expected.Name, func(t *testing.T) {
+			actual, err := c.table.catalogTable()
+			require.NoError(t, err)
+			c.expected.glue = c.table
+			require.Equal(t, c.expected, actual)
+			_, err = actual.config()
+			require.NoError(t, err)
+		})
+	}
+
+	_, err := (&GlueTable{DatabaseName: "a", Name: "b", Location: "s3://example-com/b/", SerializationLibrary: "org.apache.hadoop.hive.serde2.avro.AvroSerDe"}).catalogTable()
+	require.EqualError(t, err, `unsupported serde "org.apache.hadoop.hive.serde2.avro.AvroSerDe"`)
+	_, err = (&GlueTable{DatabaseName: "a", Name: "b", Location: "s3://example-com/b/", SerializationLibrary: "org.openx.data.jsonserde.JsonSerDe", Columns: []GlueColumn{{Name: "u", Type: "uniontype<int,string>"}}}).catalogTable()
+	require.EqualError(t, err, `column u: unsupported type "uniontype"`)
+}
+
+func TestMock__Glue(t *testing.T) {
+	glueClient := &fakeGlueClient{
+		tables: map[string]*GlueTable{
+			"analytics.access_logs": {
+				DatabaseName:         "analytics",
+				Name:                 "access_logs",
+				Location:             "s3://example-com/access_logs/",
+				SerializationLibrary: "org.openx.data.jsonserde.JsonSerDe",
+				Columns:              []GlueColumn{{Name: "id", Type: "int"}, {Name: "path", Type: "string"}},
+				PartitionKeys:        []GlueColumn{{Name: "dt", Type: "string"}, {Name: "shard", Type: "int"}},
+			},
+		},
+		partitions: map[string][]*GluePartition{
+			"analytics.access_logs": {
+				{Values: []string{"2024-05-02", "1"}, Location: "s3://archive-com/access_logs/dt=2024-05-02/shard=1"},
+				{Values: []string{"2024-05-01", "1"}},
+				{Values: []string{"2024-05-02", "2"}},
+				{Values: []string{"2024-05-03", "__HIVE_DEFAULT_PARTITION__"}},
+			},
+		},
+	}
+	var listed, expressions []string
+	client := &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			listed = append(listed, "s3://"+*params.Bucket+"/"+*params.Prefix)
+			return &s3.ListObjectsV2Output{
+				Name:     params.Bucket,
+				Contents: []types.Object{{Key: aws.String(*params.Prefix + "part-0.json")}},
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			expressions = append(expressions, *params.Expression)
+			require.Equal(t, types.JSONTypeLines, params.InputSerialization.JSON.Type)
+			_, err := io.WriteString(w, `{"id":"3","path":"/index.html","dt":"2024-05-02"}`+"\n")
+			return err
+		},
+	}
+	cfg, err := ParseDSN("s3://example-com/?glue=true&glue_cache_ttl=1m")
+	require.NoError(t, err)
+	connector := newConnector(&s3SelectDriver{}, cfg, WithS3SelectClient(client), WithGlueClient(glueClient))
+	now := time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)
+	catalog, err := connector.getGlueCatalog(context.Background())
+	require.NoError(t, err)
+	catalog.now = func() time.Time { return now }
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	query := func() []string {
+		rows, err := db.QueryContext(context.Background(), `SELECT l.id, l.path, l.dt FROM analytics.access_logs l WHERE l.dt >= ? AND l.shard = ?`, "2024-05-02", 1)
+		require.NoError(t, err)
+		defer rows.Close()
+		var actual []string
+		for rows.Next() {
+			var id int64
+			var path, dt string
+			require.NoError(t, rows.Scan(&id, &path, &dt))
+			actual = append(actual, strings.Join([]string{dt, path}, " "))
+		}
+		require.NoError(t, rows.Err())
+		return actual
+	}
+	require.Equal(t, []string{"2024-05-02 /index.html"}, query())
+	require.Equal(t, []string{"s3://archive-com/access_logs/dt=2024-05-02/shard=1/"}, listed, "partitions are pruned by WHERE")
+	require.Equal(t, []string{"SELECT l.id, l.path, '2024-05-02' AS dt FROM S3Object l WHERE '2024-05-02' >= '2024-05-02' AND 1 = 1"}, expressions)
+	require.Equal(t, []string{"dt >= '2024-05-02' AND shard = 1"}, glueClient.expressions)
+
+	require.Equal(t, []string{"2024-05-02 /index.html"}, query())
+	require.Equal(t, 1, glueClient.getTables, "tables are cached")
+	require.Len(t, glueClient.expressions, 1, "partitions are cached")
+
+	now = now.Add(2 * time.Minute)
+	query()
+	require.Equal(t, 2, glueClient.getTables, "the cache is expired after glue_cache_ttl")
+	require.Len(t, glueClient.expressions, 2)
+
+	listed = nil
+	rows, err := db.QueryContext(context.Background(), `SELECT * FROM analytics.access_logs WHERE shard = 1`)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{
+		"s3://archive-com/access_logs/dt=2024-05-02/shard=1/",
+		"s3://example-com/access_logs/dt=2024-05-01/shard=1/",
+	}, listed, "the location of a partition without location is under the table")
+
+	listed, expressions = nil, nil
+	rows, err = db.QueryContext(context.Background(), `SELECT l.id, l.shard FROM analytics.access_logs l WHERE l.dt = '2024-05-03'`)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"s3://example-com/access_logs/dt=2024-05-03/shard=__HIVE_DEFAULT_PARTITION__/"}, listed, "the default partition is NULL")
+	require.Equal(t, []string{"SELECT l.id, NULL AS shard FROM S3Object l WHERE '2024-05-03' = '2024-05-03'"}, expressions)
+
+	rows, err = db.QueryContext(context.Background(), `DESCRIBE analytics.access_logs`)
+	require.NoError(t, err)
+	var described []string
+	for rows.Next() {
+		var name, typ, nullable string
+		require.NoError(t, rows.Scan(&name, &typ, &nullable))
+		described = append(described, name+" "+typ)
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"id bigint", "path string", "dt string", "shard bigint"}, described)
+
+	_, err = db.QueryContext(context.Background(), `SELECT * FROM analytics.missing m`)
+	require.EqualError(t, err, "unknown table analytics.missing")
+}
+
+func TestMock__GlueClientConstructor(t *testing.T) {
+	glueClient := &fakeGlueClient{}
+	GlueClientConstructor = func(ctx context.Context, cfg *S3SelectConfig) (GlueClient, error) {
+		require.True(t, cfg.Glue)
+		return glueClient, nil
+	}
+	defer func() {
+		GlueClientConstructor = nil
+	}()
+	mockClients["glue_client_constructor"] = &mockS3SelectClient{}
+	db, err := sql.Open("s3-select", "s3://example-com/?glue=true&mock=glue_client_constructor")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.QueryContext(context.Background(), `SELECT * FROM analytics.access_logs l`)
+	require.EqualError(t, err, "unknown table analytics.access_logs")
+	require.Equal(t, 1, glueClient.getTables, "the glue client is created for glue=true")
+}
+
+func TestGlueCatalog__PartitionsCache(t *testing.T) {
+	glueClient := &fakeGlueClient{}
+	c := newGlueCatalog(glueClient, time.Minute)
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	table := &GlueTable{DatabaseName: "analytics", Name: "access_logs"}
+	ctx := context.Background()
+
+	for i := 0; i <= gluePartitionsCacheSize; i++ {
+		_, err := c.partitionsOf(ctx, table, "shard = "+strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	require.Equal(t, gluePartitionsCacheSize, c.partitions.len(), "the number of cached expressions is capped")
+	_, err := c.partitionsOf(ctx, table, "shard = 0")
+	require.NoError(t, err)
+	require.Len(t, glueClient.expressions, gluePartitionsCacheSize+2, "the least recently used expression is evicted")
+
+	_, err = c.partitionsOf(ctx, table, "shard = 0")
+	require.NoError(t, err)
+	require.Len(t, glueClient.expressions, gluePartitionsCacheSize+2)
+	now = now.Add(time.Minute)
+	_, err = c.partitionsOf(ctx, table, "shard = 0")
+	require.NoError(t, err)
+	require.Len(t, glueClient.expressions, gluePartitionsCacheSize+3, "expired partitions are fetched again")
+}
@@ -0,0 +1,48 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Hooks are callbacks invoked while executing queries. nil callbacks are ignored.
+type Hooks struct {
+	// BeforeQuery is called before the query is rewritten and executed.
+	BeforeQuery func(ctx context.Context, query string, args []driver.NamedValue)
+	// AfterQuery is called after all S3 Select calls of the query are finished.
+	AfterQuery func(ctx context.Context, query string, args []driver.NamedValue, err error)
+	// BeforeSelectObjectContent is called before each SelectObjectContent call. the input can be modified.
+	BeforeSelectObjectContent func(ctx context.Context, input *s3.SelectObjectContentInput)
+	// AfterSelectObjectContent is called after each SelectObjectContent call.
+	AfterSelectObjectContent func(ctx context.Context, input *s3.SelectObjectContentInput, err error)
+}
+
+func (h *Hooks) beforeQuery(ctx context.Context, query string, args []driver.NamedValue) {
+	if h == nil || h.BeforeQuery == nil {
+		return
+	}
+	h.BeforeQuery(ctx, query, args)
+}
+
+func (h *Hooks) afterQuery(ctx context.Context, query string, args []driver.NamedValue, err error) {
+	if h == nil || h.AfterQuery == nil {
+		return
+	}
+	h.AfterQuery(ctx, query, args, err)
+}
+
+func (h *Hooks) beforeSelectObjectContent(ctx context.Context, input *s3.SelectObjectContentInput) {
+	if h == nil || h.BeforeSelectObjectContent == nil {
+		return
+	}
+	h.BeforeSelectObjectContent(ctx, input)
+}
+
+func (h *Hooks) afterSelectObjectContent(ctx context.Context, input *s3.SelectObjectContentInput, err error) {
+	if h == nil || h.AfterSelectObjectContent == nil {
+		return
+	}
+	h.AfterSelectObjectContent(ctx, input, err)
+}
@@ -0,0 +1,614 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/iancoleman/orderedmap"
+)
+
+const (
+	// defaultIndexName is the name of the index file under the prefix with index=true.
+	defaultIndexName = "_s3select_index.json"
+	indexVersion     = 1
+	// bloomFalsePositiveRate is the false positive rate of bloom filters.
+	bloomFalsePositiveRate = 0.01
+	// maxBloomValues is the max number of distinct values of a column in an object, bloom filter is not built over it.
+	maxBloomValues = 1000000
+	// indexCacheSize is the max number of index files cached by the connector.
+	indexCacheSize = 16
+)
+
+// types of column statistics in the index.
+const (
+	indexColumnTypeString = "string"
+	indexColumnTypeNumber = "number"
+)
+
+// objectIndex is the sidecar index file, statistics of objects under the prefix.
+type objectIndex struct {
+	Version int           `json:"version"`
+	Objects []*indexEntry `json:"objects"`
+
+	entries map[string]*indexEntry
+	columns map[string]*projectedColumn
+}
+
+// indexEntry is statistics of an object, it is valid while the ETag of the object is the same.
+type indexEntry struct {
+	Bucket  string                       `json:"bucket"`
+	Key     string                       `json:"key"`
+	ETag    string                       `json:"etag"`
+	Records int64                        `json:"records"`
+	Bloom   []string                     `json:"bloom,omitempty"`
+	Columns map[string]*indexColumnStats `json:"columns"`
+}
+
+// indexColumnStats is statistics of a top level column of an object, by lower case name.
+// Type is empty if values are not of a single scalar type, and only Nulls is available.
+type indexColumnStats struct {
+	Type  string       `json:"type,omitempty"`
+	Min   interface{}  `json:"min,omitempty"`
+	Max   interface{}  `json:"max,omitempty"`
+	Nulls int64        `json:"nulls"`
+	Bloom *bloomFilter `json:"bloom,omitempty"`
+
+	// values are distinct values for the bloom filter, nil if the bloom filter is not built.
+	values map[string]struct{}
+	// seen is the number of non-null values.
+	seen  int64
+	mixed bool
+}
+
+func (cfg *S3SelectConfig) setIndexParams(params url.Values) error {
+	if params.Has("index_bloom") {
+		for _, name := range strings.Split(params.Get("index_bloom"), ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return errors.New("index_bloom has empty name")
+			}
+			cfg.IndexBloomColumns = append(cfg.IndexBloomColumns, name)
+		}
+		cfg.Params.Del("index_bloom")
+	}
+	if !params.Has("index") {
+		if len(cfg.IndexBloomColumns) > 0 {
+			return errors.New("index_bloom requires index")
+		}
+		return nil
+	}
+	cfg.Index = params.Get("index")
+	cfg.Params.Del("index")
+	if _, _, err := cfg.indexLocation(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setIndexToURLValues(params url.Values) {
+	if cfg.Index != "" {
+		params.Set("index", cfg.Index)
+	} else {
+		params.Del("index")
+	}
+	if len(cfg.IndexBloomColumns) > 0 {
+		params.Set("index_bloom", strings.Join(cfg.IndexBloomColumns, ","))
+	} else {
+		params.Del("index_bloom")
+	}
+}
+
+// indexLocation returns the bucket and the key of the index file.
+func (cfg *S3SelectConfig) indexLocation() (string, string, error) {
+	if b, err := strconv.ParseBool(cfg.Index); err == nil {
+		if !b {
+			return "", "", errors.New("index must be true or s3 url of the index file")
+		}
+		if cfg.ObjectKey != "" || len(cfg.Sources) > 0 || cfg.Manifest != "" || cfg.KeyTemplate != "" {
+			return "", "", errors.New("index=true requires a single prefix")
+		}
+		return cfg.BucketName, cfg.ObjectKeyPrefix + defaultIndexName, nil
+	}
+	src, err := parseSource(cfg.Index)
+	if err != nil {
+		return "", "", fmt.Errorf("parse index: %w", err)
+	}
+	if src.ObjectKey == "" {
+		return "", "", errors.New("index must be an object, not prefix")
+	}
+	return src.BucketName, src.ObjectKey, nil
+}
+
+// isIndexObject reports whether the content is the index file, it is not queried.
+func (cfg *S3SelectConfig) isIndexObject(content contentInfo) bool {
+	if cfg.Index == "" {
+		return false
+	}
+	bucketName, objectKey, err := cfg.indexLocation()
+	return err == nil && content.BucketName == bucketName && content.ObjectKey == objectKey
+}
+
+// BuildIndex builds the sidecar index of objects of db, and writes it to the location of `index` in DSN.
+// the index has the number of records, min/max and nulls of each top level column, and bloom filters of columns of `index_bloom`.
+// entries of objects whose ETag is not changed are reused from the existing index.
+func BuildIndex(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*s3SelectConn)
+		if !ok {
+			return errors.New("db is not s3-select")
+		}
+		return c.buildIndex(ctx)
+	})
+}
+
+func (conn *s3SelectConn) buildIndex(ctx context.Context) error {
+	if conn.cfg.Index == "" {
+		return errors.New("index is not set")
+	}
+	bucketName, objectKey, err := conn.cfg.indexLocation()
+	if err != nil {
+		return err
+	}
+	client, ok := conn.client.(S3PutObjectClient)
+	if !ok {
+		return errors.New("s3 select client does not support PutObject")
+	}
+	existing, err := conn.loadIndex(ctx)
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if !errors.As(err, &notFound) {
+			return err
+		}
+		existing = nil
+	}
+	bloomColumns := make([]string, 0, len(conn.cfg.IndexBloomColumns))
+	for _, name := range conn.cfg.IndexBloomColumns {
+		bloomColumns = append(bloomColumns, strings.ToLower(name))
+	}
+	query := "SELECT * FROM S3Object s"
+	if preset := conn.cfg.logPreset(); preset != nil && preset.fromRoot != "" && conn.cfg.InputSerialization == nil {
+		if query, err = rewriteFromRoot(query, preset.fromRoot); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	contentCh := make(chan contentInfo)
+	doneCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(contentCh)
+		errCh <- conn.enumerateContents(ctx, nil, nil, nil, contentCh, doneCh)
+	}()
+	index := &objectIndex{Version: indexVersion}
+	isFirst := true
+	var scanErr error
+	for content := range contentCh {
+		if content.ETag == "" {
+			conn.debugf("index: skip s3://%s/%s without ETag", content.BucketName, content.ObjectKey)
+			continue
+		}
+		if entry := existing.entry(content); entry != nil && strings.Join(entry.Bloom, ",") == strings.Join(bloomColumns, ",") {
+			conn.debugf("index: reuse s3://%s/%s", content.BucketName, content.ObjectKey)
+			index.Objects = append(index.Objects, entry)
+			isFirst = false
+			continue
+		}
+		var entry *indexEntry
+		entry, scanErr = conn.scanIndexEntry(ctx, content, query, bloomColumns, isFirst)
+		if scanErr != nil {
+			break
+		}
+		if entry != nil {
+			index.Objects = append(index.Objects, entry)
+			isFirst = false
+		}
+	}
+	close(doneCh)
+	for range contentCh {
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	bs, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(objectKey),
+		Body:        bytes.NewReader(bs),
+		ContentType: aws.String("application/json"),
+	}
+	if conn.cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(conn.cfg.ExpectedBucketOwner)
+	}
+	if conn.cfg.RequestPayer != "" {
+		input.RequestPayer = conn.cfg.RequestPayer
+	}
+	conn.debugf("index: put s3://%s/%s objects=%d", bucketName, objectKey, len(index.Objects))
+	if _, err := client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("put index s3://%s/%s: %w", bucketName, objectKey, err)
+	}
+	return nil
+}
+
+// scanIndexEntry reads all records of the object by S3 Select, and returns the statistics. nil if the object is skipped.
+func (conn *s3SelectConn) scanIndexEntry(ctx context.Context, content contentInfo, query string, bloomColumns []string, isFirst bool) (*indexEntry, error) {
+	inputSerialization, err := conn.inputSerializationFor(ctx, content)
+	if err != nil {
+		if errors.Is(err, ErrUnknownFormat) && conn.cfg.UnknownFormatPolicy == UnknownFormatPolicySkip {
+			conn.debugf("skip object: %v", err)
+			return nil, nil
+		}
+		return nil, err
+	}
+	if inputSerialization.Parquet != nil {
+		// parquet has statistics in the footer
+		return nil, nil
+	}
+	if conn.cfg.Header == S3SelectHeaderFirstObject {
+		inputSerialization = withFirstObjectHeader(inputSerialization, isFirst)
+	}
+	input := &s3.SelectObjectContentInput{
+		Bucket:             aws.String(content.BucketName),
+		Key:                aws.String(content.ObjectKey),
+		Expression:         aws.String(query),
+		ExpressionType:     types.ExpressionTypeSql,
+		InputSerialization: inputSerialization,
+		OutputSerialization: &types.OutputSerialization{
+			JSON: &types.JSONOutput{},
+		},
+	}
+	conn.cfg.applySelectObjectContentInput(input)
+	conn.debugf("index: scan s3://%s/%s", content.BucketName, content.ObjectKey)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(conn.client.SelectObjectContentWithWriter(ctx, pw, input, conn.cfg.selectObjectContentOptFns()...))
+	}()
+	defer pr.Close()
+	entry := &indexEntry{
+		Bucket:  content.BucketName,
+		Key:     content.ObjectKey,
+		ETag:    content.ETag,
+		Bloom:   bloomColumns,
+		Columns: make(map[string]*indexColumnStats),
+	}
+	dec := json.NewDecoder(pr)
+	for {
+		o := orderedmap.New()
+		if err := dec.Decode(o); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("index s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+		}
+		entry.add(o.Keys(), o.Get, bloomColumns)
+	}
+	entry.finish()
+	return entry, nil
+}
+
+func (entry *indexEntry) add(keys []string, get func(string) (interface{}, bool), bloomColumns []string) {
+	entry.Records++
+	for _, key := range keys {
+		name := strings.ToLower(key)
+		stats, ok := entry.Columns[name]
+		if !ok {
+			stats = &indexColumnStats{}
+			for _, bloomColumn := range bloomColumns {
+				if bloomColumn == name {
+					stats.values = make(map[string]struct{})
+				}
+			}
+			entry.Columns[name] = stats
+		}
+		v, _ := get(key)
+		stats.add(v)
+	}
+}
+
+// finish counts nulls including missing values, and builds bloom filters.
+func (entry *indexEntry) finish() {
+	for _, stats := range entry.Columns {
+		stats.Nulls = entry.Records - stats.seen
+		if stats.values != nil {
+			stats.Bloom = newBloomFilter(len(stats.values))
+			for value := range stats.values {
+				stats.Bloom.add(value)
+			}
+			stats.values = nil
+		}
+	}
+}
+
+func (stats *indexColumnStats) add(v interface{}) {
+	if v == nil {
+		return
+	}
+	stats.seen++
+	if stats.mixed {
+		return
+	}
+	var typ string
+	switch v.(type) {
+	case string:
+		typ = indexColumnTypeString
+	case float64:
+		typ = indexColumnTypeNumber
+	}
+	if typ == "" || (stats.Type != "" && stats.Type != typ) {
+		// min/max and bloom filter are not available for nested values and mixed types
+		stats.mixed = true
+		stats.Type, stats.Min, stats.Max, stats.values = "", nil, nil, nil
+		return
+	}
+	if stats.Type == "" {
+		stats.Type, stats.Min, stats.Max = typ, v, v
+	} else {
+		if compareProjectedValues(v, stats.Min) < 0 {
+			stats.Min = v
+		}
+		if compareProjectedValues(v, stats.Max) > 0 {
+			stats.Max = v
+		}
+	}
+	if stats.values != nil {
+		if len(stats.values) >= maxBloomValues {
+			stats.values = nil
+			return
+		}
+		stats.values[bloomKey(v)] = struct{}{}
+	}
+}
+
+// bloomKey returns the value as the key of bloom filter, numbers in JSON and literals are the same keys.
+func bloomKey(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return "n:" + strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return "n:" + strconv.FormatFloat(float64(v), 'g', -1, 64)
+	}
+	return "s:" + fmt.Sprint(v)
+}
+
+// loadIndex reads the index file, or returns the cached index if the ETag of the index file is not changed.
+func (conn *s3SelectConn) loadIndex(ctx context.Context) (*objectIndex, error) {
+	bucketName, objectKey, err := conn.cfg.indexLocation()
+	if err != nil {
+		return nil, err
+	}
+	version := objectVersion{bucket: bucketName, key: objectKey}
+	if conn.indexes != nil {
+		version.etag = conn.objectETag(ctx, contentInfo{BucketName: bucketName, ObjectKey: objectKey})
+	}
+	if version.etag != "" {
+		if index, ok := conn.indexes.get(version); ok {
+			conn.debugf("index s3://%s/%s is cached", bucketName, objectKey)
+			return index, nil
+		}
+	}
+	index, err := conn.readIndex(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	if version.etag != "" {
+		conn.indexes.add(version, index)
+	}
+	return index, nil
+}
+
+func (conn *s3SelectConn) readIndex(ctx context.Context, bucketName, objectKey string) (*objectIndex, error) {
+	bs, err := conn.getObjectBytes(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("read index s3://%s/%s: %w", bucketName, objectKey, err)
+	}
+	var index objectIndex
+	if err := json.Unmarshal(bs, &index); err != nil {
+		return nil, fmt.Errorf("parse index s3://%s/%s: %w", bucketName, objectKey, err)
+	}
+	if index.Version != indexVersion {
+		return nil, fmt.Errorf("index s3://%s/%s: unsupported version %d", bucketName, objectKey, index.Version)
+	}
+	index.entries = make(map[string]*indexEntry, len(index.Objects))
+	index.columns = make(map[string]*projectedColumn)
+	for _, entry := range index.Objects {
+		index.entries[entry.Bucket+"/"+entry.Key] = entry
+		for name, stats := range entry.Columns {
+			if stats.Type == "" {
+				continue
+			}
+			if _, ok := index.columns[name]; ok {
+				continue
+			}
+			typ := projectionTypeString
+			if stats.Type == indexColumnTypeNumber {
+				typ = projectionTypeFloat
+			}
+			index.columns[name] = &projectedColumn{name: name, typ: typ}
+		}
+	}
+	return &index, nil
+}
+
+// entry returns the entry of the object, nil if the object is not indexed or modified after indexing.
+func (index *objectIndex) entry(content contentInfo) *indexEntry {
+	if index == nil || content.ETag == "" {
+		return nil
+	}
+	entry, ok := index.entries[content.BucketName+"/"+content.ObjectKey]
+	if !ok || entry.ETag != content.ETag {
+		return nil
+	}
+	return entry
+}
+
+// column returns the column of statistics for the identifier in WHERE, such as `id` or `s.id`.
+func (index *objectIndex) column(identifier string) *projectedColumn {
+	name, ok := topLevelColumn(identifier, func(name string) bool {
+		_, ok := index.columns[strings.ToLower(name)]
+		return ok
+	})
+	if !ok {
+		return nil
+	}
+	return index.columns[strings.ToLower(name)]
+}
+
+// indexPruner returns the function which reports whether the object can be skipped by the index.
+// the index is an optimization, nil is returned if the index is not available.
+func (conn *s3SelectConn) indexPruner(ctx context.Context, query string) func(contentInfo) bool {
+	if conn.cfg.Index == "" || !hasWhereClause(query) {
+		return nil
+	}
+	index, err := conn.loadIndex(ctx)
+	if err != nil {
+		conn.debugf("index is not available: %v", err)
+		return nil
+	}
+	constraints, err := extractConstraints(query, index.column)
+	if err != nil || len(constraints) == 0 {
+		return nil
+	}
+	return func(content contentInfo) bool {
+		if index.canSkip(content, constraints) {
+			conn.debugf("skip s3://%s/%s by index", content.BucketName, content.ObjectKey)
+			return true
+		}
+		return false
+	}
+}
+
+// canSkip reports whether statistics of the object prove that no record matches constraints.
+func (index *objectIndex) canSkip(content contentInfo, constraints map[*projectedColumn][]projectionConstraint) bool {
+	entry := index.entry(content)
+	if entry == nil {
+		return false
+	}
+	if entry.Records == 0 {
+		return true
+	}
+	for column, cs := range constraints {
+		stats, ok := entry.Columns[column.name]
+		if !ok {
+			continue
+		}
+		if stats.Nulls >= entry.Records {
+			// comparisons with NULL are never true
+			return true
+		}
+		if stats.Type != index.columns[column.name].typeOfStats() {
+			continue
+		}
+		for _, c := range cs {
+			if !c.overlaps(stats.Min, stats.Max) {
+				return true
+			}
+			if stats.Bloom != nil && (c.op == "=" || c.op == "IN") {
+				found := false
+				for _, v := range c.values {
+					if stats.Bloom.mayContain(bloomKey(v)) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// typeOfStats returns the type of column statistics in the index, for the type of the column.
+func (column *projectedColumn) typeOfStats() string {
+	if column.typ == projectionTypeFloat {
+		return indexColumnTypeNumber
+	}
+	return indexColumnTypeString
+}
+
+// bloomFilter is a bloom filter with k hashes by double hashing of FNV-1a.
+type bloomFilter struct {
+	Bits []byte `json:"bits"`
+	K    int    `json:"k"`
+}
+
+// newBloomFilter returns the bloom filter for n values with bloomFalsePositiveRate.
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := int(math.Ceil(-float64(n) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		Bits: make([]byte, (m+7)/8),
+		K:    k,
+	}
+}
+
+func (b *bloomFilter) positions(s string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(b.Bits) * 8)
+	positions := make([]uint64, b.K)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % m
+	}
+	return positions
+}
+
+func (b *bloomFilter) add(s string) {
+	for _, p := range b.positions(s) {
+		b.Bits[p/8] |= 1 << (p % 8)
+	}
+}
+
+func (b *bloomFilter) mayContain(s string) bool {
+	if len(b.Bits) == 0 || b.K < 1 {
+		return true
+	}
+	for _, p := range b.positions(s) {
+		if b.Bits[p/8]&(1<<(p%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON encodes bits as base64.
+func (b *bloomFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"bits": base64.StdEncoding.EncodeToString(b.Bits),
+		"k":    b.K,
+	})
+}
@@ -0,0 +1,221 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/iancoleman/orderedmap"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	b := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		b.add(fmt.Sprintf("s:%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		require.True(t, b.mayContain(fmt.Sprintf("s:%d", i)))
+	}
+	var falsePositives int
+	for i := 1000; i < 11000; i++ {
+		if b.mayContain(fmt.Sprintf("s:%d", i)) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 300, "false positive rate is about 1%")
+
+	bs, err := json.Marshal(b)
+	require.NoError(t, err)
+	var decoded bloomFilter
+	require.NoError(t, json.Unmarshal(bs, &decoded))
+	require.Equal(t, b, &decoded)
+}
+
+func TestIndexEntry(t *testing.T) {
+	entry := &indexEntry{Columns: make(map[string]*indexColumnStats)}
+	for _, record := range []string{
+		`{"id":3,"Name":"b","tags":["x"]}`,
+		`{"id":1,"Name":null,"value":"x"}`,
+		`{"id":2,"Name":"a","tags":"y"}`,
+	} {
+		o := orderedmap.New()
+		require.NoError(t, o.UnmarshalJSON([]byte(record)))
+		entry.add(o.Keys(), o.Get, []string{"name"})
+	}
+	entry.finish()
+	require.EqualValues(t, 3, entry.Records)
+
+	id := entry.Columns["id"]
+	require.Equal(t, indexColumnTypeNumber, id.Type)
+	require.Equal(t, float64(1), id.Min)
+	require.Equal(t, float64(3), id.Max)
+	require.EqualValues(t, 0, id.Nulls)
+	require.Nil(t, id.Bloom)
+
+	name := entry.Columns["name"]
+	require.Equal(t, indexColumnTypeString, name.Type)
+	require.Equal(t, "a", name.Min)
+	require.Equal(t, "b", name.Max)
+	require.EqualValues(t, 1, name.Nulls)
+	require.NotNil(t, name.Bloom)
+	require.True(t, name.Bloom.mayContain(bloomKey("a")))
+	require.False(t, name.Bloom.mayContain(bloomKey("c")))
+
+	tags := entry.Columns["tags"]
+	require.Equal(t, "", tags.Type, "mixed types have no min/max")
+	require.Nil(t, tags.Min)
+	require.EqualValues(t, 1, tags.Nulls)
+
+	require.EqualValues(t, 2, entry.Columns["value"].Nulls, "missing values are nulls")
+}
+
+func TestS3SelectConfig__IndexParams(t *testing.T) {
+	cases := []struct {
+		dsn      string
+		expected string
+	}{
+		{
+			dsn:      "s3://example-com/data/?format=csv&index=false",
+			expected: "dsn is invalid: set query params: index must be true or s3 url of the index file",
+		},
+		{
+			dsn:      "s3://example-com/data.csv?format=csv&index=true",
+			expected: "dsn is invalid: set query params: index=true requires a single prefix",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&index=s3://index-com/data/",
+			expected: "dsn is invalid: set query params: index must be an object, not prefix",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&index_bloom=id",
+			expected: "dsn is invalid: set query params: index_bloom requires index",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&index=true&index_bloom=id,,name",
+			expected: "dsn is invalid: set query params: index_bloom has empty name",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.dsn, func(t *testing.T) {
+			_, err := ParseDSN(c.dsn)
+			require.EqualError(t, err, c.expected)
+		})
+	}
+}
+
+func TestMock__Index(t *testing.T) {
+	objects := map[string]string{
+		"data/1.json": `{"request_id":"abc","status":200}` + "\n" + `{"request_id":"def","status":500}` + "\n",
+		"data/2.json": `{"request_id":"ghi","status":200}` + "\n" + `{"request_id":"jkl","status":404}` + "\n",
+		"data/3.json": `{"request_id":null,"status":200}` + "\n",
+	}
+	etags := map[string]string{
+		"data/1.json": `"etag-1"`,
+		"data/2.json": `"etag-2"`,
+		"data/3.json": `"etag-3"`,
+	}
+	var indexBody []byte
+	var indexPuts, indexGets int
+	var selected []string
+	mockClients["index"] = &mockS3SelectClient{
+		HeadObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			require.Equal(t, "data/_s3select_index.json", *params.Key)
+			if indexBody == nil {
+				return nil, &types.NotFound{}
+			}
+			return &s3.HeadObjectOutput{ETag: aws.String(fmt.Sprintf(`"index-%d"`, indexPuts))}, nil
+		},
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			contents := []types.Object{
+				{Key: aws.String("data/1.json"), ETag: aws.String(etags["data/1.json"])},
+				{Key: aws.String("data/2.json"), ETag: aws.String(etags["data/2.json"])},
+				{Key: aws.String("data/3.json"), ETag: aws.String(etags["data/3.json"])},
+			}
+			if indexBody != nil {
+				contents = append(contents, types.Object{Key: aws.String("data/_s3select_index.json"), ETag: aws.String(`"index"`)})
+			}
+			return &s3.ListObjectsV2Output{
+				Name:     params.Bucket,
+				Contents: contents,
+			}, nil
+		},
+		GetObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			require.Equal(t, "data/_s3select_index.json", *params.Key)
+			if indexBody == nil {
+				return nil, &types.NoSuchKey{}
+			}
+			indexGets++
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader(indexBody)),
+			}, nil
+		},
+		PutObjectFunc: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			require.Equal(t, "example-com", *params.Bucket)
+			require.Equal(t, "data/_s3select_index.json", *params.Key)
+			bs, err := io.ReadAll(params.Body)
+			require.NoError(t, err)
+			indexBody = bs
+			indexPuts++
+			return &s3.PutObjectOutput{}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.NotEqual(t, "data/_s3select_index.json", *params.Key, "index file is not queried")
+			selected = append(selected, *params.Key)
+			_, err := io.WriteString(w, objects[*params.Key])
+			return err
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json_lines&index=true&index_bloom=request_id&mock=index")
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, BuildIndex(context.Background(), db))
+	require.Equal(t, []string{"data/1.json", "data/2.json", "data/3.json"}, selected)
+	var index objectIndex
+	require.NoError(t, json.Unmarshal(indexBody, &index))
+	require.Len(t, index.Objects, 3)
+	require.EqualValues(t, 2, index.Objects[0].Records)
+	require.Equal(t, "abc", index.Objects[0].Columns["request_id"].Min)
+	require.EqualValues(t, 1, index.Objects[2].Columns["request_id"].Nulls)
+
+	query := func(q string) []string {
+		t.Helper()
+		selected = nil
+		rows, err := db.QueryContext(context.Background(), q)
+		require.NoError(t, err)
+		defer rows.Close()
+		for rows.Next() {
+		}
+		require.NoError(t, rows.Err())
+		return selected
+	}
+	require.Equal(t, []string{"data/1.json"}, query(`SELECT * FROM S3Object s WHERE s.request_id = 'abc'`))
+	require.Equal(t, []string{"data/2.json"}, query(`SELECT * FROM S3Object s WHERE s.status > 300 AND s.request_id >= 'g'`))
+	require.Equal(t, []string{"data/1.json", "data/2.json", "data/3.json"}, query(`SELECT * FROM S3Object s WHERE s.status = 500 OR s.request_id = 'ghi'`), "OR is not pruned")
+	require.Equal(t, []string{"data/1.json", "data/2.json", "data/3.json"}, query(`SELECT * FROM S3Object s`))
+	require.Equal(t, 1, indexGets, "the index is cached by ETag")
+
+	etags["data/2.json"] = `"etag-2-modified"`
+	require.Equal(t, []string{"data/1.json", "data/2.json"}, query(`SELECT * FROM S3Object s WHERE s.request_id IN ('abc', 'xyz')`), "modified object is not pruned")
+
+	// rebuilding reuses entries of unmodified objects
+	selected = nil
+	require.NoError(t, BuildIndex(context.Background(), db))
+	require.Equal(t, []string{"data/2.json"}, selected)
+	require.NoError(t, json.Unmarshal(indexBody, &index))
+	require.Equal(t, `"etag-2-modified"`, index.Objects[1].ETag)
+
+	indexGets = 0
+	require.Equal(t, []string{"data/1.json"}, query(`SELECT * FROM S3Object s WHERE s.request_id IN ('abc', 'xyz')`))
+	require.Equal(t, 1, indexGets, "the rebuilt index is read again")
+}
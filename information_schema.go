@@ -0,0 +1,253 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+const informationSchemaPrefix = "information_schema."
+
+// informationSchemaViews are columns of virtual tables answered by the driver from listing, never by S3 Select.
+var informationSchemaViews = map[string][]string{
+	"objects": {"bucket", "key", "size", "last_modified", "etag", "storage_class"},
+	"formats": {"bucket", "key", "format", "compression_type", "file_header_info"},
+	"tables":  {"table_name", "location", "format", "compression_type"},
+	"columns": {"table_name", "column_name", "ordinal_position", "data_type", "is_nullable"},
+}
+
+// isInformationSchemaQuery reports whether FROM of the query is a view of information_schema.
+func isInformationSchemaQuery(query string) bool {
+	tokens, err := significantTokens(query)
+	if err != nil {
+		return false
+	}
+	for i, token := range tokens {
+		if token.Kind == lexer.KindIdentifier && strings.EqualFold(token.Value, "FROM") && i+1 < len(tokens) {
+			next := tokens[i+1]
+			return next.Kind == lexer.KindIdentifier && strings.HasPrefix(strings.ToLower(next.Value), informationSchemaPrefix)
+		}
+	}
+	return false
+}
+
+func (conn *s3SelectConn) queryInformationSchema(ctx context.Context, query string) (driver.Rows, error) {
+	q, err := parseLocalQuery(query, func(table string) ([]string, error) {
+		columns, ok := informationSchemaViews[strings.TrimPrefix(table, informationSchemaPrefix)]
+		if !ok {
+			return nil, fmt.Errorf("unknown table %s", table)
+		}
+		return columns, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("information_schema: %w", err)
+	}
+	var scan func(fn func(values []interface{}) bool) error
+	switch strings.TrimPrefix(q.table, informationSchemaPrefix) {
+	case "objects":
+		scan = func(fn func([]interface{}) bool) error {
+			return conn.scanContents(ctx, func(content contentInfo) (bool, error) {
+				return fn(objectValues(content)), nil
+			})
+		}
+	case "formats":
+		scan = func(fn func([]interface{}) bool) error {
+			return conn.scanContents(ctx, func(content contentInfo) (bool, error) {
+				values, err := conn.formatValues(ctx, content)
+				if err != nil {
+					return false, err
+				}
+				return fn(values), nil
+			})
+		}
+	case "tables":
+		scan = func(fn func([]interface{}) bool) error {
+			if conn.cfg.hasDefaultTable() && !fn(conn.tableValues()) {
+				return nil
+			}
+			tables, err := conn.tables(ctx)
+			if err != nil {
+				return err
+			}
+			for _, table := range tables {
+				values := []interface{}{table.Name, table.Location, nil, nil}
+				if table.Format != "" {
+					values[2] = string(table.Format)
+				}
+				if table.CompressionType != "" {
+					values[3] = string(table.CompressionType)
+				}
+				if !fn(values) {
+					break
+				}
+			}
+			return nil
+		}
+	case "columns":
+		scan = func(fn func([]interface{}) bool) error {
+			if conn.cfg.hasDefaultTable() {
+				ok, err := conn.scanColumns(ctx, "S3Object", fn)
+				if err != nil || !ok {
+					return err
+				}
+			}
+			tables, err := conn.tables(ctx)
+			if err != nil {
+				return err
+			}
+			for _, table := range tables {
+				tableConn, err := conn.tableConn(table)
+				if err != nil {
+					return err
+				}
+				ok, err := tableConn.scanColumns(ctx, table.Name, fn)
+				if err != nil || !ok {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	rows, err := q.run(scan)
+	if err != nil {
+		return nil, fmt.Errorf("information_schema: %w", err)
+	}
+	return newRows(q.names(), rows, false), nil
+}
+
+// scanContents calls fn for each object of the dataset until fn returns false.
+// with key_template, all objects under the prefix are listed.
+func (conn *s3SelectConn) scanContents(ctx context.Context, fn func(contentInfo) (bool, error)) error {
+	var partitions []partition
+	if conn.cfg.KeyTemplate != "" {
+		partitions = []partition{{prefix: conn.cfg.ObjectKeyPrefix}}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	contentCh := make(chan contentInfo)
+	doneCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(contentCh)
+		errCh <- conn.enumerateContents(ctx, partitions, nil, nil, contentCh, doneCh)
+	}()
+	var fnErr error
+	for content := range contentCh {
+		ok, err := fn(content)
+		if err != nil {
+			fnErr = err
+			break
+		}
+		if !ok {
+			break
+		}
+	}
+	close(doneCh)
+	for range contentCh {
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// scanColumns calls fn for columns of the schema of the dataset as rows of information_schema.columns.
+func (conn *s3SelectConn) scanColumns(ctx context.Context, table string, fn func([]interface{}) bool) (bool, error) {
+	schema, err := conn.inferSchema(ctx)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", table, err)
+	}
+	for i, column := range schema.Columns {
+		nullable := "NO"
+		if column.Nullable {
+			nullable = "YES"
+		}
+		if !fn([]interface{}{table, column.Name, int64(i + 1), column.TypeName(), nullable}) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// objectValues returns the row of information_schema.objects. objects which are not listed have only the bucket and the key.
+func objectValues(content contentInfo) []interface{} {
+	values := []interface{}{content.BucketName, content.ObjectKey, nil, nil, nil, nil}
+	if content.LastModified.IsZero() {
+		return values
+	}
+	values[2] = content.Size
+	values[3] = content.LastModified
+	values[4] = strings.Trim(content.ETag, `"`)
+	if content.StorageClass != "" {
+		values[5] = content.StorageClass
+	}
+	return values
+}
+
+// formatValues returns the row of information_schema.formats, the format is detected as queries do.
+func (conn *s3SelectConn) formatValues(ctx context.Context, content contentInfo) ([]interface{}, error) {
+	values := []interface{}{content.BucketName, content.ObjectKey, nil, nil, nil}
+	inputSerialization, err := conn.inputSerializationFor(ctx, content)
+	if err != nil {
+		if errors.Is(err, ErrUnknownFormat) {
+			return values, nil
+		}
+		return nil, err
+	}
+	format := formatOfInputSerialization(inputSerialization)
+	if conn.cfg.logPreset() != nil {
+		format = conn.cfg.Format
+	}
+	values[2] = string(format)
+	values[3] = strings.ToLower(string(inputSerialization.CompressionType))
+	if values[3] == "" {
+		values[3] = string(S3SelectCompressionTypeNone)
+	}
+	if inputSerialization.CSV != nil {
+		values[4] = string(inputSerialization.CSV.FileHeaderInfo)
+	}
+	return values, nil
+}
+
+// formatOfInputSerialization returns the format which is equivalent to the input serialization.
+func formatOfInputSerialization(inputSerialization *types.InputSerialization) S3SelectFormat {
+	switch {
+	case inputSerialization.Parquet != nil:
+		return S3SelectFormatParquet
+	case inputSerialization.JSON != nil && inputSerialization.JSON.Type == types.JSONTypeLines:
+		return S3SelectFormatJSONL
+	case inputSerialization.JSON != nil:
+		return S3SelectFormatJSON
+	case inputSerialization.CSV != nil && inputSerialization.CSV.FieldDelimiter != nil && *inputSerialization.CSV.FieldDelimiter == "\t":
+		return S3SelectFormatTSV
+	}
+	return S3SelectFormatCSV
+}
+
+// tableValues returns the row of information_schema.tables for S3Object of the DSN.
+func (conn *s3SelectConn) tableValues() []interface{} {
+	var location string
+	if conn.cfg.Manifest != "" {
+		location = conn.cfg.Manifest
+	} else {
+		sources := conn.cfg.sources()
+		locations := make([]string, 0, len(sources))
+		for _, src := range sources {
+			locations = append(locations, src.String())
+		}
+		location = strings.Join(locations, ",")
+	}
+	values := []interface{}{"S3Object", location, nil, nil}
+	if conn.cfg.Format != "" {
+		values[2] = string(conn.cfg.Format)
+	}
+	if conn.cfg.CompressionType != "" {
+		values[3] = string(conn.cfg.CompressionType)
+	}
+	return values
+}
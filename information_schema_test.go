@@ -0,0 +1,102 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsInformationSchemaQuery(t *testing.T) {
+	for query, expected := range map[string]bool{
+		"SELECT * FROM information_schema.objects":            true,
+		"select key from INFORMATION_SCHEMA.OBJECTS o":        true,
+		"SELECT * FROM S3Object s":                            false,
+		"SELECT s.information_schema FROM S3Object s":         false,
+		"SELECT * FROM S3Object s WHERE s.from = 'x'":         false,
+		"SELECT * FROM S3Object s WHERE s.information_schema": false,
+	} {
+		require.Equal(t, expected, isInformationSchemaQuery(query), query)
+	}
+}
+
+func TestMock__InformationSchema(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	mockClients["information_schema"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("logs/2024-04-30.csv"), ETag: aws.String(`"a"`), Size: 10, LastModified: aws.Time(lastModified), StorageClass: types.ObjectStorageClassStandard},
+					{Key: aws.String("logs/2024-05-01.csv.gz"), ETag: aws.String(`"b"`), Size: 30, LastModified: aws.Time(lastModified), StorageClass: types.ObjectStorageClassStandard},
+					{Key: aws.String("logs/2024-05-02.jsonl"), ETag: aws.String(`"c"`), Size: 20, LastModified: aws.Time(lastModified), StorageClass: types.ObjectStorageClassGlacier},
+					{Key: aws.String("logs/README"), ETag: aws.String(`"d"`), Size: 5, LastModified: aws.Time(lastModified)},
+				},
+			}, nil
+		},
+		HeadObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			t.Fatalf("unexpected select: %s", *params.Key)
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/logs/?format=auto&unknown_format=skip&mock=information_schema")
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.Background(), `SELECT key, size, last_modified, storage_class FROM information_schema.objects WHERE key LIKE ? ORDER BY size DESC`, "logs/2024-05%")
+	require.NoError(t, err)
+	var actual []string
+	for rows.Next() {
+		var key, storageClass string
+		var size int64
+		var modified time.Time
+		require.NoError(t, rows.Scan(&key, &size, &modified, &storageClass))
+		require.Equal(t, lastModified, modified)
+		actual = append(actual, key+" "+storageClass)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"logs/2024-05-01.csv.gz STANDARD", "logs/2024-05-02.jsonl GLACIER"}, actual)
+
+	rows, err = db.QueryContext(context.Background(), `SELECT key, format, compression_type FROM information_schema.formats f ORDER BY f.key`)
+	require.NoError(t, err)
+	actual = nil
+	for rows.Next() {
+		var key string
+		var format, compressionType sql.NullString
+		require.NoError(t, rows.Scan(&key, &format, &compressionType))
+		actual = append(actual, key+" "+format.String+" "+compressionType.String)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{
+		"logs/2024-04-30.csv csv none",
+		"logs/2024-05-01.csv.gz csv gzip",
+		"logs/2024-05-02.jsonl json_lines none",
+		"logs/README  ",
+	}, actual)
+
+	var count, total int64
+	require.NoError(t, db.QueryRowContext(context.Background(), `SELECT COUNT(*), SUM(size) FROM information_schema.objects WHERE storage_class = :class`, sql.Named("class", "STANDARD")).Scan(&count, &total))
+	require.EqualValues(t, 2, count)
+	require.EqualValues(t, 40, total)
+
+	var tableName, location string
+	require.NoError(t, db.QueryRowContext(context.Background(), `SELECT table_name, location FROM information_schema.tables`).Scan(&tableName, &location))
+	require.Equal(t, "S3Object", tableName)
+	require.Equal(t, "s3://example-com/logs/", location)
+
+	_, err = db.QueryContext(context.Background(), `SELECT * FROM information_schema.buckets`)
+	require.EqualError(t, err, "information_schema: unknown table information_schema.buckets")
+}
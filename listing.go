@@ -0,0 +1,382 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	defaultListCacheRescanInterval = 10 * time.Minute
+	// listingCacheSize is the number of cached listings of prefixes.
+	listingCacheSize = 64
+)
+
+// hexListShards are sub-prefixes of list_shards=hex.
+var hexListShards = strings.Split("0,1,2,3,4,5,6,7,8,9,a,b,c,d,e,f", ",")
+
+func (cfg *S3SelectConfig) setListParams(params url.Values) error {
+	if params.Has("list_concurrency") {
+		n, err := strconv.Atoi(params.Get("list_concurrency"))
+		if err != nil {
+			return fmt.Errorf("parse list_concurrency: %w", err)
+		}
+		if n <= 0 {
+			return errors.New("list_concurrency must be positive")
+		}
+		cfg.ListConcurrency = n
+		cfg.Params.Del("list_concurrency")
+	}
+	if params.Has("list_shards") {
+		if params.Get("list_shards") == "hex" {
+			cfg.ListShards = hexListShards
+		} else {
+			for _, shard := range strings.Split(params.Get("list_shards"), ",") {
+				if shard == "" {
+					return errors.New("list_shards has empty shard")
+				}
+				cfg.ListShards = append(cfg.ListShards, shard)
+			}
+		}
+		cfg.Params.Del("list_shards")
+		for _, a := range cfg.ListShards {
+			for _, b := range cfg.ListShards {
+				if a != b && strings.HasPrefix(b, a) {
+					return fmt.Errorf("list_shards %s is a prefix of %s", a, b)
+				}
+			}
+		}
+		if cfg.ListConcurrency <= 1 {
+			return errors.New("list_shards requires list_concurrency")
+		}
+	}
+	durationParams := []struct {
+		name string
+		dest *time.Duration
+	}{
+		{name: "list_cache_ttl", dest: &cfg.ListCacheTTL},
+		{name: "list_cache_rescan", dest: &cfg.ListCacheRescanInterval},
+	}
+	for _, p := range durationParams {
+		if !params.Has(p.name) {
+			continue
+		}
+		d, err := parseDurationOrSeconds(params.Get(p.name))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", p.name, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", p.name)
+		}
+		*p.dest = d
+		cfg.Params.Del(p.name)
+	}
+	if cfg.ListCacheRescanInterval != 0 && cfg.ListCacheTTL == 0 {
+		return errors.New("list_cache_rescan requires list_cache_ttl")
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setListToURLValues(params url.Values) {
+	if cfg.ListConcurrency != 0 {
+		params.Set("list_concurrency", strconv.Itoa(cfg.ListConcurrency))
+	} else {
+		params.Del("list_concurrency")
+	}
+	if len(cfg.ListShards) > 0 {
+		params.Set("list_shards", strings.Join(cfg.ListShards, ","))
+	} else {
+		params.Del("list_shards")
+	}
+	if cfg.ListCacheTTL != 0 {
+		params.Set("list_cache_ttl", cfg.ListCacheTTL.String())
+	} else {
+		params.Del("list_cache_ttl")
+	}
+	if cfg.ListCacheRescanInterval != 0 {
+		params.Set("list_cache_rescan", cfg.ListCacheRescanInterval.String())
+	} else {
+		params.Del("list_cache_rescan")
+	}
+}
+
+// listPrefix lists objects by the input in order of keys and calls fn for each object.
+// the listing is read from the listing cache, and sub-prefixes are listed in parallel with list_concurrency.
+func (conn *s3SelectConn) listPrefix(ctx context.Context, input *s3.ListObjectsV2Input, doneCh <-chan struct{}, fn func(contentInfo) bool) (bool, error) {
+	if conn.listCache != nil {
+		return conn.listCache.list(ctx, conn, input, doneCh, fn)
+	}
+	return conn.listUncached(ctx, input, doneCh, fn)
+}
+
+func (conn *s3SelectConn) listUncached(ctx context.Context, input *s3.ListObjectsV2Input, doneCh <-chan struct{}, fn func(contentInfo) bool) (bool, error) {
+	if conn.cfg.ListConcurrency > 1 && (input.Delimiter == nil || len(conn.cfg.ListShards) > 0) {
+		return conn.listParallel(ctx, input, doneCh, fn)
+	}
+	return conn.listContents(ctx, input, doneCh, fn)
+}
+
+// listUnit is an object, or a sub-prefix listed in parallel.
+type listUnit struct {
+	content *contentInfo
+	input   *s3.ListObjectsV2Input
+	result  chan listUnitResult
+}
+
+type listUnitResult struct {
+	contents []contentInfo
+	ok       bool
+	err      error
+}
+
+// listParallel splits the prefix of the input into sub-prefixes, by list_shards or common prefixes of a recursive listing,
+// and lists up to list_concurrency sub-prefixes at once. objects are sent to fn in order of keys.
+func (conn *s3SelectConn) listParallel(ctx context.Context, input *s3.ListObjectsV2Input, doneCh <-chan struct{}, fn func(contentInfo) bool) (bool, error) {
+	units, err := conn.splitListing(ctx, input)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// window bounds listings in flight and listed but not sent sub-prefixes
+	window := make(chan struct{}, conn.cfg.ListConcurrency)
+	go func() {
+		for _, u := range units {
+			if u.input == nil {
+				continue
+			}
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(u *listUnit) {
+				var contents []contentInfo
+				ok, err := conn.listContents(ctx, u.input, nil, func(content contentInfo) bool {
+					contents = append(contents, content)
+					return true
+				})
+				u.result <- listUnitResult{contents: contents, ok: ok, err: err}
+			}(u)
+		}
+	}()
+	for _, u := range units {
+		if u.input == nil {
+			if !fn(*u.content) {
+				return false, nil
+			}
+			continue
+		}
+		var result listUnitResult
+		select {
+		case result = <-u.result:
+		case <-conn.aliveCh:
+			return false, sql.ErrConnDone
+		case <-ctx.Done():
+			return false, nil
+		case <-doneCh:
+			return false, nil
+		}
+		<-window
+		if result.err != nil || !result.ok {
+			return false, result.err
+		}
+		for _, content := range result.contents {
+			if !fn(content) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// splitListing returns units of the listing in order of keys, units before StartAfter of the input are excluded.
+func (conn *s3SelectConn) splitListing(ctx context.Context, input *s3.ListObjectsV2Input) ([]*listUnit, error) {
+	prefix, startAfter := aws.ToString(input.Prefix), aws.ToString(input.StartAfter)
+	var units []*listUnit
+	subPrefix := func(p string) {
+		if p <= startAfter && !strings.HasPrefix(startAfter, p) {
+			return
+		}
+		sub := *input
+		sub.Prefix = aws.String(p)
+		sub.StartAfter = nil
+		if strings.HasPrefix(startAfter, p) {
+			sub.StartAfter = aws.String(startAfter)
+		}
+		units = append(units, &listUnit{input: &sub, result: make(chan listUnitResult, 1)})
+	}
+	if len(conn.cfg.ListShards) > 0 {
+		shards := append([]string{}, conn.cfg.ListShards...)
+		sort.Strings(shards)
+		for _, shard := range shards {
+			subPrefix(prefix + shard)
+		}
+		return units, nil
+	}
+	// objects just under the prefix and common prefixes are in order of keys,
+	// an object is before all keys of a common prefix if the key is less than the common prefix.
+	top := *input
+	top.Delimiter = aws.String("/")
+	top.StartAfter = nil
+	p := s3.NewListObjectsV2Paginator(conn.client, &top)
+	for p.HasMorePages() {
+		output, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		commonPrefixes := make([]string, 0, len(output.CommonPrefixes))
+		for _, cp := range output.CommonPrefixes {
+			commonPrefixes = append(commonPrefixes, aws.ToString(cp.Prefix))
+		}
+		var i int
+		for _, object := range output.Contents {
+			for i < len(commonPrefixes) && commonPrefixes[i] < *object.Key {
+				subPrefix(commonPrefixes[i])
+				i++
+			}
+			if *object.Key <= startAfter {
+				continue
+			}
+			content := newContentInfo(output, object)
+			units = append(units, &listUnit{content: &content})
+		}
+		for ; i < len(commonPrefixes); i++ {
+			subPrefix(commonPrefixes[i])
+		}
+	}
+	return units, nil
+}
+
+// listingCache caches listings of prefixes, it is shared by connections of the connector.
+// a listing younger than ttl is used without requests, and an older listing is refreshed by listing keys after the last key.
+// objects modified or deleted after listing are found by HeadObject and S3 Select of cached contents,
+// and the listing is removed to list the whole prefix again. otherwise it is listed again after rescan.
+type listingCache struct {
+	ttl    time.Duration
+	rescan time.Duration
+	now    func() time.Time
+
+	listings *lruCache[string, *listing]
+}
+
+// listing is objects of a prefix from the first key, which are all objects if complete.
+type listing struct {
+	contents []contentInfo
+	complete bool
+	// listedAt is the time of the last request, and scannedAt is the time of listing from the first key.
+	listedAt  time.Time
+	scannedAt time.Time
+}
+
+func newListingCache(cfg *S3SelectConfig) *listingCache {
+	c := &listingCache{
+		ttl:      cfg.ListCacheTTL,
+		rescan:   cfg.ListCacheRescanInterval,
+		now:      time.Now,
+		listings: newLRUCache[string, *listing](listingCacheSize),
+	}
+	if c.rescan <= 0 {
+		c.rescan = defaultListCacheRescanInterval
+	}
+	return c
+}
+
+func listingCacheKey(input *s3.ListObjectsV2Input) string {
+	return strings.Join([]string{aws.ToString(input.Bucket), aws.ToString(input.Prefix), aws.ToString(input.Delimiter)}, "\x00")
+}
+
+// list sends cached objects after StartAfter of the input, and lists objects after the last cached key if needed.
+func (c *listingCache) list(ctx context.Context, conn *s3SelectConn, input *s3.ListObjectsV2Input, doneCh <-chan struct{}, fn func(contentInfo) bool) (bool, error) {
+	key := listingCacheKey(input)
+	now := c.now()
+	cached, _ := c.listings.get(key)
+	if cached != nil && now.Sub(cached.scannedAt) >= c.rescan {
+		c.listings.remove(key)
+		cached = nil
+	}
+	startAfter := aws.ToString(input.StartAfter)
+	var lastKey string
+	scannedAt := now
+	if cached != nil {
+		conn.debugf("list cache s3://%s/%s: %d objects", aws.ToString(input.Bucket), aws.ToString(input.Prefix), len(cached.contents))
+		for _, content := range cached.contents {
+			content.ListingKey = key
+			if content.ObjectKey > startAfter && !fn(content) {
+				return false, nil
+			}
+		}
+		if cached.complete && now.Sub(cached.listedAt) < c.ttl {
+			return true, nil
+		}
+		if n := len(cached.contents); n > 0 {
+			lastKey = cached.contents[n-1].ObjectKey
+		}
+		scannedAt = cached.scannedAt
+	}
+	// the listing is cached only if it continues the cached listing
+	contiguous := startAfter <= lastKey
+	var added []contentInfo
+	refresh := *input
+	if contiguous && lastKey != "" {
+		refresh.StartAfter = aws.String(lastKey)
+	}
+	ok, err := conn.listUncached(ctx, &refresh, doneCh, func(content contentInfo) bool {
+		if contiguous {
+			added = append(added, content)
+		}
+		return fn(content)
+	})
+	if err != nil || !contiguous || ctx.Err() != nil {
+		return ok, err
+	}
+	l := &listing{
+		complete:  ok,
+		listedAt:  now,
+		scannedAt: scannedAt,
+	}
+	if cached != nil {
+		l.contents = append(cached.contents[:len(cached.contents):len(cached.contents)], added...)
+	} else {
+		l.contents = added
+	}
+	c.listings.add(key, l)
+	return ok, nil
+}
+
+// invalidate removes the listing, objects of the prefix are listed again from the first key.
+func (c *listingCache) invalidate(key string) {
+	c.listings.remove(key)
+}
+
+// deletedAfterListing reports whether the object of the cached listing is not found, then the listing is invalidated and the object is skipped.
+func (conn *s3SelectConn) deletedAfterListing(content contentInfo, err error) bool {
+	if content.ListingKey == "" || conn.listCache == nil || !isNoSuchKey(err) {
+		return false
+	}
+	conn.listCache.invalidate(content.ListingKey)
+	conn.debugf("skip object deleted after listing: s3://%s/%s", content.BucketName, content.ObjectKey)
+	return true
+}
+
+func isNoSuchKey(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "NoSuchKey", "NotFound":
+		return true
+	}
+	return false
+}
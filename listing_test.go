@@ -0,0 +1,302 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeListing lists keys as ListObjectsV2 does, pageSize keys and common prefixes per page.
+type fakeListing struct {
+	mu       sync.Mutex
+	keys     []string
+	pageSize int
+	requests []string
+}
+
+func (f *fakeListing) client() *mockS3SelectClient {
+	return &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			prefix, delimiter := aws.ToString(params.Prefix), aws.ToString(params.Delimiter)
+			if params.ContinuationToken == nil {
+				f.requests = append(f.requests, prefix+" "+aws.ToString(params.StartAfter))
+			}
+			keys := append([]string{}, f.keys...)
+			sort.Strings(keys)
+			var start int
+			if params.ContinuationToken != nil {
+				start, _ = strconv.Atoi(*params.ContinuationToken)
+			}
+			output := &s3.ListObjectsV2Output{Name: params.Bucket}
+			var n int
+			for i := start; i < len(keys); i++ {
+				key := keys[i]
+				if !strings.HasPrefix(key, prefix) || key <= aws.ToString(params.StartAfter) {
+					continue
+				}
+				if n == f.pageSize {
+					output.IsTruncated = true
+					output.NextContinuationToken = aws.String(strconv.Itoa(i))
+					break
+				}
+				if delimiter != "" {
+					if j := strings.Index(key[len(prefix):], delimiter); j >= 0 {
+						cp := key[:len(prefix)+j+len(delimiter)]
+						output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(cp)})
+						n++
+						for i+1 < len(keys) && strings.HasPrefix(keys[i+1], cp) {
+							i++
+						}
+						continue
+					}
+				}
+				output.Contents = append(output.Contents, types.Object{Key: aws.String(key), ETag: aws.String(`"` + key + `"`)})
+				n++
+			}
+			return output, nil
+		},
+	}
+}
+
+func listKeys(t *testing.T, conn *s3SelectConn, input *s3.ListObjectsV2Input, limit int) []string {
+	t.Helper()
+	var keys []string
+	ok, err := conn.listPrefix(context.Background(), input, nil, func(content contentInfo) bool {
+		keys = append(keys, content.ObjectKey)
+		return limit == 0 || len(keys) < limit
+	})
+	require.NoError(t, err)
+	require.Equal(t, limit == 0, ok)
+	return keys
+}
+
+func TestListParallel(t *testing.T) {
+	f := &fakeListing{
+		pageSize: 2,
+		keys: []string{
+			"logs/2024/01/01/a.json", "logs/2024/01/02/b.json", "logs/2024/02/01/c.json",
+			"logs/2024.json", "logs/2025/01/01/d.json", "logs/a.json", "logs/b/e.json", "logs/z.json",
+		},
+	}
+	sorted := append([]string{}, f.keys...)
+	sort.Strings(sorted)
+	conn := newConn(f.client(), &S3SelectConfig{ListConcurrency: 2})
+	input := &s3.ListObjectsV2Input{Bucket: aws.String("example-com"), Prefix: aws.String("logs/")}
+	require.Equal(t, sorted, listKeys(t, conn, input, 0))
+	require.ElementsMatch(t, []string{"logs/ ", "logs/2024/ ", "logs/2025/ ", "logs/b/ "}, f.requests, "common prefixes are listed recursively")
+
+	f.requests = nil
+	input.StartAfter = aws.String("logs/2024/01/02/b.json")
+	require.Equal(t, sorted[3:], listKeys(t, conn, input, 0))
+	require.ElementsMatch(t, []string{"logs/ ", "logs/2024/ logs/2024/01/02/b.json", "logs/2025/ ", "logs/b/ "}, f.requests)
+
+	input.StartAfter = nil
+	require.Equal(t, sorted[:3], listKeys(t, conn, input, 3))
+}
+
+func TestListParallel__Shards(t *testing.T) {
+	f := &fakeListing{
+		pageSize: 1,
+		keys:     []string{"data/0f.json", "data/3a.json", "data/3b.json", "data/f0.json", "data/sub/x.json"},
+	}
+	cfg, err := ParseDSN("s3://example-com/data/?format=json&list_concurrency=4&list_shards=hex")
+	require.NoError(t, err)
+	conn := newConn(f.client(), cfg)
+	input := conn.newListObjectsV2Input("example-com", "data/")
+	require.Equal(t, []string{"data/0f.json", "data/3a.json", "data/3b.json", "data/f0.json"}, listKeys(t, conn, input, 0))
+	require.Len(t, f.requests, 16)
+	require.Contains(t, f.requests, "data/0 ")
+	require.Contains(t, f.requests, "data/f ")
+}
+
+func TestListingCache(t *testing.T) {
+	f := &fakeListing{
+		pageSize: 2,
+		keys:     []string{"data/1.json", "data/2.json", "data/3.json"},
+	}
+	cfg, err := ParseDSN("s3://example-com/data/?format=json&list_cache_ttl=1m&list_cache_rescan=5m")
+	require.NoError(t, err)
+	conn := newConn(f.client(), cfg)
+	conn.listCache = newListingCache(cfg)
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	conn.listCache.now = func() time.Time { return now }
+	input := conn.newListObjectsV2Input("example-com", "data/")
+
+	require.Equal(t, []string{"data/1.json"}, listKeys(t, conn, input, 1))
+	require.Equal(t, []string{"data/ "}, f.requests)
+	f.requests = nil
+	require.Equal(t, []string{"data/1.json", "data/2.json", "data/3.json"}, listKeys(t, conn, input, 0))
+	require.Equal(t, []string{"data/ data/1.json"}, f.requests, "the partial listing is continued")
+
+	f.requests = nil
+	f.keys = append(f.keys, "data/4.json")
+	require.Equal(t, []string{"data/1.json", "data/2.json", "data/3.json"}, listKeys(t, conn, input, 0))
+	require.Empty(t, f.requests, "the listing is cached")
+
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, []string{"data/1.json", "data/2.json", "data/3.json", "data/4.json"}, listKeys(t, conn, input, 0))
+	require.Equal(t, []string{"data/ data/3.json"}, f.requests, "keys after the last key are listed after list_cache_ttl")
+
+	resume := *input
+	resume.StartAfter = aws.String("data/2.json")
+	f.requests = nil
+	require.Equal(t, []string{"data/3.json", "data/4.json"}, listKeys(t, conn, &resume, 0))
+	require.Empty(t, f.requests)
+
+	f.requests = nil
+	f.keys = []string{"data/1.json", "data/4.json"}
+	now = now.Add(5 * time.Minute)
+	require.Equal(t, []string{"data/1.json", "data/4.json"}, listKeys(t, conn, input, 0))
+	require.Equal(t, []string{"data/ "}, f.requests, "the whole prefix is listed after list_cache_rescan")
+
+	for i := 0; i <= listingCacheSize; i++ {
+		listKeys(t, conn, conn.newListObjectsV2Input("example-com", "data/"+strconv.Itoa(i)), 0)
+	}
+	require.Equal(t, listingCacheSize, conn.listCache.listings.len(), "the least recently used listing is evicted")
+}
+
+func TestMock__ListingCache(t *testing.T) {
+	f := &fakeListing{
+		pageSize: 1000,
+		keys:     []string{"data/1.json", "data/2.json"},
+	}
+	client := f.client()
+	client.SelectObjectContentWithWriterFunc = func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+		_, err := io.WriteString(w, `{"key":"`+*params.Key+`"}`+"\n")
+		return err
+	}
+	mockClients["listing_cache"] = client
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json_lines&list_cache_ttl=1h&mock=listing_cache")
+	require.NoError(t, err)
+	defer db.Close()
+	// connections of the pool share the listing cache
+	db.SetMaxIdleConns(0)
+
+	for i := 0; i < 2; i++ {
+		rows, err := db.QueryContext(context.Background(), `SELECT s.key FROM S3Object s`)
+		require.NoError(t, err)
+		var keys []string
+		for rows.Next() {
+			var key string
+			require.NoError(t, rows.Scan(&key))
+			keys = append(keys, key)
+		}
+		require.NoError(t, rows.Close())
+		require.Equal(t, []string{"data/1.json", "data/2.json"}, keys)
+	}
+	require.Equal(t, []string{"data/ "}, f.requests)
+}
+
+func TestMock__ListingCache__Stale(t *testing.T) {
+	f := &fakeListing{
+		pageSize: 1000,
+		keys:     []string{"data/1.json", "data/2.json"},
+	}
+	values := map[string]string{"data/1.json": "a", "data/2.json": "b"}
+	etags := map[string]string{"data/1.json": `"data/1.json"`, "data/2.json": `"data/2.json"`}
+	var selected []string
+	client := f.client()
+	client.HeadObjectFunc = func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+		etag, ok := etags[*params.Key]
+		if !ok {
+			return nil, &types.NotFound{}
+		}
+		return &s3.HeadObjectOutput{ETag: aws.String(etag)}, nil
+	}
+	client.SelectObjectContentWithWriterFunc = func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+		selected = append(selected, *params.Key)
+		value, ok := values[*params.Key]
+		if !ok {
+			return &types.NoSuchKey{}
+		}
+		_, err := io.WriteString(w, `{"value":"`+value+`"}`+"\n")
+		return err
+	}
+	mockClients["listing_cache_stale"] = client
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json_lines&list_cache_ttl=1h&cache=memory&mock=listing_cache_stale")
+	require.NoError(t, err)
+	defer db.Close()
+	query := func() []string {
+		t.Helper()
+		selected = nil
+		rows, err := db.QueryContext(context.Background(), `SELECT s."value" FROM S3Object s`)
+		require.NoError(t, err)
+		var values []string
+		for rows.Next() {
+			var value string
+			require.NoError(t, rows.Scan(&value))
+			values = append(values, value)
+		}
+		require.NoError(t, rows.Close())
+		return values
+	}
+
+	require.Equal(t, []string{"a", "b"}, query())
+	require.Equal(t, []string{"a", "b"}, query())
+	require.Empty(t, selected, "ETags of cached contents are the same")
+	require.Equal(t, []string{"data/ "}, f.requests)
+
+	// deleted after listing
+	f.keys = []string{"data/1.json"}
+	delete(values, "data/2.json")
+	delete(etags, "data/2.json")
+	require.Equal(t, []string{"a"}, query(), "the deleted object is skipped")
+	require.Equal(t, []string{"a"}, query())
+	require.Equal(t, []string{"data/ ", "data/ "}, f.requests, "the listing is invalidated")
+
+	// modified after listing
+	values["data/1.json"] = "c"
+	etags["data/1.json"] = `"modified"`
+	require.Equal(t, []string{"c"}, query(), "the stale result is not used")
+	require.Equal(t, []string{"data/1.json"}, selected)
+	require.Equal(t, []string{"data/ ", "data/ "}, f.requests)
+	query()
+	require.Equal(t, []string{"data/ ", "data/ ", "data/ "}, f.requests, "the listing is invalidated")
+}
+
+func TestS3SelectConfig__ListParams(t *testing.T) {
+	cases := []struct {
+		dsn      string
+		expected string
+	}{
+		{
+			dsn:      "s3://example-com/data/?format=csv&list_concurrency=0",
+			expected: "dsn is invalid: set query params: list_concurrency must be positive",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&list_shards=hex",
+			expected: "dsn is invalid: set query params: list_shards requires list_concurrency",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&list_concurrency=4&list_shards=2023,2024,2024-01",
+			expected: "dsn is invalid: set query params: list_shards 2024 is a prefix of 2024-01",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&list_cache_rescan=1h",
+			expected: "dsn is invalid: set query params: list_cache_rescan requires list_cache_ttl",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.dsn, func(t *testing.T) {
+			_, err := ParseDSN(c.dsn)
+			require.EqualError(t, err, c.expected)
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package s3selectsqldriver
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LiteralEncoder converts a query parameter into an S3 Select SQL literal.
+// The returned string is embedded in the query as is, so it must be properly quoted and escaped.
+type LiteralEncoder func(v interface{}) (string, error)
+
+var (
+	literalEncodersMu sync.RWMutex
+	literalEncoders   = map[reflect.Type]LiteralEncoder{}
+)
+
+// RegisterLiteralEncoder registers a LiteralEncoder for the dynamic type of sample.
+// Registered encoders take precedence over the built-in conversion rules.
+// If enc is nil, the encoder registered for the type is removed.
+func RegisterLiteralEncoder(sample interface{}, enc LiteralEncoder) error {
+	if sample == nil {
+		return errors.New("sample is nil")
+	}
+	t := reflect.TypeOf(sample)
+	literalEncodersMu.Lock()
+	defer literalEncodersMu.Unlock()
+	if enc == nil {
+		delete(literalEncoders, t)
+		return nil
+	}
+	literalEncoders[t] = enc
+	return nil
+}
+
+func lookupLiteralEncoder(v interface{}) (LiteralEncoder, bool) {
+	if v == nil {
+		return nil, false
+	}
+	literalEncodersMu.RLock()
+	defer literalEncodersMu.RUnlock()
+	enc, ok := literalEncoders[reflect.TypeOf(v)]
+	return enc, ok
+}
+
+// checkLiteralValue resolves driver.Valuer and reports whether the value can be encoded as a literal.
+func checkLiteralValue(nv *driver.NamedValue) error {
+	if _, ok := lookupLiteralEncoder(nv.Value); ok {
+		return nil
+	}
+	if vr, ok := nv.Value.(driver.Valuer); ok {
+		v, err := callValuer(vr)
+		if err != nil {
+			return err
+		}
+		nv.Value = v
+	}
+	if _, err := encodeLiteral(nv.Value); err != nil {
+		return err
+	}
+	return nil
+}
+
+func callValuer(vr driver.Valuer) (v driver.Value, err error) {
+	// same as database/sql: a nil pointer receiver with a value method is treated as NULL
+	if rv := reflect.ValueOf(vr); rv.Kind() == reflect.Pointer && rv.IsNil() &&
+		rv.Type().Elem().Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem()) {
+		return nil, nil
+	}
+	return vr.Value()
+}
+
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, "'", "''") + `'`
+}
+
+func formatFloat(f float64, bitSize int) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("unsupported float value: %v", f)
+	}
+	return strconv.FormatFloat(f, 'f', -1, bitSize), nil
+}
+
+func encodeLiteral(v interface{}) (string, error) {
+	if enc, ok := lookupLiteralEncoder(v); ok {
+		return enc(v)
+	}
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quoteString(v), nil
+	case []byte:
+		return quoteString(string(v)), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return formatFloat(float64(v), 32)
+	case float64:
+		return formatFloat(v, 64)
+	case time.Time:
+		return `CAST('` + v.Format(time.RFC3339Nano) + `' AS TIMESTAMP)`, nil
+	case time.Duration:
+		return strconv.FormatInt(int64(v), 10), nil
+	case driver.Valuer:
+		dv, err := callValuer(v)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := dv.(driver.Valuer); ok {
+			return "", fmt.Errorf("unsupported parameter type: %T", v)
+		}
+		return encodeLiteral(dv)
+	case fmt.Stringer:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return "NULL", nil
+			}
+			// a pointer to a value with its own String (e.g. *time.Time) is rendered as the value
+			if _, ok := rv.Elem().Interface().(fmt.Stringer); ok {
+				return encodeLiteral(rv.Elem().Interface())
+			}
+		}
+		// decimal-like types (e.g. *big.Float, *big.Rat) are rendered as numeric literals
+		s := v.String()
+		if _, err := strconv.ParseFloat(s, 64); err == nil && isNumericLiteral(s) {
+			return s, nil
+		}
+		return quoteString(s), nil
+	}
+	return encodeReflectLiteral(reflect.ValueOf(v))
+}
+
+func encodeReflectLiteral(rv reflect.Value) (string, error) {
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return "NULL", nil
+		}
+		return encodeLiteral(rv.Elem().Interface())
+	case reflect.String:
+		return quoteString(rv.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32:
+		return formatFloat(rv.Float(), 32)
+	case reflect.Float64:
+		return formatFloat(rv.Float(), 64)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return quoteString(string(rv.Bytes())), nil
+		}
+		elems, err := encodeListLiteral(rv)
+		if err != nil {
+			return "", err
+		}
+		return "(" + strings.Join(elems, ", ") + ")", nil
+	}
+	return "", fmt.Errorf("unsupported parameter type: %s", rv.Type())
+}
+
+// listLiteralElements returns the encoded elements if v is expanded into a literal list, such as IN (...).
+func listLiteralElements(v interface{}) ([]string, bool, error) {
+	if v == nil {
+		return nil, false, nil
+	}
+	if _, ok := lookupLiteralEncoder(v); ok {
+		return nil, false, nil
+	}
+	if _, ok := v.(driver.Valuer); ok {
+		return nil, false, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return nil, false, nil
+		}
+	case reflect.Array:
+	default:
+		return nil, false, nil
+	}
+	elems, err := encodeListLiteral(rv)
+	if err != nil {
+		return nil, true, err
+	}
+	return elems, true, nil
+}
+
+func encodeListLiteral(rv reflect.Value) ([]string, error) {
+	if rv.Len() == 0 {
+		return nil, errors.New("empty slice cannot be expanded into a list")
+	}
+	elems := make([]string, 0, rv.Len())
+	var kind, firstType string
+	for i := 0; i < rv.Len(); i++ {
+		ev := rv.Index(i).Interface()
+		if vr, ok := ev.(driver.Valuer); ok {
+			if _, ok := lookupLiteralEncoder(ev); !ok {
+				v, err := callValuer(vr)
+				if err != nil {
+					return nil, fmt.Errorf("slice element %d: %w", i, err)
+				}
+				ev = v
+			}
+		}
+		k, err := literalKind(ev)
+		if err != nil {
+			return nil, fmt.Errorf("slice element %d: %w", i, err)
+		}
+		if k != "" {
+			if kind == "" {
+				kind = k
+				firstType = fmt.Sprintf("%T", ev)
+			} else if kind != k {
+				return nil, fmt.Errorf("slice element %d: mixed element types %s and %T", i, firstType, ev)
+			}
+		}
+		s, err := encodeLiteral(ev)
+		if err != nil {
+			return nil, fmt.Errorf("slice element %d: %w", i, err)
+		}
+		elems = append(elems, s)
+	}
+	return elems, nil
+}
+
+// literalKind classifies a list element so that a list does not mix incomparable literals.
+// NULL is compatible with every kind and reported as the empty string.
+func literalKind(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if _, ok := lookupLiteralEncoder(v); ok {
+		return fmt.Sprintf("%T", v), nil
+	}
+	switch v.(type) {
+	case time.Time:
+		return "timestamp", nil
+	case time.Duration:
+		return "number", nil
+	case driver.Valuer:
+		return "", fmt.Errorf("unsupported parameter type: %T", v)
+	case fmt.Stringer:
+		if s, _ := encodeLiteral(v); isNumericLiteral(s) {
+			return "number", nil
+		}
+		return "string", nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return "", nil
+		}
+		return literalKind(rv.Elem().Interface())
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "bool", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number", nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return "string", nil
+		}
+		return "", errors.New("nested slice is not supported")
+	case reflect.Array:
+		return "", errors.New("nested slice is not supported")
+	}
+	return "", fmt.Errorf("unsupported parameter type: %T", v)
+}
+
+// isNumericLiteral reports whether s is written in a plain decimal notation that S3 Select accepts as a number.
+func isNumericLiteral(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '.':
+		case (r == '-' || r == '+') && i == 0:
+		case r == 'e' || r == 'E':
+		case (r == '-' || r == '+') && (s[i-1] == 'e' || s[i-1] == 'E'):
+		default:
+			return false
+		}
+	}
+	return true
+}
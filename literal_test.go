@@ -0,0 +1,137 @@
+package s3selectsqldriver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testStatus string
+
+type testPoint struct {
+	X, Y int
+}
+
+func TestEncodeLiteral(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{name: "nil", value: nil, expected: "NULL"},
+		{name: "string", value: "it's", expected: "'it''s'"},
+		{name: "bytes", value: []byte("hoge"), expected: "'hoge'"},
+		{name: "bool", value: true, expected: "true"},
+		{name: "int", value: int(-1), expected: "-1"},
+		{name: "int8", value: int8(8), expected: "8"},
+		{name: "int16", value: int16(16), expected: "16"},
+		{name: "int32", value: int32(32), expected: "32"},
+		{name: "int64", value: int64(64), expected: "64"},
+		{name: "uint", value: uint(1), expected: "1"},
+		{name: "uint8", value: uint8(8), expected: "8"},
+		{name: "uint16", value: uint16(16), expected: "16"},
+		{name: "uint32", value: uint32(32), expected: "32"},
+		{name: "uint64", value: uint64(18446744073709551615), expected: "18446744073709551615"},
+		{name: "float32", value: float32(1.5), expected: "1.5"},
+		{name: "float64", value: float64(0.1), expected: "0.1"},
+		{
+			name:     "time",
+			value:    time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC),
+			expected: "CAST('2020-01-02T03:04:05.123456789Z' AS TIMESTAMP)",
+		},
+		{
+			name:     "time pointer",
+			value:    func() *time.Time { t := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC); return &t }(),
+			expected: "CAST('2020-01-02T03:04:05Z' AS TIMESTAMP)",
+		},
+		{name: "nil time pointer", value: (*time.Time)(nil), expected: "NULL"},
+		{name: "nil decimal stringer", value: (*big.Float)(nil), expected: "NULL"},
+		{name: "duration", value: 1500 * time.Millisecond, expected: "1500000000"},
+		{name: "valuer", value: sql.NullString{String: "hoge", Valid: true}, expected: "'hoge'"},
+		{name: "null valuer", value: sql.NullInt64{}, expected: "NULL"},
+		{name: "decimal stringer", value: big.NewFloat(1.25), expected: "1.25"},
+		{name: "big int", value: big.NewInt(123), expected: "123"},
+		{name: "named string", value: testStatus("ok"), expected: "'ok'"},
+		{name: "nil pointer", value: (*int)(nil), expected: "NULL"},
+		{name: "slice", value: []string{"a", "b'c"}, expected: "('a', 'b''c')"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := encodeLiteral(c.value)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestEncodeLiteral__Unsupported(t *testing.T) {
+	_, err := encodeLiteral(testPoint{X: 1, Y: 2})
+	require.EqualError(t, err, "unsupported parameter type: s3selectsqldriver.testPoint")
+	_, err = encodeLiteral(map[string]int{})
+	require.Error(t, err)
+}
+
+func TestRegisterLiteralEncoder(t *testing.T) {
+	require.NoError(t, RegisterLiteralEncoder(testPoint{}, func(v interface{}) (string, error) {
+		p := v.(testPoint)
+		return encodeLiteral([]int{p.X, p.Y})
+	}))
+	defer func() {
+		require.NoError(t, RegisterLiteralEncoder(testPoint{}, nil))
+	}()
+	actual, err := encodeLiteral(testPoint{X: 1, Y: 2})
+	require.NoError(t, err)
+	require.Equal(t, "(1, 2)", actual)
+
+	nv := &driver.NamedValue{Ordinal: 1, Value: testPoint{X: 1, Y: 2}}
+	require.NoError(t, checkLiteralValue(nv))
+	require.Equal(t, testPoint{X: 1, Y: 2}, nv.Value)
+}
+
+func TestCheckLiteralValue__Valuer(t *testing.T) {
+	nv := &driver.NamedValue{Ordinal: 1, Value: sql.NullTime{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true}}
+	require.NoError(t, checkLiteralValue(nv))
+	require.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), nv.Value)
+}
+
+func TestCheckLiteralValue__NilPointer(t *testing.T) {
+	nv := &driver.NamedValue{Ordinal: 1, Value: (*time.Time)(nil)}
+	require.NoError(t, checkLiteralValue(nv))
+}
+
+func TestListLiteralElements(t *testing.T) {
+	cases := []struct {
+		name     string
+		value    interface{}
+		expected []string
+		isList   bool
+		err      string
+	}{
+		{name: "scalar", value: "a", isList: false},
+		{name: "bytes", value: []byte("a"), isList: false},
+		{name: "strings", value: []string{"a", "b", "c"}, expected: []string{"'a'", "'b'", "'c'"}, isList: true},
+		{name: "ints", value: []int{1, 2}, expected: []string{"1", "2"}, isList: true},
+		{name: "array", value: [2]int64{1, 2}, expected: []string{"1", "2"}, isList: true},
+		{name: "with null", value: []interface{}{"a", nil}, expected: []string{"'a'", "NULL"}, isList: true},
+		{name: "numbers", value: []interface{}{1, 2.5, big.NewInt(3)}, expected: []string{"1", "2.5", "3"}, isList: true},
+		{name: "empty", value: []string{}, isList: true, err: "empty slice cannot be expanded into a list"},
+		{name: "mixed", value: []interface{}{"a", 1}, isList: true, err: "slice element 1: mixed element types string and int"},
+		{name: "nested", value: [][]string{{"a"}}, isList: true, err: "slice element 0: nested slice is not supported"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, isList, err := listLiteralElements(c.value)
+			require.Equal(t, c.isList, isList)
+			if c.err != "" {
+				require.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,1321 @@
+package s3selectsqldriver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+// localQuery is a SELECT evaluated by the driver for virtual tables, such as information_schema.objects.
+// it supports WHERE, GROUP BY, HAVING, ORDER BY, LIMIT and OFFSET, and aggregates COUNT, SUM, MIN, MAX and AVG.
+type localQuery struct {
+	table      string
+	columns    []string
+	items      []localSelectItem
+	where      localExpr
+	groupBy    []localExpr
+	having     localExpr
+	orderBy    []localOrderItem
+	limit      int
+	offset     int
+	aggregates []*localAggregate
+}
+
+type localSelectItem struct {
+	name string
+	expr localExpr
+}
+
+type localOrderItem struct {
+	// output is the index of the select item for ORDER BY of aliases and positions, -1 for expressions.
+	output int
+	expr   localExpr
+	desc   bool
+}
+
+// localRow is a row of the virtual table, or the first row of the group with values of aggregates.
+type localRow struct {
+	values     []interface{}
+	aggregates []interface{}
+}
+
+// localExpr evaluates the expression for the row. NULL is nil.
+type localExpr func(row *localRow) (interface{}, error)
+
+type localAggregate struct {
+	fn  string
+	arg localExpr
+}
+
+// parseLocalQuery parses the query. columnsOf returns columns of the table in FROM.
+func parseLocalQuery(query string, columnsOf func(table string) ([]string, error)) (*localQuery, error) {
+	base, err := newTokenParser(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &localParser{tokenParser: base, q: &localQuery{limit: -1}}
+	if err := p.parse(columnsOf); err != nil {
+		return nil, err
+	}
+	return p.q, nil
+}
+
+type localParser struct {
+	tokenParser
+	q *localQuery
+	// grouped is false while parsing WHERE and GROUP BY, aggregates are not allowed in them.
+	grouped bool
+}
+
+var localReservedWords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "BY": true,
+	"LIMIT": true, "OFFSET": true, "AS": true, "AND": true, "OR": true, "NOT": true,
+}
+
+func (p *localParser) parse(columnsOf func(table string) ([]string, error)) error {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return err
+	}
+	// columns of the table are required to resolve identifiers in the select list
+	selectStart := p.pos
+	var depth int
+	for ; p.pos < len(p.tokens); p.pos++ {
+		token := p.tokens[p.pos]
+		if token.Kind == lexer.KindSymbol {
+			switch token.Value {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+		}
+		if depth == 0 && p.isKeyword("FROM") {
+			break
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return err
+	}
+	table := p.next()
+	if table.Kind != lexer.KindIdentifier {
+		return errors.New("table name is required after FROM")
+	}
+	p.q.table = strings.ToLower(table.Value)
+	columns, err := columnsOf(p.q.table)
+	if err != nil {
+		return err
+	}
+	p.q.columns = columns
+	var alias string
+	if p.acceptKeyword("AS") || (p.peek().Kind == lexer.KindIdentifier && !localReservedWords[strings.ToUpper(p.peek().Value)]) {
+		token := p.next()
+		if token.Kind != lexer.KindIdentifier {
+			return errors.New("alias is required after AS")
+		}
+		alias = strings.ToLower(token.Value)
+	}
+	clauseStart := p.pos
+	p.pos = selectStart
+	p.grouped = true
+	if err := p.parseSelectList(alias); err != nil {
+		return err
+	}
+	p.pos = clauseStart
+	if p.acceptKeyword("WHERE") {
+		p.grouped = false
+		if p.q.where, err = p.parseExpr(alias); err != nil {
+			return err
+		}
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return err
+		}
+		p.grouped = false
+		for {
+			expr, err := p.parseExpr(alias)
+			if err != nil {
+				return err
+			}
+			p.q.groupBy = append(p.q.groupBy, expr)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	p.grouped = true
+	if p.acceptKeyword("HAVING") {
+		if p.q.having, err = p.parseExpr(alias); err != nil {
+			return err
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return err
+		}
+		for {
+			item, err := p.parseOrderItem(alias)
+			if err != nil {
+				return err
+			}
+			p.q.orderBy = append(p.q.orderBy, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if p.q.limit, err = p.parseCount("LIMIT"); err != nil {
+			return err
+		}
+	}
+	if p.acceptKeyword("OFFSET") {
+		if p.q.offset, err = p.parseCount("OFFSET"); err != nil {
+			return err
+		}
+	}
+	p.acceptSymbol(";")
+	if p.peek().Kind != lexer.KindEOF {
+		return p.unexpected("end of query")
+	}
+	if p.q.having != nil && len(p.q.groupBy) == 0 && len(p.q.aggregates) == 0 {
+		return errors.New("HAVING requires GROUP BY or aggregates")
+	}
+	return nil
+}
+
+func (p *localParser) parseCount(clause string) (int, error) {
+	token := p.next()
+	if token.Kind != lexer.KindNumber {
+		return 0, fmt.Errorf("%s requires a number", clause)
+	}
+	n, err := strconv.Atoi(token.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%s requires an integer: %w", clause, err)
+	}
+	return n, nil
+}
+
+func (p *localParser) parseSelectList(alias string) error {
+	for {
+		if p.acceptSymbol("*") {
+			for i, column := range p.q.columns {
+				p.q.items = append(p.q.items, localSelectItem{name: column, expr: columnExpr(i)})
+			}
+		} else {
+			start := p.pos
+			expr, err := p.parseExpr(alias)
+			if err != nil {
+				return err
+			}
+			name := lexer.Tokens(p.tokens[start:p.pos]).String()
+			if i, ok := p.columnIndex(name, alias); ok {
+				name = p.q.columns[i]
+			}
+			if p.acceptKeyword("AS") || (p.peek().Kind == lexer.KindIdentifier && !localReservedWords[strings.ToUpper(p.peek().Value)]) {
+				token := p.next()
+				if token.Kind != lexer.KindIdentifier && token.Kind != lexer.KindString {
+					return errors.New("alias is required after AS")
+				}
+				name = unquoteIdentifier(token.Value)
+			}
+			p.q.items = append(p.q.items, localSelectItem{name: name, expr: expr})
+		}
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if !p.isKeyword("FROM") {
+		return p.unexpected("FROM")
+	}
+	return nil
+}
+
+func (p *localParser) parseOrderItem(alias string) (localOrderItem, error) {
+	item := localOrderItem{output: -1}
+	token := p.peek()
+	switch token.Kind {
+	case lexer.KindNumber:
+		// ORDER BY 2 is the position in the select list
+		n, err := strconv.Atoi(token.Value)
+		if err != nil || n < 1 || n > len(p.q.items) {
+			return item, fmt.Errorf("ORDER BY position %s is out of the select list", token.Value)
+		}
+		p.pos++
+		item.output = n - 1
+	case lexer.KindIdentifier, lexer.KindString:
+		// aliases of the select list are preferred to columns
+		for i, selectItem := range p.q.items {
+			if strings.EqualFold(selectItem.name, unquoteIdentifier(token.Value)) && !strings.HasPrefix(token.Value, "'") {
+				p.pos++
+				item.output = i
+				break
+			}
+		}
+	}
+	if item.output < 0 {
+		expr, err := p.parseExpr(alias)
+		if err != nil {
+			return item, err
+		}
+		item.expr = expr
+	}
+	if p.acceptKeyword("DESC") {
+		item.desc = true
+	} else {
+		p.acceptKeyword("ASC")
+	}
+	return item, nil
+}
+
+// columnIndex resolves the identifier, `key`, `o.key` with the alias or `information_schema.objects.key`.
+func (p *localParser) columnIndex(identifier string, alias string) (int, bool) {
+	name := strings.ToLower(unquoteIdentifier(identifier))
+	for _, prefix := range []string{alias, p.q.table} {
+		if prefix != "" && strings.HasPrefix(name, prefix+".") {
+			name = strings.TrimPrefix(name, prefix+".")
+			break
+		}
+	}
+	for i, column := range p.q.columns {
+		if column == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func unquoteIdentifier(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '`') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func columnExpr(i int) localExpr {
+	return func(row *localRow) (interface{}, error) {
+		return row.values[i], nil
+	}
+}
+
+func literalExpr(v interface{}) localExpr {
+	return func(row *localRow) (interface{}, error) {
+		return v, nil
+	}
+}
+
+func (p *localParser) parseExpr(alias string) (localExpr, error) {
+	left, err := p.parseAnd(alias)
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd(alias)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row *localRow) (interface{}, error) {
+			a, err := evalBool(l, row)
+			if err != nil {
+				return nil, err
+			}
+			if a != nil && *a {
+				return true, nil
+			}
+			b, err := evalBool(right, row)
+			if err != nil {
+				return nil, err
+			}
+			if b != nil && *b {
+				return true, nil
+			}
+			if a == nil || b == nil {
+				return nil, nil
+			}
+			return false, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *localParser) parseAnd(alias string) (localExpr, error) {
+	left, err := p.parseNot(alias)
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot(alias)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row *localRow) (interface{}, error) {
+			a, err := evalBool(l, row)
+			if err != nil {
+				return nil, err
+			}
+			if a != nil && !*a {
+				return false, nil
+			}
+			b, err := evalBool(right, row)
+			if err != nil {
+				return nil, err
+			}
+			if b != nil && !*b {
+				return false, nil
+			}
+			if a == nil || b == nil {
+				return nil, nil
+			}
+			return true, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *localParser) parseNot(alias string) (localExpr, error) {
+	if p.acceptKeyword("NOT") {
+		expr, err := p.parseNot(alias)
+		if err != nil {
+			return nil, err
+		}
+		return notExpr(expr), nil
+	}
+	return p.parsePredicate(alias)
+}
+
+func notExpr(expr localExpr) localExpr {
+	return func(row *localRow) (interface{}, error) {
+		b, err := evalBool(expr, row)
+		if err != nil || b == nil {
+			return nil, err
+		}
+		return !*b, nil
+	}
+}
+
+func (p *localParser) parsePredicate(alias string) (localExpr, error) {
+	left, err := p.parseAdditive(alias)
+	if err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	if token.Kind == lexer.KindSymbol {
+		switch op := token.Value; op {
+		case "=", "<>", "!=", "<", "<=", ">", ">=":
+			p.pos++
+			right, err := p.parseAdditive(alias)
+			if err != nil {
+				return nil, err
+			}
+			return compareExpr(op, left, right), nil
+		}
+	}
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return func(row *localRow) (interface{}, error) {
+			v, err := left(row)
+			if err != nil {
+				return nil, err
+			}
+			return (v == nil) != not, nil
+		}, nil
+	}
+	not := p.acceptKeyword("NOT")
+	var expr localExpr
+	switch {
+	case p.acceptKeyword("LIKE"):
+		expr, err = p.parseLike(alias, left)
+	case p.acceptKeyword("IN"):
+		expr, err = p.parseIn(alias, left)
+	case p.acceptKeyword("BETWEEN"):
+		expr, err = p.parseBetween(alias, left)
+	default:
+		if not {
+			return nil, p.unexpected("LIKE, IN or BETWEEN")
+		}
+		return left, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if not {
+		return notExpr(expr), nil
+	}
+	return expr, nil
+}
+
+func compareExpr(op string, left, right localExpr) localExpr {
+	return func(row *localRow) (interface{}, error) {
+		a, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		c, err := compareLocalValues(a, b)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "=":
+			return c == 0, nil
+		case "<>", "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+}
+
+func (p *localParser) parseLike(alias string, left localExpr) (localExpr, error) {
+	pattern, err := p.parseAdditive(alias)
+	if err != nil {
+		return nil, err
+	}
+	escape := ""
+	if p.acceptKeyword("ESCAPE") {
+		token := p.next()
+		if token.Kind != lexer.KindString || !strings.HasPrefix(token.Value, "'") {
+			return nil, errors.New("ESCAPE requires a string literal")
+		}
+		escape = unquoteSQLString(token.Value)
+		if len([]rune(escape)) != 1 {
+			return nil, errors.New("ESCAPE requires a single character")
+		}
+	}
+	compiled := make(map[string]*regexp.Regexp)
+	return func(row *localRow) (interface{}, error) {
+		v, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		pv, err := pattern(row)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil || pv == nil {
+			return nil, nil
+		}
+		s, ok1 := v.(string)
+		ps, ok2 := pv.(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("LIKE requires strings")
+		}
+		re, ok := compiled[ps]
+		if !ok {
+			re = likePattern(ps, escape)
+			compiled[ps] = re
+		}
+		return re.MatchString(s), nil
+	}, nil
+}
+
+// likePattern converts the pattern of LIKE, % and _ are wildcards.
+func likePattern(pattern string, escape string) *regexp.Regexp {
+	var builder strings.Builder
+	builder.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case escape != "" && string(r) == escape:
+			escaped = true
+		case r == '%':
+			builder.WriteString(".*")
+		case r == '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.MustCompile(builder.String())
+}
+
+func (p *localParser) parseIn(alias string, left localExpr) (localExpr, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var values []localExpr
+	for {
+		expr, err := p.parseExpr(alias)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, expr)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	return func(row *localRow) (interface{}, error) {
+		v, err := left(row)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		var hasNull bool
+		for _, value := range values {
+			b, err := value(row)
+			if err != nil {
+				return nil, err
+			}
+			if b == nil {
+				hasNull = true
+				continue
+			}
+			c, err := compareLocalValues(v, b)
+			if err != nil {
+				return nil, err
+			}
+			if c == 0 {
+				return true, nil
+			}
+		}
+		if hasNull {
+			return nil, nil
+		}
+		return false, nil
+	}, nil
+}
+
+func (p *localParser) parseBetween(alias string, left localExpr) (localExpr, error) {
+	lower, err := p.parseAdditive(alias)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AND"); err != nil {
+		return nil, err
+	}
+	upper, err := p.parseAdditive(alias)
+	if err != nil {
+		return nil, err
+	}
+	ge, le := compareExpr(">=", left, lower), compareExpr("<=", left, upper)
+	return func(row *localRow) (interface{}, error) {
+		a, err := evalBool(ge, row)
+		if err != nil {
+			return nil, err
+		}
+		b, err := evalBool(le, row)
+		if err != nil {
+			return nil, err
+		}
+		if (a != nil && !*a) || (b != nil && !*b) {
+			return false, nil
+		}
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		return true, nil
+	}, nil
+}
+
+func (p *localParser) parseAdditive(alias string) (localExpr, error) {
+	left, err := p.parseMultiplicative(alias)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.isSymbol("|") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].Value == "|":
+			p.pos += 2
+			op = "||"
+		case p.isSymbol("+"), p.isSymbol("-"):
+			op = p.next().Value
+		default:
+			return left, nil
+		}
+		right, err := p.parseMultiplicative(alias)
+		if err != nil {
+			return nil, err
+		}
+		left = arithmeticExpr(op, left, right)
+	}
+}
+
+func (p *localParser) parseMultiplicative(alias string) (localExpr, error) {
+	left, err := p.parseUnary(alias)
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("*") || p.isSymbol("/") || p.isSymbol("%") {
+		op := p.next().Value
+		right, err := p.parseUnary(alias)
+		if err != nil {
+			return nil, err
+		}
+		left = arithmeticExpr(op, left, right)
+	}
+	return left, nil
+}
+
+func (p *localParser) parseUnary(alias string) (localExpr, error) {
+	if p.acceptSymbol("-") {
+		expr, err := p.parseUnary(alias)
+		if err != nil {
+			return nil, err
+		}
+		return arithmeticExpr("-", literalExpr(int64(0)), expr), nil
+	}
+	return p.parsePrimary(alias)
+}
+
+func arithmeticExpr(op string, left, right localExpr) localExpr {
+	return func(row *localRow) (interface{}, error) {
+		a, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		if op == "||" {
+			return fmt.Sprint(a) + fmt.Sprint(b), nil
+		}
+		ai, aIsInt := a.(int64)
+		bi, bIsInt := b.(int64)
+		if aIsInt && bIsInt && op != "/" {
+			switch op {
+			case "+":
+				return ai + bi, nil
+			case "-":
+				return ai - bi, nil
+			case "*":
+				return ai * bi, nil
+			case "%":
+				if bi == 0 {
+					return nil, errors.New("division by zero")
+				}
+				return ai % bi, nil
+			}
+		}
+		af, ok1 := toFloat(a)
+		bf, ok2 := toFloat(b)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s requires numbers", op)
+		}
+		switch op {
+		case "+":
+			return af + bf, nil
+		case "-":
+			return af - bf, nil
+		case "*":
+			return af * bf, nil
+		case "/":
+			if bf == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return af / bf, nil
+		default:
+			if bf == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return math.Mod(af, bf), nil
+		}
+	}
+}
+
+func (p *localParser) parsePrimary(alias string) (localExpr, error) {
+	token := p.next()
+	switch token.Kind {
+	case lexer.KindNumber:
+		if i, err := strconv.ParseInt(token.Value, 10, 64); err == nil {
+			return literalExpr(i), nil
+		}
+		f, err := strconv.ParseFloat(token.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse number %s: %w", token.Value, err)
+		}
+		return literalExpr(f), nil
+	case lexer.KindString:
+		if strings.HasPrefix(token.Value, "'") {
+			return literalExpr(unquoteSQLString(token.Value)), nil
+		}
+		return p.column(token.Value, alias)
+	case lexer.KindSymbol:
+		if token.Value == "(" {
+			expr, err := p.parseExpr(alias)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	case lexer.KindIdentifier:
+		switch upper := strings.ToUpper(token.Value); upper {
+		case "NULL":
+			return literalExpr(nil), nil
+		case "TRUE":
+			return literalExpr(true), nil
+		case "FALSE":
+			return literalExpr(false), nil
+		}
+		if p.isSymbol("(") {
+			p.pos++
+			return p.parseFunction(strings.ToUpper(token.Value), alias)
+		}
+		return p.column(token.Value, alias)
+	}
+	p.pos--
+	return nil, p.unexpected("expression")
+}
+
+func (p *localParser) column(identifier string, alias string) (localExpr, error) {
+	i, ok := p.columnIndex(identifier, alias)
+	if !ok {
+		return nil, fmt.Errorf("unknown column %s of %s", identifier, p.q.table)
+	}
+	return columnExpr(i), nil
+}
+
+// parseFunction parses arguments of the function after `(`.
+func (p *localParser) parseFunction(name string, alias string) (localExpr, error) {
+	switch name {
+	case "COUNT", "SUM", "MIN", "MAX", "AVG":
+		return p.parseAggregate(name, alias)
+	case "CAST":
+		expr, err := p.parseExpr(alias)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AS"); err != nil {
+			return nil, err
+		}
+		typ := p.next()
+		if typ.Kind != lexer.KindIdentifier {
+			return nil, errors.New("type is required in CAST")
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return castExpr(expr, strings.ToUpper(typ.Value))
+	}
+	var args []localExpr
+	if !p.acceptSymbol(")") {
+		for {
+			expr, err := p.parseExpr(alias)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, expr)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	return functionExpr(name, args)
+}
+
+func (p *localParser) parseAggregate(name string, alias string) (localExpr, error) {
+	if !p.grouped {
+		return nil, fmt.Errorf("aggregate function %s is not allowed here", name)
+	}
+	aggregate := &localAggregate{fn: name}
+	if name == "COUNT" && p.acceptSymbol("*") {
+		aggregate.fn = "COUNT(*)"
+	} else {
+		p.grouped = false
+		arg, err := p.parseExpr(alias)
+		p.grouped = true
+		if err != nil {
+			return nil, err
+		}
+		aggregate.arg = arg
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	i := len(p.q.aggregates)
+	p.q.aggregates = append(p.q.aggregates, aggregate)
+	return func(row *localRow) (interface{}, error) {
+		if row.aggregates == nil {
+			return nil, fmt.Errorf("aggregate function %s is not allowed here", name)
+		}
+		return row.aggregates[i], nil
+	}, nil
+}
+
+func functionExpr(name string, args []localExpr) (localExpr, error) {
+	arity := map[string]int{"LOWER": 1, "UPPER": 1, "CHAR_LENGTH": 1, "LENGTH": 1, "TRIM": 1}
+	if n, ok := arity[name]; ok && len(args) != n {
+		return nil, fmt.Errorf("%s requires %d argument", name, n)
+	}
+	switch name {
+	case "LOWER", "UPPER", "TRIM", "CHAR_LENGTH", "LENGTH":
+		return func(row *localRow) (interface{}, error) {
+			v, err := args[0](row)
+			if err != nil || v == nil {
+				return nil, err
+			}
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s requires a string", name)
+			}
+			switch name {
+			case "LOWER":
+				return strings.ToLower(s), nil
+			case "UPPER":
+				return strings.ToUpper(s), nil
+			case "TRIM":
+				return strings.TrimSpace(s), nil
+			}
+			return int64(len([]rune(s))), nil
+		}, nil
+	case "COALESCE":
+		return func(row *localRow) (interface{}, error) {
+			for _, arg := range args {
+				v, err := arg(row)
+				if err != nil || v != nil {
+					return v, err
+				}
+			}
+			return nil, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("function %s is not supported", name)
+}
+
+func castExpr(expr localExpr, typ string) (localExpr, error) {
+	var convert func(v interface{}) (interface{}, error)
+	switch typ {
+	case "TIMESTAMP":
+		convert = func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case time.Time:
+				return v, nil
+			case string:
+				if t, ok := parseTime(v); ok {
+					return t, nil
+				}
+			}
+			return nil, fmt.Errorf("cannot cast %v as TIMESTAMP", v)
+		}
+	case "INT", "INTEGER", "BIGINT":
+		convert = func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case int64:
+				return v, nil
+			case float64:
+				return int64(v), nil
+			case string:
+				if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+					return i, nil
+				}
+			}
+			return nil, fmt.Errorf("cannot cast %v as %s", v, typ)
+		}
+	case "FLOAT", "DOUBLE", "DECIMAL", "NUMERIC":
+		convert = func(v interface{}) (interface{}, error) {
+			if f, ok := toFloat(v); ok {
+				return f, nil
+			}
+			if s, ok := v.(string); ok {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+					return f, nil
+				}
+			}
+			return nil, fmt.Errorf("cannot cast %v as %s", v, typ)
+		}
+	case "STRING", "VARCHAR", "CHAR":
+		convert = func(v interface{}) (interface{}, error) {
+			if t, ok := v.(time.Time); ok {
+				return t.Format(time.RFC3339Nano), nil
+			}
+			return fmt.Sprint(v), nil
+		}
+	case "BOOL", "BOOLEAN":
+		convert = func(v interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case bool:
+				return v, nil
+			case string:
+				if b, err := strconv.ParseBool(v); err == nil {
+					return b, nil
+				}
+			}
+			return nil, fmt.Errorf("cannot cast %v as %s", v, typ)
+		}
+	default:
+		return nil, fmt.Errorf("CAST as %s is not supported", typ)
+	}
+	return func(row *localRow) (interface{}, error) {
+		v, err := expr(row)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return convert(v)
+	}, nil
+}
+
+func evalBool(expr localExpr, row *localRow) (*bool, error) {
+	v, err := expr(row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("boolean is required, but %v", v)
+	}
+	return &b, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// compareLocalValues compares non-null values. strings are compared with timestamps as timestamps.
+func compareLocalValues(a, b interface{}) (int, error) {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			switch {
+			case af < bf:
+				return -1, nil
+			case af > bf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	switch av := a.(type) {
+	case string:
+		switch bv := b.(type) {
+		case string:
+			return strings.Compare(av, bv), nil
+		case time.Time:
+			if t, ok := parseTime(av); ok {
+				return compareTime(t, bv), nil
+			}
+		}
+	case time.Time:
+		switch bv := b.(type) {
+		case time.Time:
+			return compareTime(av, bv), nil
+		case string:
+			if t, ok := parseTime(bv); ok {
+				return compareTime(av, t), nil
+			}
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0, nil
+			case !av:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v with %v", a, b)
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// names returns names of columns of the result.
+func (q *localQuery) names() []string {
+	names := make([]string, 0, len(q.items))
+	for _, item := range q.items {
+		names = append(names, item.name)
+	}
+	return names
+}
+
+// isGrouped reports whether the result is rows of groups.
+func (q *localQuery) isGrouped() bool {
+	return len(q.groupBy) > 0 || len(q.aggregates) > 0
+}
+
+// run evaluates the query for rows from scan. scan calls fn for each row of the table, and stops if fn returns false.
+func (q *localQuery) run(scan func(fn func(values []interface{}) bool) error) ([][]interface{}, error) {
+	type group struct {
+		row    *localRow
+		states []*aggregateState
+	}
+	var groups []*group
+	groupIndex := make(map[string]*group)
+	var results []*localResult
+	var evalErr error
+	// without ORDER BY and aggregates, scanning stops at LIMIT
+	stopAt := -1
+	if !q.isGrouped() && len(q.orderBy) == 0 && q.limit >= 0 {
+		stopAt = q.offset + q.limit
+	}
+	err := scan(func(values []interface{}) bool {
+		row := &localRow{values: values}
+		if q.where != nil {
+			b, err := evalBool(q.where, row)
+			if err != nil {
+				evalErr = err
+				return false
+			}
+			if b == nil || !*b {
+				return true
+			}
+		}
+		if !q.isGrouped() {
+			result, err := q.project(row)
+			if err != nil {
+				evalErr = err
+				return false
+			}
+			results = append(results, result)
+			return stopAt < 0 || len(results) < stopAt
+		}
+		keys := make([]interface{}, 0, len(q.groupBy))
+		for _, expr := range q.groupBy {
+			v, err := expr(row)
+			if err != nil {
+				evalErr = err
+				return false
+			}
+			keys = append(keys, v)
+		}
+		key := fmt.Sprintf("%#v", keys)
+		g, ok := groupIndex[key]
+		if !ok {
+			g = &group{row: row, states: make([]*aggregateState, len(q.aggregates))}
+			for i := range g.states {
+				g.states[i] = &aggregateState{}
+			}
+			groupIndex[key] = g
+			groups = append(groups, g)
+		}
+		for i, aggregate := range q.aggregates {
+			if err := g.states[i].add(aggregate, row); err != nil {
+				evalErr = err
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if evalErr != nil {
+		return nil, evalErr
+	}
+	if q.isGrouped() {
+		if len(groups) == 0 && len(q.groupBy) == 0 {
+			// aggregates without GROUP BY returns a row for no rows
+			groups = append(groups, &group{
+				row:    &localRow{values: make([]interface{}, len(q.columns))},
+				states: make([]*aggregateState, len(q.aggregates)),
+			})
+			for i := range groups[0].states {
+				groups[0].states[i] = &aggregateState{}
+			}
+		}
+		for _, g := range groups {
+			row := &localRow{values: g.row.values, aggregates: make([]interface{}, len(q.aggregates))}
+			for i, aggregate := range q.aggregates {
+				row.aggregates[i] = g.states[i].result(aggregate)
+			}
+			if q.having != nil {
+				b, err := evalBool(q.having, row)
+				if err != nil {
+					return nil, err
+				}
+				if b == nil || !*b {
+					continue
+				}
+			}
+			result, err := q.project(row)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+	}
+	if len(q.orderBy) > 0 {
+		var sortErr error
+		sort.SliceStable(results, func(i, j int) bool {
+			for k, item := range q.orderBy {
+				a, b := results[i].keys[k], results[j].keys[k]
+				var c int
+				switch {
+				case a == nil && b == nil:
+					continue
+				case a == nil:
+					// NULLs are first in ascending order
+					c = -1
+				case b == nil:
+					c = 1
+				default:
+					var err error
+					c, err = compareLocalValues(a, b)
+					if err != nil && sortErr == nil {
+						sortErr = err
+					}
+				}
+				if c == 0 {
+					continue
+				}
+				if item.desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+		if sortErr != nil {
+			return nil, sortErr
+		}
+	}
+	if q.offset > 0 {
+		if q.offset >= len(results) {
+			results = nil
+		} else {
+			results = results[q.offset:]
+		}
+	}
+	if q.limit >= 0 && len(results) > q.limit {
+		results = results[:q.limit]
+	}
+	rows := make([][]interface{}, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, result.values)
+	}
+	return rows, nil
+}
+
+type localResult struct {
+	values []interface{}
+	// keys are values of ORDER BY.
+	keys []interface{}
+}
+
+func (q *localQuery) project(row *localRow) (*localResult, error) {
+	result := &localResult{values: make([]interface{}, 0, len(q.items))}
+	for _, item := range q.items {
+		v, err := item.expr(row)
+		if err != nil {
+			return nil, err
+		}
+		result.values = append(result.values, v)
+	}
+	for _, item := range q.orderBy {
+		if item.output >= 0 {
+			result.keys = append(result.keys, result.values[item.output])
+			continue
+		}
+		v, err := item.expr(row)
+		if err != nil {
+			return nil, err
+		}
+		result.keys = append(result.keys, v)
+	}
+	return result, nil
+}
+
+type aggregateState struct {
+	count int64
+	sum   interface{}
+	value interface{}
+}
+
+func (s *aggregateState) add(aggregate *localAggregate, row *localRow) error {
+	if aggregate.arg == nil {
+		s.count++
+		return nil
+	}
+	v, err := aggregate.arg(row)
+	if err != nil || v == nil {
+		return err
+	}
+	s.count++
+	switch aggregate.fn {
+	case "SUM", "AVG":
+		if _, ok := toFloat(v); !ok {
+			return fmt.Errorf("%s requires numbers", aggregate.fn)
+		}
+		if s.sum == nil {
+			s.sum = v
+			return nil
+		}
+		sum, err := arithmeticExpr("+", literalExpr(s.sum), literalExpr(v))(row)
+		if err != nil {
+			return err
+		}
+		s.sum = sum
+	case "MIN", "MAX":
+		if s.value == nil {
+			s.value = v
+			return nil
+		}
+		c, err := compareLocalValues(v, s.value)
+		if err != nil {
+			return err
+		}
+		if (aggregate.fn == "MIN" && c < 0) || (aggregate.fn == "MAX" && c > 0) {
+			s.value = v
+		}
+	}
+	return nil
+}
+
+func (s *aggregateState) result(aggregate *localAggregate) interface{} {
+	switch aggregate.fn {
+	case "COUNT", "COUNT(*)":
+		return s.count
+	case "SUM":
+		return s.sum
+	case "AVG":
+		if s.count == 0 {
+			return nil
+		}
+		sum, _ := toFloat(s.sum)
+		return sum / float64(s.count)
+	}
+	return s.value
+}
@@ -0,0 +1,135 @@
+package s3selectsqldriver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalQuery(t *testing.T) {
+	columns := []string{"key", "size", "last_modified", "storage_class"}
+	table := [][]interface{}{
+		{"logs/2024-05-01.csv", int64(100), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), "STANDARD"},
+		{"logs/2024-05-02.csv", int64(300), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), "GLACIER"},
+		{"logs/2024-06-01.json", int64(200), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"logs/100%.csv", int64(50), time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), "STANDARD"},
+	}
+	cases := []struct {
+		query    string
+		names    []string
+		expected [][]interface{}
+		// scanned is the number of scanned rows if scanning stops at LIMIT
+		scanned int
+	}{
+		{
+			query: `SELECT key, size FROM information_schema.objects WHERE key LIKE 'logs/2024-05%' ORDER BY size DESC`,
+			names: []string{"key", "size"},
+			expected: [][]interface{}{
+				{"logs/2024-05-02.csv", int64(300)},
+				{"logs/2024-05-01.csv", int64(100)},
+			},
+		},
+		{
+			query: `SELECT o.key AS k FROM information_schema.objects o WHERE o.size BETWEEN 100 AND 200 AND NOT o.key LIKE '%.json' ORDER BY k`,
+			names: []string{"k"},
+			expected: [][]interface{}{
+				{"logs/2024-05-01.csv"},
+			},
+		},
+		{
+			query: `SELECT key FROM information_schema.objects WHERE last_modified >= '2024-06-01' AND storage_class IS NULL`,
+			names: []string{"key"},
+			expected: [][]interface{}{
+				{"logs/2024-06-01.json"},
+			},
+		},
+		{
+			query: `SELECT key FROM information_schema.objects WHERE last_modified < CAST('2024-05-02T00:00:00Z' AS TIMESTAMP) OR key LIKE 'logs/100!%%' ESCAPE '!' ORDER BY 1`,
+			names: []string{"key"},
+			expected: [][]interface{}{
+				{"logs/100%.csv"},
+				{"logs/2024-05-01.csv"},
+			},
+		},
+		{
+			query: `SELECT storage_class, COUNT(*) AS n, SUM(size) total, MAX(key) FROM information_schema.objects GROUP BY storage_class HAVING COUNT(*) > 0 ORDER BY n DESC, storage_class`,
+			names: []string{"storage_class", "n", "total", "MAX(key)"},
+			expected: [][]interface{}{
+				{"STANDARD", int64(2), int64(150), "logs/2024-05-01.csv"},
+				{nil, int64(1), int64(200), "logs/2024-06-01.json"},
+				{"GLACIER", int64(1), int64(300), "logs/2024-05-02.csv"},
+			},
+		},
+		{
+			query: `SELECT COUNT(*), AVG(size) FROM information_schema.objects WHERE storage_class IN ('STANDARD', 'GLACIER')`,
+			names: []string{"COUNT(*)", "AVG(size)"},
+			expected: [][]interface{}{
+				{int64(3), float64(150)},
+			},
+		},
+		{
+			query: `SELECT COUNT(key) FROM information_schema.objects WHERE size > 1000`,
+			names: []string{"COUNT(key)"},
+			expected: [][]interface{}{
+				{int64(0)},
+			},
+		},
+		{
+			query: `SELECT UPPER(key), size / 100 FROM information_schema.objects ORDER BY size LIMIT 2 OFFSET 1`,
+			names: []string{"UPPER(key)", "size/100"},
+			expected: [][]interface{}{
+				{"LOGS/2024-05-01.CSV", float64(1)},
+				{"LOGS/2024-06-01.JSON", float64(2)},
+			},
+		},
+		{
+			query: `SELECT * FROM information_schema.objects LIMIT 1;`,
+			names: columns,
+			expected: [][]interface{}{
+				table[0],
+			},
+			scanned: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q, err := parseLocalQuery(c.query, func(string) ([]string, error) {
+				return columns, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, c.names, q.names())
+			var scanned int
+			actual, err := q.run(func(fn func([]interface{}) bool) error {
+				for _, row := range table {
+					scanned++
+					if !fn(row) {
+						break
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+			if c.scanned > 0 {
+				require.Equal(t, c.scanned, scanned)
+			}
+		})
+	}
+}
+
+func TestLocalQuery__Errors(t *testing.T) {
+	cases := map[string]string{
+		`SELECT name FROM information_schema.objects`:                         "unknown column name of information_schema.objects",
+		`SELECT key FROM information_schema.objects WHERE COUNT(*) > 1`:       "aggregate function COUNT is not allowed here",
+		`SELECT key FROM information_schema.objects LIMIT x`:                  "LIMIT requires a number",
+		`SELECT key FROM information_schema.objects WHERE key = 'a' 'b'`:      `unexpected "'b'", expected end of query`,
+		`SELECT key FROM information_schema.objects WHERE SUBSTRING(key) = 1`: "function SUBSTRING is not supported",
+	}
+	for query, expected := range cases {
+		_, err := parseLocalQuery(query, func(string) ([]string, error) {
+			return []string{"key", "size"}, nil
+		})
+		require.EqualError(t, err, expected, query)
+	}
+}
@@ -0,0 +1,29 @@
+package s3selectsqldriver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	c.add("a", 1)
+	c.add("b", 2)
+	v, ok := c.get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	c.add("c", 3)
+	_, ok = c.get("b")
+	require.False(t, ok, "the least recently used entry is evicted")
+	require.Equal(t, 2, c.len())
+
+	c.add("a", 4)
+	v, _ = c.get("a")
+	require.Equal(t, 4, v)
+	c.remove("a")
+	_, ok = c.get("a")
+	require.False(t, ok)
+	require.Equal(t, 1, c.len())
+}
@@ -0,0 +1,308 @@
+package s3selectsqldriver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3InventoryManifest is manifest.json of S3 Inventory.
+// see https://docs.aws.amazon.com/AmazonS3/latest/userguide/storage-inventory-location.html
+type s3InventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// redshiftManifest is manifest of Redshift COPY and UNLOAD.
+type redshiftManifest struct {
+	Entries []struct {
+		URL string `json:"url"`
+	} `json:"entries"`
+}
+
+func (cfg *S3SelectConfig) setManifestParams(params url.Values) error {
+	if !params.Has("manifest") {
+		return nil
+	}
+	src, err := parseSource(params.Get("manifest"))
+	if err != nil {
+		return fmt.Errorf("parse manifest: %w", err)
+	}
+	if src.ObjectKey == "" {
+		return errors.New("manifest must be an object, not prefix")
+	}
+	cfg.Manifest = params.Get("manifest")
+	cfg.Params.Del("manifest")
+	return nil
+}
+
+// enumerateManifest sends objects named by the manifest to send, in order of the manifest.
+// manifest is one of S3 Inventory manifest.json, Redshift/Athena manifest json, and newline separated keys or s3 urls.
+func (conn *s3SelectConn) enumerateManifest(ctx context.Context, send func(contentInfo) bool) error {
+	manifest, err := parseSource(conn.cfg.Manifest)
+	if err != nil {
+		return fmt.Errorf("parse manifest: %w", err)
+	}
+	conn.debugf("read manifest %s", conn.cfg.Manifest)
+	body, err := conn.openObject(ctx, manifest.BucketName, manifest.ObjectKey)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	bs, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	sources := make(map[string]*S3SelectSource)
+	sendObject := func(bucketName, objectKey string) bool {
+		src, ok := sources[bucketName]
+		if !ok {
+			src = &S3SelectSource{BucketName: bucketName}
+			sources[bucketName] = src
+		}
+		return send(contentInfo{
+			BucketName: bucketName,
+			ObjectKey:  objectKey,
+			Source:     src,
+		})
+	}
+	trimmed := bytes.TrimSpace(bs)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &probe); err != nil {
+			return fmt.Errorf("parse manifest: %w", err)
+		}
+		if _, ok := probe["fileFormat"]; ok {
+			var m s3InventoryManifest
+			if err := json.Unmarshal(trimmed, &m); err != nil {
+				return fmt.Errorf("parse s3 inventory manifest: %w", err)
+			}
+			return conn.enumerateS3Inventory(ctx, &m, sendObject)
+		}
+		if _, ok := probe["entries"]; ok {
+			var m redshiftManifest
+			if err := json.Unmarshal(trimmed, &m); err != nil {
+				return fmt.Errorf("parse redshift manifest: %w", err)
+			}
+			for _, entry := range m.Entries {
+				src, err := parseSource(entry.URL)
+				if err != nil {
+					return fmt.Errorf("parse manifest entry %s: %w", entry.URL, err)
+				}
+				if !sendObject(src.BucketName, src.ObjectKey) {
+					return nil
+				}
+			}
+			return nil
+		}
+		return errors.New("unknown manifest json")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		bucketName, objectKey := conn.cfg.BucketName, line
+		if strings.HasPrefix(line, "s3://") {
+			src, err := parseSource(line)
+			if err != nil {
+				return fmt.Errorf("parse manifest line %s: %w", line, err)
+			}
+			bucketName, objectKey = src.BucketName, src.ObjectKey
+		}
+		if !sendObject(bucketName, objectKey) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (conn *s3SelectConn) enumerateS3Inventory(ctx context.Context, m *s3InventoryManifest, sendObject func(bucketName, objectKey string) bool) error {
+	inventoryBucket := strings.TrimPrefix(m.DestinationBucket, "arn:aws:s3:::")
+	switch strings.ToUpper(m.FileFormat) {
+	case "CSV":
+		bucketIndex, keyIndex, deleteMarkerIndex := -1, -1, -1
+		for i, column := range strings.Split(m.FileSchema, ",") {
+			switch strings.TrimSpace(column) {
+			case "Bucket":
+				bucketIndex = i
+			case "Key":
+				keyIndex = i
+			case "IsDeleteMarker":
+				deleteMarkerIndex = i
+			}
+		}
+		if bucketIndex < 0 || keyIndex < 0 {
+			return fmt.Errorf("s3 inventory file schema has no Bucket or Key: %s", m.FileSchema)
+		}
+		for _, file := range m.Files {
+			if ok, err := conn.readS3InventoryCSV(ctx, inventoryBucket, file.Key, bucketIndex, keyIndex, deleteMarkerIndex, sendObject); err != nil || !ok {
+				return err
+			}
+		}
+		return nil
+	case "PARQUET":
+		deleteMarker := strings.Contains(m.FileSchema, "is_delete_marker")
+		for _, file := range m.Files {
+			if ok, err := conn.selectS3InventoryParquet(ctx, inventoryBucket, file.Key, deleteMarker, sendObject); err != nil || !ok {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("s3 inventory file format %s is not supported", m.FileFormat)
+	}
+}
+
+// readS3InventoryCSV reads bucket and key of gzipped csv inventory file record by record.
+func (conn *s3SelectConn) readS3InventoryCSV(ctx context.Context, bucketName, objectKey string, bucketIndex, keyIndex, deleteMarkerIndex int, sendObject func(bucketName, objectKey string) bool) (bool, error) {
+	body, err := conn.openObject(ctx, bucketName, objectKey)
+	if err != nil {
+		return false, fmt.Errorf("read s3 inventory file: %w", err)
+	}
+	defer body.Close()
+	gr, err := gzip.NewReader(body)
+	if err != nil {
+		return false, fmt.Errorf("read s3 inventory file %s: %w", objectKey, err)
+	}
+	defer gr.Close()
+	r := csv.NewReader(gr)
+	r.ReuseRecord = true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("read s3 inventory file %s: %w", objectKey, err)
+		}
+		if deleteMarkerIndex >= 0 && deleteMarkerIndex < len(record) && record[deleteMarkerIndex] == "true" {
+			continue
+		}
+		if keyIndex >= len(record) || bucketIndex >= len(record) {
+			return false, fmt.Errorf("s3 inventory file %s: unexpected record length %d", objectKey, len(record))
+		}
+		// keys of csv inventory are url encoded
+		key, err := url.QueryUnescape(record[keyIndex])
+		if err != nil {
+			return false, fmt.Errorf("s3 inventory file %s: unescape key: %w", objectKey, err)
+		}
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		if !sendObject(record[bucketIndex], key) {
+			return false, nil
+		}
+	}
+}
+
+// selectS3InventoryParquet reads bucket and key of parquet inventory file by S3 Select.
+// with deleteMarker, is_delete_marker is also read and delete markers are skipped.
+func (conn *s3SelectConn) selectS3InventoryParquet(ctx context.Context, bucketName, objectKey string, deleteMarker bool, sendObject func(bucketName, objectKey string) bool) (bool, error) {
+	expression := "SELECT s.bucket, s.key FROM S3Object s"
+	if deleteMarker {
+		expression = "SELECT s.bucket, s.key, s.is_delete_marker FROM S3Object s"
+	}
+	input := &s3.SelectObjectContentInput{
+		Bucket:         aws.String(bucketName),
+		Key:            aws.String(objectKey),
+		Expression:     aws.String(expression),
+		ExpressionType: types.ExpressionTypeSql,
+		InputSerialization: &types.InputSerialization{
+			Parquet: &types.ParquetInput{},
+		},
+		OutputSerialization: &types.OutputSerialization{
+			JSON: &types.JSONOutput{},
+		},
+	}
+	// expected_bucket_owner and request_payer are of data objects, not of the inventory destination bucket
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(conn.client.SelectObjectContentWithWriter(ctx, pw, input))
+	}()
+	defer pr.Close()
+	dec := json.NewDecoder(pr)
+	for {
+		var record struct {
+			Bucket         string `json:"bucket"`
+			Key            string `json:"key"`
+			IsDeleteMarker bool   `json:"is_delete_marker"`
+		}
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				return true, nil
+			}
+			return false, fmt.Errorf("read s3 inventory file %s: %w", objectKey, err)
+		}
+		if record.IsDeleteMarker || strings.HasSuffix(record.Key, "/") {
+			continue
+		}
+		if !sendObject(record.Bucket, record.Key) {
+			return false, nil
+		}
+	}
+}
+
+// getObjectBytes reads the object in the bucket of data objects, such as the index, with expected_bucket_owner and request_payer.
+func (conn *s3SelectConn) getObjectBytes(ctx context.Context, bucketName, objectKey string) ([]byte, error) {
+	client, ok := conn.client.(S3GetObjectClient)
+	if !ok {
+		return nil, errors.New("s3 select client does not support GetObject")
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	}
+	conn.cfg.applyGetObjectInput(input)
+	output, err := client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
+
+// openObject opens the object which is not a data object, such as manifests.
+// expected_bucket_owner and request_payer are not applied, because the object may be in another bucket.
+func (conn *s3SelectConn) openObject(ctx context.Context, bucketName, objectKey string) (io.ReadCloser, error) {
+	client, ok := conn.client.(S3GetObjectClient)
+	if !ok {
+		return nil, errors.New("s3 select client does not support GetObject")
+	}
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// readObject reads the object which is not a data object, such as catalogs, like openObject.
+func (conn *s3SelectConn) readObject(ctx context.Context, bucketName, objectKey string) ([]byte, error) {
+	body, err := conn.openObject(ctx, bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
@@ -0,0 +1,161 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func newManifestMockClient(t *testing.T, objects map[string]string) *mockS3SelectClient {
+	t.Helper()
+	return &mockS3SelectClient{
+		GetObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			require.Nil(t, params.ExpectedBucketOwner, "expected_bucket_owner is not applied to manifests")
+			body, ok := objects[*params.Bucket+"/"+*params.Key]
+			if !ok {
+				return nil, fmt.Errorf("not found: %s/%s", *params.Bucket, *params.Key)
+			}
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader([]byte(body))),
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			if params.InputSerialization.Parquet != nil {
+				require.Nil(t, params.ExpectedBucketOwner, "expected_bucket_owner is not applied to inventory files")
+				fmt.Fprintf(w, `{"bucket":"example-com","key":"data/1.csv"}`+"\n")
+				fmt.Fprintf(w, `{"bucket":"example-com","key":"data/"}`+"\n")
+				fmt.Fprintf(w, `{"bucket":"other-com","key":"data/2.json"}`+"\n")
+				if strings.Contains(*params.Expression, "s.is_delete_marker") {
+					fmt.Fprintf(w, `{"bucket":"example-com","key":"data/deleted.csv","is_delete_marker":true}`+"\n")
+				}
+				return nil
+			}
+			require.Equal(t, "123456789012", aws.ToString(params.ExpectedBucketOwner))
+			fmt.Fprintf(w, `{"location":"%s/%s"}`+"\n", *params.Bucket, *params.Key)
+			return nil
+		},
+	}
+}
+
+func queryLocations(t *testing.T, dsn string) []string {
+	t.Helper()
+	db, err := sql.Open("s3-select", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.QueryContext(context.Background(), `SELECT * FROM S3Object`)
+	require.NoError(t, err)
+	defer rows.Close()
+	locations := make([]string, 0)
+	for rows.Next() {
+		var location string
+		require.NoError(t, rows.Scan(&location))
+		locations = append(locations, location)
+	}
+	return locations
+}
+
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.String()
+}
+
+func TestMock__Manifest(t *testing.T) {
+	cases := []struct {
+		name     string
+		objects  map[string]string
+		expected []string
+	}{
+		{
+			name: "key_list",
+			objects: map[string]string{
+				"manifest-com/manifest.txt": "# keys\ndata/1.csv\n\ns3://other-com/data/2.json\n",
+			},
+			expected: []string{"example-com/data/1.csv", "other-com/data/2.json"},
+		},
+		{
+			name: "redshift",
+			objects: map[string]string{
+				"manifest-com/manifest.txt": `{"entries":[{"url":"s3://example-com/data/1.csv","mandatory":true},{"url":"s3://other-com/data/2.json","mandatory":true}]}`,
+			},
+			expected: []string{"example-com/data/1.csv", "other-com/data/2.json"},
+		},
+		{
+			name: "s3_inventory_csv",
+			objects: map[string]string{
+				"manifest-com/manifest.txt": `{
+					"sourceBucket": "example-com",
+					"destinationBucket": "arn:aws:s3:::inventory-com",
+					"fileFormat": "CSV",
+					"fileSchema": "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size",
+					"files": [{"key": "inventory/files/1.csv.gz"}]
+				}`,
+				"inventory-com/inventory/files/1.csv.gz": gzipString(t, `"example-com","data/1.csv","v1","true","false","10"`+"\n"+
+					`"example-com","data/deleted.csv","v2","true","true","0"`+"\n"+
+					`"example-com","data/","v3","true","false","0"`+"\n"+
+					`"example-com","data/with%20space.csv","v4","true","false","10"`+"\n"),
+			},
+			expected: []string{"example-com/data/1.csv", "example-com/data/with space.csv"},
+		},
+		{
+			name: "s3_inventory_parquet",
+			objects: map[string]string{
+				"manifest-com/manifest.txt": `{
+					"sourceBucket": "example-com",
+					"destinationBucket": "arn:aws:s3:::inventory-com",
+					"fileFormat": "Parquet",
+					"fileSchema": "message s3.inventory { required binary bucket (UTF8); required binary key (UTF8); }",
+					"files": [{"key": "inventory/files/1.parquet"}]
+				}`,
+			},
+			expected: []string{"example-com/data/1.csv", "other-com/data/2.json"},
+		},
+		{
+			name: "s3_inventory_parquet_delete_marker",
+			objects: map[string]string{
+				"manifest-com/manifest.txt": `{
+					"sourceBucket": "example-com",
+					"destinationBucket": "arn:aws:s3:::inventory-com",
+					"fileFormat": "Parquet",
+					"fileSchema": "message s3.inventory { required binary bucket (UTF8); required binary key (UTF8); optional boolean is_delete_marker; }",
+					"files": [{"key": "inventory/files/1.parquet"}]
+				}`,
+			},
+			expected: []string{"example-com/data/1.csv", "other-com/data/2.json"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockName := "manifest_" + c.name
+			mockClients[mockName] = newManifestMockClient(t, c.objects)
+			restore := requireNoErrorLog(t)
+			defer restore()
+			actual := queryLocations(t, "s3://example-com/data/?manifest=s3://manifest-com/manifest.txt&expected_bucket_owner=123456789012&mock="+mockName)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestMock__Manifest__ORCNotSupported(t *testing.T) {
+	mockClients["manifest_orc"] = newManifestMockClient(t, map[string]string{
+		"manifest-com/manifest.json": `{"destinationBucket":"arn:aws:s3:::inventory-com","fileFormat":"ORC","files":[{"key":"1.orc"}]}`,
+	})
+	db, err := sql.Open("s3-select", "s3://example-com/data/?manifest=s3://manifest-com/manifest.json&mock=manifest_orc")
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.QueryContext(context.Background(), `SELECT * FROM S3Object`)
+	require.EqualError(t, err, "s3 inventory file format ORC is not supported")
+}
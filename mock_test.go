@@ -11,6 +11,14 @@ import (
 type mockS3SelectClient struct {
 	SelectObjectContentWithWriterFunc func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error
 	ListObjectsV2Func                 func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	GetObjectFunc                     func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObjectFunc                    func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	PutObjectFunc                     func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUploadFunc         func(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPartFunc                    func(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUploadFunc       func(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUploadFunc          func(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	DeleteObjectFunc                  func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 }
 
 func (m *mockS3SelectClient) SelectObjectContentWithWriter(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
@@ -26,3 +34,59 @@ func (m *mockS3SelectClient) ListObjectsV2(ctx context.Context, params *s3.ListO
 	}
 	return m.ListObjectsV2Func(ctx, params)
 }
+
+func (m *mockS3SelectClient) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if m.GetObjectFunc == nil {
+		return nil, errors.New("unexpected call GetObject")
+	}
+	return m.GetObjectFunc(ctx, params)
+}
+
+func (m *mockS3SelectClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if m.HeadObjectFunc == nil {
+		return nil, errors.New("unexpected call HeadObject")
+	}
+	return m.HeadObjectFunc(ctx, params)
+}
+
+func (m *mockS3SelectClient) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if m.PutObjectFunc == nil {
+		return nil, errors.New("unexpected call PutObject")
+	}
+	return m.PutObjectFunc(ctx, params)
+}
+
+func (m *mockS3SelectClient) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	if m.CreateMultipartUploadFunc == nil {
+		return nil, errors.New("unexpected call CreateMultipartUpload")
+	}
+	return m.CreateMultipartUploadFunc(ctx, params)
+}
+
+func (m *mockS3SelectClient) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if m.UploadPartFunc == nil {
+		return nil, errors.New("unexpected call UploadPart")
+	}
+	return m.UploadPartFunc(ctx, params)
+}
+
+func (m *mockS3SelectClient) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if m.CompleteMultipartUploadFunc == nil {
+		return nil, errors.New("unexpected call CompleteMultipartUpload")
+	}
+	return m.CompleteMultipartUploadFunc(ctx, params)
+}
+
+func (m *mockS3SelectClient) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if m.AbortMultipartUploadFunc == nil {
+		return nil, errors.New("unexpected call AbortMultipartUpload")
+	}
+	return m.AbortMultipartUploadFunc(ctx, params)
+}
+
+func (m *mockS3SelectClient) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	if m.DeleteObjectFunc == nil {
+		return nil, errors.New("unexpected call DeleteObject")
+	}
+	return m.DeleteObjectFunc(ctx, params)
+}
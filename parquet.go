@@ -0,0 +1,842 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// parquetFooterReadBytes is the size of the tail of object read at first, most footers fit in it.
+	parquetFooterReadBytes = 64 * 1024
+	// parquetFooterMaxBytes is the max size of the footer.
+	parquetFooterMaxBytes = 64 * 1024 * 1024
+	// parquetFooterCacheSize is the max number of cached footers.
+	parquetFooterCacheSize = 1000
+)
+
+// physical types of parquet.
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// repetition types of parquet.
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+)
+
+// parquetKind is the logical type of the schema element, derived from the physical type, ConvertedType and LogicalType.
+type parquetKind string
+
+const (
+	parquetKindGroup           parquetKind = "group"
+	parquetKindList            parquetKind = "list"
+	parquetKindMap             parquetKind = "map"
+	parquetKindBoolean         parquetKind = "boolean"
+	parquetKindInt             parquetKind = "int"
+	parquetKindUint            parquetKind = "uint"
+	parquetKindFloat           parquetKind = "float"
+	parquetKindDecimal         parquetKind = "decimal"
+	parquetKindString          parquetKind = "string"
+	parquetKindBinary          parquetKind = "binary"
+	parquetKindDate            parquetKind = "date"
+	parquetKindTime            parquetKind = "time"
+	parquetKindTimestampMillis parquetKind = "timestamp_millis"
+	parquetKindTimestampMicros parquetKind = "timestamp_micros"
+	parquetKindTimestampNanos  parquetKind = "timestamp_nanos"
+	parquetKindInt96           parquetKind = "int96"
+)
+
+// parquetFooter is FileMetaData of parquet, only fields used for schema and statistics.
+type parquetFooter struct {
+	numRows   int64
+	root      *parquetNode
+	rowGroups []parquetRowGroup
+	// leaves are top level primitive columns whose statistics can be used, by lower case name.
+	leaves map[string]*parquetLeaf
+}
+
+type parquetNode struct {
+	name       string
+	physical   int
+	repetition int
+	kind       parquetKind
+	children   []*parquetNode
+}
+
+type parquetLeaf struct {
+	node   *parquetNode
+	column *projectedColumn
+}
+
+type parquetRowGroup struct {
+	numRows int64
+	// statistics of top level columns by name.
+	statistics map[string]parquetStatistics
+}
+
+type parquetStatistics struct {
+	min, max  []byte
+	hasMinMax bool
+	nullCount int64
+	hasNulls  bool
+}
+
+var (
+	errNotParquet           = errors.New("not parquet")
+	errInvalidParquetFooter = errors.New("invalid parquet footer")
+)
+
+type parquetFooterCacheEntry struct {
+	footer *parquetFooter
+	err    error
+}
+
+// parquetFooterCache caches footers per object version.
+// errors of the content, such as an invalid footer, are also cached.
+var parquetFooterCache = newLRUCache[objectVersion, parquetFooterCacheEntry](parquetFooterCacheSize)
+
+// parquetFooter returns the footer of the parquet object, cached by the key and the ETag.
+func (conn *s3SelectConn) parquetFooter(ctx context.Context, content contentInfo) (*parquetFooter, error) {
+	content.ETag = conn.objectETag(ctx, content)
+	if content.ETag != "" {
+		if entry, ok := parquetFooterCache.get(objectVersion{bucket: content.BucketName, key: content.ObjectKey, etag: content.ETag}); ok {
+			return entry.footer, entry.err
+		}
+	}
+	footer, etag, err := conn.readParquetFooter(ctx, content)
+	if err != nil && !errors.Is(err, errNotParquet) && !errors.Is(err, errInvalidParquetFooter) {
+		return nil, err
+	}
+	if etag == "" {
+		etag = content.ETag
+	}
+	if etag != "" {
+		parquetFooterCache.add(objectVersion{bucket: content.BucketName, key: content.ObjectKey, etag: etag}, parquetFooterCacheEntry{footer: footer, err: err})
+	}
+	return footer, err
+}
+
+// readParquetFooter reads the footer of the parquet object by ranged GetObject.
+// the tail of the object is read at first, and the rest of the footer is read if the footer is larger than it.
+func (conn *s3SelectConn) readParquetFooter(ctx context.Context, content contentInfo) (*parquetFooter, string, error) {
+	tail, etag, err := conn.getObjectRange(ctx, content, fmt.Sprintf("bytes=-%d", parquetFooterReadBytes), parquetFooterReadBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(tail) < 8 || string(tail[len(tail)-4:]) != "PAR1" {
+		return nil, etag, fmt.Errorf("%w: s3://%s/%s", errNotParquet, content.BucketName, content.ObjectKey)
+	}
+	size := int64(binary.LittleEndian.Uint32(tail[len(tail)-8:]))
+	if size > parquetFooterMaxBytes {
+		return nil, etag, fmt.Errorf("%w: footer of s3://%s/%s is too large: %d bytes", errInvalidParquetFooter, content.BucketName, content.ObjectKey, size)
+	}
+	if size+8 > int64(len(tail)) {
+		tail, etag, err = conn.getObjectRange(ctx, content, fmt.Sprintf("bytes=-%d", size+8), size+8)
+		if err != nil {
+			return nil, "", err
+		}
+		if int64(len(tail)) < size+8 {
+			return nil, etag, fmt.Errorf("%w: footer of s3://%s/%s is truncated", errInvalidParquetFooter, content.BucketName, content.ObjectKey)
+		}
+	}
+	footer, err := parseParquetFooter(tail[int64(len(tail))-8-size : len(tail)-8])
+	if err != nil {
+		return nil, etag, fmt.Errorf("%w: s3://%s/%s: %v", errInvalidParquetFooter, content.BucketName, content.ObjectKey, err)
+	}
+	return footer, etag, nil
+}
+
+// skipByParquetFooter reports whether the parquet object can be skipped by statistics of the footer, and sets footerSchema.
+// the footer is an optimization, the object is not skipped if the footer is not available.
+func (conn *s3SelectConn) skipByParquetFooter(ctx context.Context, content contentInfo, query string, hasWhere bool, footerSchema **Schema) bool {
+	footer, err := conn.parquetFooter(ctx, content)
+	if err != nil {
+		conn.debugf("parquet footer is not available: %v", err)
+		return false
+	}
+	if footerSchema != nil && *footerSchema == nil {
+		*footerSchema = footer.schema()
+	}
+	if !hasWhere {
+		return false
+	}
+	skip, err := footer.canSkip(query)
+	if err != nil {
+		conn.debugf("parquet statistics are not available: %v", err)
+		return false
+	}
+	if skip {
+		conn.debugf("skip s3://%s/%s by parquet statistics", content.BucketName, content.ObjectKey)
+	}
+	return skip
+}
+
+// hasWhereClause reports whether the query has top level WHERE.
+func hasWhereClause(query string) bool {
+	tokens, err := significantTokens(query)
+	if err != nil {
+		return false
+	}
+	return len(whereClause(tokens)) > 0
+}
+
+// parseParquetFooter parses FileMetaData encoded by thrift compact protocol.
+func parseParquetFooter(bs []byte) (*parquetFooter, error) {
+	r := &thriftReader{buf: bs}
+	fileMetaData, err := r.readStruct()
+	if err != nil {
+		return nil, err
+	}
+	footer := &parquetFooter{
+		numRows: fileMetaData.int64(3),
+		leaves:  make(map[string]*parquetLeaf),
+	}
+	elements := fileMetaData.list(2)
+	if len(elements) == 0 {
+		return nil, errors.New("schema is empty")
+	}
+	var pos int
+	footer.root, err = parseParquetNode(elements, &pos, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range footer.root.children {
+		typ, ok := node.statisticsType()
+		if !ok {
+			continue
+		}
+		footer.leaves[strings.ToLower(node.name)] = &parquetLeaf{
+			node:   node,
+			column: &projectedColumn{name: node.name, typ: typ},
+		}
+	}
+	for _, v := range fileMetaData.list(4) {
+		rowGroup, ok := v.(thriftStruct)
+		if !ok {
+			return nil, errors.New("invalid row group")
+		}
+		rg := parquetRowGroup{
+			numRows:    rowGroup.int64(3),
+			statistics: make(map[string]parquetStatistics),
+		}
+		for _, v := range rowGroup.list(1) {
+			chunk, ok := v.(thriftStruct)
+			if !ok {
+				return nil, errors.New("invalid column chunk")
+			}
+			metaData := chunk.strct(3)
+			path := metaData.list(3)
+			if len(path) != 1 {
+				continue
+			}
+			name, _ := path[0].([]byte)
+			statistics, ok := metaData[12].(thriftStruct)
+			if !ok {
+				continue
+			}
+			rg.statistics[strings.ToLower(string(name))] = parseParquetStatistics(statistics, int(metaData.int64(1)))
+		}
+		footer.rowGroups = append(footer.rowGroups, rg)
+	}
+	return footer, nil
+}
+
+// parseParquetNode builds the tree of the schema, elements are flattened in depth-first order with num_children.
+func parseParquetNode(elements []interface{}, pos *int, depth int) (*parquetNode, error) {
+	if *pos >= len(elements) {
+		return nil, errors.New("schema is truncated")
+	}
+	if depth > 64 {
+		return nil, errors.New("schema is too deep")
+	}
+	element, ok := elements[*pos].(thriftStruct)
+	if !ok {
+		return nil, errors.New("invalid schema element")
+	}
+	*pos++
+	node := &parquetNode{
+		name:       string(element.binary(4)),
+		physical:   -1,
+		repetition: int(element.int64(3)),
+	}
+	if _, ok := element[1]; ok {
+		node.physical = int(element.int64(1))
+	}
+	numChildren := int(element.int64(5))
+	for i := 0; i < numChildren; i++ {
+		child, err := parseParquetNode(elements, pos, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, child)
+	}
+	node.kind = parquetKindOf(element, node.physical, numChildren > 0)
+	return node, nil
+}
+
+// parquetKindOf returns the kind of the schema element. LogicalType is preferred to ConvertedType.
+func parquetKindOf(element thriftStruct, physical int, group bool) parquetKind {
+	if logicalType, ok := element[10].(thriftStruct); ok {
+		for id, v := range logicalType {
+			switch id {
+			case 1, 4, 12, 14:
+				return parquetKindString
+			case 2:
+				return parquetKindMap
+			case 3:
+				return parquetKindList
+			case 5:
+				return parquetKindDecimal
+			case 6:
+				return parquetKindDate
+			case 7:
+				return parquetKindTime
+			case 8:
+				timestamp, _ := v.(thriftStruct)
+				unit := timestamp.strct(2)
+				switch {
+				case unit[1] != nil:
+					return parquetKindTimestampMillis
+				case unit[2] != nil:
+					return parquetKindTimestampMicros
+				case unit[3] != nil:
+					return parquetKindTimestampNanos
+				}
+			case 10:
+				integer, _ := v.(thriftStruct)
+				if signed, ok := integer[2].(bool); ok && !signed {
+					return parquetKindUint
+				}
+				return parquetKindInt
+			}
+		}
+	}
+	if _, ok := element[6]; ok {
+		switch element.int64(6) {
+		case 0, 4, 19:
+			return parquetKindString
+		case 1, 2:
+			return parquetKindMap
+		case 3:
+			return parquetKindList
+		case 5:
+			return parquetKindDecimal
+		case 6:
+			return parquetKindDate
+		case 7, 8:
+			return parquetKindTime
+		case 9:
+			return parquetKindTimestampMillis
+		case 10:
+			return parquetKindTimestampMicros
+		case 11, 12, 13, 14:
+			return parquetKindUint
+		case 15, 16, 17, 18:
+			return parquetKindInt
+		}
+	}
+	if group {
+		return parquetKindGroup
+	}
+	switch physical {
+	case parquetBoolean:
+		return parquetKindBoolean
+	case parquetInt32, parquetInt64:
+		return parquetKindInt
+	case parquetInt96:
+		return parquetKindInt96
+	case parquetFloat, parquetDouble:
+		return parquetKindFloat
+	}
+	return parquetKindBinary
+}
+
+func parseParquetStatistics(statistics thriftStruct, physical int) parquetStatistics {
+	var s parquetStatistics
+	minValue, hasMin := statistics[6].([]byte)
+	maxValue, hasMax := statistics[5].([]byte)
+	if (!hasMin || !hasMax) && physical != parquetByteArray && physical != parquetFixedLenByteArray {
+		// deprecated min and max are in signed order, only valid for numbers
+		minValue, hasMin = statistics[2].([]byte)
+		maxValue, hasMax = statistics[1].([]byte)
+	}
+	if hasMin && hasMax {
+		s.min, s.max, s.hasMinMax = minValue, maxValue, true
+	}
+	if _, ok := statistics[3]; ok {
+		s.nullCount, s.hasNulls = statistics.int64(3), true
+	}
+	return s
+}
+
+// statisticsType returns the type of the column for predicates, if statistics of the column can be compared.
+func (node *parquetNode) statisticsType() (projectionType, bool) {
+	if node.repetition == parquetRepeated || len(node.children) > 0 {
+		return "", false
+	}
+	switch node.kind {
+	case parquetKindInt:
+		return projectionTypeInt, node.physical == parquetInt32 || node.physical == parquetInt64
+	case parquetKindFloat:
+		return projectionTypeFloat, true
+	case parquetKindString:
+		return projectionTypeString, node.physical == parquetByteArray
+	case parquetKindDate:
+		return projectionTypeDate, node.physical == parquetInt32
+	case parquetKindTimestampMillis, parquetKindTimestampMicros, parquetKindTimestampNanos:
+		return projectionTypeDate, node.physical == parquetInt64
+	}
+	return "", false
+}
+
+// value decodes min or max of statistics in plain encoding.
+func (node *parquetNode) value(bs []byte) (interface{}, bool) {
+	var n int64
+	switch node.physical {
+	case parquetInt32:
+		if len(bs) != 4 {
+			return nil, false
+		}
+		n = int64(int32(binary.LittleEndian.Uint32(bs)))
+	case parquetInt64:
+		if len(bs) != 8 {
+			return nil, false
+		}
+		n = int64(binary.LittleEndian.Uint64(bs))
+	case parquetFloat:
+		if len(bs) != 4 {
+			return nil, false
+		}
+		f := float64(math.Float32frombits(binary.LittleEndian.Uint32(bs)))
+		return f, !math.IsNaN(f)
+	case parquetDouble:
+		if len(bs) != 8 {
+			return nil, false
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(bs))
+		return f, !math.IsNaN(f)
+	case parquetByteArray:
+		return string(bs), true
+	default:
+		return nil, false
+	}
+	switch node.kind {
+	case parquetKindDate:
+		return time.Unix(n*24*60*60, 0).UTC(), true
+	case parquetKindTimestampMillis:
+		return time.UnixMilli(n).UTC(), true
+	case parquetKindTimestampMicros:
+		return time.UnixMicro(n).UTC(), true
+	case parquetKindTimestampNanos:
+		return time.Unix(0, n).UTC(), true
+	}
+	return n, true
+}
+
+// column returns the column of statistics for the identifier in WHERE, such as `id` or `s.id`.
+func (footer *parquetFooter) column(identifier string) *projectedColumn {
+	name, ok := topLevelColumn(identifier, func(name string) bool {
+		return footer.root.child(name) != nil
+	})
+	if !ok {
+		return nil
+	}
+	leaf, ok := footer.leaves[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	return leaf.column
+}
+
+func (node *parquetNode) child(name string) *parquetNode {
+	for _, child := range node.children {
+		if strings.EqualFold(child.name, name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// topLevelColumn returns the name of the top level column of the identifier, `id` or `s.id` with the alias of S3Object.
+// `a.b` is the field b of the column a, not the column b of the alias a, if isColumn(a).
+func topLevelColumn(identifier string, isColumn func(name string) bool) (string, bool) {
+	parts := strings.Split(identifier, ".")
+	switch len(parts) {
+	case 1:
+		return parts[0], true
+	case 2:
+		if isColumn(parts[0]) {
+			return "", false
+		}
+		return parts[1], true
+	}
+	return "", false
+}
+
+// canSkip reports whether statistics of all row groups prove that no record matches predicates in WHERE of the query.
+func (footer *parquetFooter) canSkip(query string) (bool, error) {
+	constraints, err := extractConstraints(query, footer.column)
+	if err != nil {
+		return false, err
+	}
+	if len(constraints) == 0 {
+		return false, nil
+	}
+	for _, rg := range footer.rowGroups {
+		if footer.mayMatch(rg, constraints) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (footer *parquetFooter) mayMatch(rg parquetRowGroup, constraints map[*projectedColumn][]projectionConstraint) bool {
+	if rg.numRows == 0 {
+		return false
+	}
+	for column, cs := range constraints {
+		leaf := footer.leaves[strings.ToLower(column.name)]
+		s, ok := rg.statistics[strings.ToLower(column.name)]
+		if !ok {
+			continue
+		}
+		if s.hasNulls && s.nullCount >= rg.numRows {
+			// comparisons with NULL are never true
+			return false
+		}
+		if !s.hasMinMax {
+			continue
+		}
+		minValue, ok := leaf.node.value(s.min)
+		if !ok {
+			continue
+		}
+		maxValue, ok := leaf.node.value(s.max)
+		if !ok {
+			continue
+		}
+		for _, c := range cs {
+			if !c.overlaps(minValue, maxValue) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// overlaps reports whether some value in [min, max] may satisfy the constraint.
+func (c projectionConstraint) overlaps(min, max interface{}) bool {
+	inRange := func(v interface{}) bool {
+		return compareProjectedValues(v, min) >= 0 && compareProjectedValues(v, max) <= 0
+	}
+	switch c.op {
+	case "=":
+		return inRange(c.values[0])
+	case "<>", "!=":
+		return compareProjectedValues(min, c.values[0]) != 0 || compareProjectedValues(max, c.values[0]) != 0
+	case ">":
+		return compareProjectedValues(max, c.values[0]) > 0
+	case ">=":
+		return compareProjectedValues(max, c.values[0]) >= 0
+	case "<":
+		return compareProjectedValues(min, c.values[0]) < 0
+	case "<=":
+		return compareProjectedValues(min, c.values[0]) <= 0
+	case "IN":
+		for _, v := range c.values {
+			if inRange(v) {
+				return true
+			}
+		}
+		return false
+	case "BETWEEN":
+		return compareProjectedValues(max, c.values[0]) >= 0 && compareProjectedValues(min, c.values[1]) <= 0
+	}
+	return true
+}
+
+// schema returns the logical schema of the parquet.
+func (footer *parquetFooter) schema() *Schema {
+	schema := &Schema{}
+	for _, node := range footer.root.children {
+		schema.Columns = append(schema.Columns, node.schemaColumn())
+	}
+	return schema
+}
+
+func (node *parquetNode) schemaColumn() *SchemaColumn {
+	column := &SchemaColumn{
+		Name:     node.name,
+		Nullable: node.repetition == parquetOptional,
+	}
+	if node.repetition == parquetRepeated {
+		// a repeated field without LIST annotation is a list of required elements
+		element := *node
+		element.repetition = parquetRequired
+		column.Type = SchemaTypeArray
+		column.Element = element.schemaColumn()
+		return column
+	}
+	switch node.kind {
+	case parquetKindList:
+		column.Type = SchemaTypeArray
+		if len(node.children) == 1 {
+			repeated := node.children[0]
+			if len(repeated.children) == 1 && repeated.name != "array" && repeated.name != node.name+"_tuple" {
+				// three level list: <list> { repeated group list { <element> } }
+				column.Element = repeated.children[0].schemaColumn()
+			} else {
+				// two level list: <list> { repeated <element> }
+				element := *repeated
+				element.repetition = parquetRequired
+				column.Element = element.schemaColumn()
+			}
+		}
+		return column
+	case parquetKindMap:
+		column.Type = SchemaTypeMap
+		if len(node.children) == 1 && len(node.children[0].children) == 2 {
+			keyValue := node.children[0]
+			column.Fields = []*SchemaColumn{keyValue.children[0].schemaColumn(), keyValue.children[1].schemaColumn()}
+		}
+		return column
+	case parquetKindGroup:
+		column.Type = SchemaTypeStruct
+		for _, child := range node.children {
+			column.Fields = append(column.Fields, child.schemaColumn())
+		}
+		return column
+	case parquetKindBoolean:
+		column.Type = SchemaTypeBoolean
+	case parquetKindInt, parquetKindUint, parquetKindTime:
+		column.Type = SchemaTypeBigint
+	case parquetKindFloat, parquetKindDecimal:
+		column.Type = SchemaTypeDouble
+	case parquetKindDate, parquetKindTimestampMillis, parquetKindTimestampMicros, parquetKindTimestampNanos, parquetKindInt96:
+		column.Type = SchemaTypeTimestamp
+	default:
+		column.Type = SchemaTypeString
+	}
+	return column
+}
+
+// thriftStruct is the decoded struct of thrift compact protocol by field id.
+// values are bool, int64, float64, []byte, []interface{} or thriftStruct.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) int64(id int16) int64 {
+	n, _ := s[id].(int64)
+	return n
+}
+
+func (s thriftStruct) binary(id int16) []byte {
+	bs, _ := s[id].([]byte)
+	return bs
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	l, _ := s[id].([]interface{})
+	return l
+}
+
+func (s thriftStruct) strct(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+// types of thrift compact protocol.
+const (
+	thriftStop       = 0
+	thriftTrue       = 1
+	thriftFalse      = 2
+	thriftByte       = 3
+	thriftI16        = 4
+	thriftI32        = 5
+	thriftI64        = 6
+	thriftDouble     = 7
+	thriftBinary     = 8
+	thriftList       = 9
+	thriftSet        = 10
+	thriftMap        = 11
+	thriftStructType = 12
+)
+
+// thriftReader decodes thrift compact protocol without IDL, parquet metadata is decoded generically.
+type thriftReader struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+var errThriftTruncated = errors.New("thrift: unexpected end of data")
+
+func (r *thriftReader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errThriftTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) readVarint() (uint64, error) {
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, errors.New("thrift: varint overflows")
+}
+
+func (r *thriftReader) readZigzag() (int64, error) {
+	x, err := r.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	return int64(x>>1) ^ -int64(x&1), nil
+}
+
+func (r *thriftReader) readStruct() (thriftStruct, error) {
+	r.depth++
+	defer func() { r.depth-- }()
+	if r.depth > 64 {
+		return nil, errors.New("thrift: nested too deep")
+	}
+	s := make(thriftStruct)
+	var id int16
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		typ := b & 0x0f
+		if typ == thriftStop {
+			return s, nil
+		}
+		if delta := b >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			n, err := r.readZigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(n)
+		}
+		v, err := r.readValue(typ, false)
+		if err != nil {
+			return nil, err
+		}
+		s[id] = v
+	}
+}
+
+// readValue reads the value of the type. bool of field is in the type, and bool of element is a byte.
+func (r *thriftReader) readValue(typ byte, element bool) (interface{}, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		if element {
+			b, err := r.readByte()
+			return b == thriftTrue, err
+		}
+		return typ == thriftTrue, nil
+	case thriftByte:
+		b, err := r.readByte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.readZigzag()
+	case thriftDouble:
+		if r.pos+8 > len(r.buf) {
+			return nil, errThriftTruncated
+		}
+		f := math.Float64frombits(binary.LittleEndian.Uint64(r.buf[r.pos:]))
+		r.pos += 8
+		return f, nil
+	case thriftBinary:
+		n, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.buf)-r.pos) {
+			return nil, errThriftTruncated
+		}
+		bs := r.buf[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return bs, nil
+	case thriftList, thriftSet:
+		b, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(b >> 4)
+		if size == 15 {
+			if size, err = r.readVarint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(len(r.buf)-r.pos) {
+			// every element has at least 1 byte
+			return nil, errThriftTruncated
+		}
+		l := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			v, err := r.readValue(b&0x0f, true)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case thriftMap:
+		size, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return []interface{}{}, nil
+		}
+		if size > uint64(len(r.buf)-r.pos) {
+			return nil, errThriftTruncated
+		}
+		b, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		// maps are not used in parquet metadata, entries are flattened for skipping
+		l := make([]interface{}, 0, 2*size)
+		for i := uint64(0); i < size; i++ {
+			k, err := r.readValue(b>>4, true)
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.readValue(b&0x0f, true)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, k, v)
+		}
+		return l, nil
+	case thriftStructType:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("thrift: unknown type %d", typ)
+}
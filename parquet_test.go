@@ -0,0 +1,339 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+// thriftField is a field of struct for encoding test footers by thrift compact protocol.
+type thriftField struct {
+	id int16
+	v  interface{}
+}
+
+type thriftFields []thriftField
+
+func thriftTypeOf(v interface{}) byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return thriftTrue
+		}
+		return thriftFalse
+	case int32:
+		return thriftI32
+	case int64:
+		return thriftI64
+	case string, []byte:
+		return thriftBinary
+	case []interface{}:
+		return thriftList
+	case thriftFields:
+		return thriftStructType
+	}
+	panic(fmt.Sprintf("unsupported type %T", v))
+}
+
+func writeThriftVarint(buf *bytes.Buffer, x uint64) {
+	for x >= 0x80 {
+		buf.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	buf.WriteByte(byte(x))
+}
+
+func writeThriftStruct(buf *bytes.Buffer, fields thriftFields) {
+	var last int16
+	for _, f := range fields {
+		typ := thriftTypeOf(f.v)
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			buf.WriteByte(byte(delta)<<4 | typ)
+		} else {
+			buf.WriteByte(typ)
+			writeThriftVarint(buf, uint64((int64(f.id)<<1)^(int64(f.id)>>63)))
+		}
+		last = f.id
+		if _, ok := f.v.(bool); !ok {
+			writeThriftValue(buf, f.v)
+		}
+	}
+	buf.WriteByte(thriftStop)
+}
+
+func writeThriftValue(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			buf.WriteByte(thriftTrue)
+		} else {
+			buf.WriteByte(thriftFalse)
+		}
+	case int32:
+		writeThriftVarint(buf, uint64((int64(v)<<1)^(int64(v)>>63)))
+	case int64:
+		writeThriftVarint(buf, uint64((v<<1)^(v>>63)))
+	case string:
+		writeThriftVarint(buf, uint64(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		writeThriftVarint(buf, uint64(len(v)))
+		buf.Write(v)
+	case []interface{}:
+		var typ byte = thriftI32
+		if len(v) > 0 {
+			typ = thriftTypeOf(v[0])
+			if typ == thriftFalse {
+				typ = thriftTrue
+			}
+		}
+		if len(v) < 15 {
+			buf.WriteByte(byte(len(v))<<4 | typ)
+		} else {
+			buf.WriteByte(0xf0 | typ)
+			writeThriftVarint(buf, uint64(len(v)))
+		}
+		for _, elem := range v {
+			writeThriftValue(buf, elem)
+		}
+	case thriftFields:
+		writeThriftStruct(buf, v)
+	}
+}
+
+func int64Bytes(n int64) []byte {
+	bs := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bs, uint64(n))
+	return bs
+}
+
+type testRowGroup struct {
+	numRows          int64
+	idMin, idMax     int64
+	nameMin, nameMax string
+	tsMin, tsMax     time.Time
+}
+
+func testParquetColumnChunk(name string, physical int32, min, max []byte) thriftFields {
+	return thriftFields{
+		{id: 2, v: int64(4)},
+		{id: 3, v: thriftFields{
+			{id: 1, v: physical},
+			{id: 2, v: []interface{}{int32(0)}},
+			{id: 3, v: []interface{}{name}},
+			{id: 4, v: int32(0)},
+			{id: 5, v: int64(1)},
+			{id: 6, v: int64(1)},
+			{id: 7, v: int64(1)},
+			{id: 9, v: int64(4)},
+			{id: 12, v: thriftFields{
+				{id: 3, v: int64(0)},
+				{id: 5, v: max},
+				{id: 6, v: min},
+			}},
+		}},
+	}
+}
+
+// testParquetFooter returns FileMetaData with id, name, ts, tags (list), attrs (map) and user (struct) columns.
+func testParquetFooter(rowGroups ...testRowGroup) []byte {
+	schema := []interface{}{
+		thriftFields{{id: 4, v: "schema"}, {id: 5, v: int32(6)}},
+		thriftFields{{id: 1, v: int32(parquetInt64)}, {id: 3, v: int32(parquetRequired)}, {id: 4, v: "id"}},
+		thriftFields{{id: 1, v: int32(parquetByteArray)}, {id: 3, v: int32(parquetOptional)}, {id: 4, v: "name"}, {id: 6, v: int32(0)}},
+		thriftFields{{id: 1, v: int32(parquetInt64)}, {id: 3, v: int32(parquetOptional)}, {id: 4, v: "ts"}, {id: 10, v: thriftFields{
+			{id: 8, v: thriftFields{{id: 1, v: true}, {id: 2, v: thriftFields{{id: 2, v: thriftFields{}}}}}},
+		}}},
+		thriftFields{{id: 3, v: int32(parquetOptional)}, {id: 4, v: "tags"}, {id: 5, v: int32(1)}, {id: 6, v: int32(3)}},
+		thriftFields{{id: 3, v: int32(parquetRepeated)}, {id: 4, v: "list"}, {id: 5, v: int32(1)}},
+		thriftFields{{id: 1, v: int32(parquetByteArray)}, {id: 3, v: int32(parquetOptional)}, {id: 4, v: "element"}, {id: 10, v: thriftFields{{id: 1, v: thriftFields{}}}}},
+		thriftFields{{id: 3, v: int32(parquetOptional)}, {id: 4, v: "attrs"}, {id: 5, v: int32(1)}, {id: 6, v: int32(1)}},
+		thriftFields{{id: 3, v: int32(parquetRepeated)}, {id: 4, v: "key_value"}, {id: 5, v: int32(2)}},
+		thriftFields{{id: 1, v: int32(parquetByteArray)}, {id: 3, v: int32(parquetRequired)}, {id: 4, v: "key"}, {id: 6, v: int32(0)}},
+		thriftFields{{id: 1, v: int32(parquetInt32)}, {id: 3, v: int32(parquetOptional)}, {id: 4, v: "value"}},
+		thriftFields{{id: 3, v: int32(parquetOptional)}, {id: 4, v: "user"}, {id: 5, v: int32(1)}},
+		thriftFields{{id: 1, v: int32(parquetDouble)}, {id: 3, v: int32(parquetOptional)}, {id: 4, v: "score"}},
+	}
+	groups := make([]interface{}, 0, len(rowGroups))
+	var numRows int64
+	for _, rg := range rowGroups {
+		numRows += rg.numRows
+		groups = append(groups, thriftFields{
+			{id: 1, v: []interface{}{
+				testParquetColumnChunk("id", parquetInt64, int64Bytes(rg.idMin), int64Bytes(rg.idMax)),
+				testParquetColumnChunk("name", parquetByteArray, []byte(rg.nameMin), []byte(rg.nameMax)),
+				testParquetColumnChunk("ts", parquetInt64, int64Bytes(rg.tsMin.UnixMicro()), int64Bytes(rg.tsMax.UnixMicro())),
+			}},
+			{id: 2, v: int64(100)},
+			{id: 3, v: rg.numRows},
+		})
+	}
+	var buf bytes.Buffer
+	writeThriftStruct(&buf, thriftFields{
+		{id: 1, v: int32(1)},
+		{id: 2, v: schema},
+		{id: 3, v: numRows},
+		{id: 4, v: groups},
+	})
+	return buf.Bytes()
+}
+
+// testParquetObject returns the parquet object with the footer, without data pages.
+func testParquetObject(footer []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("PAR1")
+	buf.Write(footer)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(footer)))
+	buf.WriteString("PAR1")
+	return buf.Bytes()
+}
+
+func TestParseParquetFooter(t *testing.T) {
+	footer, err := parseParquetFooter(testParquetFooter(
+		testRowGroup{numRows: 100, idMin: 1, idMax: 100, nameMin: "a", nameMax: "m", tsMin: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), tsMax: time.Date(2023, 6, 30, 0, 0, 0, 0, time.UTC)},
+		testRowGroup{numRows: 100, idMin: 101, idMax: 200, nameMin: "n", nameMax: "z", tsMin: time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), tsMax: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)},
+	))
+	require.NoError(t, err)
+	var actual []string
+	for _, column := range footer.schema().Columns {
+		actual = append(actual, fmt.Sprintf("%s %s %v", column.Name, column.TypeName(), column.Nullable))
+	}
+	require.Equal(t, []string{
+		"id bigint false",
+		"name string true",
+		"ts timestamp true",
+		"tags array<string> true",
+		"attrs map<string,bigint> true",
+		"user struct<score:double> true",
+	}, actual)
+
+	cases := []struct {
+		query    string
+		expected bool
+	}{
+		{query: `SELECT * FROM S3Object s`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE s.id = 150`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE s.id > 200`, expected: true},
+		{query: `SELECT * FROM S3Object s WHERE id BETWEEN 201 AND 300`, expected: true},
+		{query: `SELECT * FROM S3Object s WHERE s.id IN (0, 250)`, expected: true},
+		{query: `SELECT * FROM S3Object s WHERE s.id < 1 OR s.name = 'b'`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE s.name = 'zz'`, expected: true},
+		{query: `SELECT * FROM S3Object s WHERE s.id > 150 AND s.name < 'n'`, expected: true},
+		{query: `SELECT * FROM S3Object s WHERE s.id > 150 AND s.name <= 'n'`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE s.ts >= CAST('2024-01-01T00:00:00Z' AS TIMESTAMP)`, expected: true},
+		{query: `SELECT * FROM S3Object s WHERE s.user.score > 1`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE NOT s.id = 1000`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE CASE WHEN s.id > 1000 THEN 1 ELSE 0 END = 0`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE s.id > 500 - 400`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE s.id = 1000 IS NOT TRUE`, expected: false},
+		{query: `SELECT * FROM S3Object s WHERE s.id = 1000 IS NOT TRUE AND s.id BETWEEN 201 AND 300`, expected: true},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			skip, err := footer.canSkip(c.query)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, skip)
+		})
+	}
+}
+
+func TestParseParquetFooter__Invalid(t *testing.T) {
+	footer := testParquetFooter(testRowGroup{numRows: 1})
+	for i := 0; i < len(footer); i++ {
+		// must not panic with truncated footers
+		parseParquetFooter(footer[:i])
+	}
+	_, err := parseParquetFooter(nil)
+	require.Error(t, err)
+}
+
+func TestMock__ParquetStatistics(t *testing.T) {
+	objects := map[string][]byte{
+		"data/1.parquet": testParquetObject(testParquetFooter(testRowGroup{numRows: 100, idMin: 1, idMax: 100, nameMin: "a", nameMax: "z"})),
+		"data/2.parquet": testParquetObject(testParquetFooter(testRowGroup{numRows: 100, idMin: 101, idMax: 200, nameMin: "a", nameMax: "z"})),
+	}
+	var getObjectCalls int
+	var selected []string
+	mockClients["parquet_statistics"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("data/1.parquet"), ETag: aws.String(`"parquet-1"`)},
+					{Key: aws.String("data/2.parquet"), ETag: aws.String(`"parquet-2"`)},
+				},
+			}, nil
+		},
+		GetObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			getObjectCalls++
+			body := objects[*params.Key]
+			var n int
+			_, err := fmt.Sscanf(*params.Range, "bytes=-%d", &n)
+			require.NoError(t, err)
+			if n < len(body) {
+				body = body[len(body)-n:]
+			}
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader(body)),
+				ETag: aws.String(`"parquet-` + strings.TrimSuffix(strings.TrimPrefix(*params.Key, "data/"), ".parquet") + `"`),
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.NotNil(t, params.InputSerialization.Parquet)
+			selected = append(selected, *params.Key)
+			_, err := io.WriteString(w, `{"id":150,"tags":["a"]}`+"\n")
+			return err
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=parquet&mock=parquet_statistics")
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		rows, err := db.QueryContext(context.Background(), `SELECT s.id, s.tags FROM S3Object s WHERE s.id > 150`)
+		require.NoError(t, err)
+		columnTypes, err := rows.ColumnTypes()
+		require.NoError(t, err)
+		require.Equal(t, "BIGINT", columnTypes[0].DatabaseTypeName())
+		require.Equal(t, "ARRAY<STRING>", columnTypes[1].DatabaseTypeName())
+		nullable, ok := columnTypes[0].Nullable()
+		require.True(t, ok)
+		require.False(t, nullable)
+		require.NoError(t, rows.Close())
+	}
+	require.Equal(t, []string{"data/2.parquet", "data/2.parquet"}, selected)
+	require.Equal(t, 2, getObjectCalls, "footers are cached by ETag")
+
+	rows, err := db.QueryContext(context.Background(), `DESCRIBE S3Object`)
+	require.NoError(t, err)
+	defer rows.Close()
+	var actual []string
+	for rows.Next() {
+		var name, typ, nullable string
+		require.NoError(t, rows.Scan(&name, &typ, &nullable))
+		actual = append(actual, name+" "+typ+" "+nullable)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{
+		"id bigint NO",
+		"name string YES",
+		"ts timestamp YES",
+		"tags array<string> YES",
+		"attrs map<string,bigint> YES",
+		"user struct<score:double> YES",
+	}, actual)
+	require.Len(t, selected, 2, "DESCRIBE of parquet does not select")
+}
@@ -0,0 +1,397 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// codecs and encodings of parquet.
+const (
+	parquetCodecUncompressed = 0
+	parquetCodecGzip         = 2
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+)
+
+// converted types of parquet written by parquetWriter.
+const (
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMicros = 10
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetWriter encodes rows as a parquet file of one row group, columns are optional top level columns.
+// bigint, double, boolean and timestamp are written as they are, and other types are written as strings, nested values in JSON.
+type parquetWriter struct {
+	columns []string
+	types   []string
+	codec   int
+	rows    [][]interface{}
+}
+
+func newParquetWriter(columns []string, types []string, codec int) *parquetWriter {
+	return &parquetWriter{
+		columns: columns,
+		types:   types,
+		codec:   codec,
+	}
+}
+
+func (w *parquetWriter) physical(index int) (physical int, converted int) {
+	switch w.types[index] {
+	case SchemaTypeBigint:
+		return parquetInt64, -1
+	case SchemaTypeDouble:
+		return parquetDouble, -1
+	case SchemaTypeBoolean:
+		return parquetBoolean, -1
+	case SchemaTypeTimestamp:
+		return parquetInt64, parquetConvertedTimestampMicros
+	}
+	return parquetByteArray, parquetConvertedUTF8
+}
+
+// value converts the value of the column for the physical type, nil for null.
+func (w *parquetWriter) value(index int, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	column := &SchemaColumn{Name: w.columns[index], Type: w.types[index]}
+	switch column.Type {
+	case SchemaTypeBigint, SchemaTypeDouble, SchemaTypeBoolean:
+		return column.ConvertValue(v)
+	case SchemaTypeTimestamp:
+		t, err := column.ConvertValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.(time.Time).UnixMicro(), nil
+	}
+	s, err := unloadString(v)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %w", column.Name, err)
+	}
+	return s, nil
+}
+
+// widen changes the type of the column for added rows, only bigint can be widened to double.
+func (w *parquetWriter) widen(index int, typ string) error {
+	if w.types[index] != SchemaTypeBigint || typ != SchemaTypeDouble {
+		return fmt.Errorf("column %s: %s can not be widened to %s", w.columns[index], w.types[index], typ)
+	}
+	for _, row := range w.rows {
+		if v, ok := row[index].(int64); ok {
+			row[index] = float64(v)
+		}
+	}
+	w.types[index] = typ
+	return nil
+}
+
+// add appends the row, values are converted for the types of columns.
+func (w *parquetWriter) add(row []interface{}) error {
+	values := make([]interface{}, len(w.columns))
+	for i := range w.columns {
+		if i >= len(row) {
+			continue
+		}
+		v, err := w.value(i, row[i])
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	w.rows = append(w.rows, values)
+	return nil
+}
+
+func (w *parquetWriter) numRows() int {
+	return len(w.rows)
+}
+
+// bytes returns the parquet file of added rows.
+func (w *parquetWriter) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(parquetMagic)
+	chunks := &thriftWriter{}
+	chunks.listHeader(thriftStructType, len(w.columns))
+	var totalByteSize int64
+	for i, name := range w.columns {
+		physical, _ := w.physical(i)
+		page, stats := w.page(i, physical)
+		compressed := page
+		if w.codec == parquetCodecGzip {
+			var b bytes.Buffer
+			gw := gzip.NewWriter(&b)
+			if _, err := gw.Write(page); err != nil {
+				return nil, err
+			}
+			if err := gw.Close(); err != nil {
+				return nil, err
+			}
+			compressed = b.Bytes()
+		}
+		header := &thriftWriter{}
+		header.begin()
+		header.i32Field(1, 0) // DATA_PAGE
+		header.i32Field(2, int32(len(page)))
+		header.i32Field(3, int32(len(compressed)))
+		header.structField(5, func() {
+			header.i32Field(1, int32(len(w.rows)))
+			header.i32Field(2, parquetEncodingPlain)
+			header.i32Field(3, parquetEncodingRLE)
+			header.i32Field(4, parquetEncodingRLE)
+		})
+		header.end()
+		offset := int64(buf.Len())
+		buf.Write(header.buf)
+		buf.Write(compressed)
+		totalByteSize += int64(len(header.buf) + len(page))
+
+		chunks.begin()
+		chunks.i64Field(2, offset)
+		chunks.structField(3, func() {
+			chunks.i32Field(1, int32(physical))
+			chunks.listField(2, thriftI32, 2)
+			chunks.zigzag(parquetEncodingPlain)
+			chunks.zigzag(parquetEncodingRLE)
+			chunks.listField(3, thriftBinary, 1)
+			chunks.binary([]byte(name))
+			chunks.i32Field(4, int32(w.codec))
+			chunks.i64Field(5, int64(len(w.rows)))
+			chunks.i64Field(6, int64(len(header.buf)+len(page)))
+			chunks.i64Field(7, int64(len(header.buf)+len(compressed)))
+			chunks.i64Field(9, offset)
+			chunks.structField(12, func() {
+				chunks.i64Field(3, stats.nullCount)
+				if stats.hasMinMax {
+					chunks.binaryField(5, stats.max)
+					chunks.binaryField(6, stats.min)
+				}
+			})
+		})
+		chunks.end()
+	}
+
+	meta := &thriftWriter{}
+	meta.begin()
+	meta.i32Field(1, 1)
+	meta.listField(2, thriftStructType, len(w.columns)+1)
+	meta.begin()
+	meta.binaryField(4, []byte("schema"))
+	meta.i32Field(5, int32(len(w.columns)))
+	meta.end()
+	for i, name := range w.columns {
+		physical, converted := w.physical(i)
+		meta.begin()
+		meta.i32Field(1, int32(physical))
+		meta.i32Field(3, parquetOptional)
+		meta.binaryField(4, []byte(name))
+		if converted >= 0 {
+			meta.i32Field(6, int32(converted))
+		}
+		meta.end()
+	}
+	meta.i64Field(3, int64(len(w.rows)))
+	meta.listField(4, thriftStructType, 1)
+	meta.begin()
+	meta.fieldHeader(1, thriftList)
+	meta.buf = append(meta.buf, chunks.buf...)
+	meta.i64Field(2, totalByteSize)
+	meta.i64Field(3, int64(len(w.rows)))
+	meta.end()
+	meta.binaryField(6, []byte("s3-select-sql-driver"))
+	meta.end()
+
+	buf.Write(meta.buf)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(meta.buf)))
+	buf.Write(size[:])
+	buf.Write(parquetMagic)
+	return buf.Bytes(), nil
+}
+
+// page encodes definition levels and values of the column in plain encoding, and returns statistics of it.
+func (w *parquetWriter) page(index int, physical int) ([]byte, parquetStatistics) {
+	var stats parquetStatistics
+	var levels, values []byte
+	var minValue, maxValue interface{}
+	var bits, nbits int
+	// definition levels are RLE runs of bit width 1
+	var run, last int
+	flush := func() {
+		if run == 0 {
+			return
+		}
+		levels = appendUvarint(levels, uint64(run)<<1)
+		levels = append(levels, byte(last))
+	}
+	for _, row := range w.rows {
+		v := row[index]
+		level := 1
+		if v == nil {
+			level = 0
+			stats.nullCount++
+		}
+		if level != last || run == 0 {
+			flush()
+			run, last = 0, level
+		}
+		run++
+		if v == nil {
+			continue
+		}
+		if minValue == nil || parquetLess(v, minValue) {
+			minValue = v
+		}
+		if maxValue == nil || parquetLess(maxValue, v) {
+			maxValue = v
+		}
+		switch physical {
+		case parquetBoolean:
+			if v.(bool) {
+				bits |= 1 << nbits
+			}
+			nbits++
+			if nbits == 8 {
+				values = append(values, byte(bits))
+				bits, nbits = 0, 0
+			}
+		default:
+			values = appendParquetPlain(values, v)
+		}
+	}
+	flush()
+	if nbits > 0 {
+		values = append(values, byte(bits))
+	}
+	if minValue != nil && physical != parquetBoolean {
+		stats.min = appendParquetPlain(nil, minValue)
+		stats.max = appendParquetPlain(nil, maxValue)
+		if physical == parquetByteArray {
+			// statistics of byte arrays have no length prefix
+			stats.min, stats.max = stats.min[4:], stats.max[4:]
+		}
+		stats.hasMinMax = true
+	}
+	page := make([]byte, 4, 4+len(levels)+len(values))
+	binary.LittleEndian.PutUint32(page, uint32(len(levels)))
+	page = append(page, levels...)
+	return append(page, values...), stats
+}
+
+func appendParquetPlain(bs []byte, v interface{}) []byte {
+	var b [8]byte
+	switch v := v.(type) {
+	case int64:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		return append(bs, b[:]...)
+	case float64:
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		return append(bs, b[:]...)
+	case string:
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(v)))
+		bs = append(bs, b[:4]...)
+		return append(bs, v...)
+	}
+	return bs
+}
+
+func parquetLess(a, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		return a < b.(int64)
+	case float64:
+		return a < b.(float64)
+	case string:
+		return a < b.(string)
+	}
+	return false
+}
+
+func appendUvarint(bs []byte, x uint64) []byte {
+	for x >= 0x80 {
+		bs = append(bs, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(bs, byte(x))
+}
+
+// thriftWriter encodes thrift compact protocol, the inverse of thriftReader.
+type thriftWriter struct {
+	buf []byte
+	// last are the last field ids of nested structs.
+	last []int16
+}
+
+func (w *thriftWriter) begin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.buf = append(w.buf, thriftStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) zigzag(x int64) {
+	w.buf = appendUvarint(w.buf, uint64(x<<1)^uint64(x>>63))
+}
+
+func (w *thriftWriter) binary(bs []byte) {
+	w.buf = appendUvarint(w.buf, uint64(len(bs)))
+	w.buf = append(w.buf, bs...)
+}
+
+func (w *thriftWriter) i32Field(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64Field(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) binaryField(id int16, bs []byte) {
+	w.fieldHeader(id, thriftBinary)
+	w.binary(bs)
+}
+
+func (w *thriftWriter) structField(id int16, fn func()) {
+	w.fieldHeader(id, thriftStructType)
+	w.begin()
+	fn()
+	w.end()
+}
+
+// listField writes the header of the list field, elements are written after it.
+func (w *thriftWriter) listField(id int16, elem byte, size int) {
+	w.fieldHeader(id, thriftList)
+	w.listHeader(elem, size)
+}
+
+func (w *thriftWriter) listHeader(elem byte, size int) {
+	if size < 15 {
+		w.buf = append(w.buf, byte(size)<<4|elem)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elem)
+	w.buf = appendUvarint(w.buf, uint64(size))
+}
@@ -0,0 +1,143 @@
+package s3selectsqldriver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+// tokenParser reads significant tokens of a query, it is the base of parsers of local queries and DDL statements.
+type tokenParser struct {
+	tokens []lexer.Token
+	pos    int
+	// raw is tokens with spaces and comments, offsets[i] is the index of tokens[i] in raw
+	// and offsets[len(tokens)] is the end of the last significant token.
+	raw     lexer.Tokens
+	offsets []int
+}
+
+func newTokenParser(query string) (tokenParser, error) {
+	raw, err := lexer.NewLexer(query).Lex()
+	if err != nil {
+		return tokenParser{}, err
+	}
+	p := tokenParser{raw: raw, offsets: make([]int, 0, len(raw)+1)}
+	var end int
+	for i, token := range raw {
+		switch token.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment, lexer.KindEOF:
+			continue
+		}
+		end = i + 1
+		if token.Kind == lexer.KindSymbol && len(p.tokens) > 0 {
+			last := &p.tokens[len(p.tokens)-1]
+			if last.Kind == lexer.KindSymbol {
+				switch last.Value + token.Value {
+				case ">=", "<=", "<>", "!=":
+					last.Value += token.Value
+					continue
+				}
+			}
+		}
+		p.tokens = append(p.tokens, token)
+		p.offsets = append(p.offsets, i)
+	}
+	p.offsets = append(p.offsets, end)
+	return p, nil
+}
+
+// significantTokens returns tokens without spaces and comments, and merges comparison operators such as `>=`.
+func significantTokens(query string) ([]lexer.Token, error) {
+	p, err := newTokenParser(query)
+	if err != nil {
+		return nil, err
+	}
+	return p.tokens, nil
+}
+
+// truncate drops tokens from the index, such as the trailing `;` of a statement.
+func (p *tokenParser) truncate(end int) {
+	p.tokens = p.tokens[:end]
+	p.offsets = p.offsets[:end+1]
+}
+
+// source returns the query text of tokens[start:end] as is, with spaces and comments between them.
+func (p *tokenParser) source(start, end int) string {
+	if start >= end {
+		return ""
+	}
+	return p.raw[p.offsets[start]:p.offsets[end]].String()
+}
+
+func (p *tokenParser) peek() lexer.Token {
+	if p.pos >= len(p.tokens) {
+		return lexer.Token{Kind: lexer.KindEOF}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *tokenParser) next() lexer.Token {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *tokenParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *tokenParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.Kind == lexer.KindIdentifier && strings.EqualFold(token.Value, keyword)
+}
+
+func (p *tokenParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tokenParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+	return nil
+}
+
+func (p *tokenParser) isSymbol(symbol string) bool {
+	token := p.peek()
+	return token.Kind == lexer.KindSymbol && token.Value == symbol
+}
+
+func (p *tokenParser) acceptSymbol(symbol string) bool {
+	if p.isSymbol(symbol) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tokenParser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected(symbol)
+	}
+	return nil
+}
+
+// unexpected returns the error of the next token, expected is omitted if it is empty.
+func (p *tokenParser) unexpected(expected string) error {
+	token := p.peek()
+	var msg string
+	if token.Kind == lexer.KindEOF {
+		msg = "unexpected end of query"
+	} else {
+		msg = fmt.Sprintf("unexpected %q", token.Value)
+	}
+	if expected == "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s, expected %s", msg, expected)
+}
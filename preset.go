@@ -0,0 +1,442 @@
+package s3selectsqldriver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+// presets of AWS log formats.
+const (
+	S3SelectFormatCloudTrail S3SelectFormat = "cloudtrail"
+	S3SelectFormatALB        S3SelectFormat = "alb"
+	S3SelectFormatCloudFront S3SelectFormat = "cloudfront"
+	S3SelectFormatVPCFlow    S3SelectFormat = "vpcflow"
+	S3SelectFormatS3Access   S3SelectFormat = "s3access"
+	S3SelectFormatWAF        S3SelectFormat = "waf"
+)
+
+type columnType int
+
+const (
+	columnTypeInt columnType = iota + 1
+	columnTypeFloat
+	columnTypeTimestamp
+	columnTypeUnixSeconds
+	columnTypeUnixMillis
+)
+
+type presetColumn struct {
+	typ columnType
+	// layout is the time layout of columnTypeTimestamp
+	layout string
+}
+
+type logPreset struct {
+	// base is the underlying format
+	base            S3SelectFormat
+	compressionType S3SelectCompressionType
+	csv             *types.CSVInput
+	// columns are names of _1.._N, names which are reserved words (end, time, key...) are rewritten only as s.name
+	columns   []string
+	types     map[string]presetColumn
+	fromRoot  string
+	recursive bool
+	prefix    func(loc LogLocation) (string, error)
+}
+
+// LogLocation is the location of AWS logs in the standard key layout.
+type LogLocation struct {
+	// Prefix is the prefix set at the log destination, such as `logs/`.
+	Prefix    string
+	AccountID string
+	Region    string
+	Date      time.Time
+	// Name is the distribution id of CloudFront, the web ACL name of WAF, or the source bucket of partitioned S3 access logs.
+	Name string
+}
+
+var logPresets = map[S3SelectFormat]*logPreset{
+	S3SelectFormatCloudTrail: {
+		compressionType: S3SelectCompressionTypeGzip,
+		base:            S3SelectFormatJSON,
+		fromRoot:        "S3Object[*].Records[*]",
+		types: map[string]presetColumn{
+			"eventTime": {typ: columnTypeTimestamp, layout: time.RFC3339},
+		},
+		prefix: func(loc LogLocation) (string, error) {
+			if err := loc.require(true, true, false); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%sAWSLogs/%s/CloudTrail/%s/%s/", loc.Prefix, loc.AccountID, loc.Region, loc.Date.Format("2006/01/02")), nil
+		},
+	},
+	S3SelectFormatALB: {
+		compressionType: S3SelectCompressionTypeGzip,
+		base:            S3SelectFormatCSV,
+		csv: &types.CSVInput{
+			FileHeaderInfo:  types.FileHeaderInfoNone,
+			FieldDelimiter:  aws.String(" "),
+			RecordDelimiter: aws.String("\n"),
+			QuoteCharacter:  aws.String(`"`),
+		},
+		columns: []string{
+			"type", "time", "elb", "client_port", "target_port",
+			"request_processing_time", "target_processing_time", "response_processing_time",
+			"elb_status_code", "target_status_code", "received_bytes", "sent_bytes",
+			"request", "user_agent", "ssl_cipher", "ssl_protocol", "target_group_arn", "trace_id",
+			"domain_name", "chosen_cert_arn", "matched_rule_priority", "request_creation_time",
+			"actions_executed", "redirect_url", "error_reason", "target_port_list", "target_status_code_list",
+			"classification", "classification_reason",
+		},
+		types: map[string]presetColumn{
+			"time":                     {typ: columnTypeTimestamp, layout: time.RFC3339Nano},
+			"request_processing_time":  {typ: columnTypeFloat},
+			"target_processing_time":   {typ: columnTypeFloat},
+			"response_processing_time": {typ: columnTypeFloat},
+			"elb_status_code":          {typ: columnTypeInt},
+			"target_status_code":       {typ: columnTypeInt},
+			"received_bytes":           {typ: columnTypeInt},
+			"sent_bytes":               {typ: columnTypeInt},
+			"matched_rule_priority":    {typ: columnTypeInt},
+			"request_creation_time":    {typ: columnTypeTimestamp, layout: time.RFC3339Nano},
+		},
+		prefix: func(loc LogLocation) (string, error) {
+			if err := loc.require(true, true, false); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%sAWSLogs/%s/elasticloadbalancing/%s/%s/", loc.Prefix, loc.AccountID, loc.Region, loc.Date.Format("2006/01/02")), nil
+		},
+	},
+	S3SelectFormatCloudFront: {
+		compressionType: S3SelectCompressionTypeGzip,
+		base:            S3SelectFormatCSV,
+		csv: &types.CSVInput{
+			FileHeaderInfo:  types.FileHeaderInfoNone,
+			FieldDelimiter:  aws.String("\t"),
+			RecordDelimiter: aws.String("\n"),
+			Comments:        aws.String("#"),
+		},
+		columns: []string{
+			"date", "time", "x_edge_location", "sc_bytes", "c_ip", "cs_method", "cs_host", "cs_uri_stem",
+			"sc_status", "cs_referer", "cs_user_agent", "cs_uri_query", "cs_cookie", "x_edge_result_type",
+			"x_edge_request_id", "x_host_header", "cs_protocol", "cs_bytes", "time_taken", "x_forwarded_for",
+			"ssl_protocol", "ssl_cipher", "x_edge_response_result_type", "cs_protocol_version", "fle_status",
+			"fle_encrypted_fields", "c_port", "time_to_first_byte", "x_edge_detailed_result_type",
+			"sc_content_type", "sc_content_len", "sc_range_start", "sc_range_end",
+		},
+		types: map[string]presetColumn{
+			"date":               {typ: columnTypeTimestamp, layout: "2006-01-02"},
+			"sc_bytes":           {typ: columnTypeInt},
+			"sc_status":          {typ: columnTypeInt},
+			"cs_bytes":           {typ: columnTypeInt},
+			"time_taken":         {typ: columnTypeFloat},
+			"c_port":             {typ: columnTypeInt},
+			"time_to_first_byte": {typ: columnTypeFloat},
+			"sc_content_len":     {typ: columnTypeInt},
+			"sc_range_start":     {typ: columnTypeInt},
+			"sc_range_end":       {typ: columnTypeInt},
+		},
+		prefix: func(loc LogLocation) (string, error) {
+			if loc.Name == "" || loc.Date.IsZero() {
+				return "", errors.New("cloudfront log location requires log_name (distribution id) and log_date")
+			}
+			return fmt.Sprintf("%s%s.%s-", loc.Prefix, loc.Name, loc.Date.Format("2006-01-02")), nil
+		},
+	},
+	S3SelectFormatVPCFlow: {
+		compressionType: S3SelectCompressionTypeGzip,
+		base:            S3SelectFormatCSV,
+		csv: &types.CSVInput{
+			FileHeaderInfo:  types.FileHeaderInfoIgnore,
+			FieldDelimiter:  aws.String(" "),
+			RecordDelimiter: aws.String("\n"),
+		},
+		columns: []string{
+			"version", "account_id", "interface_id", "srcaddr", "dstaddr", "srcport", "dstport",
+			"protocol", "packets", "bytes", "start", "end", "action", "log_status",
+		},
+		types: map[string]presetColumn{
+			"version":  {typ: columnTypeInt},
+			"srcport":  {typ: columnTypeInt},
+			"dstport":  {typ: columnTypeInt},
+			"protocol": {typ: columnTypeInt},
+			"packets":  {typ: columnTypeInt},
+			"bytes":    {typ: columnTypeInt},
+			"start":    {typ: columnTypeUnixSeconds},
+			"end":      {typ: columnTypeUnixSeconds},
+		},
+		prefix: func(loc LogLocation) (string, error) {
+			if err := loc.require(true, true, false); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%sAWSLogs/%s/vpcflowlogs/%s/%s/", loc.Prefix, loc.AccountID, loc.Region, loc.Date.Format("2006/01/02")), nil
+		},
+	},
+	S3SelectFormatS3Access: {
+		compressionType: S3SelectCompressionTypeNone,
+		base:            S3SelectFormatCSV,
+		csv: &types.CSVInput{
+			FileHeaderInfo:  types.FileHeaderInfoNone,
+			FieldDelimiter:  aws.String(" "),
+			RecordDelimiter: aws.String("\n"),
+			QuoteCharacter:  aws.String(`"`),
+		},
+		// time is split into time and time_offset, such as `[06/Feb/2019:00:00:38` and `+0000]`
+		columns: []string{
+			"bucket_owner", "bucket", "time", "time_offset", "remote_ip", "requester", "request_id",
+			"operation", "key", "request_uri", "http_status", "error_code", "bytes_sent", "object_size",
+			"total_time", "turn_around_time", "referrer", "user_agent", "version_id", "host_id",
+			"signature_version", "cipher_suite", "authentication_type", "host_header", "tls_version",
+			"access_point_arn", "acl_required",
+		},
+		types: map[string]presetColumn{
+			"time":             {typ: columnTypeTimestamp, layout: "[02/Jan/2006:15:04:05"},
+			"http_status":      {typ: columnTypeInt},
+			"bytes_sent":       {typ: columnTypeInt},
+			"object_size":      {typ: columnTypeInt},
+			"total_time":       {typ: columnTypeInt},
+			"turn_around_time": {typ: columnTypeInt},
+		},
+		prefix: func(loc LogLocation) (string, error) {
+			if loc.Date.IsZero() {
+				return "", errors.New("s3access log location requires log_date")
+			}
+			if loc.AccountID == "" {
+				// simple prefix: [prefix][YYYY]-[MM]-[DD]-[hh]-[mm]-[ss]-[UniqueString]
+				return fmt.Sprintf("%s%s-", loc.Prefix, loc.Date.Format("2006-01-02")), nil
+			}
+			if loc.Region == "" || loc.Name == "" {
+				return "", errors.New("partitioned s3access log location requires account_id, log_region and log_name (source bucket)")
+			}
+			return fmt.Sprintf("%s%s/%s/%s/%s/", loc.Prefix, loc.AccountID, loc.Region, loc.Name, loc.Date.Format("2006/01/02")), nil
+		},
+	},
+	S3SelectFormatWAF: {
+		compressionType: S3SelectCompressionTypeGzip,
+		base:            S3SelectFormatJSONL,
+		types: map[string]presetColumn{
+			"timestamp": {typ: columnTypeUnixMillis},
+		},
+		// WAF logs are partitioned by hour and minute under the date
+		recursive: true,
+		prefix: func(loc LogLocation) (string, error) {
+			if err := loc.require(true, true, true); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%sAWSLogs/%s/WAFLogs/%s/%s/%s/", loc.Prefix, loc.AccountID, loc.Region, loc.Name, loc.Date.Format("2006/01/02")), nil
+		},
+	},
+}
+
+func (loc LogLocation) require(accountID, region, name bool) error {
+	var missing []string
+	if accountID && loc.AccountID == "" {
+		missing = append(missing, "account_id")
+	}
+	if region && loc.Region == "" {
+		missing = append(missing, "log_region")
+	}
+	if name && loc.Name == "" {
+		missing = append(missing, "log_name")
+	}
+	if loc.Date.IsZero() {
+		missing = append(missing, "log_date")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("log location requires %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// LogPrefix returns the key prefix of the AWS log format in the standard key layout.
+//
+//	prefix, err := s3selectsqldriver.LogPrefix(s3selectsqldriver.S3SelectFormatCloudTrail, s3selectsqldriver.LogLocation{
+//		AccountID: "123456789012",
+//		Region:    "us-east-1",
+//		Date:      time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+//	})
+//	// AWSLogs/123456789012/CloudTrail/us-east-1/2024/05/01/
+func LogPrefix(format S3SelectFormat, loc LogLocation) (string, error) {
+	preset, ok := logPresets[format]
+	if !ok {
+		return "", fmt.Errorf("format %s is not a log format preset", format)
+	}
+	return preset.prefix(loc)
+}
+
+func (cfg *S3SelectConfig) logPreset() *logPreset {
+	return logPresets[cfg.Format]
+}
+
+// setLogLocationParams sets ObjectKeyPrefix by account_id, log_region, log_date and log_name.
+// the params are removed, because the prefix is kept in the DSN path.
+func (cfg *S3SelectConfig) setLogLocationParams(params url.Values) error {
+	names := []string{"account_id", "log_region", "log_date", "log_name"}
+	var found bool
+	for _, name := range names {
+		if params.Has(name) {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	if cfg.logPreset() == nil {
+		return errors.New("account_id, log_region, log_date and log_name require log format preset")
+	}
+	if cfg.ObjectKey != "" || len(cfg.Sources) > 0 {
+		return errors.New("log location requires a single prefix")
+	}
+	loc := LogLocation{
+		Prefix:    cfg.ObjectKeyPrefix,
+		AccountID: params.Get("account_id"),
+		Region:    params.Get("log_region"),
+		Name:      params.Get("log_name"),
+	}
+	if params.Has("log_date") {
+		date, err := time.Parse("2006-01-02", params.Get("log_date"))
+		if err != nil {
+			return fmt.Errorf("parse log_date: %w", err)
+		}
+		loc.Date = date
+	}
+	prefix, err := LogPrefix(cfg.Format, loc)
+	if err != nil {
+		return err
+	}
+	cfg.ObjectKeyPrefix = prefix
+	for _, name := range names {
+		cfg.Params.Del(name)
+	}
+	return nil
+}
+
+// newPresetInputSerialization returns InputSerialization of the preset, without compression type.
+func (preset *logPreset) newInputSerialization() *types.InputSerialization {
+	switch preset.base {
+	case S3SelectFormatJSON:
+		return &types.InputSerialization{
+			JSON: &types.JSONInput{Type: types.JSONTypeDocument},
+		}
+	case S3SelectFormatJSONL:
+		return &types.InputSerialization{
+			JSON: &types.JSONInput{Type: types.JSONTypeLines},
+		}
+	}
+	csvInput := *preset.csv
+	return &types.InputSerialization{
+		CSV: &csvInput,
+	}
+}
+
+// columnNames returns ColumnNames of the config, or columns of the log format preset.
+func (cfg *S3SelectConfig) columnNames() []string {
+	if len(cfg.ColumnNames) > 0 {
+		return cfg.ColumnNames
+	}
+	if preset := cfg.logPreset(); preset != nil && cfg.InputSerialization == nil {
+		return preset.columns
+	}
+	return nil
+}
+
+// rewriteFromRoot rewrites `FROM S3Object` to the JSON root of the preset, such as `FROM S3Object[*].Records[*]`.
+func rewriteFromRoot(query string, root string) (string, error) {
+	l := lexer.NewLexer(query)
+	tokens, err := l.Lex()
+	if err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	var afterFrom bool
+	for idx, token := range tokens {
+		switch token.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+			builder.WriteString(token.Value)
+			continue
+		case lexer.KindIdentifier:
+			if afterFrom && strings.EqualFold(token.Value, "S3Object") && !isFollowedBy(tokens, idx, "[") {
+				builder.WriteString(root)
+				afterFrom = false
+				continue
+			}
+		}
+		afterFrom = token.Kind == lexer.KindIdentifier && strings.EqualFold(token.Value, "FROM")
+		builder.WriteString(token.Value)
+	}
+	return builder.String(), nil
+}
+
+func isFollowedBy(tokens []lexer.Token, idx int, symbol string) bool {
+	if idx+1 >= len(tokens) {
+		return false
+	}
+	next := tokens[idx+1]
+	return next.Kind == lexer.KindSymbol && next.Value == symbol
+}
+
+// convertPresetColumns converts string values of typed columns of the preset. "-" and empty values are NULL.
+func (cfg *S3SelectConfig) convertPresetColumns(columns []string, rows [][]interface{}) {
+	preset := cfg.logPreset()
+	if preset == nil || cfg.InputSerialization != nil {
+		return
+	}
+	for i, column := range columns {
+		typ, ok := preset.types[column]
+		if !ok {
+			continue
+		}
+		for _, row := range rows {
+			if i >= len(row) {
+				continue
+			}
+			row[i] = convertPresetValue(row[i], typ)
+		}
+	}
+}
+
+func convertPresetValue(v interface{}, typ presetColumn) interface{} {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = strings.TrimSpace(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return v
+	}
+	if s == "" || s == "-" {
+		return nil
+	}
+	switch typ.typ {
+	case columnTypeInt:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case columnTypeFloat:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case columnTypeTimestamp:
+		if t, err := time.Parse(typ.layout, s); err == nil {
+			return t
+		}
+	case columnTypeUnixSeconds:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(n, 0).UTC()
+		}
+	case columnTypeUnixMillis:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.UnixMilli(n).UTC()
+		}
+	}
+	return v
+}
@@ -0,0 +1,220 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogPrefix(t *testing.T) {
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		format   S3SelectFormat
+		loc      LogLocation
+		expected string
+		errorMsg string
+	}{
+		{
+			format:   S3SelectFormatCloudTrail,
+			loc:      LogLocation{AccountID: "123456789012", Region: "us-east-1", Date: date},
+			expected: "AWSLogs/123456789012/CloudTrail/us-east-1/2024/05/01/",
+		},
+		{
+			format:   S3SelectFormatALB,
+			loc:      LogLocation{Prefix: "alb/", AccountID: "123456789012", Region: "us-east-1", Date: date},
+			expected: "alb/AWSLogs/123456789012/elasticloadbalancing/us-east-1/2024/05/01/",
+		},
+		{
+			format:   S3SelectFormatVPCFlow,
+			loc:      LogLocation{AccountID: "123456789012", Region: "us-east-1", Date: date},
+			expected: "AWSLogs/123456789012/vpcflowlogs/us-east-1/2024/05/01/",
+		},
+		{
+			format:   S3SelectFormatWAF,
+			loc:      LogLocation{AccountID: "123456789012", Region: "us-east-1", Name: "my-acl", Date: date},
+			expected: "AWSLogs/123456789012/WAFLogs/us-east-1/my-acl/2024/05/01/",
+		},
+		{
+			format:   S3SelectFormatCloudFront,
+			loc:      LogLocation{Prefix: "cf/", Name: "E2EXAMPLE", Date: date},
+			expected: "cf/E2EXAMPLE.2024-05-01-",
+		},
+		{
+			format:   S3SelectFormatS3Access,
+			loc:      LogLocation{Prefix: "access/", Date: date},
+			expected: "access/2024-05-01-",
+		},
+		{
+			format:   S3SelectFormatS3Access,
+			loc:      LogLocation{Prefix: "access/", AccountID: "123456789012", Region: "us-east-1", Name: "source-bucket", Date: date},
+			expected: "access/123456789012/us-east-1/source-bucket/2024/05/01/",
+		},
+		{
+			format:   S3SelectFormatWAF,
+			loc:      LogLocation{AccountID: "123456789012"},
+			errorMsg: "log location requires log_region, log_name, log_date",
+		},
+		{
+			format:   S3SelectFormatCSV,
+			loc:      LogLocation{Date: date},
+			errorMsg: "format csv is not a log format preset",
+		},
+	}
+	for _, c := range cases {
+		t.Run(string(c.format)+":"+c.expected, func(t *testing.T) {
+			actual, err := LogPrefix(c.format, c.loc)
+			if c.errorMsg != "" {
+				require.EqualError(t, err, c.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestRewriteFromRoot(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "SELECT s.eventName FROM S3Object s",
+			expected: "SELECT s.eventName FROM S3Object[*].Records[*] s",
+		},
+		{
+			query:    "select * from s3object",
+			expected: "select * from S3Object[*].Records[*]",
+		},
+		{
+			query:    "SELECT * FROM S3Object[*].Records[0] s",
+			expected: "SELECT * FROM S3Object[*].Records[0] s",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			actual, err := rewriteFromRoot(c.query, "S3Object[*].Records[*]")
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestPresetColumnNames(t *testing.T) {
+	cases := []struct {
+		format   S3SelectFormat
+		query    string
+		expected string
+	}{
+		{
+			format:   S3SelectFormatVPCFlow,
+			query:    `SELECT CASE WHEN s.action = 'REJECT' THEN s.bytes ELSE 0 END AS rejected, s."end" FROM S3Object s WHERE s.start > 0`,
+			expected: `SELECT CASE WHEN s._13 = 'REJECT' THEN s._10 ELSE 0 END AS rejected, s._12 FROM S3Object s WHERE s._11 > 0`,
+		},
+		{
+			format:   S3SelectFormatALB,
+			query:    `SELECT s.time, CASE s.type WHEN 'h2' THEN 1 ELSE 0 END FROM S3Object s WHERE s.elb_status_code >= '500'`,
+			expected: `SELECT s._2, CASE s._1 WHEN 'h2' THEN 1 ELSE 0 END FROM S3Object s WHERE s._9 >= '500'`,
+		},
+		{
+			format:   S3SelectFormatS3Access,
+			query:    `SELECT s.key FROM S3Object s WHERE s.key LIKE 'data/%' AND s.http_status BETWEEN '500' AND '599'`,
+			expected: `SELECT s._9 FROM S3Object s WHERE s._9 LIKE 'data/%' AND s._11 BETWEEN '500' AND '599'`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(string(c.format), func(t *testing.T) {
+			cfg := &S3SelectConfig{Format: c.format}
+			actual, err := rewriteColumnNames(c.query, cfg.columnNames())
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestMock__PresetALB(t *testing.T) {
+	mockClients["preset_alb"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			require.Equal(t, "AWSLogs/123456789012/elasticloadbalancing/us-east-1/2024/05/01/", *params.Prefix)
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("AWSLogs/123456789012/elasticloadbalancing/us-east-1/2024/05/01/log.gz")},
+				},
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.Equal(t, "SELECT s._2, s._9, s._6 FROM S3Object s", *params.Expression)
+			require.Equal(t, types.CompressionTypeGzip, params.InputSerialization.CompressionType)
+			require.Equal(t, types.FileHeaderInfoNone, params.InputSerialization.CSV.FileHeaderInfo)
+			require.Equal(t, " ", *params.InputSerialization.CSV.FieldDelimiter)
+			fmt.Fprintln(w, `{"_2":"2024-05-01T00:00:01.123456Z","_9":"200","_6":"0.001"}`)
+			fmt.Fprintln(w, `{"_2":"2024-05-01T00:00:02.000000Z","_9":"-","_6":"-1"}`)
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/?format=alb&account_id=123456789012&log_region=us-east-1&log_date=2024-05-01&mock=preset_alb")
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.QueryContext(context.Background(), `SELECT s.time, s.elb_status_code, s.request_processing_time FROM S3Object s`)
+	require.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"time", "elb_status_code", "request_processing_time"}, columns)
+	type record struct {
+		Time       time.Time
+		StatusCode sql.NullInt64
+		Processing float64
+	}
+	var actual []record
+	for rows.Next() {
+		var r record
+		require.NoError(t, rows.Scan(&r.Time, &r.StatusCode, &r.Processing))
+		actual = append(actual, r)
+	}
+	require.Equal(t, []record{
+		{
+			Time:       time.Date(2024, 5, 1, 0, 0, 1, 123456000, time.UTC),
+			StatusCode: sql.NullInt64{Int64: 200, Valid: true},
+			Processing: 0.001,
+		},
+		{
+			Time:       time.Date(2024, 5, 1, 0, 0, 2, 0, time.UTC),
+			Processing: -1,
+		},
+	}, actual)
+}
+
+func TestMock__PresetCloudTrail(t *testing.T) {
+	mockClients["preset_cloudtrail"] = &mockS3SelectClient{
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			require.Equal(t, "SELECT r.eventTime, r.eventName FROM S3Object[*].Records[*] r", *params.Expression)
+			require.Equal(t, types.JSONTypeDocument, params.InputSerialization.JSON.Type)
+			require.Equal(t, types.CompressionTypeGzip, params.InputSerialization.CompressionType)
+			fmt.Fprintln(w, `{"eventTime":"2024-05-01T00:00:00Z","eventName":"GetObject"}`)
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/trail.json.gz?format=cloudtrail&mock=preset_cloudtrail")
+	require.NoError(t, err)
+	defer db.Close()
+	var eventTime time.Time
+	var eventName string
+	err = db.QueryRowContext(context.Background(), `SELECT r.eventTime, r.eventName FROM S3Object r`).Scan(&eventTime, &eventName)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), eventTime)
+	require.Equal(t, "GetObject", eventName)
+}
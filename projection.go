@@ -0,0 +1,828 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+// maxPartitions is the max number of partitions derived from key_template for a query.
+const maxPartitions = 10000
+
+type projectionType string
+
+const (
+	projectionTypeDate projectionType = "date"
+	projectionTypeEnum projectionType = "enum"
+	projectionTypeInt  projectionType = "int"
+	// projectionTypeFloat and projectionTypeString are not for key_template, but for statistics of objects.
+	projectionTypeFloat projectionType = "float"
+	// projectionTypeString accepts only string literals, unlike enum.
+	projectionTypeString projectionType = "string"
+)
+
+// keyTemplate is the parsed key_template, such as `logs/{dt:date:yyyy/MM/dd/HH:1h}/{region:enum:us-east-1|ap-northeast-1}/`.
+type keyTemplate struct {
+	// parts are literals and placeholders in order, placeholders are empty.
+	parts   []string
+	columns []*projectedColumn
+	// positions are indexes of columns in parts.
+	positions []int
+}
+
+type projectedColumn struct {
+	name string
+	typ  projectionType
+	// format is the original format of date, such as yyyy/MM/dd, and layout is Go time layout of it.
+	format string
+	layout string
+	step   time.Duration
+	values []string
+	min    int64
+	max    int64
+	digits int
+}
+
+type projectedValue struct {
+	column *projectedColumn
+	value  interface{}
+}
+
+// partition is a prefix derived from key_template and the values of projected columns.
+type partition struct {
+	// bucket is set for partitions of Glue, empty means the bucket of DSN.
+	bucket string
+	prefix string
+	values []projectedValue
+}
+
+var dateFormatReplacer = strings.NewReplacer(
+	"yyyy", "2006",
+	"MM", "01",
+	"dd", "02",
+	"HH", "15",
+	"mm", "04",
+	"ss", "05",
+)
+
+func parseKeyTemplate(s string) (*keyTemplate, error) {
+	tmpl := &keyTemplate{}
+	seen := make(map[string]bool)
+	rest := s
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			tmpl.parts = append(tmpl.parts, rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("placeholder is not closed: %s", rest[start:])
+		}
+		if start > 0 {
+			tmpl.parts = append(tmpl.parts, rest[:start])
+		}
+		column, err := parseProjectedColumn(rest[start+1 : start+end])
+		if err != nil {
+			return nil, err
+		}
+		if seen[strings.ToLower(column.name)] {
+			return nil, fmt.Errorf("placeholder %s is duplicated", column.name)
+		}
+		seen[strings.ToLower(column.name)] = true
+		tmpl.positions = append(tmpl.positions, len(tmpl.parts))
+		tmpl.parts = append(tmpl.parts, "")
+		tmpl.columns = append(tmpl.columns, column)
+		rest = rest[start+end+1:]
+	}
+	if len(tmpl.columns) == 0 {
+		return nil, errors.New("key_template has no placeholder")
+	}
+	return tmpl, nil
+}
+
+// parseProjectedColumn parses the placeholder `name:type:args`.
+//
+//	{dt:date:yyyy/MM/dd/HH:1h}
+//	{region:enum:us-east-1|ap-northeast-1}
+//	{shard:int:0-15:2}
+func parseProjectedColumn(s string) (*projectedColumn, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) < 3 || fields[0] == "" {
+		return nil, fmt.Errorf("placeholder {%s} must be {name:type:args}", s)
+	}
+	column := &projectedColumn{
+		name: fields[0],
+		typ:  projectionType(strings.ToLower(fields[1])),
+	}
+	args := fields[2:]
+	switch column.typ {
+	case projectionTypeDate:
+		if len(args) > 2 {
+			return nil, fmt.Errorf("placeholder {%s}: date must be {name:date:format[:step]}", s)
+		}
+		column.format = args[0]
+		column.layout = dateFormatReplacer.Replace(args[0])
+		if len(args) == 2 {
+			step, err := parseProjectionStep(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("placeholder {%s}: %w", s, err)
+			}
+			column.step = step
+		} else {
+			column.step = defaultProjectionStep(args[0])
+		}
+	case projectionTypeEnum:
+		if len(args) != 1 || args[0] == "" {
+			return nil, fmt.Errorf("placeholder {%s}: enum must be {name:enum:value1|value2}", s)
+		}
+		column.values = strings.Split(args[0], "|")
+	case projectionTypeInt:
+		if len(args) > 2 {
+			return nil, fmt.Errorf("placeholder {%s}: int must be {name:int:min-max[:digits]}", s)
+		}
+		minStr, maxStr, ok := strings.Cut(args[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("placeholder {%s}: int must be {name:int:min-max[:digits]}", s)
+		}
+		var err error
+		if column.min, err = strconv.ParseInt(minStr, 10, 64); err != nil {
+			return nil, fmt.Errorf("placeholder {%s}: parse min: %w", s, err)
+		}
+		if column.max, err = strconv.ParseInt(maxStr, 10, 64); err != nil {
+			return nil, fmt.Errorf("placeholder {%s}: parse max: %w", s, err)
+		}
+		if column.min > column.max {
+			return nil, fmt.Errorf("placeholder {%s}: min is greater than max", s)
+		}
+		if len(args) == 2 {
+			if column.digits, err = strconv.Atoi(args[1]); err != nil {
+				return nil, fmt.Errorf("placeholder {%s}: parse digits: %w", s, err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("placeholder {%s}: unknown type %s", s, fields[1])
+	}
+	return column, nil
+}
+
+// parseProjectionStep parses the step of date, Go duration or days such as `1d`.
+func parseProjectionStep(s string) (time.Duration, error) {
+	var step time.Duration
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("parse step: %w", err)
+		}
+		step = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if step, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("parse step: %w", err)
+		}
+	}
+	if step <= 0 {
+		return 0, errors.New("step must be positive")
+	}
+	return step, nil
+}
+
+// defaultProjectionStep is the smallest unit of the format.
+func defaultProjectionStep(format string) time.Duration {
+	switch {
+	case strings.Contains(format, "ss"):
+		return time.Second
+	case strings.Contains(format, "mm"):
+		return time.Minute
+	case strings.Contains(format, "HH"):
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func (cfg *S3SelectConfig) setKeyTemplateParams(params url.Values) error {
+	if !params.Has("key_template") {
+		return nil
+	}
+	if _, err := parseKeyTemplate(params.Get("key_template")); err != nil {
+		return fmt.Errorf("parse key_template: %w", err)
+	}
+	if cfg.ObjectKey != "" || len(cfg.Sources) > 0 {
+		return errors.New("key_template requires a single prefix")
+	}
+	if cfg.Manifest != "" {
+		return errors.New("key_template and manifest are exclusive")
+	}
+	cfg.KeyTemplate = params.Get("key_template")
+	cfg.Params.Del("key_template")
+	return nil
+}
+
+func (column *projectedColumn) formatKey(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(column.layout)
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		if len(s) < column.digits {
+			s = strings.Repeat("0", column.digits-len(s)) + s
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// literal returns the value as S3 Select SQL literal.
+func (column *projectedColumn) literal(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return "CAST('" + v.Format(time.RFC3339) + "' AS TIMESTAMP)"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+}
+
+// parseLiteral converts the literal in WHERE to the value of the column.
+func (column *projectedColumn) parseLiteral(s string) (interface{}, bool) {
+	switch column.typ {
+	case projectionTypeDate:
+		return parseTime(s)
+	case projectionTypeInt:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return n, err == nil
+	case projectionTypeFloat:
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+	return s, true
+}
+
+func compareProjectedValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// projectionConstraint is a predicate on the projected column extracted from WHERE.
+type projectionConstraint struct {
+	op     string
+	values []interface{}
+}
+
+func (c projectionConstraint) match(v interface{}) bool {
+	switch c.op {
+	case "IN":
+		for _, value := range c.values {
+			if compareProjectedValues(v, value) == 0 {
+				return true
+			}
+		}
+		return false
+	case "BETWEEN":
+		return compareProjectedValues(v, c.values[0]) >= 0 && compareProjectedValues(v, c.values[1]) <= 0
+	}
+	cmp := compareProjectedValues(v, c.values[0])
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "<>", "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return true
+}
+
+// planPartitions derives partitions to scan from the predicates on projected columns in WHERE.
+// only top level AND conjuncts which are exactly `column op literal`, `column IN (...)` or `column BETWEEN a AND b` are used,
+// other predicates are evaluated by S3 Select with the values of each partition.
+func (tmpl *keyTemplate) planPartitions(prefix string, query string, now time.Time) ([]partition, error) {
+	constraints, err := tmpl.extractConstraints(query)
+	if err != nil {
+		return nil, err
+	}
+	domains := make([][]interface{}, len(tmpl.columns))
+	total := 1
+	for i, column := range tmpl.columns {
+		domain, err := column.domain(constraints[column], now)
+		if err != nil {
+			return nil, err
+		}
+		if len(domain) == 0 {
+			return nil, nil
+		}
+		domains[i] = domain
+		total *= len(domain)
+		if total > maxPartitions {
+			return nil, fmt.Errorf("key_template: too many partitions, more than %d", maxPartitions)
+		}
+	}
+	partitions := make([]partition, 0, total)
+	indexes := make([]int, len(domains))
+	for {
+		p := partition{
+			values: make([]projectedValue, len(tmpl.columns)),
+		}
+		parts := make([]string, len(tmpl.parts))
+		copy(parts, tmpl.parts)
+		for i, column := range tmpl.columns {
+			v := domains[i][indexes[i]]
+			p.values[i] = projectedValue{column: column, value: v}
+			parts[tmpl.positions[i]] = column.formatKey(v)
+		}
+		p.prefix = prefix + strings.Join(parts, "")
+		partitions = append(partitions, p)
+		i := len(indexes) - 1
+		for ; i >= 0; i-- {
+			indexes[i]++
+			if indexes[i] < len(domains[i]) {
+				break
+			}
+			indexes[i] = 0
+		}
+		if i < 0 {
+			break
+		}
+	}
+	return partitions, nil
+}
+
+// domain returns the values of the column that satisfy constraints.
+func (column *projectedColumn) domain(constraints []projectionConstraint, now time.Time) ([]interface{}, error) {
+	var candidates []interface{}
+	switch column.typ {
+	case projectionTypeEnum:
+		for _, v := range column.values {
+			candidates = append(candidates, v)
+		}
+	case projectionTypeInt:
+		if column.max-column.min >= maxPartitions {
+			return nil, fmt.Errorf("key_template: too many values of %s", column.name)
+		}
+		for n := column.min; n <= column.max; n++ {
+			candidates = append(candidates, n)
+		}
+	case projectionTypeDate:
+		var lower, upper *time.Time
+		for _, c := range constraints {
+			switch c.op {
+			case "=", ">", ">=", "IN", "BETWEEN":
+				for _, v := range c.values {
+					t := v.(time.Time)
+					if lower == nil || t.Before(*lower) {
+						lower = &t
+					}
+				}
+			}
+			switch c.op {
+			case "=", "<", "<=", "IN", "BETWEEN":
+				for _, v := range c.values {
+					t := v.(time.Time)
+					if upper == nil || t.After(*upper) {
+						upper = &t
+					}
+				}
+			}
+		}
+		if lower == nil {
+			return nil, fmt.Errorf("key_template: %s requires a lower bound in WHERE, such as %s >= ?", column.name, column.name)
+		}
+		if upper == nil {
+			upper = &now
+		}
+		start := lower.UTC().Truncate(column.step)
+		for t := start; !t.After(*upper); t = t.Add(column.step) {
+			if len(candidates) >= maxPartitions {
+				return nil, fmt.Errorf("key_template: too many values of %s", column.name)
+			}
+			candidates = append(candidates, t)
+		}
+	}
+	ret := make([]interface{}, 0, len(candidates))
+	for _, v := range candidates {
+		ok := true
+		for _, c := range constraints {
+			if !c.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+func (tmpl *keyTemplate) column(identifier string) *projectedColumn {
+	name := identifier
+	if i := strings.LastIndex(identifier, "."); i >= 0 {
+		name = identifier[i+1:]
+	}
+	for _, column := range tmpl.columns {
+		if strings.EqualFold(column.name, name) {
+			return column
+		}
+	}
+	return nil
+}
+
+// whereClause returns tokens between top level WHERE and GROUP BY, ORDER BY, LIMIT or the end.
+func whereClause(tokens []lexer.Token) []lexer.Token {
+	start, end := -1, len(tokens)
+	var depth int
+	for i, token := range tokens {
+		if token.Kind == lexer.KindSymbol {
+			switch token.Value {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+		}
+		if depth != 0 || token.Kind != lexer.KindIdentifier {
+			continue
+		}
+		switch strings.ToUpper(token.Value) {
+		case "WHERE":
+			if start < 0 {
+				start = i + 1
+			}
+		case "GROUP", "ORDER", "LIMIT":
+			if start >= 0 && end == len(tokens) {
+				end = i
+			}
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	return tokens[start:end]
+}
+
+func (tmpl *keyTemplate) extractConstraints(query string) (map[*projectedColumn][]projectionConstraint, error) {
+	return extractConstraints(query, tmpl.column)
+}
+
+// extractConstraints returns predicates of top level AND in WHERE, on columns which lookup returns.
+// a predicate is used only if the whole conjunct is `column op literal`, `column IN (...)` or `column BETWEEN a AND b`,
+// so conjuncts with CASE, arithmetic or IS are evaluated only by S3 Select.
+func extractConstraints(query string, lookup func(identifier string) *projectedColumn) (map[*projectedColumn][]projectionConstraint, error) {
+	tokens, err := significantTokens(query)
+	if err != nil {
+		return nil, err
+	}
+	constraints := make(map[*projectedColumn][]projectionConstraint)
+	conjuncts, ok := splitConjuncts(whereClause(tokens))
+	if !ok {
+		// predicates can not be used with top level OR
+		return constraints, nil
+	}
+	for _, conjunct := range conjuncts {
+		if len(conjunct) == 0 || conjunct[0].Kind != lexer.KindIdentifier {
+			continue
+		}
+		column := lookup(conjunct[0].Value)
+		if column == nil {
+			continue
+		}
+		c, n, ok := parseConstraint(column, conjunct[1:])
+		if ok && 1+n == len(conjunct) {
+			constraints[column] = append(constraints[column], c)
+		}
+	}
+	return constraints, nil
+}
+
+// splitConjuncts splits WHERE by top level AND, the AND of BETWEEN is a part of the conjunct.
+// parentheses and CASE ... END are not split. ok is false if WHERE has top level OR.
+func splitConjuncts(where []lexer.Token) (conjuncts [][]lexer.Token, ok bool) {
+	var depth, start int
+	var between bool
+	for i, token := range where {
+		switch {
+		case token.Kind == lexer.KindSymbol && token.Value == "(":
+			depth++
+		case token.Kind == lexer.KindSymbol && token.Value == ")":
+			depth--
+		case token.Kind != lexer.KindIdentifier:
+		case strings.EqualFold(token.Value, "CASE"):
+			depth++
+		case strings.EqualFold(token.Value, "END"):
+			depth--
+		case depth != 0:
+		case strings.EqualFold(token.Value, "OR"):
+			return nil, false
+		case strings.EqualFold(token.Value, "BETWEEN"):
+			between = true
+		case strings.EqualFold(token.Value, "AND"):
+			if between {
+				between = false
+				continue
+			}
+			conjuncts = append(conjuncts, where[start:i])
+			start = i + 1
+		}
+	}
+	return append(conjuncts, where[start:]), true
+}
+
+// parseConstraint parses the operator and literals after the projected column, n is the number of consumed tokens.
+func parseConstraint(column *projectedColumn, tokens []lexer.Token) (c projectionConstraint, n int, ok bool) {
+	if len(tokens) == 0 {
+		return c, 0, false
+	}
+	op := strings.ToUpper(tokens[0].Value)
+	switch op {
+	case "=", "<>", "!=", ">", ">=", "<", "<=":
+		v, m, ok := parseProjectionLiteral(column, tokens[1:])
+		if !ok {
+			return c, 0, false
+		}
+		return projectionConstraint{op: op, values: []interface{}{v}}, 1 + m, true
+	case "IN":
+		if len(tokens) < 2 || tokens[1].Value != "(" {
+			return c, 0, false
+		}
+		c.op = op
+		n = 2
+		for n < len(tokens) {
+			v, m, ok := parseProjectionLiteral(column, tokens[n:])
+			if !ok {
+				return c, 0, false
+			}
+			c.values = append(c.values, v)
+			n += m
+			if n >= len(tokens) {
+				return c, 0, false
+			}
+			switch tokens[n].Value {
+			case ",":
+				n++
+				continue
+			case ")":
+				return c, n + 1, true
+			}
+			return c, 0, false
+		}
+	case "BETWEEN":
+		lower, m, ok := parseProjectionLiteral(column, tokens[1:])
+		if !ok || 1+m >= len(tokens) || !strings.EqualFold(tokens[1+m].Value, "AND") {
+			return c, 0, false
+		}
+		upper, l, ok := parseProjectionLiteral(column, tokens[2+m:])
+		if !ok {
+			return c, 0, false
+		}
+		return projectionConstraint{op: op, values: []interface{}{lower, upper}}, 2 + m + l, true
+	}
+	return c, 0, false
+}
+
+// parseProjectionLiteral parses a string, a number or `CAST('...' AS TIMESTAMP)`.
+func parseProjectionLiteral(column *projectedColumn, tokens []lexer.Token) (interface{}, int, bool) {
+	if len(tokens) == 0 {
+		return nil, 0, false
+	}
+	var s string
+	var n int
+	switch {
+	case tokens[0].Kind == lexer.KindString && strings.HasPrefix(tokens[0].Value, "'"):
+		s, n = unquoteSQLString(tokens[0].Value), 1
+	case column.typ == projectionTypeString:
+		return nil, 0, false
+	case tokens[0].Kind == lexer.KindNumber:
+		s, n = tokens[0].Value, 1
+	case tokens[0].Value == "-" && len(tokens) > 1 && tokens[1].Kind == lexer.KindNumber:
+		s, n = "-"+tokens[1].Value, 2
+	case strings.EqualFold(tokens[0].Value, "CAST") && len(tokens) >= 6 &&
+		tokens[1].Value == "(" && tokens[2].Kind == lexer.KindString &&
+		strings.EqualFold(tokens[3].Value, "AS") && strings.EqualFold(tokens[4].Value, "TIMESTAMP") && tokens[5].Value == ")":
+		s, n = unquoteSQLString(tokens[2].Value), 6
+	default:
+		return nil, 0, false
+	}
+	v, ok := column.parseLiteral(s)
+	if !ok {
+		return nil, 0, false
+	}
+	return v, n, true
+}
+
+func unquoteSQLString(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "'"), "'")
+	return strings.ReplaceAll(s, "''", "'")
+}
+
+// rewriteProjectedColumns replaces projected columns in the query with the values of the partition.
+// a projected column in the select list is aliased by its name. star is true if the query is `SELECT *`.
+func rewriteProjectedColumns(query string, values []projectedValue) (rewrited string, star bool, err error) {
+	l := lexer.NewLexer(query)
+	tokens, err := l.Lex()
+	if err != nil {
+		return "", false, err
+	}
+	byName := make(map[string]projectedValue, len(values))
+	for _, v := range values {
+		byName[strings.ToLower(v.column.name)] = v
+	}
+	var builder strings.Builder
+	var prev lexer.Token
+	var inSelectList bool
+	for idx, token := range tokens {
+		switch token.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+			builder.WriteString(token.Value)
+			continue
+		}
+		if token.Kind == lexer.KindIdentifier {
+			switch strings.ToUpper(token.Value) {
+			case "SELECT":
+				inSelectList = true
+			case "FROM":
+				inSelectList = false
+			}
+		}
+		if token.Kind == lexer.KindSymbol && token.Value == "*" && prev.Kind == lexer.KindIdentifier && strings.EqualFold(prev.Value, "SELECT") {
+			star = true
+		}
+		v, ok := projectedValueOf(byName, token)
+		if ok && !isAliasOrFunction(prev, tokens, idx) {
+			builder.WriteString(v.column.literal(v.value))
+			if inSelectList && isSelectItem(prev, tokens, idx) {
+				builder.WriteString(" AS " + v.column.name)
+			}
+		} else {
+			builder.WriteString(token.Value)
+		}
+		prev = token
+	}
+	return builder.String(), star, nil
+}
+
+func projectedValueOf(byName map[string]projectedValue, token lexer.Token) (projectedValue, bool) {
+	if token.Kind != lexer.KindIdentifier {
+		return projectedValue{}, false
+	}
+	name := token.Value
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	v, ok := byName[strings.ToLower(name)]
+	return v, ok
+}
+
+// isSelectItem reports whether the token at idx is a whole item of the select list without alias.
+func isSelectItem(prev lexer.Token, tokens []lexer.Token, idx int) bool {
+	if !(prev.Kind == lexer.KindIdentifier && strings.EqualFold(prev.Value, "SELECT")) && prev.Value != "," {
+		return false
+	}
+	for _, next := range tokens[idx+1:] {
+		switch next.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+			continue
+		}
+		return next.Value == "," || strings.EqualFold(next.Value, "FROM")
+	}
+	return false
+}
+
+// projectionWriter appends the values of projected columns to each JSON record written by S3 Select.
+type projectionWriter struct {
+	w      io.Writer
+	fields []byte
+	buf    []byte
+}
+
+func newProjectionWriter(w io.Writer, values []projectedValue) (*projectionWriter, error) {
+	var fields bytes.Buffer
+	for i, v := range values {
+		if i > 0 {
+			fields.WriteByte(',')
+		}
+		name, err := json.Marshal(v.column.name)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = v.value
+		if t, ok := v.value.(time.Time); ok {
+			value = t.Format(time.RFC3339)
+		}
+		bs, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields.Write(name)
+		fields.WriteByte(':')
+		fields.Write(bs)
+	}
+	return &projectionWriter{w: w, fields: fields.Bytes()}, nil
+}
+
+func (pw *projectionWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := pw.writeRecord(pw.buf[:i]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last record without newline.
+func (pw *projectionWriter) Flush() error {
+	if len(bytes.TrimSpace(pw.buf)) == 0 {
+		pw.buf = nil
+		return nil
+	}
+	err := pw.writeRecord(pw.buf)
+	pw.buf = nil
+	return err
+}
+
+func (pw *projectionWriter) writeRecord(line []byte) error {
+	trimmed := bytes.TrimRight(line, " \t\r")
+	if !bytes.HasSuffix(trimmed, []byte("}")) {
+		_, err := pw.w.Write(append(line, '\n'))
+		return err
+	}
+	body := trimmed[:len(trimmed)-1]
+	var record bytes.Buffer
+	record.Write(body)
+	if !bytes.Equal(bytes.TrimSpace(body), []byte("{")) {
+		record.WriteByte(',')
+	}
+	record.Write(pw.fields)
+	record.WriteString("}\n")
+	_, err := pw.w.Write(record.Bytes())
+	return err
+}
+
+// convertProjectedColumns converts date values of projected columns to time.Time.
+func (tmpl *keyTemplate) convertProjectedColumns(columns []string, rows [][]interface{}) {
+	for i, name := range columns {
+		column := tmpl.column(name)
+		if column == nil || column.typ != projectionTypeDate {
+			continue
+		}
+		for _, row := range rows {
+			if i >= len(row) {
+				continue
+			}
+			if s, ok := row[i].(string); ok {
+				if t, ok := parseTime(s); ok {
+					row[i] = t
+				}
+			}
+		}
+	}
+}
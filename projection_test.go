@@ -0,0 +1,239 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyTemplate__Error(t *testing.T) {
+	cases := []struct {
+		template string
+		errorMsg string
+	}{
+		{template: "logs/", errorMsg: "key_template has no placeholder"},
+		{template: "logs/{dt:date:yyyy/MM/dd", errorMsg: "placeholder is not closed: {dt:date:yyyy/MM/dd"},
+		{template: "logs/{dt}/", errorMsg: "placeholder {dt} must be {name:type:args}"},
+		{template: "logs/{dt:hour:HH}/", errorMsg: "placeholder {dt:hour:HH}: unknown type hour"},
+		{template: "logs/{n:int:9-1}/", errorMsg: "placeholder {n:int:9-1}: min is greater than max"},
+		{template: "logs/{dt:date:yyyy/MM/dd:0h}/", errorMsg: "placeholder {dt:date:yyyy/MM/dd:0h}: step must be positive"},
+		{template: "{a:enum:x}/{A:enum:y}/", errorMsg: "placeholder A is duplicated"},
+	}
+	for _, c := range cases {
+		t.Run(c.template, func(t *testing.T) {
+			_, err := parseKeyTemplate(c.template)
+			require.EqualError(t, err, c.errorMsg)
+		})
+	}
+}
+
+func TestKeyTemplate__PlanPartitions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 3, 30, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		template string
+		query    string
+		expected []string
+		errorMsg string
+	}{
+		{
+			name:     "hourly range",
+			template: "{dt:date:yyyy/MM/dd/HH}/",
+			query:    "SELECT * FROM S3Object s WHERE s.dt >= '2024-05-01T01:00:00Z' AND s.dt < CAST('2024-05-01T03:00:00Z' AS TIMESTAMP)",
+			expected: []string{"logs/2024/05/01/01/", "logs/2024/05/01/02/"},
+		},
+		{
+			name:     "lower bound only",
+			template: "{dt:date:yyyy/MM/dd/HH}/",
+			query:    "SELECT * FROM S3Object s WHERE s.dt > '2024-05-01T01:30:00Z' LIMIT 10",
+			expected: []string{"logs/2024/05/01/02/", "logs/2024/05/01/03/"},
+		},
+		{
+			name:     "daily between with enum",
+			template: "dt={dt:date:yyyy-MM-dd}/region={region:enum:us-east-1|ap-northeast-1|eu-west-1}/",
+			query:    "SELECT * FROM S3Object s WHERE s.dt BETWEEN '2024-04-30' AND '2024-05-01' AND region IN ('us-east-1', 'eu-west-1')",
+			expected: []string{
+				"logs/dt=2024-04-30/region=us-east-1/",
+				"logs/dt=2024-04-30/region=eu-west-1/",
+				"logs/dt=2024-05-01/region=us-east-1/",
+				"logs/dt=2024-05-01/region=eu-west-1/",
+			},
+		},
+		{
+			name:     "int range",
+			template: "{dt:date:yyyy/MM/dd:1d}/shard={shard:int:0-15:2}/",
+			query:    "SELECT * FROM S3Object s WHERE s.dt = '2024-05-01' AND s.shard >= 14 AND (s.shard <> 0 OR s.id = 1)",
+			expected: []string{"logs/2024/05/01/shard=14/", "logs/2024/05/01/shard=15/"},
+		},
+		{
+			name:     "not on step",
+			template: "{dt:date:yyyy/MM/dd}/",
+			query:    "SELECT * FROM S3Object s WHERE s.dt = '2024-05-01T12:00:00Z'",
+			expected: []string{},
+		},
+		{
+			name:     "CASE is not a constraint",
+			template: "region={region:enum:us-east-1|ap-northeast-1|eu-west-1}/",
+			query:    "SELECT * FROM S3Object s WHERE CASE WHEN s.region = 'us-east-1' THEN 1 ELSE 0 END = 0",
+			expected: []string{"logs/region=us-east-1/", "logs/region=ap-northeast-1/", "logs/region=eu-west-1/"},
+		},
+		{
+			name:     "arithmetic is not a constraint",
+			template: "shard={shard:int:0-3}/",
+			query:    "SELECT * FROM S3Object s WHERE s.shard > 5 - 10",
+			expected: []string{"logs/shard=0/", "logs/shard=1/", "logs/shard=2/", "logs/shard=3/"},
+		},
+		{
+			name:     "IS is not a constraint",
+			template: "shard={shard:int:0-3}/",
+			query:    "SELECT * FROM S3Object s WHERE s.shard = 0 IS NOT TRUE AND s.shard BETWEEN 1 AND 2 AND CASE s.shard WHEN 1 THEN s.a AND s.b END",
+			expected: []string{"logs/shard=1/", "logs/shard=2/"},
+		},
+		{
+			name:     "top level OR",
+			template: "{dt:date:yyyy/MM/dd}/",
+			query:    "SELECT * FROM S3Object s WHERE s.dt = '2024-05-01' OR s.id = 1",
+			errorMsg: "key_template: dt requires a lower bound in WHERE, such as dt >= ?",
+		},
+		{
+			name:     "too many",
+			template: "{dt:date:yyyy/MM/dd/HH/mm/ss}/",
+			query:    "SELECT * FROM S3Object s WHERE s.dt >= '2024-04-01'",
+			errorMsg: "key_template: too many values of dt",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tmpl, err := parseKeyTemplate(c.template)
+			require.NoError(t, err)
+			partitions, err := tmpl.planPartitions("logs/", c.query, now)
+			if c.errorMsg != "" {
+				require.EqualError(t, err, c.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			actual := make([]string, 0, len(partitions))
+			for _, p := range partitions {
+				actual = append(actual, p.prefix)
+			}
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestRewriteProjectedColumns(t *testing.T) {
+	tmpl, err := parseKeyTemplate("{dt:date:yyyy/MM/dd}/{region:enum:us-east-1}/")
+	require.NoError(t, err)
+	values := []projectedValue{
+		{column: tmpl.columns[0], value: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{column: tmpl.columns[1], value: "us-east-1"},
+	}
+	cases := []struct {
+		query    string
+		expected string
+		star     bool
+	}{
+		{
+			query:    "SELECT s.dt, s.id, region AS r FROM S3Object s WHERE s.dt >= CAST('2024-05-01T00:00:00Z' AS TIMESTAMP)",
+			expected: "SELECT CAST('2024-05-01T00:00:00Z' AS TIMESTAMP) AS dt, s.id, 'us-east-1' AS r FROM S3Object s WHERE CAST('2024-05-01T00:00:00Z' AS TIMESTAMP) >= CAST('2024-05-01T00:00:00Z' AS TIMESTAMP)",
+		},
+		{
+			query:    "SELECT * FROM S3Object s WHERE s.region = 'us-east-1'",
+			expected: "SELECT * FROM S3Object s WHERE 'us-east-1' = 'us-east-1'",
+			star:     true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			actual, star, err := rewriteProjectedColumns(c.query, values)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+			require.Equal(t, c.star, star)
+		})
+	}
+}
+
+func TestProjectionWriter(t *testing.T) {
+	tmpl, err := parseKeyTemplate("{dt:date:yyyy/MM/dd}/{shard:int:0-1}/")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	pw, err := newProjectionWriter(&buf, []projectedValue{
+		{column: tmpl.columns[0], value: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{column: tmpl.columns[1], value: int64(1)},
+	})
+	require.NoError(t, err)
+	for _, chunk := range []string{`{"id":1}`, "\n{", "}\n", `{"id":`, `2}`} {
+		_, err := pw.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, pw.Flush())
+	require.Equal(t,
+		`{"id":1,"dt":"2024-05-01T00:00:00Z","shard":1}`+"\n"+
+			`{"dt":"2024-05-01T00:00:00Z","shard":1}`+"\n"+
+			`{"id":2,"dt":"2024-05-01T00:00:00Z","shard":1}`+"\n",
+		buf.String(),
+	)
+}
+
+func TestMock__KeyTemplate(t *testing.T) {
+	var mu sync.Mutex
+	var listed []string
+	mockClients["key_template"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			mu.Lock()
+			listed = append(listed, *params.Prefix)
+			mu.Unlock()
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String(*params.Prefix + "part-00000.json")},
+				},
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			switch *params.Key {
+			case "logs/2024/05/01/23/part-00000.json":
+				require.Equal(t, "SELECT * FROM S3Object s WHERE CAST('2024-05-01T23:00:00Z' AS TIMESTAMP) >= CAST('2024-05-01T23:00:00Z' AS TIMESTAMP) AND CAST('2024-05-01T23:00:00Z' AS TIMESTAMP) <= CAST('2024-05-02T00:00:00Z' AS TIMESTAMP)", *params.Expression)
+				fmt.Fprintln(w, `{"id":1}`)
+			case "logs/2024/05/02/00/part-00000.json":
+				fmt.Fprintln(w, `{"id":2}`)
+			default:
+				return fmt.Errorf("unexpected key: %s", *params.Key)
+			}
+			return nil
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/logs/?format=json_lines&key_template={dt:date:yyyy/MM/dd/HH}/&mock=key_template")
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.QueryContext(context.Background(), `SELECT * FROM S3Object s WHERE s.dt >= ? AND s.dt <= ?`,
+		time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+	)
+	require.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"id", "dt"}, columns)
+	var actual []string
+	for rows.Next() {
+		var id int
+		var dt time.Time
+		require.NoError(t, rows.Scan(&id, &dt))
+		actual = append(actual, fmt.Sprintf("%d:%s", id, dt.Format(time.RFC3339)))
+	}
+	require.Equal(t, []string{"1:2024-05-01T23:00:00Z", "2:2024-05-02T00:00:00Z"}, actual)
+	require.Equal(t, []string{"logs/2024/05/01/23/", "logs/2024/05/02/00/"}, listed)
+}
@@ -0,0 +1,480 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+const (
+	// ResultCacheMemory is the value of cache for the in-memory result cache.
+	ResultCacheMemory = "memory"
+
+	defaultResultCacheTTL     = time.Hour
+	defaultResultCacheMaxSize = 256 << 20
+	resultCacheVersion        = 1
+)
+
+func (cfg *S3SelectConfig) setResultCacheParams(params url.Values) error {
+	if params.Has("cache") {
+		cfg.Cache = params.Get("cache")
+		if cfg.Cache == "" {
+			return errors.New("cache must be memory or a directory")
+		}
+		cfg.Params.Del("cache")
+	}
+	if params.Has("cache_ttl") {
+		d, err := parseDurationOrSeconds(params.Get("cache_ttl"))
+		if err != nil {
+			return fmt.Errorf("parse cache_ttl: %w", err)
+		}
+		if d <= 0 {
+			return errors.New("cache_ttl must be positive")
+		}
+		cfg.CacheTTL = d
+		cfg.Params.Del("cache_ttl")
+	}
+	if params.Has("cache_max_size") {
+		n, err := parseByteSize(params.Get("cache_max_size"))
+		if err != nil {
+			return fmt.Errorf("parse cache_max_size: %w", err)
+		}
+		cfg.CacheMaxSize = n
+		cfg.Params.Del("cache_max_size")
+	}
+	if cfg.Cache == "" && (cfg.CacheTTL != 0 || cfg.CacheMaxSize != 0) {
+		return errors.New("cache_ttl and cache_max_size require cache")
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setResultCacheToURLValues(params url.Values) {
+	if cfg.Cache != "" {
+		params.Set("cache", cfg.Cache)
+	} else {
+		params.Del("cache")
+	}
+	if cfg.CacheTTL != 0 {
+		params.Set("cache_ttl", cfg.CacheTTL.String())
+	} else {
+		params.Del("cache_ttl")
+	}
+	if cfg.CacheMaxSize != 0 {
+		params.Set("cache_max_size", fmt.Sprintf("%dB", cfg.CacheMaxSize))
+	} else {
+		params.Del("cache_max_size")
+	}
+}
+
+type resultCacheBypassKey struct{}
+
+// WithoutResultCache returns the context for QueryContext, which does not read the result cache.
+// results of the query are still written to the cache, so it refreshes cached results.
+func WithoutResultCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, resultCacheBypassKey{}, true)
+}
+
+func resultCacheBypassed(ctx context.Context) bool {
+	b, _ := ctx.Value(resultCacheBypassKey{}).(bool)
+	return b
+}
+
+// resultCache caches the output of S3 Select per object, it is shared by connections of the connector.
+// the key is the normalized expression with bound arguments, serializations and the ETag of the object,
+// so the cached output is valid while the object is not modified, and only new or modified objects are selected again.
+// entries are kept in memory, or in files of dir, and the least recently used entries are evicted over maxSize.
+type resultCache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	now     func() time.Time
+
+	mu      sync.Mutex
+	loaded  bool
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type resultCacheEntry struct {
+	key     string
+	data    []byte
+	size    int64
+	expires time.Time
+}
+
+func newResultCache(cfg *S3SelectConfig) *resultCache {
+	c := &resultCache{
+		ttl:     cfg.CacheTTL,
+		maxSize: cfg.CacheMaxSize,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if cfg.Cache != ResultCacheMemory {
+		c.dir = cfg.Cache
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultResultCacheTTL
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = defaultResultCacheMaxSize
+	}
+	return c
+}
+
+// resultCacheKey returns the key of the output of the input for the object of the ETag.
+func resultCacheKey(input *s3.SelectObjectContentInput, etag string) (string, error) {
+	bs, err := json.Marshal(struct {
+		Version             int
+		Bucket              *string
+		Key                 *string
+		ETag                string
+		Expression          string
+		InputSerialization  *types.InputSerialization
+		OutputSerialization *types.OutputSerialization
+		ScanRange           *types.ScanRange
+		SSECustomerKeyMD5   *string
+	}{
+		Version:             resultCacheVersion,
+		Bucket:              input.Bucket,
+		Key:                 input.Key,
+		ETag:                etag,
+		Expression:          normalizeQuery(*input.Expression),
+		InputSerialization:  input.InputSerialization,
+		OutputSerialization: input.OutputSerialization,
+		ScanRange:           input.ScanRange,
+		SSECustomerKeyMD5:   input.SSECustomerKeyMD5,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalizeQuery removes comments and collapses spaces of the query.
+func normalizeQuery(query string) string {
+	tokens, err := lexer.NewLexer(query).Lex()
+	if err != nil {
+		return query
+	}
+	var b strings.Builder
+	var space bool
+	for _, token := range tokens {
+		switch token.Kind {
+		case lexer.KindSpace, lexer.KindNewline, lexer.KindComment:
+			space = true
+			continue
+		case lexer.KindEOF:
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(token.Value)
+	}
+	return b.String()
+}
+
+// open returns the reader of the cached output of the key, files of dir are read without loading them into memory.
+func (c *resultCache) open(key string) (io.ReadCloser, bool) {
+	c.mu.Lock()
+	c.load()
+	elem, ok := c.entries[key]
+	if !ok && c.dir != "" {
+		// the file may be written by another process
+		elem, ok = c.addFile(key)
+	}
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := elem.Value.(*resultCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.removeElement(elem)
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.mu.Unlock()
+	if c.dir == "" {
+		return io.NopCloser(bytes.NewReader(entry.data)), true
+	}
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// add adds the entry and evicts the least recently used entries over maxSize.
+func (c *resultCache) add(entry *resultCacheEntry) {
+	entry.expires = c.now().Add(c.ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	if elem, ok := c.entries[entry.key]; ok {
+		c.size -= elem.Value.(*resultCacheEntry).size
+		c.lru.Remove(elem)
+		delete(c.entries, entry.key)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *resultCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*resultCacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	if c.dir != "" {
+		os.Remove(c.path(entry.key))
+	}
+}
+
+func (c *resultCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// createTemp creates the temporary file of the output, it is renamed to the file of the key by renameTemp
+// so readers never see a partial file.
+func (c *resultCache) createTemp() (*os.File, error) {
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(c.dir, ".tmp-")
+}
+
+// renameTemp closes the temporary file and renames it to the file of the key.
+func (c *resultCache) renameTemp(f *os.File, key string) error {
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path(key)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// load reads files of dir once, older files are evicted first. c.mu must be held.
+func (c *resultCache) load() {
+	if c.dir == "" || c.loaded {
+		return
+	}
+	c.loaded = true
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if !isResultCacheKey(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	for _, info := range files {
+		c.pushFile(info, false)
+	}
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// addFile adds the file of the key written after load. c.mu must be held.
+func (c *resultCache) addFile(key string) (*list.Element, bool) {
+	info, err := os.Stat(c.path(key))
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	elem := c.pushFile(info, true)
+	for c.size > c.maxSize && c.lru.Back() != elem {
+		c.removeElement(c.lru.Back())
+	}
+	return elem, true
+}
+
+func (c *resultCache) pushFile(info os.FileInfo, front bool) *list.Element {
+	entry := &resultCacheEntry{
+		key:     info.Name(),
+		size:    info.Size(),
+		expires: info.ModTime().Add(c.ttl),
+	}
+	c.size += entry.size
+	var elem *list.Element
+	if front {
+		elem = c.lru.PushFront(entry)
+	} else {
+		elem = c.lru.PushBack(entry)
+	}
+	c.entries[entry.key] = elem
+	return elem
+}
+
+func isResultCacheKey(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// selectObjectContent executes S3 Select for the object of the content, the output is read from and written to the result cache.
+// the ETag of the object is read by HeadObject if the content has no ETag, such as the object of the DSN,
+// or if the content is of the cached listing, whose ETag may be stale.
+// objects without ETag are always selected, and hooks are called only when S3 Select is executed.
+func (conn *s3SelectConn) selectObjectContent(ctx context.Context, w io.Writer, input *s3.SelectObjectContentInput, content contentInfo) error {
+	if conn.resultCache == nil {
+		return conn.selectObjectContentWithHooks(ctx, w, input)
+	}
+	if content.ListingKey != "" {
+		etag, err := conn.headObjectETag(ctx, content)
+		switch {
+		case isNoSuchKey(err):
+			return err
+		case err != nil:
+			conn.debugf("head object s3://%s/%s: %v", content.BucketName, content.ObjectKey, err)
+			return conn.selectObjectContentWithHooks(ctx, w, input)
+		case etag != "" && etag != content.ETag:
+			conn.debugf("object modified after listing: s3://%s/%s", content.BucketName, content.ObjectKey)
+			conn.listCache.invalidate(content.ListingKey)
+			content.ETag = etag
+		}
+	}
+	content.ETag = conn.objectETag(ctx, content)
+	if content.ETag == "" {
+		return conn.selectObjectContentWithHooks(ctx, w, input)
+	}
+	key, err := resultCacheKey(input, content.ETag)
+	if err != nil {
+		return fmt.Errorf("result cache key: %w", err)
+	}
+	if !resultCacheBypassed(ctx) {
+		if r, ok := conn.resultCache.open(key); ok {
+			conn.debugf("result cache hit s3://%s/%s", content.BucketName, content.ObjectKey)
+			defer r.Close()
+			_, err := io.Copy(w, r)
+			return err
+		}
+	}
+	cw := conn.resultCache.newWriter(w)
+	if err := conn.selectObjectContentWithHooks(ctx, cw, input); err != nil {
+		cw.discard()
+		return err
+	}
+	if ctx.Err() != nil {
+		cw.discard()
+		return nil
+	}
+	if err := cw.commit(key); err != nil {
+		conn.errorf("write result cache of s3://%s/%s: %v", content.BucketName, content.ObjectKey, err)
+	}
+	return nil
+}
+
+func (conn *s3SelectConn) selectObjectContentWithHooks(ctx context.Context, w io.Writer, input *s3.SelectObjectContentInput) error {
+	conn.hooks.beforeSelectObjectContent(ctx, input)
+	err := conn.client.SelectObjectContentWithWriter(ctx, w, input, conn.cfg.selectObjectContentOptFns()...)
+	conn.hooks.afterSelectObjectContent(ctx, input, err)
+	return err
+}
+
+// resultCacheWriter writes the output to w and keeps it for the result cache, unless it is larger than maxSize.
+// the output is kept in memory, or in a temporary file of dir which is renamed by commit.
+type resultCacheWriter struct {
+	c        *resultCache
+	w        io.Writer
+	buf      bytes.Buffer
+	file     *os.File
+	size     int64
+	overflow bool
+	err      error
+}
+
+func (c *resultCache) newWriter(w io.Writer) *resultCacheWriter {
+	cw := &resultCacheWriter{c: c, w: w}
+	if c.dir != "" {
+		cw.file, cw.err = c.createTemp()
+	}
+	return cw
+}
+
+func (w *resultCacheWriter) Write(p []byte) (int, error) {
+	if !w.overflow && w.err == nil {
+		switch {
+		case w.size+int64(len(p)) > w.c.maxSize:
+			w.overflow = true
+			w.discard()
+		case w.file != nil:
+			_, w.err = w.file.Write(p)
+		default:
+			w.buf.Write(p)
+		}
+		w.size += int64(len(p))
+	}
+	return w.w.Write(p)
+}
+
+// commit stores the output for the key, the output larger than maxSize is not stored.
+func (w *resultCacheWriter) commit(key string) error {
+	if w.overflow {
+		return nil
+	}
+	if w.err != nil {
+		w.discard()
+		return w.err
+	}
+	entry := &resultCacheEntry{key: key, size: w.size}
+	if w.file == nil {
+		entry.data = w.buf.Bytes()
+	} else {
+		f := w.file
+		w.file = nil
+		if err := w.c.renameTemp(f, key); err != nil {
+			return err
+		}
+	}
+	w.c.add(entry)
+	return nil
+}
+
+// discard removes the temporary file.
+func (w *resultCacheWriter) discard() {
+	w.buf = bytes.Buffer{}
+	if w.file != nil {
+		w.file.Close()
+		os.Remove(w.file.Name())
+		w.file = nil
+	}
+}
@@ -0,0 +1,213 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	require.Equal(t,
+		"SELECT * FROM S3Object s WHERE s.name = 'a  b'",
+		normalizeQuery("  SELECT *\n  FROM S3Object s -- comment\n WHERE s.name = 'a  b' "),
+	)
+}
+
+func putResultCache(c *resultCache, key string, data []byte) error {
+	w := c.newWriter(io.Discard)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.commit(key)
+}
+
+func getResultCache(c *resultCache, key string) ([]byte, bool) {
+	r, ok := c.open(key)
+	if !ok {
+		return nil, false
+	}
+	defer r.Close()
+	bs, err := io.ReadAll(r)
+	return bs, err == nil
+}
+
+func TestResultCache(t *testing.T) {
+	for _, cache := range []string{ResultCacheMemory, t.TempDir()} {
+		t.Run(filepath.Base(cache), func(t *testing.T) {
+			c := newResultCache(&S3SelectConfig{Cache: cache, CacheTTL: time.Minute, CacheMaxSize: 10})
+			now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+			c.now = func() time.Time { return now }
+
+			require.NoError(t, putResultCache(c, "a", []byte("aaaa")))
+			require.NoError(t, putResultCache(c, "b", []byte("bbbb")))
+			bs, ok := getResultCache(c, "a")
+			require.True(t, ok)
+			require.Equal(t, "aaaa", string(bs))
+
+			require.NoError(t, putResultCache(c, "c", []byte("cccc")))
+			_, ok = getResultCache(c, "b")
+			require.False(t, ok, "the least recently used entry is evicted")
+			_, ok = getResultCache(c, "a")
+			require.True(t, ok)
+
+			require.NoError(t, putResultCache(c, "d", []byte("dddddddddddd")))
+			_, ok = getResultCache(c, "d")
+			require.False(t, ok, "larger than max size")
+			if c.dir != "" {
+				entries, err := os.ReadDir(c.dir)
+				require.NoError(t, err)
+				for _, e := range entries {
+					require.False(t, strings.HasPrefix(e.Name(), ".tmp-"), "the temporary file is removed")
+				}
+			}
+
+			now = now.Add(time.Minute)
+			_, ok = getResultCache(c, "a")
+			require.False(t, ok, "expired")
+			require.EqualValues(t, 4, c.size)
+		})
+	}
+}
+
+func TestResultCache__Dir(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &S3SelectConfig{Cache: dir, CacheMaxSize: 10}
+	key := strings.Repeat("ab", 32)
+	require.NoError(t, putResultCache(newResultCache(cfg), key, []byte("cached")))
+
+	c := newResultCache(cfg)
+	bs, ok := getResultCache(c, key)
+	require.True(t, ok, "files are shared with other caches of the directory")
+	require.Equal(t, "cached", string(bs))
+
+	other := strings.Repeat("cd", 32)
+	require.NoError(t, putResultCache(c, other, []byte("other")))
+	_, err := os.Stat(filepath.Join(dir, key))
+	require.True(t, os.IsNotExist(err), "the file of the evicted entry is removed")
+
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, ok = getResultCache(c, other)
+	require.False(t, ok, "expired by default cache_ttl")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestMock__ResultCache(t *testing.T) {
+	objects := map[string]string{
+		"data/1.json": `{"id":1}` + "\n",
+		"data/2.json": `{"id":2}` + "\n",
+	}
+	etags := map[string]string{
+		"data/1.json": `"etag-1"`,
+		"data/2.json": `"etag-2"`,
+	}
+	var selected []string
+	mockClients["result_cache"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			var contents []types.Object
+			for _, key := range []string{"data/1.json", "data/2.json"} {
+				contents = append(contents, types.Object{Key: aws.String(key), ETag: aws.String(etags[key])})
+			}
+			return &s3.ListObjectsV2Output{
+				Name:     params.Bucket,
+				Contents: contents,
+			}, nil
+		},
+		HeadObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{ETag: aws.String(etags[*params.Key])}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			selected = append(selected, *params.Key)
+			_, err := io.WriteString(w, objects[*params.Key])
+			return err
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json_lines&cache=memory&mock=result_cache")
+	require.NoError(t, err)
+	defer db.Close()
+
+	query := func(ctx context.Context, q string, args ...interface{}) []int64 {
+		t.Helper()
+		selected = nil
+		rows, err := db.QueryContext(ctx, q, args...)
+		require.NoError(t, err)
+		defer rows.Close()
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		require.NoError(t, rows.Err())
+		return ids
+	}
+	ctx := context.Background()
+	require.Equal(t, []int64{1, 2}, query(ctx, `SELECT s.id FROM S3Object s WHERE s.id > ?`, 0))
+	require.Equal(t, []string{"data/1.json", "data/2.json"}, selected)
+
+	require.Equal(t, []int64{1, 2}, query(ctx, "SELECT s.id\nFROM S3Object s  WHERE s.id > ?", 0))
+	require.Empty(t, selected, "results of the normalized query are cached")
+
+	query(ctx, `SELECT s.id FROM S3Object s WHERE s.id > ?`, 1)
+	require.Equal(t, []string{"data/1.json", "data/2.json"}, selected, "arguments are the part of the key")
+
+	objects["data/2.json"] = `{"id":3}` + "\n"
+	etags["data/2.json"] = `"etag-2-modified"`
+	require.Equal(t, []int64{1, 3}, query(ctx, `SELECT s.id FROM S3Object s WHERE s.id > ?`, 0))
+	require.Equal(t, []string{"data/2.json"}, selected, "only the modified object is selected")
+
+	require.Equal(t, []int64{1, 3}, query(WithoutResultCache(ctx), `SELECT s.id FROM S3Object s WHERE s.id > ?`, 0))
+	require.Equal(t, []string{"data/1.json", "data/2.json"}, selected, "the cache is bypassed")
+
+	// the ETag of the object of the DSN is read by HeadObject
+	db, err = sql.Open("s3-select", "s3://example-com/data/2.json?format=json_lines&cache=memory&mock=result_cache")
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, []int64{3}, query(ctx, `SELECT s.id FROM S3Object s`))
+	require.Equal(t, []string{"data/2.json"}, selected)
+	require.Equal(t, []int64{3}, query(ctx, `SELECT s.id FROM S3Object s`))
+	require.Empty(t, selected, "the result of the object of the DSN is cached")
+	objects["data/2.json"] = `{"id":4}` + "\n"
+	etags["data/2.json"] = `"etag-2-modified-again"`
+	require.Equal(t, []int64{4}, query(ctx, `SELECT s.id FROM S3Object s`))
+	require.Equal(t, []string{"data/2.json"}, selected)
+}
+
+func TestS3SelectConfig__ResultCacheParams(t *testing.T) {
+	cases := []struct {
+		dsn      string
+		expected string
+	}{
+		{
+			dsn:      "s3://example-com/data/?format=csv&cache_ttl=1h",
+			expected: "dsn is invalid: set query params: cache_ttl and cache_max_size require cache",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&cache=memory&cache_max_size=0",
+			expected: "dsn is invalid: set query params: parse cache_max_size: size must be positive",
+		},
+		{
+			dsn:      "s3://example-com/data/?format=csv&cache=memory&cache_ttl=0",
+			expected: "dsn is invalid: set query params: cache_ttl must be positive",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.dsn, func(t *testing.T) {
+			_, err := ParseDSN(c.dsn)
+			require.EqualError(t, err, c.expected)
+		})
+	}
+}
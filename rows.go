@@ -11,6 +11,19 @@ type s3SelectRows struct {
 	columns   []string
 	rows      [][]interface{}
 	index     int
+
+	// continuation is updated with the origin of each row as rows are read.
+	continuation *Continuation
+	fingerprint  string
+	origins      []rowOrigin
+	// err is the error of the query after rows, the continuation token is set to errOrigin by the error.
+	err       error
+	errOrigin rowOrigin
+
+	// schema is inferred from rows lazily, for column types.
+	schema *Schema
+	// knownSchema is the schema of objects such as the footer of parquet, types of columns in it are preferred.
+	knownSchema *Schema
 }
 
 func newRows(columns []string, rows [][]interface{}, parseTime bool) *s3SelectRows {
@@ -34,26 +47,49 @@ func (rows *s3SelectRows) Columns() []string {
 
 func (rows *s3SelectRows) Next(dest []driver.Value) error {
 	if rows.index >= len(rows.rows) {
+		if rows.err != nil {
+			rows.setToken(rows.errOrigin)
+			return rows.err
+		}
 		return io.EOF
 	}
-	row := rows.rows[rows.index]
+	scanRow(dest, rows.rows[rows.index], rows.parseTime)
+	// values are consistent with ColumnTypeScanType
+	rows.convertRow(dest)
+	if rows.index < len(rows.origins) {
+		rows.setToken(rows.origins[rows.index])
+	}
+	rows.index++
+	return nil
+}
+
+func (rows *s3SelectRows) setToken(origin rowOrigin) {
+	if rows.continuation == nil || origin.key == "" {
+		return
+	}
+	rows.continuation.setToken(resumePoint{
+		Fingerprint: rows.fingerprint,
+		Source:      origin.source,
+		Bucket:      origin.bucket,
+		Key:         origin.key,
+		Offset:      origin.offset,
+	}.encode())
+}
+
+func scanRow(dest []driver.Value, row []interface{}, withParseTime bool) {
 	for i := range dest {
 		if i >= len(row) {
 			dest[i] = nil
 			continue
 		}
-		if str, ok := row[i].(string); ok && rows.parseTime {
+		if str, ok := row[i].(string); ok && withParseTime {
 			if t, ok := parseTime(str); ok {
 				dest[i] = t
 				continue
 			}
 		}
-		if i < len(row) {
-			dest[i] = row[i]
-		}
+		dest[i] = row[i]
 	}
-	rows.index++
-	return nil
 }
 
 func parseTime(s string) (time.Time, bool) {
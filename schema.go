@@ -0,0 +1,790 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/iancoleman/orderedmap"
+	"github.com/mashiike/s3-select-sql-driver/lexer"
+)
+
+const (
+	defaultSampleRecords = 100
+	defaultSampleObjects = 3
+)
+
+// column types of Schema.
+const (
+	SchemaTypeString    = "string"
+	SchemaTypeBigint    = "bigint"
+	SchemaTypeDouble    = "double"
+	SchemaTypeBoolean   = "boolean"
+	SchemaTypeTimestamp = "timestamp"
+	SchemaTypeStruct    = "struct"
+	SchemaTypeArray     = "array"
+	SchemaTypeMap       = "map"
+)
+
+// Schema is the schema of the dataset, inferred from sampled records.
+type Schema struct {
+	Columns []*SchemaColumn
+}
+
+// SchemaColumn is a column of Schema. Fields are set for struct and map (the key and the value), and Element is set for array.
+type SchemaColumn struct {
+	Name     string
+	Type     string
+	Nullable bool
+	Fields   []*SchemaColumn
+	Element  *SchemaColumn
+
+	// count is the number of non-null values, for merging.
+	count int
+	// null is true if only null values are seen.
+	null bool
+}
+
+// Column returns the column by name, case-insensitive. nil if not found.
+func (s *Schema) Column(name string) *SchemaColumn {
+	for _, column := range s.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return column
+		}
+	}
+	return nil
+}
+
+// TypeName returns the type of the column including nested types, such as `array<struct<id:bigint,name:string>>`.
+func (c *SchemaColumn) TypeName() string {
+	switch c.Type {
+	case SchemaTypeStruct:
+		fields := make([]string, 0, len(c.Fields))
+		for _, field := range c.Fields {
+			fields = append(fields, field.Name+":"+field.TypeName())
+		}
+		return "struct<" + strings.Join(fields, ",") + ">"
+	case SchemaTypeArray:
+		if c.Element == nil {
+			return "array<string>"
+		}
+		return "array<" + c.Element.TypeName() + ">"
+	case SchemaTypeMap:
+		if len(c.Fields) != 2 {
+			return "map<string,string>"
+		}
+		return "map<" + c.Fields[0].TypeName() + "," + c.Fields[1].TypeName() + ">"
+	}
+	return c.Type
+}
+
+// ScanType returns the Go type of values of the column after ConvertValue.
+func (c *SchemaColumn) ScanType() reflect.Type {
+	switch c.Type {
+	case SchemaTypeBigint:
+		return reflect.TypeOf(int64(0))
+	case SchemaTypeDouble:
+		return reflect.TypeOf(float64(0))
+	case SchemaTypeBoolean:
+		return reflect.TypeOf(false)
+	case SchemaTypeTimestamp:
+		return reflect.TypeOf(time.Time{})
+	case SchemaTypeString:
+		return reflect.TypeOf("")
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+// ConvertValue converts the raw value of the column, such as a string of CSV, to the value of the type.
+// it implements driver.ValueConverter.
+func (c *SchemaColumn) ConvertValue(v interface{}) (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch c.Type {
+	case SchemaTypeBigint:
+		switch v := v.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			// float64 is integral in [-2^63, 2^63)
+			if v != math.Trunc(v) || v < -(1<<63) || v >= 1<<63 {
+				return nil, fmt.Errorf("column %s: can not convert %v to %s", c.Name, v, c.Type)
+			}
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		}
+	case SchemaTypeDouble:
+		switch v := v.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(v), 64)
+		}
+	case SchemaTypeBoolean:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(v))
+		}
+	case SchemaTypeTimestamp:
+		switch v := v.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			if t, ok := parseTime(v); ok {
+				return t, nil
+			}
+			return nil, fmt.Errorf("column %s: can not parse %q as timestamp", c.Name, v)
+		}
+	case SchemaTypeString:
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case time.Time:
+			return v.Format(time.RFC3339Nano), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return fmt.Sprint(v), nil
+	default:
+		return v, nil
+	}
+	return nil, fmt.Errorf("column %s: can not convert %T to %s", c.Name, v, c.Type)
+}
+
+// stringInference is how types of string values are inferred.
+type stringInference int
+
+const (
+	// stringAsString infers strings as string.
+	stringAsString stringInference = iota
+	// stringAsTimestamp infers strings in time formats as timestamp, such as JSON values.
+	stringAsTimestamp
+	// stringAsAny infers strings as bigint, double, boolean or timestamp by the content, such as CSV fields.
+	stringAsAny
+)
+
+// schemaBuilder infers the schema from records.
+type schemaBuilder struct {
+	columns []*SchemaColumn
+	records int
+}
+
+func (b *schemaBuilder) add(keys []string, get func(string) (interface{}, bool), inference stringInference) {
+	b.records++
+	for _, key := range keys {
+		v, _ := get(key)
+		column := findSchemaColumn(b.columns, key)
+		if column == nil {
+			column = &SchemaColumn{Name: key, null: true}
+			b.columns = append(b.columns, column)
+		}
+		mergeSchemaValue(column, v, inference)
+	}
+}
+
+func (b *schemaBuilder) schema() *Schema {
+	for _, column := range b.columns {
+		finishSchemaColumn(column, b.records)
+	}
+	return &Schema{Columns: b.columns}
+}
+
+func findSchemaColumn(columns []*SchemaColumn, name string) *SchemaColumn {
+	for _, column := range columns {
+		if column.Name == name {
+			return column
+		}
+	}
+	return nil
+}
+
+func mergeSchemaValue(column *SchemaColumn, v interface{}, inference stringInference) {
+	if v == nil {
+		column.Nullable = true
+		return
+	}
+	column.count++
+	typ := schemaTypeOf(v, inference)
+	if column.null {
+		column.null = false
+		column.Type = typ
+	} else {
+		column.Type = mergeSchemaType(column.Type, typ)
+	}
+	switch v := v.(type) {
+	case *orderedmap.OrderedMap:
+		mergeSchemaStruct(column, v.Keys(), v.Get, inference)
+	case orderedmap.OrderedMap:
+		mergeSchemaStruct(column, v.Keys(), v.Get, inference)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		mergeSchemaStruct(column, keys, func(key string) (interface{}, bool) {
+			value, ok := v[key]
+			return value, ok
+		}, inference)
+	case []interface{}:
+		if column.Element == nil {
+			column.Element = &SchemaColumn{Name: column.Name, null: true}
+		}
+		for _, elem := range v {
+			mergeSchemaValue(column.Element, elem, inference)
+		}
+	}
+}
+
+func mergeSchemaStruct(column *SchemaColumn, keys []string, get func(string) (interface{}, bool), inference stringInference) {
+	for _, key := range keys {
+		value, _ := get(key)
+		field := findSchemaColumn(column.Fields, key)
+		if field == nil {
+			field = &SchemaColumn{Name: key, null: true}
+			column.Fields = append(column.Fields, field)
+		}
+		mergeSchemaValue(field, value, inference)
+	}
+}
+
+// finishSchemaColumn sets Nullable of columns which are missing in some records, and the type of null only columns.
+func finishSchemaColumn(column *SchemaColumn, records int) {
+	if column.count < records {
+		column.Nullable = true
+	}
+	if column.null {
+		column.Type = SchemaTypeString
+		column.Nullable = true
+	}
+	for _, field := range column.Fields {
+		finishSchemaColumn(field, column.count)
+	}
+	if column.Element != nil {
+		finishSchemaColumn(column.Element, column.Element.count)
+	}
+}
+
+func schemaTypeOf(v interface{}, inference stringInference) string {
+	switch v := v.(type) {
+	case bool:
+		return SchemaTypeBoolean
+	case int64, int:
+		return SchemaTypeBigint
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return SchemaTypeBigint
+		}
+		return SchemaTypeDouble
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return SchemaTypeBigint
+		}
+		return SchemaTypeDouble
+	case time.Time:
+		return SchemaTypeTimestamp
+	case string:
+		if inference == stringAsString {
+			return SchemaTypeString
+		}
+		if inference == stringAsAny {
+			s := strings.TrimSpace(v)
+			if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return SchemaTypeBigint
+			}
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				return SchemaTypeDouble
+			}
+			if _, err := strconv.ParseBool(s); err == nil && !isNumeric(s) {
+				return SchemaTypeBoolean
+			}
+		}
+		if _, ok := parseTime(v); ok {
+			return SchemaTypeTimestamp
+		}
+		return SchemaTypeString
+	case *orderedmap.OrderedMap, orderedmap.OrderedMap, map[string]interface{}:
+		return SchemaTypeStruct
+	case []interface{}:
+		return SchemaTypeArray
+	}
+	return SchemaTypeString
+}
+
+func mergeSchemaType(a, b string) string {
+	if a == b {
+		return a
+	}
+	if (a == SchemaTypeBigint && b == SchemaTypeDouble) || (a == SchemaTypeDouble && b == SchemaTypeBigint) {
+		return SchemaTypeDouble
+	}
+	return SchemaTypeString
+}
+
+func (cfg *S3SelectConfig) setSampleParams(params url.Values) error {
+	intParams := []struct {
+		name string
+		dest *int
+	}{
+		{name: "sample_records", dest: &cfg.SampleRecords},
+		{name: "sample_objects", dest: &cfg.SampleObjects},
+	}
+	for _, p := range intParams {
+		if !params.Has(p.name) {
+			continue
+		}
+		n, err := strconv.Atoi(params.Get(p.name))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", p.name, err)
+		}
+		if n <= 0 {
+			return fmt.Errorf("%s must be positive", p.name)
+		}
+		*p.dest = n
+		cfg.Params.Del(p.name)
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setSampleToURLValues(params url.Values) {
+	if cfg.SampleRecords != 0 {
+		params.Set("sample_records", strconv.Itoa(cfg.SampleRecords))
+	} else {
+		params.Del("sample_records")
+	}
+	if cfg.SampleObjects != 0 {
+		params.Set("sample_objects", strconv.Itoa(cfg.SampleObjects))
+	} else {
+		params.Del("sample_objects")
+	}
+}
+
+// describedTable returns the table of `DESCRIBE <table>` or `SHOW COLUMNS FROM <table>`, such as S3Object.
+func describedTable(query string) (string, bool) {
+	tokens, err := significantTokens(query)
+	if err != nil {
+		return "", false
+	}
+	if n := len(tokens); n > 0 && tokens[n-1].Kind == lexer.KindSymbol && tokens[n-1].Value == ";" {
+		tokens = tokens[:n-1]
+	}
+	var table lexer.Token
+	switch {
+	case len(tokens) == 2 && (strings.EqualFold(tokens[0].Value, "DESCRIBE") || strings.EqualFold(tokens[0].Value, "DESC")):
+		table = tokens[1]
+	case len(tokens) == 4 && strings.EqualFold(tokens[0].Value, "SHOW") && strings.EqualFold(tokens[1].Value, "COLUMNS") &&
+		(strings.EqualFold(tokens[2].Value, "FROM") || strings.EqualFold(tokens[2].Value, "IN")):
+		table = tokens[3]
+	default:
+		return "", false
+	}
+	if tokens[0].Kind != lexer.KindIdentifier || (table.Kind != lexer.KindIdentifier && !(table.Kind == lexer.KindString && strings.HasPrefix(table.Value, `"`))) {
+		return "", false
+	}
+	return unquoteIdentifier(table.Value), true
+}
+
+// describe returns the inferred schema as rows of column_name, data_type and is_nullable.
+func (conn *s3SelectConn) describe(ctx context.Context) (driver.Rows, error) {
+	schema, err := conn.inferSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([][]interface{}, 0, len(schema.Columns))
+	for _, column := range schema.Columns {
+		nullable := "NO"
+		if column.Nullable {
+			nullable = "YES"
+		}
+		rows = append(rows, []interface{}{column.Name, column.TypeName(), nullable})
+	}
+	return newRows([]string{"column_name", "data_type", "is_nullable"}, rows, false), nil
+}
+
+// InferSchema infers the schema of the dataset of db, by sampling records of the first objects.
+func InferSchema(ctx context.Context, db *sql.DB) (*Schema, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var schema *Schema
+	err = conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*s3SelectConn)
+		if !ok {
+			return errors.New("db is not s3-select")
+		}
+		var err error
+		schema, err = c.inferSchema(ctx)
+		return err
+	})
+	return schema, err
+}
+
+// inferSchema samples the first SampleRecords records of the first SampleObjects objects by S3 Select with LIMIT.
+// the schema of parquet is read from the footer of the first parquet object instead, and the declared Schema is returned as is.
+func (conn *s3SelectConn) inferSchema(ctx context.Context) (*Schema, error) {
+	if conn.cfg.Schema != nil {
+		return conn.cfg.Schema, nil
+	}
+	if conn.cfg.KeyTemplate != "" {
+		return nil, errors.New("schema inference is not supported with key_template")
+	}
+	sampleRecords, sampleObjects := defaultSampleRecords, defaultSampleObjects
+	if conn.cfg.SampleRecords > 0 {
+		sampleRecords = conn.cfg.SampleRecords
+	}
+	if conn.cfg.SampleObjects > 0 {
+		sampleObjects = conn.cfg.SampleObjects
+	}
+	query := fmt.Sprintf("SELECT * FROM S3Object s LIMIT %d", sampleRecords)
+	if preset := conn.cfg.logPreset(); preset != nil && preset.fromRoot != "" && conn.cfg.InputSerialization == nil {
+		var err error
+		if query, err = rewriteFromRoot(query, preset.fromRoot); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	contentCh := make(chan contentInfo)
+	doneCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(contentCh)
+		errCh <- conn.enumerateContents(ctx, nil, nil, nil, contentCh, doneCh)
+	}()
+	var builder schemaBuilder
+	var footerSchema *Schema
+	var sampled int
+	for content := range contentCh {
+		ok, err := conn.sampleObject(ctx, content, query, &builder, &footerSchema)
+		if err != nil {
+			close(doneCh)
+			return nil, err
+		}
+		if ok {
+			sampled++
+		}
+		if sampled >= sampleObjects {
+			break
+		}
+	}
+	close(doneCh)
+	for range contentCh {
+	}
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	if footerSchema != nil {
+		return footerSchema, nil
+	}
+	schema := builder.schema()
+	conn.applyKnownTypes(schema)
+	return schema, nil
+}
+
+// sampleObject adds records of the object to builder, or sets footerSchema by the footer of parquet. ok is false if the object is skipped by unknown_format=skip.
+func (conn *s3SelectConn) sampleObject(ctx context.Context, content contentInfo, query string, builder *schemaBuilder, footerSchema **Schema) (bool, error) {
+	inputSerialization, err := conn.inputSerializationFor(ctx, content)
+	if err != nil {
+		if errors.Is(err, ErrUnknownFormat) && conn.cfg.UnknownFormatPolicy == UnknownFormatPolicySkip {
+			conn.debugf("skip object: %v", err)
+			return false, nil
+		}
+		return false, err
+	}
+	if inputSerialization.Parquet != nil {
+		footer, err := conn.parquetFooter(ctx, content)
+		if err == nil {
+			if *footerSchema == nil {
+				*footerSchema = footer.schema()
+			}
+			return true, nil
+		}
+		conn.debugf("parquet footer is not available: %v", err)
+	}
+	input := &s3.SelectObjectContentInput{
+		Bucket:             aws.String(content.BucketName),
+		Key:                aws.String(content.ObjectKey),
+		Expression:         aws.String(query),
+		ExpressionType:     types.ExpressionTypeSql,
+		InputSerialization: inputSerialization,
+		OutputSerialization: &types.OutputSerialization{
+			JSON: &types.JSONOutput{},
+		},
+	}
+	conn.cfg.applySelectObjectContentInput(input)
+	conn.debugf("sample s3://%s/%s", content.BucketName, content.ObjectKey)
+	var buf bytes.Buffer
+	if err := conn.client.SelectObjectContentWithWriter(ctx, &buf, input, conn.cfg.selectObjectContentOptFns()...); err != nil {
+		return false, fmt.Errorf("sample s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+	}
+	columnNames := conn.cfg.columnNames()
+	inference := stringAsTimestamp
+	if inputSerialization.CSV != nil {
+		inference = stringAsAny
+	}
+	dec := json.NewDecoder(&buf)
+	for {
+		o := orderedmap.New()
+		if err := dec.Decode(o); err != nil {
+			if err == io.EOF {
+				return true, nil
+			}
+			return false, fmt.Errorf("sample s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+		}
+		keys := o.Keys()
+		names := append([]string{}, keys...)
+		renameColumns(names, columnNames)
+		byName := make(map[string]string, len(keys))
+		for i, name := range names {
+			byName[name] = keys[i]
+		}
+		builder.add(names, func(name string) (interface{}, bool) {
+			return o.Get(byName[name])
+		}, inference)
+	}
+}
+
+// applyKnownTypes overwrites types of columns of the log format preset.
+func (conn *s3SelectConn) applyKnownTypes(schema *Schema) {
+	preset := conn.cfg.logPreset()
+	if preset == nil || conn.cfg.InputSerialization != nil {
+		return
+	}
+	for name, typ := range preset.types {
+		column := schema.Column(name)
+		if column == nil {
+			continue
+		}
+		switch typ.typ {
+		case columnTypeInt:
+			column.Type = SchemaTypeBigint
+		case columnTypeFloat:
+			column.Type = SchemaTypeDouble
+		default:
+			column.Type = SchemaTypeTimestamp
+		}
+	}
+}
+
+// convertRow converts values of the row to ScanType of scalar columns, such as float64 of JSON numbers to int64 of BIGINT.
+// values which can not be converted are left as is.
+func (rows *s3SelectRows) convertRow(dest []driver.Value) {
+	for i, v := range dest {
+		if v == nil {
+			continue
+		}
+		column := rows.schemaColumn(i)
+		switch column.Type {
+		case SchemaTypeBigint, SchemaTypeDouble, SchemaTypeBoolean, SchemaTypeTimestamp:
+		default:
+			continue
+		}
+		if reflect.TypeOf(v) == column.ScanType() {
+			continue
+		}
+		if converted, err := column.ConvertValue(v); err == nil {
+			dest[i] = converted
+		}
+	}
+}
+
+// ColumnTypeDatabaseTypeName implements driver.RowsColumnTypeDatabaseTypeName, the type is inferred from values of the result.
+func (rows *s3SelectRows) ColumnTypeDatabaseTypeName(index int) string {
+	return strings.ToUpper(rows.schemaColumn(index).TypeName())
+}
+
+// ColumnTypeScanType implements driver.RowsColumnTypeScanType.
+func (rows *s3SelectRows) ColumnTypeScanType(index int) reflect.Type {
+	return rows.schemaColumn(index).ScanType()
+}
+
+// ColumnTypeNullable implements driver.RowsColumnTypeNullable.
+func (rows *s3SelectRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return rows.schemaColumn(index).Nullable, true
+}
+
+func (rows *s3SelectRows) schemaColumn(index int) *SchemaColumn {
+	if rows.schema == nil {
+		// without parse_time, strings are scanned as strings
+		inference := stringAsString
+		if rows.parseTime {
+			inference = stringAsTimestamp
+		}
+		indexes := make(map[string]int, len(rows.columns))
+		for i, column := range rows.columns {
+			indexes[column] = i
+		}
+		var builder schemaBuilder
+		for _, row := range rows.rows {
+			builder.add(rows.columns, func(name string) (interface{}, bool) {
+				if i, ok := indexes[name]; ok && i < len(row) {
+					return row[i], true
+				}
+				return nil, false
+			}, inference)
+		}
+		rows.schema = builder.schema()
+		if rows.knownSchema != nil {
+			for i, column := range rows.schema.Columns {
+				if known := rows.knownSchema.Column(column.Name); known != nil {
+					copied := *known
+					copied.Name = column.Name
+					rows.schema.Columns[i] = &copied
+				}
+			}
+		}
+	}
+	if index < len(rows.schema.Columns) {
+		return rows.schema.Columns[index]
+	}
+	return &SchemaColumn{Type: SchemaTypeString, Nullable: true}
+}
+
+// parseTypeName parses the type such as `array<struct<id:bigint,name:string>>`, the inverse of TypeName.
+// scalar returns the type of Schema for the scalar type name, false if it is not supported.
+func parseTypeName(s string, scalar func(name string) (string, bool)) (*SchemaColumn, error) {
+	p := &typeNameParser{s: s, scalar: scalar}
+	column, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q in type %q", p.s[p.pos:], s)
+	}
+	return column, nil
+}
+
+type typeNameParser struct {
+	s      string
+	pos    int
+	scalar func(name string) (string, bool)
+}
+
+func (p *typeNameParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// name reads a type name or a field name, parameters such as `decimal(10,2)` are included.
+func (p *typeNameParser) name() string {
+	start := p.pos
+	var depth int
+	for ; p.pos < len(p.s); p.pos++ {
+		c := p.s[p.pos]
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.IndexByte("<>,:", c) >= 0:
+			return strings.TrimSpace(p.s[start:p.pos])
+		}
+	}
+	return strings.TrimSpace(p.s[start:])
+}
+
+func (p *typeNameParser) consume(c byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *typeNameParser) expect(c byte) error {
+	if !p.consume(c) {
+		return fmt.Errorf("%q is expected in type %q", c, p.s)
+	}
+	return nil
+}
+
+func (p *typeNameParser) parse() (*SchemaColumn, error) {
+	name := strings.ToLower(p.name())
+	column := &SchemaColumn{Type: name, Nullable: true}
+	switch name {
+	case SchemaTypeArray:
+		if err := p.expect('<'); err != nil {
+			return nil, err
+		}
+		element, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		column.Element = element
+	case SchemaTypeMap:
+		if err := p.expect('<'); err != nil {
+			return nil, err
+		}
+		key, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+		value, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		column.Fields = []*SchemaColumn{key, value}
+	case SchemaTypeStruct:
+		if err := p.expect('<'); err != nil {
+			return nil, err
+		}
+		for {
+			fieldName := p.name()
+			if fieldName == "" {
+				return nil, fmt.Errorf("field name is empty in type %q", p.s)
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			field, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			field.Name = fieldName
+			column.Fields = append(column.Fields, field)
+			if !p.consume(',') {
+				break
+			}
+		}
+	default:
+		typ, ok := p.scalar(name)
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %q", name)
+		}
+		column.Type = typ
+		return column, nil
+	}
+	if err := p.expect('>'); err != nil {
+		return nil, err
+	}
+	return column, nil
+}
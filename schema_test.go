@@ -0,0 +1,213 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/iancoleman/orderedmap"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaBuilder(t *testing.T) {
+	cases := []struct {
+		name      string
+		records   []string
+		inference stringInference
+		expected  [][3]string
+	}{
+		{
+			name: "json",
+			records: []string{
+				`{"id":1,"score":1,"name":"a","at":"2023-01-02T03:04:05Z","tags":["x"],"user":{"id":1}}`,
+				`{"id":2,"score":1.5,"name":null,"at":"2023-01-02T03:04:05Z","tags":[],"user":{"id":2,"name":"b"}}`,
+			},
+			inference: stringAsTimestamp,
+			expected: [][3]string{
+				{"id", "bigint", "NO"},
+				{"score", "double", "NO"},
+				{"name", "string", "YES"},
+				{"at", "timestamp", "NO"},
+				{"tags", "array<string>", "NO"},
+				{"user", "struct<id:bigint,name:string>", "NO"},
+			},
+		},
+		{
+			name: "missing keys and conflicts",
+			records: []string{
+				`{"id":1,"value":true}`,
+				`{"id":"x","extra":null}`,
+			},
+			inference: stringAsTimestamp,
+			expected: [][3]string{
+				{"id", "string", "NO"},
+				{"value", "boolean", "YES"},
+				{"extra", "string", "YES"},
+			},
+		},
+		{
+			name: "csv",
+			records: []string{
+				`{"id":"1","price":"10","ok":"true","at":"2023-01-02","name":"a"}`,
+				`{"id":"2","price":"10.5","ok":"false","at":"2023-01-03","name":"1"}`,
+			},
+			inference: stringAsAny,
+			expected: [][3]string{
+				{"id", "bigint", "NO"},
+				{"price", "double", "NO"},
+				{"ok", "boolean", "NO"},
+				{"at", "timestamp", "NO"},
+				{"name", "string", "NO"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var builder schemaBuilder
+			for _, record := range c.records {
+				o := orderedmap.New()
+				require.NoError(t, o.UnmarshalJSON([]byte(record)))
+				builder.add(o.Keys(), o.Get, c.inference)
+			}
+			schema := builder.schema()
+			actual := make([][3]string, 0, len(schema.Columns))
+			for _, column := range schema.Columns {
+				nullable := "NO"
+				if column.Nullable {
+					nullable = "YES"
+				}
+				actual = append(actual, [3]string{column.Name, column.TypeName(), nullable})
+			}
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestSchemaColumn__ConvertValue(t *testing.T) {
+	cases := []struct {
+		typ      string
+		value    interface{}
+		expected interface{}
+		isErr    bool
+	}{
+		{typ: SchemaTypeBigint, value: "10", expected: int64(10)},
+		{typ: SchemaTypeBigint, value: float64(10), expected: int64(10)},
+		{typ: SchemaTypeBigint, value: 1.5, isErr: true},
+		{typ: SchemaTypeBigint, value: 1e19, isErr: true},
+		{typ: SchemaTypeDouble, value: "1.5", expected: 1.5},
+		{typ: SchemaTypeBoolean, value: "true", expected: true},
+		{typ: SchemaTypeTimestamp, value: "2023-01-02", expected: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{typ: SchemaTypeString, value: float64(1), expected: "1"},
+		{typ: SchemaTypeString, value: nil, expected: nil},
+	}
+	for _, c := range cases {
+		column := &SchemaColumn{Name: "c", Type: c.typ}
+		actual, err := column.ConvertValue(c.value)
+		if c.isErr {
+			require.Error(t, err, c.value)
+			continue
+		}
+		require.NoError(t, err)
+		require.EqualValues(t, c.expected, actual)
+	}
+	_, err := (&SchemaColumn{Name: "c", Type: SchemaTypeBigint}).ConvertValue("x")
+	require.Error(t, err)
+}
+
+func TestRows__ScanType(t *testing.T) {
+	rows := newRows([]string{"id", "score", "name"}, [][]interface{}{
+		{float64(1), 1.5, "a"},
+		{float64(2), float64(2), nil},
+	}, false)
+	dest := make([]driver.Value, 3)
+	for rows.Next(dest) == nil {
+		for i, v := range dest {
+			if v == nil {
+				continue
+			}
+			require.Equal(t, rows.ColumnTypeScanType(i), reflect.TypeOf(v), rows.columns[i])
+		}
+	}
+	require.Equal(t, "BIGINT", rows.ColumnTypeDatabaseTypeName(0))
+	require.Equal(t, "DOUBLE", rows.ColumnTypeDatabaseTypeName(1))
+}
+
+func TestDescribedTable(t *testing.T) {
+	for query, expected := range map[string]string{
+		"DESCRIBE S3Object":               "S3Object",
+		"desc s3object;":                  "s3object",
+		"SHOW COLUMNS FROM S3Object":      "S3Object",
+		"show columns in s3object":        "s3object",
+		`DESCRIBE "access_logs"`:          "access_logs",
+		"SELECT * FROM S3Object":          "",
+		"DESCRIBE S3Object s WHERE 1 = 1": "",
+	} {
+		table, ok := describedTable(query)
+		require.Equal(t, expected != "", ok, query)
+		require.Equal(t, expected, table, query)
+	}
+}
+
+func TestMock__Describe(t *testing.T) {
+	var expressions []string
+	mockClients["describe"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			return &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+				Contents: []types.Object{
+					{Key: aws.String("data/1.csv")},
+					{Key: aws.String("data/2.csv")},
+					{Key: aws.String("data/3.csv")},
+				},
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			expressions = append(expressions, *params.Expression)
+			if *params.Key == "data/1.csv" {
+				_, err := io.WriteString(w, `{"id":"1","name":"a"}`+"\n")
+				return err
+			}
+			_, err := io.WriteString(w, `{"id":"2","name":"b","price":"1.5"}`+"\n")
+			return err
+		},
+	}
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=csv&sample_objects=2&sample_records=10&mock=describe")
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.QueryContext(context.Background(), `DESCRIBE S3Object`)
+	require.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	require.Equal(t, []string{"column_name", "data_type", "is_nullable"}, columns)
+	columnTypes, err := rows.ColumnTypes()
+	require.NoError(t, err)
+	require.Equal(t, "STRING", columnTypes[0].DatabaseTypeName())
+	nullable, ok := columnTypes[0].Nullable()
+	require.True(t, ok)
+	require.False(t, nullable)
+	var actual []string
+	for rows.Next() {
+		var name, typ, nullable string
+		require.NoError(t, rows.Scan(&name, &typ, &nullable))
+		actual = append(actual, strings.Join([]string{name, typ, nullable}, " "))
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"id bigint NO", "name string NO", "price double YES"}, actual)
+	require.Equal(t, []string{"SELECT * FROM S3Object s LIMIT 10", "SELECT * FROM S3Object s LIMIT 10"}, expressions)
+
+	schema, err := InferSchema(context.Background(), db)
+	require.NoError(t, err)
+	require.Equal(t, SchemaTypeBigint, schema.Column("ID").Type)
+}
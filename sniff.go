@@ -0,0 +1,261 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// sniffBytes is the size of the head of object read for sniffing.
+	sniffBytes = 16 * 1024
+	// sniffCacheSize is the max number of cached sniff results.
+	sniffCacheSize = 10000
+)
+
+type sniffResult struct {
+	Format          S3SelectFormat
+	CompressionType S3SelectCompressionType
+	HasHeader       bool
+}
+
+// sniffCache caches sniff results per object version.
+var sniffCache = newLRUCache[objectVersion, sniffResult](sniffCacheSize)
+
+// sniffInputSerialization returns InputSerialization detected from the content of the object with format=sniff.
+func (conn *s3SelectConn) sniffInputSerialization(ctx context.Context, content contentInfo, compressionType S3SelectCompressionType) (*types.InputSerialization, error) {
+	// the ETag is also used for the parquet footer read by sniffing
+	content.ETag = conn.objectETag(ctx, content)
+	result, ok := sniffCache.get(objectVersion{bucket: content.BucketName, key: content.ObjectKey, etag: content.ETag})
+	if !ok || content.ETag == "" {
+		var etag string
+		var err error
+		result, etag, err = conn.sniffObject(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		if etag != "" {
+			sniffCache.add(objectVersion{bucket: content.BucketName, key: content.ObjectKey, etag: etag}, result)
+		}
+	}
+	if result.Format == "" {
+		return nil, fmt.Errorf("%w: s3://%s/%s", ErrUnknownFormat, content.BucketName, content.ObjectKey)
+	}
+	conn.debugf("sniffed s3://%s/%s format=%s compression_type=%s header=%v", content.BucketName, content.ObjectKey, result.Format, result.CompressionType, result.HasHeader)
+	copied := *conn.cfg
+	copied.Format = result.Format
+	copied.CompressionType = result.CompressionType
+	if compressionType != "" {
+		copied.CompressionType = compressionType
+	}
+	inputSerialization, err := copied.newInputSeliarization()
+	if err != nil {
+		return nil, err
+	}
+	if inputSerialization.CSV != nil && !result.HasHeader && conn.cfg.Header == "" {
+		inputSerialization.CSV.FileHeaderInfo = types.FileHeaderInfoNone
+	}
+	return inputSerialization, nil
+}
+
+// sniffObject reads the head of the object by ranged GetObject, and detects the format.
+// unknown format is returned as empty Format, for caching.
+func (conn *s3SelectConn) sniffObject(ctx context.Context, content contentInfo) (sniffResult, string, error) {
+	head, etag, err := conn.getObjectRange(ctx, content, fmt.Sprintf("bytes=0-%d", sniffBytes-1), sniffBytes)
+	if err != nil {
+		return sniffResult{}, "", err
+	}
+	var result sniffResult
+	if bytes.HasPrefix(head, []byte("PAR1")) {
+		// parquet has magic bytes at both of the head and the footer, the footer is cached for statistics
+		_, err := conn.parquetFooter(ctx, content)
+		switch {
+		case errors.Is(err, errNotParquet):
+			return result, etag, nil
+		case err != nil && !errors.Is(err, errInvalidParquetFooter):
+			return sniffResult{}, "", err
+		}
+		result.Format = S3SelectFormatParquet
+		result.CompressionType = S3SelectCompressionTypeNone
+		return result, etag, nil
+	}
+	sample := head
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		result.CompressionType = S3SelectCompressionTypeGzip
+		gr, err := gzip.NewReader(bytes.NewReader(head))
+		if err != nil {
+			return result, etag, nil
+		}
+		sample = readPartial(gr)
+	case len(head) >= 4 && bytes.HasPrefix(head, []byte("BZh")) && head[3] >= '1' && head[3] <= '9':
+		result.CompressionType = S3SelectCompressionTypeBzip2
+		sample = readPartial(bzip2.NewReader(bytes.NewReader(head)))
+	default:
+		result.CompressionType = S3SelectCompressionTypeNone
+	}
+	result.Format, result.HasHeader = sniffFormat(sample, len(head) < sniffBytes)
+	return result, etag, nil
+}
+
+// getObjectRange reads the range of the object up to limit bytes, and returns it with the ETag.
+func (conn *s3SelectConn) getObjectRange(ctx context.Context, content contentInfo, byteRange string, limit int64) ([]byte, string, error) {
+	client, ok := conn.client.(S3GetObjectClient)
+	if !ok {
+		return nil, "", errors.New("s3 select client does not support GetObject")
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(content.BucketName),
+		Key:    aws.String(content.ObjectKey),
+		Range:  aws.String(byteRange),
+	}
+	conn.cfg.applyGetObjectInput(input)
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = conn.cfg.sseCustomerKeyHeaders()
+	output, err := client.GetObject(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("get object s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+	}
+	defer output.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(output.Body, limit))
+	if err != nil {
+		return nil, "", fmt.Errorf("get object s3://%s/%s: %w", content.BucketName, content.ObjectKey, err)
+	}
+	return bs, aws.ToString(output.ETag), nil
+}
+
+// readPartial reads r until error, the head of compressed stream ends with unexpected EOF.
+func readPartial(r io.Reader) []byte {
+	var buf bytes.Buffer
+	io.Copy(&buf, io.LimitReader(r, 4*sniffBytes))
+	return buf.Bytes()
+}
+
+// sniffFormat detects the format of decompressed sample. complete is true if sample is the whole of the object.
+func sniffFormat(sample []byte, complete bool) (S3SelectFormat, bool) {
+	sample = bytes.TrimPrefix(sample, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimLeft(sample, " \t\r\n")
+	if len(trimmed) == 0 {
+		return "", false
+	}
+	switch trimmed[0] {
+	case '[':
+		return S3SelectFormatJSON, false
+	case '{':
+		return sniffJSON(trimmed), false
+	}
+	if !complete {
+		// drop the last line, it may be truncated
+		if i := bytes.LastIndexByte(sample, '\n'); i > 0 {
+			sample = sample[:i]
+		}
+	}
+	return sniffCSV(sample)
+}
+
+// sniffJSON detects JSON document or JSON Lines. multiple values separated by newlines are JSON Lines.
+func sniffJSON(sample []byte) S3SelectFormat {
+	dec := json.NewDecoder(bytes.NewReader(sample))
+	var v json.RawMessage
+	if err := dec.Decode(&v); err != nil {
+		// a large document is truncated
+		return S3SelectFormatJSON
+	}
+	rest := sample[dec.InputOffset():]
+	trimmed := bytes.TrimLeft(rest, " \t\r")
+	if !bytes.HasPrefix(trimmed, []byte("\n")) {
+		return S3SelectFormatJSON
+	}
+	if bytes.HasPrefix(bytes.TrimLeft(trimmed, " \t\r\n"), []byte("{")) {
+		return S3SelectFormatJSONL
+	}
+	return S3SelectFormatJSON
+}
+
+// sniffCSV detects CSV or TSV by the consistency of delimiters, and whether the first row is header.
+func sniffCSV(sample []byte) (S3SelectFormat, bool) {
+	candidates := []struct {
+		format    S3SelectFormat
+		delimiter rune
+	}{
+		{format: S3SelectFormatTSV, delimiter: '\t'},
+		{format: S3SelectFormatCSV, delimiter: ','},
+	}
+	for _, c := range candidates {
+		if !bytes.ContainsRune(sample, c.delimiter) {
+			continue
+		}
+		r := csv.NewReader(bytes.NewReader(sample))
+		r.Comma = c.delimiter
+		r.LazyQuotes = true
+		records, err := r.ReadAll()
+		if err != nil || len(records) == 0 || len(records[0]) < 2 {
+			continue
+		}
+		return c.format, hasHeader(records)
+	}
+	return "", false
+}
+
+// hasHeader votes for each column, the first row is header if the column types or lengths of the first row differ from the others.
+// columns of variable length strings have no vote.
+func hasHeader(records [][]string) bool {
+	header := records[0]
+	rows := records[1:]
+	if len(rows) == 0 {
+		return false
+	}
+	seen := make(map[string]bool, len(header))
+	for _, name := range header {
+		if strings.TrimSpace(name) == "" || seen[name] {
+			return false
+		}
+		seen[name] = true
+	}
+	var votes int
+	for i, name := range header {
+		allNumeric := true
+		sameLength := true
+		length := -1
+		for _, row := range rows {
+			if i >= len(row) {
+				continue
+			}
+			if !isNumeric(row[i]) {
+				allNumeric = false
+			}
+			if length >= 0 && len(row[i]) != length {
+				sameLength = false
+			}
+			length = len(row[i])
+		}
+		switch {
+		case isNumeric(name):
+			votes--
+		case length < 0:
+		case allNumeric:
+			votes++
+		case sameLength && len(name) != length:
+			votes++
+		case sameLength:
+			votes--
+		}
+	}
+	return votes > 0
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil
+}
@@ -0,0 +1,193 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffFormat(t *testing.T) {
+	cases := []struct {
+		name      string
+		sample    string
+		complete  bool
+		format    S3SelectFormat
+		hasHeader bool
+	}{
+		{
+			name:     "json_document",
+			sample:   `{"records":[{"id":1},{"id":2}]}`,
+			complete: true,
+			format:   S3SelectFormatJSON,
+		},
+		{
+			name:     "json_array",
+			sample:   `[{"id":1},{"id":2}]`,
+			complete: true,
+			format:   S3SelectFormatJSON,
+		},
+		{
+			name:     "json_lines",
+			sample:   "{\"id\":1}\n{\"id\":2}\n",
+			complete: true,
+			format:   S3SelectFormatJSONL,
+		},
+		{
+			name:     "truncated_json_document",
+			sample:   "{\n  \"records\": [\n    {\"id\": 1},\n",
+			complete: false,
+			format:   S3SelectFormatJSON,
+		},
+		{
+			name:      "csv_with_header",
+			sample:    "id,name,score\n1,hoge,1.5\n2,fuga,2.5\n3,pi",
+			complete:  false,
+			format:    S3SelectFormatCSV,
+			hasHeader: true,
+		},
+		{
+			name:     "csv_without_header",
+			sample:   "1,hoge,1.5\n2,fuga,2.5\n",
+			complete: true,
+			format:   S3SelectFormatCSV,
+		},
+		{
+			name:      "tsv_with_header",
+			sample:    "id\tmessage\n1\thello, world\n2\tgood, bye\n",
+			complete:  true,
+			format:    S3SelectFormatTSV,
+			hasHeader: true,
+		},
+		{
+			name:     "plain_text",
+			sample:   "hello world\n",
+			complete: true,
+			format:   "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			format, hasHeader := sniffFormat([]byte(c.sample), c.complete)
+			require.Equal(t, c.format, format)
+			require.Equal(t, c.hasHeader, hasHeader)
+		})
+	}
+}
+
+func TestMock__FormatSniff(t *testing.T) {
+	objects := map[string]string{
+		"data/part-00000": "id,name\n1,hoge\n2,fugafuga\n",
+		"data/part-00001": gzipString(t, "{\"id\":1}\n{\"id\":2}\n"),
+		"data/part-00002": "PAR1" + strings.Repeat("\x00", 16) + "PAR1",
+		"data/part-00003": "hello world\n",
+	}
+	var getObjectCalls int
+	mockClients["format_sniff"] = &mockS3SelectClient{
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			output := &s3.ListObjectsV2Output{
+				Name: params.Bucket,
+			}
+			for _, key := range []string{"data/part-00000", "data/part-00001", "data/part-00002", "data/part-00003"} {
+				output.Contents = append(output.Contents, types.Object{
+					Key:  aws.String(key),
+					ETag: aws.String(fmt.Sprintf(`"sniff-%s"`, key)),
+				})
+			}
+			return output, nil
+		},
+		GetObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			getObjectCalls++
+			body := objects[*params.Key]
+			if *params.Range == "bytes=-4" {
+				body = body[len(body)-4:]
+			}
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(bytes.NewReader([]byte(body))),
+				ETag: aws.String(fmt.Sprintf(`"sniff-%s"`, *params.Key)),
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			var format string
+			switch {
+			case params.InputSerialization.CSV != nil:
+				format = string(params.InputSerialization.CSV.FileHeaderInfo)
+			case params.InputSerialization.JSON != nil:
+				format = string(params.InputSerialization.JSON.Type)
+			case params.InputSerialization.Parquet != nil:
+				format = "parquet"
+			}
+			fmt.Fprintf(w, `{"key":"%s","format":"%s","compression_type":"%s"}`+"\n", *params.Key, format, params.InputSerialization.CompressionType)
+			return nil
+		},
+	}
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=sniff&unknown_format=skip&mock=format_sniff")
+	require.NoError(t, err)
+	defer db.Close()
+	for i := 0; i < 2; i++ {
+		rows, err := db.QueryContext(context.Background(), `SELECT * FROM S3Object`)
+		require.NoError(t, err)
+		actual := make([]string, 0, 3)
+		for rows.Next() {
+			var key, format, compressionType string
+			require.NoError(t, rows.Scan(&key, &format, &compressionType))
+			actual = append(actual, key+":"+format+":"+compressionType)
+		}
+		require.NoError(t, rows.Close())
+		require.Equal(t, []string{
+			"data/part-00000:USE:NONE",
+			"data/part-00001:LINES:GZIP",
+			"data/part-00002:parquet:NONE",
+		}, actual)
+	}
+	require.Equal(t, 5, getObjectCalls, "second query uses cached results")
+}
+
+func TestMock__FormatSniff__SingleKey(t *testing.T) {
+	etag := `"single-1"`
+	var getObjectCalls, headObjectCalls int
+	mockClients["format_sniff_single_key"] = &mockS3SelectClient{
+		HeadObjectFunc: func(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+			headObjectCalls++
+			require.Equal(t, "data/single", *params.Key)
+			return &s3.HeadObjectOutput{ETag: aws.String(etag)}, nil
+		},
+		GetObjectFunc: func(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+			getObjectCalls++
+			body := "id,name\n1,hoge\n"
+			return &s3.GetObjectOutput{
+				Body: io.NopCloser(strings.NewReader(body)),
+				ETag: aws.String(etag),
+			}, nil
+		},
+		SelectObjectContentWithWriterFunc: func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+			_, err := fmt.Fprintf(w, `{"key":"%s"}`+"\n", *params.Key)
+			return err
+		},
+	}
+	db, err := sql.Open("s3-select", "s3://example-com/data/single?format=sniff&mock=format_sniff_single_key")
+	require.NoError(t, err)
+	defer db.Close()
+	query := func() {
+		t.Helper()
+		var key string
+		require.NoError(t, db.QueryRowContext(context.Background(), `SELECT * FROM S3Object`).Scan(&key))
+		require.Equal(t, "data/single", key)
+	}
+	query()
+	query()
+	require.Equal(t, 2, headObjectCalls)
+	require.Equal(t, 1, getObjectCalls, "the result is cached by the key and the ETag of HeadObject")
+
+	etag = `"single-2"`
+	query()
+	require.Equal(t, 2, getObjectCalls, "the overwritten object is sniffed again")
+}
@@ -0,0 +1,350 @@
+package s3selectsqldriver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3SelectSource is a location of objects queried as a part of one logical table.
+// Either ObjectKey or ObjectKeyPrefix is set.
+type S3SelectSource struct {
+	BucketName      string
+	ObjectKey       string
+	ObjectKeyPrefix string
+	// Format and CompressionType override S3SelectConfig's, if set.
+	Format          S3SelectFormat
+	CompressionType S3SelectCompressionType
+}
+
+func (src S3SelectSource) String() string {
+	path := "/" + src.ObjectKey
+	if src.ObjectKeyPrefix != "" {
+		path = "/" + src.ObjectKeyPrefix
+	}
+	u := &url.URL{
+		Scheme: "s3",
+		Host:   src.BucketName,
+		Path:   path,
+	}
+	return u.String()
+}
+
+// parseSource parses `s3://<bucket>/<key or prefix>`.
+func parseSource(s string) (S3SelectSource, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return S3SelectSource{}, err
+	}
+	if u.Scheme != "s3" {
+		return S3SelectSource{}, errors.New("dsn scheme not s3")
+	}
+	if u.Host == "" {
+		return S3SelectSource{}, errors.New("dsn bucket name is empty")
+	}
+	if u.Path == "" {
+		return S3SelectSource{}, errors.New("dsn object key is empty")
+	}
+	src := S3SelectSource{
+		BucketName: u.Host,
+	}
+	if strings.HasSuffix(u.Path, filepath.Base(u.Path)) {
+		src.ObjectKey = strings.TrimPrefix(u.Path, "/")
+	} else {
+		src.ObjectKeyPrefix = strings.TrimPrefix(u.Path, "/")
+	}
+	return src, nil
+}
+
+// splitSources splits comma separated sources, such as `s3://bucket/a.csv,s3://other/b/`.
+func splitSources(s string) []string {
+	parts := strings.Split(s, ",s3://")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = "s3://" + parts[i]
+	}
+	return parts
+}
+
+// sources returns the sources of the config. if Sources is empty, the single source of BucketName, ObjectKey and ObjectKeyPrefix is returned.
+func (cfg *S3SelectConfig) sources() []S3SelectSource {
+	if len(cfg.Sources) > 0 {
+		return cfg.Sources
+	}
+	return []S3SelectSource{
+		{
+			BucketName:      cfg.BucketName,
+			ObjectKey:       cfg.ObjectKey,
+			ObjectKeyPrefix: cfg.ObjectKeyPrefix,
+		},
+	}
+}
+
+// inputSerializationFor returns InputSerialization for the object of the source.
+// Format is resolved in order: S3SelectConfig.InputSerialization, source's Format, S3SelectConfig.Format, and the extension of the object key.
+func (cfg *S3SelectConfig) inputSerializationFor(src *S3SelectSource, objectKey string) (*types.InputSerialization, error) {
+	if cfg.InputSerialization != nil || src == nil {
+		return cfg.newInputSeliarization()
+	}
+	copied := *cfg
+	if src.Format != "" {
+		copied.Format = src.Format
+	}
+	if src.CompressionType != "" {
+		copied.CompressionType = src.CompressionType
+	}
+	if copied.Format == "" {
+		format, compressionType, ok := detectFormatFromKey(objectKey)
+		if !ok {
+			return nil, fmt.Errorf("format is not set: s3://%s/%s", src.BucketName, objectKey)
+		}
+		copied.Format = format
+		if compressionType != "" && src.CompressionType == "" && cfg.CompressionType == "" {
+			copied.CompressionType = compressionType
+		}
+	}
+	if copied.CompressionType == "" {
+		copied.CompressionType = S3SelectCompressionTypeNone
+	}
+	return copied.newInputSeliarization()
+}
+
+// detectSourcesFormat sets Format and CompressionType of each source from the extension of the object key.
+// a prefix source can not be detected, so format must be set.
+func (cfg *S3SelectConfig) detectSourcesFormat(compressionTypeSet bool) error {
+	if !compressionTypeSet {
+		cfg.CompressionType = ""
+	}
+	for i := range cfg.Sources {
+		src := &cfg.Sources[i]
+		if src.ObjectKey == "" {
+			return fmt.Errorf("format is not set: %s", src)
+		}
+		format, compressionType, detected := detectFormatFromKey(src.ObjectKey)
+		if !detected {
+			return fmt.Errorf("format is not set: %s", src)
+		}
+		src.Format = format
+		if compressionType != "" && !compressionTypeSet {
+			src.CompressionType = compressionType
+		}
+	}
+	return nil
+}
+
+// detectFormatFromKey detects the format and the compression type from extensions of the key, such as `.csv.gz`.
+// compressionType is empty if the key has no compression extension.
+func detectFormatFromKey(key string) (format S3SelectFormat, compressionType S3SelectCompressionType, detected bool) {
+	remain := key
+	for ext := filepath.Ext(remain); ext != ""; ext = filepath.Ext(remain) {
+		remain = strings.TrimSuffix(remain, ext)
+		switch ext {
+		case ".csv":
+			format = S3SelectFormatCSV
+			detected = true
+		case ".tsv":
+			format = S3SelectFormatTSV
+			detected = true
+		case ".json":
+			format = S3SelectFormatJSON
+			detected = true
+		case ".parquet":
+			format = S3SelectFormatParquet
+			detected = true
+		case ".jsonl", ".jsonlines":
+			format = S3SelectFormatJSONL
+			detected = true
+		case ".gz":
+			compressionType = S3SelectCompressionTypeGzip
+		case ".bz2":
+			compressionType = S3SelectCompressionTypeBzip2
+		}
+	}
+	return
+}
+
+// firstContent returns the first object of the dataset, which is not skipped by a continuation or an index.
+// it returns nil if the dataset has no objects.
+func (conn *s3SelectConn) firstContent(ctx context.Context, partitions []partition) (*contentInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	contentCh := make(chan contentInfo)
+	doneCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(contentCh)
+		errCh <- conn.enumerateContents(ctx, partitions, nil, nil, contentCh, doneCh)
+	}()
+	content, ok := <-contentCh
+	close(doneCh)
+	cancel()
+	for range contentCh {
+	}
+	err := <-errCh
+	if !ok {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// enumerateContents sends objects of all sources to contentCh in order.
+// with key_template, objects under the prefixes of partitions are sent instead.
+// with resume, objects before the resume point are skipped.
+// with prune, objects for which it returns true are skipped. the sidecar index file is never sent.
+func (conn *s3SelectConn) enumerateContents(ctx context.Context, partitions []partition, resume *resumePoint, prune func(contentInfo) bool, contentCh chan<- contentInfo, doneCh <-chan struct{}) error {
+	send := func(content contentInfo) bool {
+		if conn.cfg.isIndexObject(content) {
+			return true
+		}
+		if resume != nil {
+			if content.SourceIndex != resume.Source || content.BucketName != resume.Bucket || content.ObjectKey != resume.Key {
+				return true
+			}
+			content.Skip = resume.Offset
+			resume = nil
+		}
+		if prune != nil && prune(content) {
+			return true
+		}
+		select {
+		case contentCh <- content:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-doneCh:
+			return false
+		}
+	}
+	if conn.cfg.Manifest != "" {
+		if err := conn.enumerateManifest(ctx, send); err != nil {
+			return err
+		}
+		if resume != nil && ctx.Err() == nil {
+			return fmt.Errorf("object of continuation token is not found in manifest: s3://%s/%s", resume.Bucket, resume.Key)
+		}
+		return nil
+	}
+	if conn.cfg.KeyTemplate != "" || conn.glueTable.partitioned() {
+		for i := range partitions {
+			if resume != nil && i < resume.Source {
+				continue
+			}
+			p := &partitions[i]
+			bucket := p.bucket
+			if bucket == "" {
+				bucket = conn.cfg.BucketName
+			}
+			conn.debugf("partition: s3://%s/%s", bucket, p.prefix)
+			ok, err := conn.listContentsFrom(ctx, i, bucket, p.prefix, resume, doneCh, func(content contentInfo) bool {
+				content.SourceIndex = i
+				content.Partition = p
+				return send(content)
+			})
+			if err != nil || !ok {
+				return err
+			}
+		}
+		return nil
+	}
+	sources := conn.cfg.sources()
+	for i := range sources {
+		if resume != nil && i < resume.Source {
+			continue
+		}
+		src := &sources[i]
+		if src.ObjectKey != "" {
+			if !send(contentInfo{
+				BucketName:  src.BucketName,
+				ObjectKey:   src.ObjectKey,
+				Source:      src,
+				SourceIndex: i,
+			}) {
+				return nil
+			}
+			continue
+		}
+		ok, err := conn.listContentsFrom(ctx, i, src.BucketName, src.ObjectKeyPrefix, resume, doneCh, func(content contentInfo) bool {
+			content.Source = src
+			content.SourceIndex = i
+			return send(content)
+		})
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// listContentsFrom lists objects under the prefix of the index-th source.
+// if the resume point is in the source, the object of it is sent first, and objects after it are listed by StartAfter.
+func (conn *s3SelectConn) listContentsFrom(ctx context.Context, index int, bucketName string, prefix string, resume *resumePoint, doneCh <-chan struct{}, fn func(contentInfo) bool) (bool, error) {
+	input := conn.newListObjectsV2Input(bucketName, prefix)
+	if resume != nil && resume.Source == index {
+		if resume.Bucket != bucketName || !strings.HasPrefix(resume.Key, prefix) {
+			return false, errors.New("continuation token does not match sources")
+		}
+		if !fn(contentInfo{BucketName: resume.Bucket, ObjectKey: resume.Key}) {
+			return false, nil
+		}
+		input.StartAfter = aws.String(resume.Key)
+	}
+	return conn.listPrefix(ctx, input, doneCh, fn)
+}
+
+func (conn *s3SelectConn) newListObjectsV2Input(bucketName string, prefix string) *s3.ListObjectsV2Input {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	if preset := conn.cfg.logPreset(); preset != nil && preset.recursive {
+		input.Delimiter = nil
+	}
+	conn.cfg.applyListObjectsV2Input(input)
+	return input
+}
+
+// listContents lists objects by the input and calls fn for each object.
+// ok is false if fn returns false or the query is done.
+func (conn *s3SelectConn) listContents(ctx context.Context, input *s3.ListObjectsV2Input, doneCh <-chan struct{}, fn func(contentInfo) bool) (ok bool, err error) {
+	p := s3.NewListObjectsV2Paginator(conn.client, input)
+	for p.HasMorePages() {
+		select {
+		case <-conn.aliveCh:
+			return false, sql.ErrConnDone
+		case <-ctx.Done():
+			return false, nil
+		case <-doneCh:
+			return false, nil
+		default:
+		}
+		output, err := p.NextPage(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, object := range output.Contents {
+			if !fn(newContentInfo(output, object)) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func newContentInfo(output *s3.ListObjectsV2Output, object types.Object) contentInfo {
+	return contentInfo{
+		BucketName:   *output.Name,
+		ObjectKey:    *object.Key,
+		ETag:         aws.ToString(object.ETag),
+		LastModified: aws.ToTime(object.LastModified),
+		Size:         object.Size,
+		StorageClass: string(object.StorageClass),
+	}
+}
@@ -0,0 +1,258 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	sseCustomerAlgorithmAES256 = "AES256"
+	redactedValue              = "REDACTED"
+)
+
+func (cfg *S3SelectConfig) setRequestParams(params url.Values) error {
+	if params.Has("expected_bucket_owner") {
+		cfg.ExpectedBucketOwner = params.Get("expected_bucket_owner")
+		cfg.Params.Del("expected_bucket_owner")
+	}
+	if params.Has("request_payer") {
+		switch strings.ToLower(params.Get("request_payer")) {
+		case "requester":
+			cfg.RequestPayer = types.RequestPayerRequester
+		default:
+			return fmt.Errorf("unknown request_payer: %s", params.Get("request_payer"))
+		}
+		cfg.Params.Del("request_payer")
+	}
+	var keySources int
+	if params.Has("sse_customer_key") {
+		if params.Get("sse_customer_key") == redactedValue {
+			return errors.New("sse_customer_key is redacted")
+		}
+		key, err := base64.StdEncoding.DecodeString(params.Get("sse_customer_key"))
+		if err != nil {
+			return fmt.Errorf("decode sse_customer_key: %w", err)
+		}
+		cfg.SSECustomerKey = key
+		cfg.Params.Del("sse_customer_key")
+		keySources++
+	}
+	if params.Has("sse_customer_key_file") {
+		cfg.SSECustomerKeyFile = params.Get("sse_customer_key_file")
+		cfg.Params.Del("sse_customer_key_file")
+		keySources++
+	}
+	if params.Has("sse_customer_key_env") {
+		cfg.SSECustomerKeyEnv = params.Get("sse_customer_key_env")
+		cfg.Params.Del("sse_customer_key_env")
+		keySources++
+	}
+	if keySources > 1 {
+		return errors.New("sse_customer_key, sse_customer_key_file and sse_customer_key_env are exclusive")
+	}
+	if params.Has("sse_customer_algorithm") {
+		cfg.SSECustomerAlgorithm = params.Get("sse_customer_algorithm")
+		cfg.Params.Del("sse_customer_algorithm")
+	}
+	if err := cfg.loadSSECustomerKey(); err != nil {
+		return err
+	}
+	if cfg.SSECustomerAlgorithm != "" && len(cfg.SSECustomerKey) == 0 {
+		return errors.New("sse_customer_algorithm requires customer key")
+	}
+	return nil
+}
+
+// loadSSECustomerKey loads SSECustomerKey from SSECustomerKeyFile or SSECustomerKeyEnv.
+// the file may contain the raw 256-bit key or base64 encoded key, the environment variable must be base64 encoded.
+func (cfg *S3SelectConfig) loadSSECustomerKey() error {
+	switch {
+	case cfg.SSECustomerKeyFile != "":
+		bs, err := os.ReadFile(cfg.SSECustomerKeyFile)
+		if err != nil {
+			return fmt.Errorf("read sse_customer_key_file: %w", err)
+		}
+		if key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(bs))); err == nil && len(key) == 32 {
+			bs = key
+		}
+		cfg.SSECustomerKey = bs
+	case cfg.SSECustomerKeyEnv != "":
+		v, ok := os.LookupEnv(cfg.SSECustomerKeyEnv)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", cfg.SSECustomerKeyEnv)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("decode environment variable %s: %w", cfg.SSECustomerKeyEnv, err)
+		}
+		cfg.SSECustomerKey = key
+	}
+	if len(cfg.SSECustomerKey) == 0 {
+		return nil
+	}
+	if len(cfg.SSECustomerKey) != 32 {
+		return fmt.Errorf("sse customer key must be 256 bits, but %d bits", len(cfg.SSECustomerKey)*8)
+	}
+	if cfg.SSECustomerAlgorithm == "" {
+		cfg.SSECustomerAlgorithm = sseCustomerAlgorithmAES256
+	}
+	return nil
+}
+
+func (cfg *S3SelectConfig) setRequestParamsToURLValues(params url.Values) {
+	if cfg.ExpectedBucketOwner != "" {
+		params.Set("expected_bucket_owner", cfg.ExpectedBucketOwner)
+	} else {
+		params.Del("expected_bucket_owner")
+	}
+	if cfg.RequestPayer != "" {
+		params.Set("request_payer", string(cfg.RequestPayer))
+	} else {
+		params.Del("request_payer")
+	}
+	params.Del("sse_customer_key")
+	params.Del("sse_customer_key_file")
+	params.Del("sse_customer_key_env")
+	switch {
+	case cfg.SSECustomerKeyFile != "":
+		params.Set("sse_customer_key_file", cfg.SSECustomerKeyFile)
+	case cfg.SSECustomerKeyEnv != "":
+		params.Set("sse_customer_key_env", cfg.SSECustomerKeyEnv)
+	case len(cfg.SSECustomerKey) > 0:
+		params.Set("sse_customer_key", redactedValue)
+	}
+	if cfg.SSECustomerAlgorithm != "" && cfg.SSECustomerAlgorithm != sseCustomerAlgorithmAES256 {
+		params.Set("sse_customer_algorithm", cfg.SSECustomerAlgorithm)
+	} else {
+		params.Del("sse_customer_algorithm")
+	}
+}
+
+func (cfg *S3SelectConfig) applySelectObjectContentInput(input *s3.SelectObjectContentInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = cfg.sseCustomerKeyHeaders()
+}
+
+// sseCustomerKeyHeaders returns algorithm, base64 encoded key and MD5 of the key for SSE-C requests. all are nil without SSE-C.
+func (cfg *S3SelectConfig) sseCustomerKeyHeaders() (algorithm, key, keyMD5 *string) {
+	if len(cfg.SSECustomerKey) == 0 {
+		return nil, nil, nil
+	}
+	sum := md5.Sum(cfg.SSECustomerKey)
+	return aws.String(cfg.SSECustomerAlgorithm),
+		aws.String(base64.StdEncoding.EncodeToString(cfg.SSECustomerKey)),
+		aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// selectObjectContentOptFns returns option functions of SelectObjectContent,
+// SelectObjectContentInput has no RequestPayer field, so the header is added by middleware.
+func (cfg *S3SelectConfig) selectObjectContentOptFns() []func(*s3.Options) {
+	if cfg.RequestPayer == "" {
+		return nil
+	}
+	payer := string(cfg.RequestPayer)
+	return []func(*s3.Options){
+		func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("x-amz-request-payer", payer))
+		},
+	}
+}
+
+func (cfg *S3SelectConfig) applyListObjectsV2Input(input *s3.ListObjectsV2Input) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+}
+
+func (cfg *S3SelectConfig) applyGetObjectInput(input *s3.GetObjectInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+}
+
+func (cfg *S3SelectConfig) applyHeadObjectInput(input *s3.HeadObjectInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = cfg.sseCustomerKeyHeaders()
+}
+
+func (cfg *S3SelectConfig) applyPutObjectInput(input *s3.PutObjectInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = cfg.sseCustomerKeyHeaders()
+}
+
+func (cfg *S3SelectConfig) applyCreateMultipartUploadInput(input *s3.CreateMultipartUploadInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = cfg.sseCustomerKeyHeaders()
+}
+
+func (cfg *S3SelectConfig) applyUploadPartInput(input *s3.UploadPartInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = cfg.sseCustomerKeyHeaders()
+}
+
+func (cfg *S3SelectConfig) applyCompleteMultipartUploadInput(input *s3.CompleteMultipartUploadInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = cfg.sseCustomerKeyHeaders()
+}
+
+func (cfg *S3SelectConfig) applyAbortMultipartUploadInput(input *s3.AbortMultipartUploadInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+}
+
+func (cfg *S3SelectConfig) applyDeleteObjectInput(input *s3.DeleteObjectInput) {
+	if cfg.ExpectedBucketOwner != "" {
+		input.ExpectedBucketOwner = aws.String(cfg.ExpectedBucketOwner)
+	}
+	if cfg.RequestPayer != "" {
+		input.RequestPayer = cfg.RequestPayer
+	}
+}
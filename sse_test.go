@@ -0,0 +1,90 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3SelectConfig__SSECustomerKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	t.Run("inline key is redacted", func(t *testing.T) {
+		cfg, err := ParseDSN("s3://example-com/data.csv?sse_customer_key=" + url.QueryEscape(encoded))
+		require.NoError(t, err)
+		require.Equal(t, key, cfg.SSECustomerKey)
+		require.Equal(t, "AES256", cfg.SSECustomerAlgorithm)
+		require.Equal(t, "s3://example-com/data.csv?compression_type=none&format=csv&sse_customer_key=REDACTED", cfg.String())
+		_, err = ParseDSN(cfg.String())
+		require.EqualError(t, err, "dsn is invalid: set query params: sse_customer_key is redacted")
+	})
+	t.Run("key file", func(t *testing.T) {
+		dir := t.TempDir()
+		rawPath := filepath.Join(dir, "raw.key")
+		require.NoError(t, os.WriteFile(rawPath, key, 0600))
+		encodedPath := filepath.Join(dir, "encoded.key")
+		require.NoError(t, os.WriteFile(encodedPath, []byte(encoded+"\n"), 0600))
+		for _, path := range []string{rawPath, encodedPath} {
+			cfg, err := ParseDSN("s3://example-com/data.csv?sse_customer_key_file=" + url.QueryEscape(path))
+			require.NoError(t, err)
+			require.Equal(t, key, cfg.SSECustomerKey)
+			require.Equal(t, "s3://example-com/data.csv?compression_type=none&format=csv&sse_customer_key_file="+url.QueryEscape(path), cfg.String())
+		}
+	})
+	t.Run("key env", func(t *testing.T) {
+		t.Setenv("TEST_SSE_CUSTOMER_KEY", encoded)
+		cfg, err := ParseDSN("s3://example-com/data.csv?sse_customer_key_env=TEST_SSE_CUSTOMER_KEY")
+		require.NoError(t, err)
+		require.Equal(t, key, cfg.SSECustomerKey)
+		require.Equal(t, "s3://example-com/data.csv?compression_type=none&format=csv&sse_customer_key_env=TEST_SSE_CUSTOMER_KEY", cfg.String())
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ParseDSN("s3://example-com/data.csv?sse_customer_key_env=TEST_SSE_CUSTOMER_KEY_NOT_SET")
+		require.EqualError(t, err, "dsn is invalid: set query params: environment variable TEST_SSE_CUSTOMER_KEY_NOT_SET is not set")
+		_, err = ParseDSN("s3://example-com/data.csv?sse_customer_key=" + url.QueryEscape(base64.StdEncoding.EncodeToString([]byte("short"))))
+		require.EqualError(t, err, "dsn is invalid: set query params: sse customer key must be 256 bits, but 40 bits")
+		_, err = ParseDSN("s3://example-com/data.csv?request_payer=bucket_owner")
+		require.EqualError(t, err, "dsn is invalid: set query params: unknown request_payer: bucket_owner")
+	})
+}
+
+func TestFakeS3__SSECustomerKeyAndRequestOptions(t *testing.T) {
+	key := bytes.Repeat([]byte{0x02}, 32)
+	sum := md5.Sum(key)
+	t.Setenv("TEST_SSE_CUSTOMER_KEY", base64.StdEncoding.EncodeToString(key))
+	server := newFakeS3Server(t)
+	server.PutObject("example-com/csv/1.csv", []byte("id\n1\n"))
+	server.SelectFunc = func(r *http.Request, bucket, key, expression string) (string, error) {
+		return `{"id":"1"}` + "\n", nil
+	}
+	dsn := "s3://example-com/csv/?format=csv&use_path_style=true&sse_customer_key_env=TEST_SSE_CUSTOMER_KEY" +
+		"&expected_bucket_owner=123456789012&request_payer=requester&endpoint=" + url.QueryEscape(server.URL)
+	runTestsWithDB(t, dsn, func(t *testing.T, db *sql.DB) {
+		restore := requireNoErrorLog(t)
+		defer restore()
+		var id string
+		require.NoError(t, db.QueryRowContext(context.Background(), `SELECT * FROM s3object s`).Scan(&id))
+		require.Equal(t, "1", id)
+	})
+	requests := server.Requests()
+	require.Len(t, requests, 2)
+	for _, r := range requests {
+		require.Equal(t, "123456789012", r.Header.Get("X-Amz-Expected-Bucket-Owner"))
+		require.Equal(t, string(types.RequestPayerRequester), r.Header.Get("X-Amz-Request-Payer"))
+	}
+	selectRequest := requests[1]
+	require.Equal(t, "AES256", selectRequest.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	require.Equal(t, base64.StdEncoding.EncodeToString(key), selectRequest.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+	require.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), selectRequest.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+}
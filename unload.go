@@ -0,0 +1,732 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/iancoleman/orderedmap"
+)
+
+const (
+	// defaultUnloadMaxFileSize is the size of written objects, rows are written to the next object after it.
+	defaultUnloadMaxFileSize = 128 * 1024 * 1024
+	// s3MultipartPartSize is the size of parts of multipart upload, objects smaller than it are put at once.
+	s3MultipartPartSize = 8 * 1024 * 1024
+)
+
+// unloadOptions are options of UNLOAD and CREATE TABLE AS SELECT.
+type unloadOptions struct {
+	bucketName  string
+	prefix      string
+	format      S3SelectFormat
+	compression S3SelectCompressionType
+	maxFileSize int64
+	delimiter   string
+	header      bool
+}
+
+// parseUnloadOptions parses the location and options of `WITH (...)`, format is one of parquet, csv and json_lines.
+func parseUnloadOptions(location string, options map[string]string) (*unloadOptions, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse location: %w", err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("location must be s3://bucket/prefix/, but %s", location)
+	}
+	opts := &unloadOptions{
+		bucketName:  u.Host,
+		prefix:      strings.TrimPrefix(u.Path, "/"),
+		format:      S3SelectFormatParquet,
+		compression: S3SelectCompressionTypeNone,
+		maxFileSize: defaultUnloadMaxFileSize,
+		delimiter:   ",",
+		header:      true,
+	}
+	if opts.prefix != "" && !strings.HasSuffix(opts.prefix, "/") {
+		opts.prefix += "/"
+	}
+	for key, value := range options {
+		switch key {
+		case "format":
+			switch strings.ToLower(value) {
+			case "parquet":
+				opts.format = S3SelectFormatParquet
+			case "csv", "textfile":
+				opts.format = S3SelectFormatCSV
+			case "json", "json_lines", "jsonfile":
+				opts.format = S3SelectFormatJSONL
+			default:
+				return nil, fmt.Errorf("unsupported format: %s", value)
+			}
+		case "compression", "write_compression":
+			switch strings.ToLower(value) {
+			case "none":
+				opts.compression = S3SelectCompressionTypeNone
+			case "gzip":
+				opts.compression = S3SelectCompressionTypeGzip
+			default:
+				return nil, fmt.Errorf("unsupported compression: %s", value)
+			}
+		case "max_file_size":
+			if opts.maxFileSize, err = parseByteSize(value); err != nil {
+				return nil, fmt.Errorf("parse max_file_size: %w", err)
+			}
+		case "field_delimiter":
+			if len(unescapeDialectString(value)) != 1 {
+				return nil, fmt.Errorf("field_delimiter must be a character, but %q", value)
+			}
+			opts.delimiter = unescapeDialectString(value)
+		case "header":
+			if opts.header, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("parse header: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %s", key)
+		}
+	}
+	return opts, nil
+}
+
+// parseByteSize parses sizes such as `1048576`, `512KB`, `64MB` or `1GB`.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, errors.New("size must be positive")
+	}
+	return n * unit, nil
+}
+
+// unloadStatement executes `UNLOAD (query) TO 's3://bucket/prefix/' [WITH (name = value, ...)]`.
+func (conn *s3SelectConn) unloadStatement(ctx context.Context, p *ddlParser) (driver.Result, error) {
+	query, err := p.parenthesized()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("TO"); err != nil {
+		return nil, err
+	}
+	location, err := p.stringLiteral()
+	if err != nil {
+		return nil, err
+	}
+	options := map[string]string{}
+	if p.acceptKeyword("WITH") {
+		if options, err = p.options(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.done() {
+		return nil, p.unexpected("end of query")
+	}
+	opts, err := parseUnloadOptions(location, options)
+	if err != nil {
+		return nil, err
+	}
+	n, _, err := conn.unload(ctx, strings.TrimSpace(query), opts)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+// createTableAsSelect executes `CREATE TABLE name [WITH (external_location = 's3://...', ...)] AS query`,
+// and registers the table of written objects.
+func (conn *s3SelectConn) createTableAsSelect(ctx context.Context, p *ddlParser, name string, ifNotExists bool) (driver.Result, error) {
+	options := map[string]string{}
+	if p.acceptKeyword("WITH") {
+		var err error
+		if options, err = p.options(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+	query := strings.TrimSpace(p.rest())
+	if query == "" {
+		return nil, errors.New("query is empty")
+	}
+	location := options["external_location"]
+	if location == "" {
+		return nil, errors.New("external_location is required")
+	}
+	delete(options, "external_location")
+	opts, err := parseUnloadOptions(location, options)
+	if err != nil {
+		return nil, err
+	}
+	if conn.existsTable(ctx, name) {
+		if ifNotExists {
+			return driver.ResultNoRows, nil
+		}
+		return nil, fmt.Errorf("table %s already exists", name)
+	}
+	n, columns, err := conn.unload(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	table := &CatalogTable{
+		Name:            name,
+		Location:        "s3://" + opts.bucketName + "/" + opts.prefix,
+		Format:          opts.format,
+		CompressionType: opts.compression,
+		Params:          map[string]string{},
+		Columns:         columns,
+	}
+	if opts.format == S3SelectFormatCSV {
+		table.Params["header"] = string(S3SelectHeaderUse)
+		if !opts.header {
+			names := make([]string, 0, len(columns))
+			for _, column := range columns {
+				names = append(names, column.Name)
+			}
+			table.Params["header"] = string(S3SelectHeaderNone)
+			table.Params["column_names"] = strings.Join(names, ",")
+		}
+		if opts.delimiter != "," {
+			table.Params["delimiter"] = opts.delimiter
+		}
+	}
+	if _, err := table.config(); err != nil {
+		return nil, fmt.Errorf("table %s: %w", name, err)
+	}
+	if !conn.registry.add(table) {
+		return nil, fmt.Errorf("table %s already exists", name)
+	}
+	conn.debugf("create table %s: %s rows=%d", name, table.Location, n)
+	return driver.RowsAffected(n), nil
+}
+
+// existsTable reports whether the table is created or in the catalog.
+func (conn *s3SelectConn) existsTable(ctx context.Context, name string) bool {
+	if conn.registry.table(name) != nil {
+		return true
+	}
+	catalog, err := conn.loadCatalog(ctx)
+	return err == nil && catalog.Table(name) != nil
+}
+
+// unload writes rows of the query to objects under the location, and returns the number of rows and columns of written objects.
+// the location must be empty, and objects already written are deleted on errors.
+// rows are written by batches as S3 Select returns them, so types of columns are inferred from the first batch.
+func (conn *s3SelectConn) unload(ctx context.Context, query string, opts *unloadOptions) (int64, []*CatalogColumn, error) {
+	if _, ok := conn.client.(S3PutObjectClient); !ok {
+		return 0, nil, errors.New("s3 select client does not support PutObject")
+	}
+	if _, ok := conn.client.(S3MultipartUploadClient); !ok {
+		return 0, nil, errors.New("s3 select client does not support multipart upload")
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(opts.bucketName),
+		Prefix:  aws.String(opts.prefix),
+		MaxKeys: 1,
+	}
+	conn.cfg.applyListObjectsV2Input(input)
+	output, err := conn.client.ListObjectsV2(ctx, input)
+	if err != nil {
+		return 0, nil, fmt.Errorf("list s3://%s/%s: %w", opts.bucketName, opts.prefix, err)
+	}
+	if len(output.Contents) > 0 {
+		return 0, nil, fmt.Errorf("location s3://%s/%s is not empty", opts.bucketName, opts.prefix)
+	}
+	w := &unloadWriter{conn: conn, opts: opts}
+	var n int64
+	write := func(rows driver.Rows) error {
+		names := rows.Columns()
+		if len(names) == 0 {
+			return nil
+		}
+		if w.columns == nil {
+			w.columns = unloadColumns(rows, names, opts.format)
+		}
+		if len(names) > len(w.columns) {
+			return fmt.Errorf("column %s is not in the first %d rows", names[len(w.columns)], rowBatchSize)
+		}
+		dest := make([]driver.Value, len(names))
+		for {
+			if err := rows.Next(dest); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			row := make([]interface{}, len(dest))
+			for i, v := range dest {
+				row[i] = v
+			}
+			if err := w.write(ctx, row); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	rows, err := conn.query(withRowBatch(ctx, func(rows *s3SelectRows) error {
+		return write(rows)
+	}), query, nil)
+	if err != nil {
+		w.abort()
+		return 0, nil, err
+	}
+	defer rows.Close()
+	// rows of virtual tables such as information_schema are not passed by batches
+	if err := write(rows); err != nil {
+		w.abort()
+		return 0, nil, err
+	}
+	if err := w.close(ctx); err != nil {
+		w.abort()
+		return 0, nil, err
+	}
+	conn.debugf("unload: s3://%s/%s rows=%d objects=%d", opts.bucketName, opts.prefix, n, w.objects)
+	if w.columns == nil {
+		w.columns = []*CatalogColumn{}
+	}
+	return n, w.columns, nil
+}
+
+// unloadColumns returns columns of written objects by types of the rows.
+func unloadColumns(rows driver.Rows, names []string, format S3SelectFormat) []*CatalogColumn {
+	columns := make([]*CatalogColumn, 0, len(names))
+	for i, name := range names {
+		typ := SchemaTypeString
+		if r, ok := rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+			typ = strings.ToLower(r.ColumnTypeDatabaseTypeName(i))
+		}
+		switch typ {
+		case SchemaTypeBigint, SchemaTypeDouble, SchemaTypeBoolean, SchemaTypeTimestamp, SchemaTypeString:
+		default:
+			// nested values are written as JSON strings except for JSON Lines
+			if format != S3SelectFormatJSONL {
+				typ = SchemaTypeString
+			}
+		}
+		columns = append(columns, &CatalogColumn{Name: name, Type: typ})
+	}
+	return columns
+}
+
+// rowBatchSize is the number of rows passed to the row batch callback at once.
+const rowBatchSize = 1000
+
+type rowBatchKey struct{}
+
+// withRowBatch returns the context for query, rows of S3 Select are passed to fn by batches instead of the result.
+func withRowBatch(ctx context.Context, fn func(rows *s3SelectRows) error) context.Context {
+	return context.WithValue(ctx, rowBatchKey{}, fn)
+}
+
+func rowBatchFromContext(ctx context.Context) func(rows *s3SelectRows) error {
+	fn, _ := ctx.Value(rowBatchKey{}).(func(rows *s3SelectRows) error)
+	return fn
+}
+
+// unloadWriter writes rows to objects of the format, the next object is started when the size exceeds max_file_size.
+type unloadWriter struct {
+	conn    *s3SelectConn
+	opts    *unloadOptions
+	columns []*CatalogColumn
+	objects int
+	// keys are keys of completed objects, they are deleted on abort.
+	keys []string
+
+	// object is the object being written in CSV or JSON Lines.
+	object  *s3ObjectWriter
+	counter *countingWriter
+	gzip    *gzip.Writer
+	csv     *csv.Writer
+	json    *json.Encoder
+	// parquet buffers rows of the object, the footer is written after all rows.
+	parquet     *parquetWriter
+	parquetSize int64
+}
+
+func (w *unloadWriter) nextKey() string {
+	ext := ".parquet"
+	switch w.opts.format {
+	case S3SelectFormatCSV:
+		ext = ".csv"
+	case S3SelectFormatJSONL:
+		ext = ".json"
+	}
+	if w.opts.compression == S3SelectCompressionTypeGzip && w.opts.format != S3SelectFormatParquet {
+		ext += ".gz"
+	}
+	w.objects++
+	return fmt.Sprintf("%spart-%05d%s", w.opts.prefix, w.objects-1, ext)
+}
+
+func (w *unloadWriter) write(ctx context.Context, row []interface{}) error {
+	if err := w.widenColumns(row); err != nil {
+		return err
+	}
+	if w.opts.format == S3SelectFormatParquet {
+		return w.writeParquet(ctx, row)
+	}
+	if w.object == nil {
+		if err := w.open(ctx); err != nil {
+			return err
+		}
+	}
+	if w.csv != nil {
+		record := make([]string, len(row))
+		for i, v := range row {
+			s, err := unloadString(v)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", w.columns[i].Name, err)
+			}
+			record[i] = s
+		}
+		if err := w.csv.Write(record); err != nil {
+			return err
+		}
+	} else {
+		o := orderedmap.New()
+		for i, v := range row {
+			o.Set(w.columns[i].Name, v)
+		}
+		if err := w.json.Encode(o); err != nil {
+			return err
+		}
+	}
+	if w.counter.n >= w.opts.maxFileSize {
+		return w.closeObject(ctx)
+	}
+	return nil
+}
+
+// widenColumns widens types of columns for values which can not be converted to them, such as a double of a bigint column,
+// because types are inferred from the first rows. columns of parquet objects already written can not be widened.
+func (w *unloadWriter) widenColumns(row []interface{}) error {
+	for i, v := range row {
+		if v == nil || i >= len(w.columns) {
+			continue
+		}
+		column := &SchemaColumn{Name: w.columns[i].Name, Type: w.columns[i].Type}
+		if _, err := column.ConvertValue(v); err == nil {
+			continue
+		}
+		typ := mergeSchemaType(column.Type, schemaTypeOf(v, stringAsString))
+		if w.opts.format == S3SelectFormatParquet {
+			if len(w.keys) > 0 {
+				return fmt.Errorf("column %s: %v is not %s of objects already written", column.Name, v, column.Type)
+			}
+			if w.parquet != nil {
+				if err := w.parquet.widen(i, typ); err != nil {
+					return err
+				}
+			}
+		}
+		w.conn.debugf("unload: column %s is widened from %s to %s", column.Name, column.Type, typ)
+		w.columns[i].Type = typ
+	}
+	return nil
+}
+
+func (w *unloadWriter) open(ctx context.Context) error {
+	w.object = newS3ObjectWriter(ctx, w.conn, w.opts.bucketName, w.nextKey())
+	w.counter = &countingWriter{w: w.object}
+	var out io.Writer = w.counter
+	if w.opts.compression == S3SelectCompressionTypeGzip {
+		w.gzip = gzip.NewWriter(w.counter)
+		out = w.gzip
+	}
+	if w.opts.format == S3SelectFormatCSV {
+		w.csv = csv.NewWriter(out)
+		w.csv.Comma = rune(w.opts.delimiter[0])
+		if w.opts.header {
+			names := make([]string, 0, len(w.columns))
+			for _, column := range w.columns {
+				names = append(names, column.Name)
+			}
+			return w.csv.Write(names)
+		}
+		return nil
+	}
+	w.json = json.NewEncoder(out)
+	return nil
+}
+
+func (w *unloadWriter) closeObject(ctx context.Context) error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if w.gzip != nil {
+		if err := w.gzip.Close(); err != nil {
+			return err
+		}
+	}
+	if err := w.object.Close(); err != nil {
+		return err
+	}
+	w.keys = append(w.keys, w.object.key)
+	w.object, w.counter, w.gzip, w.csv, w.json = nil, nil, nil, nil, nil
+	return nil
+}
+
+func (w *unloadWriter) writeParquet(ctx context.Context, row []interface{}) error {
+	if w.parquet == nil {
+		names := make([]string, 0, len(w.columns))
+		types := make([]string, 0, len(w.columns))
+		for _, column := range w.columns {
+			names = append(names, column.Name)
+			types = append(types, column.Type)
+		}
+		codec := parquetCodecUncompressed
+		if w.opts.compression == S3SelectCompressionTypeGzip {
+			codec = parquetCodecGzip
+		}
+		w.parquet, w.parquetSize = newParquetWriter(names, types, codec), 0
+	}
+	if err := w.parquet.add(row); err != nil {
+		return err
+	}
+	// the size is estimated by plain encoding without compression
+	for _, v := range w.parquet.rows[w.parquet.numRows()-1] {
+		if s, ok := v.(string); ok {
+			w.parquetSize += int64(len(s)) + 4
+		} else {
+			w.parquetSize += 8
+		}
+	}
+	if w.parquetSize >= w.opts.maxFileSize {
+		return w.flushParquet(ctx)
+	}
+	return nil
+}
+
+func (w *unloadWriter) flushParquet(ctx context.Context) error {
+	bs, err := w.parquet.bytes()
+	if err != nil {
+		return err
+	}
+	w.parquet = nil
+	w.object = newS3ObjectWriter(ctx, w.conn, w.opts.bucketName, w.nextKey())
+	if _, err := w.object.Write(bs); err != nil {
+		return err
+	}
+	if err := w.object.Close(); err != nil {
+		return err
+	}
+	w.keys = append(w.keys, w.object.key)
+	w.object = nil
+	return nil
+}
+
+func (w *unloadWriter) close(ctx context.Context) error {
+	if w.parquet != nil && w.parquet.numRows() > 0 {
+		return w.flushParquet(ctx)
+	}
+	if w.object != nil {
+		return w.closeObject(ctx)
+	}
+	return nil
+}
+
+// abort aborts the object in progress and deletes completed objects, so that the location is empty to retry.
+// errors are logged, objects are left if the client does not implement S3DeleteObjectClient.
+func (w *unloadWriter) abort() {
+	if w.object != nil {
+		w.object.abort()
+		w.object = nil
+	}
+	if len(w.keys) == 0 {
+		return
+	}
+	client, ok := w.conn.client.(S3DeleteObjectClient)
+	if !ok {
+		w.conn.errorf("unload: s3 select client does not support DeleteObject, delete objects under s3://%s/%s to retry", w.opts.bucketName, w.opts.prefix)
+		return
+	}
+	for _, key := range w.keys {
+		input := &s3.DeleteObjectInput{
+			Bucket: aws.String(w.opts.bucketName),
+			Key:    aws.String(key),
+		}
+		w.conn.cfg.applyDeleteObjectInput(input)
+		w.conn.debugf("unload: delete s3://%s/%s", w.opts.bucketName, key)
+		if _, err := client.DeleteObject(context.Background(), input); err != nil {
+			w.conn.errorf("delete s3://%s/%s: %v", w.opts.bucketName, key, err)
+		}
+	}
+	w.keys = nil
+}
+
+// unloadString formats the value for CSV and strings of parquet, nested values are formatted in JSON.
+func unloadString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// s3ObjectWriter uploads the object by multipart upload, the object smaller than a part is put by PutObject.
+type s3ObjectWriter struct {
+	ctx      context.Context
+	conn     *s3SelectConn
+	bucket   string
+	key      string
+	buf      bytes.Buffer
+	uploadID *string
+	parts    []types.CompletedPart
+}
+
+func newS3ObjectWriter(ctx context.Context, conn *s3SelectConn, bucket, key string) *s3ObjectWriter {
+	return &s3ObjectWriter{
+		ctx:    ctx,
+		conn:   conn,
+		bucket: bucket,
+		key:    key,
+	}
+}
+
+func (w *s3ObjectWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for w.buf.Len() >= s3MultipartPartSize {
+		if err := w.uploadPart(w.buf.Next(s3MultipartPartSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *s3ObjectWriter) uploadPart(part []byte) error {
+	client := w.conn.client.(S3MultipartUploadClient)
+	if w.uploadID == nil {
+		input := &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(w.bucket),
+			Key:    aws.String(w.key),
+		}
+		w.conn.cfg.applyCreateMultipartUploadInput(input)
+		output, err := client.CreateMultipartUpload(w.ctx, input)
+		if err != nil {
+			return fmt.Errorf("create multipart upload s3://%s/%s: %w", w.bucket, w.key, err)
+		}
+		w.uploadID = output.UploadId
+	}
+	number := int32(len(w.parts) + 1)
+	input := &s3.UploadPartInput{
+		Bucket:     aws.String(w.bucket),
+		Key:        aws.String(w.key),
+		UploadId:   w.uploadID,
+		PartNumber: number,
+		Body:       bytes.NewReader(part),
+	}
+	w.conn.cfg.applyUploadPartInput(input)
+	output, err := client.UploadPart(w.ctx, input)
+	if err != nil {
+		return fmt.Errorf("upload part %d of s3://%s/%s: %w", number, w.bucket, w.key, err)
+	}
+	w.parts = append(w.parts, types.CompletedPart{ETag: output.ETag, PartNumber: number})
+	return nil
+}
+
+func (w *s3ObjectWriter) Close() error {
+	if w.uploadID == nil {
+		input := &s3.PutObjectInput{
+			Bucket: aws.String(w.bucket),
+			Key:    aws.String(w.key),
+			Body:   bytes.NewReader(w.buf.Bytes()),
+		}
+		w.conn.cfg.applyPutObjectInput(input)
+		w.conn.debugf("unload: put s3://%s/%s", w.bucket, w.key)
+		if _, err := w.conn.client.(S3PutObjectClient).PutObject(w.ctx, input); err != nil {
+			return fmt.Errorf("put s3://%s/%s: %w", w.bucket, w.key, err)
+		}
+		return nil
+	}
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.bucket),
+		Key:             aws.String(w.key),
+		UploadId:        w.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	}
+	w.conn.cfg.applyCompleteMultipartUploadInput(input)
+	w.conn.debugf("unload: complete multipart upload s3://%s/%s parts=%d", w.bucket, w.key, len(w.parts))
+	if _, err := w.conn.client.(S3MultipartUploadClient).CompleteMultipartUpload(w.ctx, input); err != nil {
+		return fmt.Errorf("complete multipart upload s3://%s/%s: %w", w.bucket, w.key, err)
+	}
+	w.uploadID = nil
+	return nil
+}
+
+// abort aborts the multipart upload in progress, errors are logged because the upload expires by the lifecycle of the bucket.
+func (w *s3ObjectWriter) abort() {
+	if w.uploadID == nil {
+		return
+	}
+	input := &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: w.uploadID,
+	}
+	w.conn.cfg.applyAbortMultipartUploadInput(input)
+	if _, err := w.conn.client.(S3MultipartUploadClient).AbortMultipartUpload(context.Background(), input); err != nil {
+		w.conn.errorf("abort multipart upload s3://%s/%s: %v", w.bucket, w.key, err)
+	}
+	w.uploadID = nil
+}
@@ -0,0 +1,466 @@
+package s3selectsqldriver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUnloadOptions(t *testing.T) {
+	opts, err := parseUnloadOptions("s3://example-com/out", nil)
+	require.NoError(t, err)
+	require.Equal(t, &unloadOptions{
+		bucketName:  "example-com",
+		prefix:      "out/",
+		format:      S3SelectFormatParquet,
+		compression: S3SelectCompressionTypeNone,
+		maxFileSize: defaultUnloadMaxFileSize,
+		delimiter:   ",",
+		header:      true,
+	}, opts)
+
+	opts, err = parseUnloadOptions("s3://example-com/out/", map[string]string{
+		"format":          "TEXTFILE",
+		"compression":     "gzip",
+		"max_file_size":   "64MB",
+		"field_delimiter": `\t`,
+		"header":          "false",
+	})
+	require.NoError(t, err)
+	require.Equal(t, &unloadOptions{
+		bucketName:  "example-com",
+		prefix:      "out/",
+		format:      S3SelectFormatCSV,
+		compression: S3SelectCompressionTypeGzip,
+		maxFileSize: 64 * 1024 * 1024,
+		delimiter:   "\t",
+		header:      false,
+	}, opts)
+
+	cases := []struct {
+		location string
+		options  map[string]string
+		expected string
+	}{
+		{location: "https://example-com/out/", expected: "location must be s3://bucket/prefix/, but https://example-com/out/"},
+		{location: "s3://example-com/out/", options: map[string]string{"format": "orc"}, expected: "unsupported format: orc"},
+		{location: "s3://example-com/out/", options: map[string]string{"compression": "zstd"}, expected: "unsupported compression: zstd"},
+		{location: "s3://example-com/out/", options: map[string]string{"max_file_size": "0"}, expected: "parse max_file_size: size must be positive"},
+		{location: "s3://example-com/out/", options: map[string]string{"field_delimiter": "||"}, expected: `field_delimiter must be a character, but "||"`},
+		{location: "s3://example-com/out/", options: map[string]string{"partitioned_by": "dt"}, expected: "unknown option partitioned_by"},
+	}
+	for _, c := range cases {
+		t.Run(c.expected, func(t *testing.T) {
+			_, err := parseUnloadOptions(c.location, c.options)
+			require.EqualError(t, err, c.expected)
+		})
+	}
+}
+
+// readTestParquet decodes columns of the parquet written by parquetWriter.
+func readTestParquet(t *testing.T, bs []byte) map[string][]interface{} {
+	t.Helper()
+	require.Equal(t, "PAR1", string(bs[:4]))
+	require.Equal(t, "PAR1", string(bs[len(bs)-4:]))
+	size := int(binary.LittleEndian.Uint32(bs[len(bs)-8:]))
+	r := &thriftReader{buf: bs[len(bs)-8-size : len(bs)-8]}
+	fileMetaData, err := r.readStruct()
+	require.NoError(t, err)
+	columns := make(map[string][]interface{})
+	for _, v := range fileMetaData.list(4) {
+		for _, v := range v.(thriftStruct).list(1) {
+			metaData := v.(thriftStruct).strct(3)
+			name := string(metaData.list(3)[0].([]byte))
+			r := &thriftReader{buf: bs, pos: int(metaData.int64(9))}
+			header, err := r.readStruct()
+			require.NoError(t, err)
+			page := bs[r.pos : r.pos+int(header.int64(3))]
+			if metaData.int64(4) == parquetCodecGzip {
+				gr, err := gzip.NewReader(bytes.NewReader(page))
+				require.NoError(t, err)
+				page, err = io.ReadAll(gr)
+				require.NoError(t, err)
+			}
+			// definition levels are RLE runs
+			n := int(binary.LittleEndian.Uint32(page))
+			levelReader := &thriftReader{buf: page[4 : 4+n]}
+			var levels []int
+			for levelReader.pos < len(levelReader.buf) {
+				run, err := levelReader.readVarint()
+				require.NoError(t, err)
+				level, err := levelReader.readByte()
+				require.NoError(t, err)
+				for i := uint64(0); i < run>>1; i++ {
+					levels = append(levels, int(level))
+				}
+			}
+			values := page[4+n:]
+			var bit int
+			for _, level := range levels {
+				if level == 0 {
+					columns[name] = append(columns[name], nil)
+					continue
+				}
+				switch metaData.int64(1) {
+				case parquetBoolean:
+					columns[name] = append(columns[name], values[bit/8]&(1<<(bit%8)) != 0)
+					bit++
+				case parquetInt64:
+					columns[name] = append(columns[name], int64(binary.LittleEndian.Uint64(values)))
+					values = values[8:]
+				case parquetDouble:
+					columns[name] = append(columns[name], math.Float64frombits(binary.LittleEndian.Uint64(values)))
+					values = values[8:]
+				case parquetByteArray:
+					l := int(binary.LittleEndian.Uint32(values))
+					columns[name] = append(columns[name], string(values[4:4+l]))
+					values = values[4+l:]
+				}
+			}
+		}
+	}
+	return columns
+}
+
+func TestParquetWriter(t *testing.T) {
+	for _, codec := range []int{parquetCodecUncompressed, parquetCodecGzip} {
+		w := newParquetWriter(
+			[]string{"id", "price", "ok", "ts", "name", "tags"},
+			[]string{SchemaTypeBigint, SchemaTypeDouble, SchemaTypeBoolean, SchemaTypeTimestamp, SchemaTypeString, SchemaTypeString},
+			codec,
+		)
+		ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		require.NoError(t, w.add([]interface{}{int64(3), 1.5, true, ts, "alice", []interface{}{"a", "b"}}))
+		require.NoError(t, w.add([]interface{}{nil, nil, nil, nil, nil, nil}))
+		require.NoError(t, w.add([]interface{}{float64(1), "2.5", false, "2024-05-02", "bob", nil}))
+		require.EqualError(t, w.add([]interface{}{"x"}), `strconv.ParseInt: parsing "x": invalid syntax`)
+		bs, err := w.bytes()
+		require.NoError(t, err)
+
+		require.Equal(t, map[string][]interface{}{
+			"id":    {int64(3), nil, int64(1)},
+			"price": {1.5, nil, 2.5},
+			"ok":    {true, nil, false},
+			"ts":    {ts.UnixMicro(), nil, ts.AddDate(0, 0, 1).UnixMicro()},
+			"name":  {"alice", nil, "bob"},
+			"tags":  {`["a","b"]`, nil, nil},
+		}, readTestParquet(t, bs))
+
+		footer, err := parseParquetFooter(bs[len(bs)-8-int(binary.LittleEndian.Uint32(bs[len(bs)-8:])) : len(bs)-8])
+		require.NoError(t, err)
+		require.Equal(t, int64(3), footer.numRows)
+		require.Equal(t, []string{"id bigint", "price double", "ok boolean", "ts timestamp", "name string", "tags string"}, func() []string {
+			var columns []string
+			for _, column := range footer.schema().Columns {
+				columns = append(columns, column.Name+" "+column.TypeName())
+			}
+			return columns
+		}())
+		skip, err := footer.canSkip("SELECT * FROM S3Object s WHERE s.id > 3")
+		require.NoError(t, err)
+		require.True(t, skip, "statistics are written")
+		skip, err = footer.canSkip("SELECT * FROM S3Object s WHERE s.name = 'bob'")
+		require.NoError(t, err)
+		require.False(t, skip)
+	}
+}
+
+// memoryS3 stores objects put by PutObject and multipart upload, and lists them.
+type memoryS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string][][]byte
+	aborted []string
+}
+
+func (m *memoryS3) client(selectFunc func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error) *mockS3SelectClient {
+	m.objects = make(map[string][]byte)
+	m.parts = make(map[string][][]byte)
+	return &mockS3SelectClient{
+		SelectObjectContentWithWriterFunc: selectFunc,
+		ListObjectsV2Func: func(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			output := &s3.ListObjectsV2Output{Name: params.Bucket}
+			var keys []string
+			for key := range m.objects {
+				if strings.HasPrefix(key, *params.Bucket+"/"+*params.Prefix) {
+					keys = append(keys, strings.TrimPrefix(key, *params.Bucket+"/"))
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				output.Contents = append(output.Contents, types.Object{Key: aws.String(key), ETag: aws.String(`"` + key + `"`)})
+			}
+			return output, nil
+		},
+		PutObjectFunc: func(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+			bs, err := io.ReadAll(params.Body)
+			if err != nil {
+				return nil, err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.objects[*params.Bucket+"/"+*params.Key] = bs
+			return &s3.PutObjectOutput{}, nil
+		},
+		CreateMultipartUploadFunc: func(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+			return &s3.CreateMultipartUploadOutput{UploadId: aws.String(*params.Bucket + "/" + *params.Key)}, nil
+		},
+		UploadPartFunc: func(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+			bs, err := io.ReadAll(params.Body)
+			if err != nil {
+				return nil, err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if int(params.PartNumber) != len(m.parts[*params.UploadId])+1 {
+				return nil, fmt.Errorf("unexpected part number %d", params.PartNumber)
+			}
+			m.parts[*params.UploadId] = append(m.parts[*params.UploadId], bs)
+			return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+		},
+		CompleteMultipartUploadFunc: func(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			parts := m.parts[*params.UploadId]
+			if len(params.MultipartUpload.Parts) != len(parts) {
+				return nil, fmt.Errorf("unexpected parts %d", len(params.MultipartUpload.Parts))
+			}
+			m.objects[*params.UploadId] = bytes.Join(parts, nil)
+			return &s3.CompleteMultipartUploadOutput{}, nil
+		},
+		AbortMultipartUploadFunc: func(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.aborted = append(m.aborted, *params.UploadId)
+			return &s3.AbortMultipartUploadOutput{}, nil
+		},
+		DeleteObjectFunc: func(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			delete(m.objects, *params.Bucket+"/"+*params.Key)
+			return &s3.DeleteObjectOutput{}, nil
+		},
+	}
+}
+
+func (m *memoryS3) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestS3ObjectWriter(t *testing.T) {
+	var m memoryS3
+	conn := newConn(m.client(nil), &S3SelectConfig{})
+	w := newS3ObjectWriter(context.Background(), conn, "example-com", "out/large.csv")
+	data := bytes.Repeat([]byte("0123456789abcdef"), (s3MultipartPartSize*2+100)/16)
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err := w.Write(data[i:end])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.Len(t, m.parts["example-com/out/large.csv"], 3, "objects larger than a part are uploaded in parts")
+	require.Equal(t, data, m.objects["example-com/out/large.csv"])
+
+	w = newS3ObjectWriter(context.Background(), conn, "example-com", "out/aborted.csv")
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	w.abort()
+	require.Equal(t, []string{"example-com/out/aborted.csv"}, m.aborted)
+	require.NotContains(t, m.keys(), "example-com/out/aborted.csv")
+}
+
+func TestMock__Unload(t *testing.T) {
+	var m memoryS3
+	var expressions []string
+	mockClients["unload"] = m.client(func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+		expressions = append(expressions, *params.Key+": "+*params.Expression)
+		if strings.HasPrefix(*params.Expression, "SELECT s.name ") {
+			_, err := io.WriteString(w, `{"name":"alice"}`+"\n"+`{"name":"bob,jr"}`+"\n")
+			return err
+		}
+		if strings.HasPrefix(*params.Key, "data/") {
+			_, err := io.WriteString(w, `{"id":1,"name":"alice","tags":["a"]}`+"\n"+`{"id":2,"name":"bob,jr","tags":null}`+"\n")
+			return err
+		}
+		require.Equal(t, "out/names/part-00000.csv", *params.Key)
+		require.Equal(t, types.FileHeaderInfoUse, params.InputSerialization.CSV.FileHeaderInfo)
+		_, err := io.WriteString(w, `{"name":"alice"}`+"\n")
+		return err
+	})
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/?format=json_lines&mock=unload")
+	require.NoError(t, err)
+	defer db.Close()
+
+	m.objects["example-com/data/1.json"] = nil
+	result, err := db.ExecContext(context.Background(), `UNLOAD (SELECT s.id, s.name, s.tags FROM S3Object s WHERE s.id > ?) TO 's3://example-com/out/csv/' WITH (format = 'csv', compression = 'gzip')`, 0)
+	require.NoError(t, err)
+	n, err := result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+	require.Equal(t, []string{"data/1.json: SELECT s.id, s.name, s.tags FROM S3Object s WHERE s.id > 0"}, expressions)
+	gr, err := gzip.NewReader(bytes.NewReader(m.objects["example-com/out/csv/part-00000.csv.gz"]))
+	require.NoError(t, err)
+	bs, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Equal(t, "id,name,tags\n1,alice,\"[\"\"a\"\"]\"\n2,\"bob,jr\",\n", string(bs))
+
+	_, err = db.ExecContext(context.Background(), `UNLOAD (SELECT * FROM S3Object s) TO 's3://example-com/out/csv/'`)
+	require.EqualError(t, err, "location s3://example-com/out/csv/ is not empty")
+
+	_, err = db.ExecContext(context.Background(), `UNLOAD (SELECT * FROM S3Object s) TO 's3://example-com/out/json/' WITH (format = json_lines, max_file_size = 1)`)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":1,"name":"alice","tags":["a"]}`+"\n", string(m.objects["example-com/out/json/part-00000.json"]))
+	require.Equal(t, `{"id":2,"name":"bob,jr","tags":null}`+"\n", string(m.objects["example-com/out/json/part-00001.json"]), "objects are split by max_file_size")
+
+	_, err = db.ExecContext(context.Background(), `UNLOAD (SELECT * FROM S3Object s) TO 's3://example-com/out/parquet/'`)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]interface{}{
+		"id":   {int64(1), int64(2)},
+		"name": {"alice", "bob,jr"},
+		"tags": {`["a"]`, nil},
+	}, readTestParquet(t, m.objects["example-com/out/parquet/part-00000.parquet"]))
+
+	// CREATE TABLE AS SELECT registers the table of written objects
+	result, err = db.ExecContext(context.Background(), `CREATE TABLE names WITH (external_location = 's3://example-com/out/names/', format = 'csv') AS SELECT s.name FROM S3Object s`)
+	require.NoError(t, err)
+	n, err = result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+	require.Equal(t, "name\nalice\n\"bob,jr\"\n", string(m.objects["example-com/out/names/part-00000.csv"]))
+	_, err = db.ExecContext(context.Background(), `CREATE TABLE names WITH (external_location = 's3://example-com/out/names2/') AS SELECT * FROM S3Object s`)
+	require.EqualError(t, err, "table names already exists")
+	_, err = db.ExecContext(context.Background(), `CREATE TABLE IF NOT EXISTS names WITH (external_location = 's3://example-com/out/names2/') AS SELECT * FROM S3Object s`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(context.Background(), `CREATE TABLE others AS SELECT * FROM S3Object s`)
+	require.EqualError(t, err, "external_location is required")
+
+	expressions = nil
+	rows, err := db.QueryContext(context.Background(), `SELECT n.name FROM names n`)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"out/names/part-00000.csv: SELECT n.name FROM S3Object n"}, expressions)
+	require.Equal(t, []string{
+		"example-com/data/1.json",
+		"example-com/out/csv/part-00000.csv.gz",
+		"example-com/out/json/part-00000.json",
+		"example-com/out/json/part-00001.json",
+		"example-com/out/names/part-00000.csv",
+		"example-com/out/parquet/part-00000.parquet",
+	}, m.keys())
+}
+
+func TestMock__UnloadByBatches(t *testing.T) {
+	var m memoryS3
+	var fail bool
+	mockClients["unload_batches"] = m.client(func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+		for i := 0; i < rowBatchSize+500; i++ {
+			if _, err := fmt.Fprintf(w, `{"id":%d}`+"\n", i); err != nil {
+				return err
+			}
+		}
+		m.mu.Lock()
+		written := len(m.objects)
+		m.mu.Unlock()
+		require.Greater(t, written, 1, "rows are written before S3 Select completes")
+		if fail {
+			return errors.New("select failed")
+		}
+		return nil
+	})
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/1.json?format=json_lines&mock=unload_batches")
+	require.NoError(t, err)
+	defer db.Close()
+	m.objects["example-com/data/1.json"] = nil
+
+	fail = true
+	_, err = db.ExecContext(context.Background(), `UNLOAD (SELECT * FROM S3Object s) TO 's3://example-com/out/' WITH (format = json_lines, max_file_size = 1)`)
+	require.EqualError(t, err, "select failed")
+	require.Equal(t, []string{"example-com/data/1.json"}, m.keys(), "written objects are deleted on errors")
+
+	fail = false
+	result, err := db.ExecContext(context.Background(), `UNLOAD (SELECT * FROM S3Object s) TO 's3://example-com/out/' WITH (format = json_lines, max_file_size = 1)`)
+	require.NoError(t, err, "the location is empty to retry")
+	n, err := result.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(rowBatchSize+500), n)
+	require.Len(t, m.keys(), rowBatchSize+501)
+	require.Equal(t, `{"id":1499}`+"\n", string(m.objects["example-com/out/part-01499.json"]))
+}
+
+func TestMock__UnloadWidenColumns(t *testing.T) {
+	var m memoryS3
+	mockClients["unload_widen"] = m.client(func(ctx context.Context, w io.Writer, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) error {
+		for i := 0; i < rowBatchSize; i++ {
+			if _, err := fmt.Fprintf(w, `{"price":%d}`+"\n", i); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, `{"price":10.5}`+"\n")
+		return err
+	})
+	restore := requireNoErrorLog(t)
+	defer restore()
+	db, err := sql.Open("s3-select", "s3://example-com/data/1.json?format=json_lines&mock=unload_widen")
+	require.NoError(t, err)
+	defer db.Close()
+	m.objects["example-com/data/1.json"] = nil
+
+	_, err = db.ExecContext(context.Background(), `UNLOAD (SELECT * FROM S3Object s) TO 's3://example-com/out/parquet/'`)
+	require.NoError(t, err)
+	prices := readTestParquet(t, m.objects["example-com/out/parquet/part-00000.parquet"])["price"]
+	require.Len(t, prices, rowBatchSize+1)
+	require.Equal(t, float64(1), prices[1])
+	require.Equal(t, 10.5, prices[rowBatchSize], "bigint is widened to double after the first rows")
+
+	_, err = db.ExecContext(context.Background(), `CREATE TABLE prices WITH (external_location = 's3://example-com/out/csv/', format = 'csv') AS SELECT * FROM S3Object s`)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(string(m.objects["example-com/out/csv/part-00000.csv"]), "999\n10.5\n"))
+	rows, err := db.QueryContext(context.Background(), `SELECT data_type FROM information_schema.columns WHERE table_name = 'prices'`)
+	require.NoError(t, err)
+	var types []string
+	for rows.Next() {
+		var typ string
+		require.NoError(t, rows.Scan(&typ))
+		types = append(types, typ)
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"double"}, types, "the table has the widened type")
+
+	_, err = db.ExecContext(context.Background(), `UNLOAD (SELECT * FROM S3Object s) TO 's3://example-com/out/split/' WITH (max_file_size = 1)`)
+	require.EqualError(t, err, "column price: 10.5 is not bigint of objects already written")
+	for _, key := range m.keys() {
+		require.False(t, strings.HasPrefix(key, "example-com/out/split/"), "written objects are deleted")
+	}
+}